@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+var (
+	flagAWSRegion            string
+	flagAWSProfile           string
+	flagAWSRoleArn           string
+	flagAWSEndpointURL       string
+	flagAWSProtonEndpointURL string
+	flagAWSS3EndpointURL     string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&flagAWSRegion, "region", "",
+		"The AWS region to use. Defaults to AWS_REGION or the profile's region")
+	rootCmd.PersistentFlags().StringVar(&flagAWSProfile, "profile", "",
+		"The AWS shared config profile to use. Defaults to AWS_PROFILE")
+	rootCmd.PersistentFlags().StringVar(&flagAWSRoleArn, "role-arn", "",
+		"The ARN of an IAM role to assume using STS")
+	rootCmd.PersistentFlags().StringVar(&flagAWSEndpointURL, "endpoint-url", "",
+		"Override the endpoint URL for all AWS API calls, for example a local stand-in like http://localhost:4566")
+	rootCmd.PersistentFlags().StringVar(&flagAWSProtonEndpointURL, "proton-endpoint-url", "",
+		"Override the endpoint URL for AWS Proton API calls. Takes precedence over --endpoint-url")
+	rootCmd.PersistentFlags().StringVar(&flagAWSS3EndpointURL, "s3-endpoint-url", "",
+		"Override the endpoint URL for Amazon S3 API calls. Takes precedence over --endpoint-url")
+}
+
+// ProtonAPI is the subset of the AWS Proton client used by protonizer.
+// It is satisfied by *serviceSyncProtonClient and can be replaced by a fake in tests.
+type ProtonAPI interface {
+	ListEnvironmentTemplates(context.Context, *proton.ListEnvironmentTemplatesInput, ...func(*proton.Options)) (*proton.ListEnvironmentTemplatesOutput, error)
+	GetEnvironmentTemplate(context.Context, *proton.GetEnvironmentTemplateInput, ...func(*proton.Options)) (*proton.GetEnvironmentTemplateOutput, error)
+	CreateEnvironmentTemplate(context.Context, *proton.CreateEnvironmentTemplateInput, ...func(*proton.Options)) (*proton.CreateEnvironmentTemplateOutput, error)
+	UpdateEnvironmentTemplate(context.Context, *proton.UpdateEnvironmentTemplateInput, ...func(*proton.Options)) (*proton.UpdateEnvironmentTemplateOutput, error)
+	GetEnvironmentTemplateVersion(context.Context, *proton.GetEnvironmentTemplateVersionInput, ...func(*proton.Options)) (*proton.GetEnvironmentTemplateVersionOutput, error)
+	CreateEnvironmentTemplateVersion(context.Context, *proton.CreateEnvironmentTemplateVersionInput, ...func(*proton.Options)) (*proton.CreateEnvironmentTemplateVersionOutput, error)
+	UpdateEnvironmentTemplateVersion(context.Context, *proton.UpdateEnvironmentTemplateVersionInput, ...func(*proton.Options)) (*proton.UpdateEnvironmentTemplateVersionOutput, error)
+	DeleteEnvironmentTemplateVersion(context.Context, *proton.DeleteEnvironmentTemplateVersionInput, ...func(*proton.Options)) (*proton.DeleteEnvironmentTemplateVersionOutput, error)
+	ListEnvironmentTemplateVersions(context.Context, *proton.ListEnvironmentTemplateVersionsInput, ...func(*proton.Options)) (*proton.ListEnvironmentTemplateVersionsOutput, error)
+
+	ListServiceTemplates(context.Context, *proton.ListServiceTemplatesInput, ...func(*proton.Options)) (*proton.ListServiceTemplatesOutput, error)
+	GetServiceTemplate(context.Context, *proton.GetServiceTemplateInput, ...func(*proton.Options)) (*proton.GetServiceTemplateOutput, error)
+	CreateServiceTemplate(context.Context, *proton.CreateServiceTemplateInput, ...func(*proton.Options)) (*proton.CreateServiceTemplateOutput, error)
+	UpdateServiceTemplate(context.Context, *proton.UpdateServiceTemplateInput, ...func(*proton.Options)) (*proton.UpdateServiceTemplateOutput, error)
+	GetServiceTemplateVersion(context.Context, *proton.GetServiceTemplateVersionInput, ...func(*proton.Options)) (*proton.GetServiceTemplateVersionOutput, error)
+	CreateServiceTemplateVersion(context.Context, *proton.CreateServiceTemplateVersionInput, ...func(*proton.Options)) (*proton.CreateServiceTemplateVersionOutput, error)
+	UpdateServiceTemplateVersion(context.Context, *proton.UpdateServiceTemplateVersionInput, ...func(*proton.Options)) (*proton.UpdateServiceTemplateVersionOutput, error)
+	DeleteServiceTemplateVersion(context.Context, *proton.DeleteServiceTemplateVersionInput, ...func(*proton.Options)) (*proton.DeleteServiceTemplateVersionOutput, error)
+	ListServiceTemplateVersions(context.Context, *proton.ListServiceTemplateVersionsInput, ...func(*proton.Options)) (*proton.ListServiceTemplateVersionsOutput, error)
+
+	ListEnvironments(context.Context, *proton.ListEnvironmentsInput, ...func(*proton.Options)) (*proton.ListEnvironmentsOutput, error)
+	GetEnvironment(context.Context, *proton.GetEnvironmentInput, ...func(*proton.Options)) (*proton.GetEnvironmentOutput, error)
+	UpdateEnvironment(context.Context, *proton.UpdateEnvironmentInput, ...func(*proton.Options)) (*proton.UpdateEnvironmentOutput, error)
+	ListServices(context.Context, *proton.ListServicesInput, ...func(*proton.Options)) (*proton.ListServicesOutput, error)
+	ListServiceInstances(context.Context, *proton.ListServiceInstancesInput, ...func(*proton.Options)) (*proton.ListServiceInstancesOutput, error)
+	GetServiceInstance(context.Context, *proton.GetServiceInstanceInput, ...func(*proton.Options)) (*proton.GetServiceInstanceOutput, error)
+	UpdateServiceInstance(context.Context, *proton.UpdateServiceInstanceInput, ...func(*proton.Options)) (*proton.UpdateServiceInstanceOutput, error)
+	CreateEnvironment(context.Context, *proton.CreateEnvironmentInput, ...func(*proton.Options)) (*proton.CreateEnvironmentOutput, error)
+	DeleteEnvironment(context.Context, *proton.DeleteEnvironmentInput, ...func(*proton.Options)) (*proton.DeleteEnvironmentOutput, error)
+	ListEnvironmentOutputs(context.Context, *proton.ListEnvironmentOutputsInput, ...func(*proton.Options)) (*proton.ListEnvironmentOutputsOutput, error)
+	GetService(context.Context, *proton.GetServiceInput, ...func(*proton.Options)) (*proton.GetServiceOutput, error)
+	CreateService(context.Context, *proton.CreateServiceInput, ...func(*proton.Options)) (*proton.CreateServiceOutput, error)
+	DeleteService(context.Context, *proton.DeleteServiceInput, ...func(*proton.Options)) (*proton.DeleteServiceOutput, error)
+	ListServiceInstanceOutputs(context.Context, *proton.ListServiceInstanceOutputsInput, ...func(*proton.Options)) (*proton.ListServiceInstanceOutputsOutput, error)
+	ListServicePipelineOutputs(context.Context, *proton.ListServicePipelineOutputsInput, ...func(*proton.Options)) (*proton.ListServicePipelineOutputsOutput, error)
+
+	GetRepository(context.Context, *proton.GetRepositoryInput, ...func(*proton.Options)) (*proton.GetRepositoryOutput, error)
+	CreateRepository(context.Context, *proton.CreateRepositoryInput, ...func(*proton.Options)) (*proton.CreateRepositoryOutput, error)
+	GetTemplateSyncConfig(context.Context, *proton.GetTemplateSyncConfigInput, ...func(*proton.Options)) (*proton.GetTemplateSyncConfigOutput, error)
+	CreateTemplateSyncConfig(context.Context, *proton.CreateTemplateSyncConfigInput, ...func(*proton.Options)) (*proton.CreateTemplateSyncConfigOutput, error)
+	UpdateTemplateSyncConfig(context.Context, *proton.UpdateTemplateSyncConfigInput, ...func(*proton.Options)) (*proton.UpdateTemplateSyncConfigOutput, error)
+	GetTemplateSyncStatus(context.Context, *proton.GetTemplateSyncStatusInput, ...func(*proton.Options)) (*proton.GetTemplateSyncStatusOutput, error)
+	GetServiceSyncConfig(context.Context, *getServiceSyncConfigInput, ...func(*proton.Options)) (*getServiceSyncConfigOutput, error)
+	CreateServiceSyncConfig(context.Context, *createServiceSyncConfigInput, ...func(*proton.Options)) (*createServiceSyncConfigOutput, error)
+	UpdateServiceSyncConfig(context.Context, *updateServiceSyncConfigInput, ...func(*proton.Options)) (*updateServiceSyncConfigOutput, error)
+
+	TagResource(context.Context, *proton.TagResourceInput, ...func(*proton.Options)) (*proton.TagResourceOutput, error)
+	ListTagsForResource(context.Context, *proton.ListTagsForResourceInput, ...func(*proton.Options)) (*proton.ListTagsForResourceOutput, error)
+}
+
+// S3API is the subset of the Amazon S3 client used by protonizer.
+// It is satisfied by *s3.Client and can be replaced by a fake in tests.
+type S3API interface {
+	manager.UploadAPIClient
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// loads aws config using the --region, --profile and --role-arn flags,
+// falling back to the sdk's default environment and shared config settings
+func getAWSConfig() aws.Config {
+	ctx := context.Background()
+	opts := []func(*config.LoadOptions) error{
+		config.WithRetryer(func() aws.Retryer {
+			//retry throttled calls more persistently than the sdk default
+			return retry.AddWithMaxAttempts(retry.NewStandard(), awsMaxAttempts)
+		}),
+	}
+	if flagAWSRegion != "" {
+		opts = append(opts, config.WithRegion(flagAWSRegion))
+	}
+	if flagAWSProfile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(flagAWSProfile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	handleError("aws config", err)
+
+	if cfg.Region == "" {
+		errorExit(`Please specify the AWS region using the --region flag, the "AWS_REGION" environment variable, or an AWS profile.
+
+For example:
+protonizer publish --region us-east-1
+OR
+export AWS_REGION=us-east-1`)
+	}
+
+	//validate endpoint overrides up front rather than on the first api call
+	for _, u := range []string{flagAWSEndpointURL, flagAWSProtonEndpointURL, flagAWSS3EndpointURL} {
+		if err := validateEndpointURL(u); err != nil {
+			errorExit(err)
+		}
+	}
+
+	if flagAWSRoleArn != "" {
+		cfg.Credentials = assumeRoleCredentials(cfg, flagAWSRoleArn)
+	}
+
+	return cfg
+}
+
+// returns credentials for an iam role, assumed using an aws config's credentials
+func assumeRoleCredentials(cfg aws.Config, roleArn string) aws.CredentialsProvider {
+	debug("assuming role", roleArn)
+	stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if flagAWSEndpointURL != "" {
+			o.EndpointResolver = sts.EndpointResolverFromURL(flagAWSEndpointURL)
+		}
+	})
+	return aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, roleArn,
+		func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = fmt.Sprintf("protonizer-%d", time.Now().UTC().Unix())
+		}))
+}
+
+// returns an error if an endpoint override isn't an absolute url
+func validateEndpointURL(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("endpoint url: %q is invalid. use an absolute url such as http://localhost:4566", endpoint)
+	}
+	return nil
+}
+
+// returns the endpoint override for a service, if any
+func endpointURL(serviceURL string) string {
+	if serviceURL != "" {
+		return serviceURL
+	}
+	return flagAWSEndpointURL
+}
+
+// returns a proton client for an aws config
+func newProtonClient(cfg aws.Config) ProtonAPI {
+	u := endpointURL(flagAWSProtonEndpointURL)
+	client := proton.NewFromConfig(cfg, func(o *proton.Options) {
+		if u != "" {
+			o.EndpointResolver = proton.EndpointResolverFromURL(u)
+		}
+	})
+	return &serviceSyncProtonClient{Client: client, cfg: cfg, endpoint: u}
+}
+
+// returns an s3 client for an aws config
+func newS3Client(cfg aws.Config) S3API {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if u := endpointURL(flagAWSS3EndpointURL); u != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(u)
+
+			//stand-ins generally don't support virtual hosted buckets
+			o.UsePathStyle = true
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func TestValidateEndpointURL(t *testing.T) {
+	for _, u := range []string{"", "http://localhost:4566", "https://proton.example.com"} {
+		if err := validateEndpointURL(u); err != nil {
+			t.Errorf("expecting %q to be valid: %v", u, err)
+		}
+	}
+	for _, u := range []string{"localhost:4566", "/proton", "http://"} {
+		if err := validateEndpointURL(u); err == nil {
+			t.Errorf("expecting %q to be invalid", u)
+		}
+	}
+}
+
+func TestEndpointURL(t *testing.T) {
+	defer func() { flagAWSEndpointURL = "" }()
+
+	flagAWSEndpointURL = "http://localhost:4566"
+	if u := endpointURL(""); u != flagAWSEndpointURL {
+		t.Error("expecting general endpoint, got", u)
+	}
+	if u := endpointURL("http://localhost:5000"); u != "http://localhost:5000" {
+		t.Error("expecting service endpoint to take precedence, got", u)
+	}
+}
+
+func TestNewProtonClientEndpointURL(t *testing.T) {
+	target := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Write([]byte(`{"environmentTemplate":{"name":"my_env","arn":"arn:aws:proton:us-east-1:123456789012:environment-template/my_env"}}`))
+	}))
+	defer server.Close()
+
+	flagAWSProtonEndpointURL = server.URL
+	defer func() { flagAWSProtonEndpointURL = "" }()
+
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}
+	out, err := newProtonClient(cfg).GetEnvironmentTemplate(context.Background(),
+		&proton.GetEnvironmentTemplateInput{Name: aws.String("my_env")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(target, "GetEnvironmentTemplate") {
+		t.Error("expecting request to be sent to endpoint override, got target", target)
+	}
+	if aws.ToString(out.EnvironmentTemplate.Name) != "my_env" {
+		t.Error("unexpected response", out.EnvironmentTemplate)
+	}
+}
+
+func TestProtonClientServiceSyncConfig(t *testing.T) {
+	var targets, auth []string
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		auth = append(auth, r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if strings.HasSuffix(r.Header.Get("X-Amz-Target"), "GetServiceSyncConfig") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"com.amazonaws.proton#ResourceNotFoundException","message":"not found"}`))
+			return
+		}
+		w.Write([]byte(`{"serviceSyncConfig":{"serviceName":"my-service","repositoryProvider":"GITHUB",` +
+			`"repositoryName":"my-org/my-service","branch":"main","filePath":".aws/proton/proton-ops.yaml"}}`))
+	}))
+	defer server.Close()
+
+	flagAWSProtonEndpointURL = server.URL
+	defer func() { flagAWSProtonEndpointURL = "" }()
+
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}
+	client := newProtonClient(cfg)
+	_, err := client.GetServiceSyncConfig(context.Background(),
+		&getServiceSyncConfigInput{ServiceName: aws.String("my-service")})
+	if !isNotFound(err) {
+		t.Error("expecting a not found error, got", err)
+	}
+
+	out, err := client.CreateServiceSyncConfig(context.Background(), &createServiceSyncConfigInput{
+		ServiceName:        aws.String("my-service"),
+		RepositoryProvider: types.RepositoryProviderGithub,
+		RepositoryName:     aws.String("my-org/my-service"),
+		Branch:             aws.String("main"),
+		FilePath:           aws.String(protonOpsFile),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"AwsProton20200720.GetServiceSyncConfig", "AwsProton20200720.CreateServiceSyncConfig"}
+	if strings.Join(targets, ",") != strings.Join(expected, ",") {
+		t.Errorf("expecting targets %v, got %v", expected, targets)
+	}
+	for _, a := range auth {
+		if !strings.HasPrefix(a, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(a, "/us-east-1/proton/") {
+			t.Error("expecting a signed proton request, got", a)
+		}
+	}
+	if body["serviceName"] != "my-service" || body["repositoryProvider"] != "GITHUB" || body["filePath"] != protonOpsFile {
+		t.Error("unexpected request body", body)
+	}
+	if out.ServiceSyncConfig == nil || aws.ToString(out.ServiceSyncConfig.Branch) != "main" {
+		t.Error("unexpected response", out.ServiceSyncConfig)
+	}
+}
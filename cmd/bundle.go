@@ -3,31 +3,121 @@ package cmd
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagBundleFile          string
+	flagBundleOut           string
+	flagBundleScanAllowlist string
 )
 
-func createTarGZFile(source, target string) error {
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Creates a reproducible template bundle",
+	Long: `Creates the same reproducible template bundle that publish uploads to S3, without publishing it.
+The template is scanned for secrets before it is bundled.`,
+	Run: doBundle,
+	Example: `
+# Bundle the template in the current directory
+protonizer bundle
+
+# Bundle a template to a specific file
+protonizer bundle -f my_template/v1/proton.yaml -o /tmp/my_template.tar.gz`,
+}
+
+func init() {
+	bundleCmd.Flags().StringVarP(&flagBundleFile, "file", "f", "proton.yaml", "The proton yaml file to use")
+	bundleCmd.Flags().StringVarP(&flagBundleOut, "out", "o", "bundle.tar.gz", "The bundle file to create")
+	bundleCmd.Flags().StringVar(&flagBundleScanAllowlist, "scan-allowlist", "",
+		"A file listing secret scan false positives (file or file:line). Defaults to "+scanAllowlistFile+" in the template directory")
+	rootCmd.AddCommand(bundleCmd)
+}
+
+func doBundle(cmd *cobra.Command, args []string) {
+
+	//assume template bundle is in the same directory as the proton.yaml file
+	dir := filepath.Dir(flagBundleFile)
+	scanTemplateOrExit(dir, flagBundleScanAllowlist, "bundle")
+
+	m := "creating template bundle: " + flagBundleOut
+	debug(m)
+	hash, err := createTarGZFile(dir, flagBundleOut)
+	handleError(m, err)
+
+	fmt.Println("bundle:", flagBundleOut)
+	fmt.Println("sha256:", hash)
+}
+
+// tag key used to store a bundle's SHA-256 on a template version
+const bundleHashTagKey = "protonizer-bundle-sha256"
+
+// tag key used to store the s3 location a template version's bundle was published to
+const bundleSourceTagKey = "protonizer-bundle-source"
+
+// fixed timestamp written to every bundle entry so that bundles are reproducible
+var bundleModTime = time.Unix(0, 0).UTC()
+
+// creates a reproducible tar.gz of the source directory and returns its SHA-256.
+// entries are sorted, timestamps and ownership are normalized,
+// and executable bits are preserved so that the same template
+// always produces a byte-for-byte identical bundle
+func createTarGZFile(source, target string) (string, error) {
+
+	paths, err := bundlePaths(source, target)
+	if err != nil {
+		return "", err
+	}
 
 	f, err := os.Create(target)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer f.Close()
 
-	gw := gzip.NewWriter(f)
-	defer gw.Close()
+	hash := sha256.New()
+	gw := gzip.NewWriter(io.MultiWriter(f, hash))
 	tw := tar.NewWriter(gw)
-	defer tw.Close()
 
-	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+	for _, p := range paths {
+		err = addBundleEntry(tw, source, p)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	//flush the tar and gzip streams before reading the hash
+	if err = tw.Close(); err != nil {
+		return "", err
+	}
+	if err = gw.Close(); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// returns the sorted list of paths to include in a bundle
+func bundlePaths(source, target string) ([]string, error) {
+	paths := []string{}
+	err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
+		//the root is implied by the archive
+		if path == source {
 			return nil
 		}
 
@@ -38,35 +128,75 @@ func createTarGZFile(source, target string) error {
 
 		//ignore .git
 		if strings.Contains(path, ".git") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		header, err := tar.FileInfoHeader(info, info.Name())
-		if err != nil {
-			return err
-		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		header.Name = strings.TrimPrefix(path, source)
-		if err != nil {
-			return err
-		}
+	//sort by archive name so that entry order never depends on the walk
+	sort.Slice(paths, func(i, j int) bool {
+		return bundleEntryName(source, paths[i]) < bundleEntryName(source, paths[j])
+	})
+	return paths, nil
+}
 
-		if info.IsDir() {
-			header.Name += "/"
-		}
+// returns the archive name of a path relative to the bundle source
+func bundleEntryName(source, path string) string {
+	rel, err := filepath.Rel(source, path)
+	if err != nil {
+		rel = strings.TrimPrefix(path, source)
+	}
+	return filepath.ToSlash(rel)
+}
 
-		err = tw.WriteHeader(header)
-		if err != nil {
-			return err
-		}
+// writes a single normalized entry to the archive
+func addBundleEntry(tw *tar.Writer, source, path string) error {
 
-		f, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
 
-		_, err = io.Copy(tw, f)
+	header := &tar.Header{
+		Name:    bundleEntryName(source, path),
+		ModTime: bundleModTime,
+		Format:  tar.FormatPAX,
+	}
+
+	if info.IsDir() {
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		header.Mode = 0755
+		return tw.WriteHeader(header)
+	}
+
+	//only keep the executable bit so that umask differences don't leak into the bundle
+	header.Typeflag = tar.TypeReg
+	header.Mode = 0644
+	if info.Mode().Perm()&0111 != 0 {
+		header.Mode = 0755
+	}
+	header.Size = info.Size()
+
+	err = tw.WriteHeader(header)
+	if err != nil {
 		return err
-	})
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
 }
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes a small template directory for bundling
+func internalWriteBundleSource(t *testing.T, dir string, modTime time.Time) {
+	files := map[string]os.FileMode{
+		"proton.yaml":                         0644,
+		"schema/schema.yaml":                  0600,
+		"infrastructure/manifest.yaml":        0664,
+		"infrastructure/output.sh":            0775,
+		"infrastructure/install-terraform.sh": 0700,
+	}
+	for f, mode := range files {
+		p := filepath.Join(dir, f)
+		err := os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(p, []byte(f), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chmod(p, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(p, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreateTarGZFileReproducible(t *testing.T) {
+
+	dir1 := t.TempDir()
+	internalWriteBundleSource(t, dir1, time.Now())
+
+	dir2 := t.TempDir()
+	internalWriteBundleSource(t, dir2, time.Now().Add(-48*time.Hour))
+
+	hash1, err := createTarGZFile(dir1, filepath.Join(dir1, "bundle.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash2, err := createTarGZFile(dir2, filepath.Join(dir2, "bundle.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(hash1)
+	t.Log(hash2)
+
+	if hash1 != hash2 {
+		t.Error("expecting identical bundles for identical content")
+	}
+
+	b1, _ := os.ReadFile(filepath.Join(dir1, "bundle.tar.gz"))
+	b2, _ := os.ReadFile(filepath.Join(dir2, "bundle.tar.gz"))
+	if string(b1) != string(b2) {
+		t.Error("expecting byte-for-byte identical bundles")
+	}
+}
+
+func TestCreateTarGZFileEntries(t *testing.T) {
+
+	dir := t.TempDir()
+	internalWriteBundleSource(t, dir, time.Now())
+	target := filepath.Join(dir, "bundle.tar.gz")
+
+	_, err := createTarGZFile(dir, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+
+	expected := []struct {
+		name string
+		mode int64
+	}{
+		{"infrastructure/", 0755},
+		{"infrastructure/install-terraform.sh", 0755},
+		{"infrastructure/manifest.yaml", 0644},
+		{"infrastructure/output.sh", 0755},
+		{"proton.yaml", 0644},
+		{"schema/", 0755},
+		{"schema/schema.yaml", 0644},
+	}
+
+	i := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(h.Name, h.Mode, h.ModTime)
+		if i >= len(expected) {
+			t.Fatal("unexpected entry", h.Name)
+		}
+		if h.Name != expected[i].name {
+			t.Errorf("expecting entry %s, got %s", expected[i].name, h.Name)
+		}
+		if h.Mode != expected[i].mode {
+			t.Errorf("expecting mode %o for %s, got %o", expected[i].mode, h.Name, h.Mode)
+		}
+		if !h.ModTime.Equal(bundleModTime) || h.Uid != 0 || h.Gid != 0 || h.Uname != "" {
+			t.Error("expecting normalized timestamp and ownership for", h.Name)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("expecting %d entries, got %d", len(expected), i)
+	}
+}
@@ -0,0 +1,478 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	flagDeployTemplate                string
+	flagDeploySpec                    string
+	flagDeployName                    string
+	flagDeployDir                     string
+	flagDeployTimeout                 time.Duration
+	flagDeployProtonRoleArn           string
+	flagDeployCodebuildRoleArn        string
+	flagDeployRepositoryConnectionArn string
+	flagDeployRepositoryID            string
+	flagDeployBranch                  string
+)
+
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "Deploys test environments and services from spec files",
+	Long: `Deploys test environments and services from spec files, to try out a template.
+
+The spec is validated against the local template's schema/schema.yaml before anything is created.
+Deployment status is shown until the deployment finishes, followed by its outputs.
+Use destroy to tear down what was deployed.`,
+}
+
+var deployEnvironmentCmd = &cobra.Command{
+	Use:   "environment",
+	Short: "Creates an environment from an environment spec file",
+	Args:  cobra.NoArgs,
+	Run:   doDeployEnvironment,
+	Example: `
+# Create test-env from major version 1 of the template in the current directory
+protonizer deploy environment --template my_env_template:1 --spec spec.yaml --name test-env \
+  --proton-role-arn arn:aws:iam::123456789012:role/ProtonServiceRole`,
+}
+
+var deployServiceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Creates a service from a service spec file",
+	Args:  cobra.NoArgs,
+	Run:   doDeployService,
+	Example: `
+# Create test-svc from version 1.2 of the template in my_svc_template/v1
+protonizer deploy service --template my_svc_template:1.2 --spec spec.yaml --name test-svc --dir my_svc_template/v1
+
+# Create a service with a pipeline
+protonizer deploy service --template my_svc_template:1 --spec spec.yaml --name test-svc \
+  --repository-connection-arn arn:aws:codestar-connections:us-east-1:123456789012:connection/abc \
+  --repository-id my-org/my-service --branch main`,
+}
+
+// an environment spec file
+type environmentSpec struct {
+	Proton string                 `yaml:"proton"`
+	Spec   map[string]interface{} `yaml:"spec"`
+}
+
+// the proton value of environment spec files
+const environmentSpecKind = "EnvironmentSpec"
+
+// the outputs of an environment, service instance or pipeline
+type deploymentOutputs struct {
+	Name    string
+	Outputs []types.Output
+}
+
+func init() {
+	for _, c := range []*cobra.Command{deployEnvironmentCmd, deployServiceCmd} {
+		c.Flags().StringVarP(&flagDeployTemplate, "template", "t", "",
+			"The template and version to deploy, in the format name:major[.minor] (required)")
+		c.Flags().StringVarP(&flagDeploySpec, "spec", "s", "",
+			"The spec file to deploy (required)")
+		c.Flags().StringVarP(&flagDeployName, "name", "n", "",
+			"The name of the environment or service to create (required)")
+		c.Flags().StringVar(&flagDeployDir, "dir", ".",
+			"The local template directory whose schema/schema.yaml the spec is validated against")
+		c.Flags().DurationVar(&flagDeployTimeout, "timeout", 60*time.Minute,
+			"How long to wait for the deployment to finish")
+		c.MarkFlagRequired("template")
+		c.MarkFlagRequired("spec")
+		c.MarkFlagRequired("name")
+	}
+	deployEnvironmentCmd.Flags().StringVar(&flagDeployProtonRoleArn, "proton-role-arn", "",
+		"The IAM role proton uses to provision the environment")
+	deployEnvironmentCmd.Flags().StringVar(&flagDeployCodebuildRoleArn, "codebuild-role-arn", "",
+		"The IAM role used for CodeBuild provisioning")
+	deployServiceCmd.Flags().StringVar(&flagDeployRepositoryConnectionArn, "repository-connection-arn", "",
+		"The CodeStar connection for the service's source repository. Required for templates with a pipeline")
+	deployServiceCmd.Flags().StringVar(&flagDeployRepositoryID, "repository-id", "",
+		"The service's source repository, in the format owner/repo. Required for templates with a pipeline")
+	deployServiceCmd.Flags().StringVar(&flagDeployBranch, "branch", "",
+		"The branch of the service's source repository. Required for templates with a pipeline")
+
+	deployCmd.AddCommand(deployEnvironmentCmd)
+	deployCmd.AddCommand(deployServiceCmd)
+	rootCmd.AddCommand(deployCmd)
+}
+
+func doDeployEnvironment(cmd *cobra.Command, args []string) {
+	template, major, minor, err := parseDeployTemplate(flagDeployTemplate)
+	if err != nil {
+		errorExit(err)
+	}
+	spec, err := os.ReadFile(flagDeploySpec)
+	handleError("reading spec", err)
+	schema, err := readLocalSchema(flagDeployDir)
+	handleError("reading schema", err)
+	if err := validateEnvironmentSpec(schema, spec); err != nil {
+		errorExit(fmt.Sprintf("%s is not valid:\n%v", flagDeploySpec, err))
+	}
+
+	cfg := getAWSConfig()
+	ctx, cancel := context.WithTimeout(context.Background(), flagDeployTimeout)
+	defer cancel()
+
+	fmt.Printf("creating environment %s from %s \n", flagDeployName, flagDeployTemplate)
+	outputs, _, err := deployEnvironment(ctx, newProtonClient(cfg), &proton.CreateEnvironmentInput{
+		Name:                 aws.String(flagDeployName),
+		TemplateName:         aws.String(template),
+		TemplateMajorVersion: aws.String(major),
+		TemplateMinorVersion: optionalString(minor),
+		Spec:                 aws.String(string(spec)),
+		ProtonServiceRoleArn: optionalString(flagDeployProtonRoleArn),
+		CodebuildRoleArn:     optionalString(flagDeployCodebuildRoleArn),
+	}, pollInitialDelay, pollMaxDelay, os.Stdout)
+	if err != nil {
+		if errors.Is(err, errDeploymentFailed) {
+			errorExit(fmt.Sprintf("%v. use destroy environment %s to clean up", err, flagDeployName))
+		}
+		handleError("deploying environment", err)
+	}
+	printDeploymentOutputs(os.Stdout, outputs)
+}
+
+func doDeployService(cmd *cobra.Command, args []string) {
+	template, major, minor, err := parseDeployTemplate(flagDeployTemplate)
+	if err != nil {
+		errorExit(err)
+	}
+	spec, err := os.ReadFile(flagDeploySpec)
+	handleError("reading spec", err)
+	schema, err := readLocalSchema(flagDeployDir)
+	handleError("reading schema", err)
+	if err := validateServiceSpec(schema, spec); err != nil {
+		errorExit(fmt.Sprintf("%s is not valid:\n%v", flagDeploySpec, err))
+	}
+	hasPipeline := schema.Schema.PipelineInputType != ""
+	if hasPipeline && (flagDeployRepositoryConnectionArn == "" || flagDeployRepositoryID == "" || flagDeployBranch == "") {
+		errorExit("the template has a pipeline. --repository-connection-arn, --repository-id and --branch are required")
+	}
+
+	cfg := getAWSConfig()
+	ctx, cancel := context.WithTimeout(context.Background(), flagDeployTimeout)
+	defer cancel()
+
+	fmt.Printf("creating service %s from %s \n", flagDeployName, flagDeployTemplate)
+	outputs, _, err := deployService(ctx, newProtonClient(cfg), &proton.CreateServiceInput{
+		Name:                    aws.String(flagDeployName),
+		TemplateName:            aws.String(template),
+		TemplateMajorVersion:    aws.String(major),
+		TemplateMinorVersion:    optionalString(minor),
+		Spec:                    aws.String(string(spec)),
+		RepositoryConnectionArn: optionalString(flagDeployRepositoryConnectionArn),
+		RepositoryId:            optionalString(flagDeployRepositoryID),
+		BranchName:              optionalString(flagDeployBranch),
+	}, hasPipeline, pollInitialDelay, pollMaxDelay, os.Stdout)
+	if err != nil {
+		if errors.Is(err, errDeploymentFailed) {
+			errorExit(fmt.Sprintf("%v. use destroy service %s to clean up", err, flagDeployName))
+		}
+		handleError("deploying service", err)
+	}
+	printDeploymentOutputs(os.Stdout, outputs)
+}
+
+// parses a template in the format name:major[.minor]
+func parseDeployTemplate(s string) (string, string, string, error) {
+	name, version, _ := strings.Cut(s, ":")
+	major, minor, _ := strings.Cut(version, ".")
+	invalid := func(v string) bool {
+		_, err := strconv.Atoi(v)
+		return err != nil
+	}
+	if name == "" || invalid(major) || (strings.Contains(version, ".") && invalid(minor)) {
+		return "", "", "", fmt.Errorf("template: %q must use the format: name:major[.minor]", s)
+	}
+	return name, major, minor, nil
+}
+
+// reads the schema of a local template directory
+func readLocalSchema(dir string) (*templateSchema, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "schema", "schema.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplateSchema(b)
+}
+
+// validates an environment spec against a template schema, returning all problems found
+func validateEnvironmentSpec(schema *templateSchema, b []byte) error {
+	var spec environmentSpec
+	if err := yaml.Unmarshal(b, &spec); err != nil {
+		return err
+	}
+	inputType, err := schema.inputType(schema.Schema.EnvironmentInputType)
+	if err != nil {
+		return err
+	}
+	if inputType == nil {
+		return errors.New("the schema has no environment_input_type")
+	}
+
+	problems := []string{}
+	if spec.Proton != environmentSpecKind {
+		problems = append(problems, "proton: must be "+environmentSpecKind)
+	}
+	problems = append(problems, inputType.validateInputs(spec.Spec, "spec.")...)
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// validates a service spec against a template schema, returning all problems found
+func validateServiceSpec(schema *templateSchema, b []byte) error {
+	var spec serviceSpec
+	if err := yaml.Unmarshal(b, &spec); err != nil {
+		return err
+	}
+	serviceType, err := schema.inputType(schema.Schema.ServiceInputType)
+	if err != nil {
+		return err
+	}
+	if serviceType == nil {
+		return errors.New("the schema has no service_input_type")
+	}
+	pipelineType, err := schema.inputType(schema.Schema.PipelineInputType)
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	if spec.Proton != serviceSpecKind {
+		add("proton: must be %s", serviceSpecKind)
+	}
+	if len(spec.Instances) == 0 {
+		add("instances: at least one service instance is required")
+	}
+	seen := map[string]bool{}
+	for i, instance := range spec.Instances {
+		if instance.Name == "" || instance.Environment == "" {
+			add("instances[%d]: name and environment are required", i)
+			continue
+		}
+		if seen[instance.Name] {
+			add("instances[%d]: %s is specified more than once", i, instance.Name)
+		}
+		seen[instance.Name] = true
+		problems = append(problems, serviceType.validateInputs(instance.Spec, instance.Name+".spec.")...)
+	}
+	switch {
+	case pipelineType != nil:
+		problems = append(problems, pipelineType.validateInputs(spec.Pipeline, "pipeline.")...)
+	case spec.Pipeline != nil:
+		add("pipeline: the template has no pipeline")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// returns a report func that writes a deployment's status when it changes
+func deploymentStatusWriter(w io.Writer) func(*templateDeployment) {
+	last := map[string]types.DeploymentStatus{}
+	return func(d *templateDeployment) {
+		if last[d.String()] != d.DeploymentStatus {
+			last[d.String()] = d.DeploymentStatus
+			fmt.Fprintln(w, d, d.DeploymentStatus)
+		}
+	}
+}
+
+// creates an environment and waits for it to deploy, returning its outputs and
+// whether the environment was created, which is true even if it then failed to deploy
+func deployEnvironment(ctx context.Context, protonClient ProtonAPI, in *proton.CreateEnvironmentInput,
+	initial, max time.Duration, w io.Writer) ([]deploymentOutputs, bool, error) {
+
+	m := "proton.CreateEnvironment()"
+	debug(m)
+	if _, err := protonClient.CreateEnvironment(ctx, in); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", m, err)
+	}
+
+	d := templateDeployment{Name: aws.ToString(in.Name), Environment: aws.ToString(in.Name)}
+	err := waitForDeployment(ctx, protonClient, environmentKind{}, d, initial, max, deploymentStatusWriter(w))
+	if err != nil {
+		return nil, true, err
+	}
+
+	outputs := []types.Output{}
+	p := proton.NewListEnvironmentOutputsPaginator(protonClient, &proton.ListEnvironmentOutputsInput{EnvironmentName: in.Name})
+	for p.HasMorePages() {
+		m := "proton.ListEnvironmentOutputs()"
+		debug(m)
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: %w", m, err)
+		}
+		outputs = append(outputs, out.Outputs...)
+	}
+	return []deploymentOutputs{{Name: d.Name, Outputs: outputs}}, true, nil
+}
+
+// creates a service and waits for its instances and pipeline to deploy,
+// returning their outputs and whether the service was created, which is true
+// even if it then failed to deploy
+func deployService(ctx context.Context, protonClient ProtonAPI, in *proton.CreateServiceInput, hasPipeline bool,
+	initial, max time.Duration, w io.Writer) ([]deploymentOutputs, bool, error) {
+
+	m := "proton.CreateService()"
+	debug(m)
+	if _, err := protonClient.CreateService(ctx, in); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", m, err)
+	}
+
+	//the service is active once its instances and pipeline are created
+	name := aws.ToString(in.Name)
+	var last types.ServiceStatus
+	err := pollWithBackoff(ctx, initial, max, func(ctx context.Context) (bool, error) {
+		m := "proton.GetService()"
+		debug(m)
+		out, err := protonClient.GetService(ctx, &proton.GetServiceInput{Name: in.Name})
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", m, err)
+		}
+		s := out.Service
+		if s.Status != last {
+			last = s.Status
+			fmt.Fprintln(w, name, s.Status)
+		}
+		switch {
+		case s.Status == types.ServiceStatusActive:
+			return true, nil
+		case strings.Contains(string(s.Status), "FAILED"):
+			msg := aws.ToString(s.StatusMessage)
+			if msg == "" {
+				msg = "no status message"
+			}
+			return false, fmt.Errorf("%w: %s: %s", errDeploymentFailed, s.Status, msg)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, true, err
+	}
+
+	//then wait for each instance to deploy
+	instances := []templateDeployment{}
+	instancePages := proton.NewListServiceInstancesPaginator(protonClient, &proton.ListServiceInstancesInput{ServiceName: in.Name})
+	for instancePages.HasMorePages() {
+		m := "proton.ListServiceInstances()"
+		debug(m)
+		out, err := instancePages.NextPage(ctx)
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, i := range out.ServiceInstances {
+			instances = append(instances, templateDeployment{Name: aws.ToString(i.Name), Service: name})
+		}
+	}
+
+	result := []deploymentOutputs{}
+	report := deploymentStatusWriter(w)
+	for _, d := range instances {
+		if err := waitForDeployment(ctx, protonClient, serviceKind{}, d, initial, max, report); err != nil {
+			return nil, true, fmt.Errorf("%s: %w", d, err)
+		}
+		outputs := []types.Output{}
+		p := proton.NewListServiceInstanceOutputsPaginator(protonClient, &proton.ListServiceInstanceOutputsInput{
+			ServiceName:         in.Name,
+			ServiceInstanceName: aws.String(d.Name),
+		})
+		for p.HasMorePages() {
+			m := "proton.ListServiceInstanceOutputs()"
+			debug(m)
+			out, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, true, fmt.Errorf("%s: %w", m, err)
+			}
+			outputs = append(outputs, out.Outputs...)
+		}
+		result = append(result, deploymentOutputs{Name: d.String(), Outputs: outputs})
+	}
+
+	if hasPipeline {
+		d := templateDeployment{Name: "pipeline", Service: name}
+		if err := waitForDeployment(ctx, protonClient, servicePipeline{}, d, initial, max, report); err != nil {
+			return nil, true, fmt.Errorf("%s: %w", d, err)
+		}
+		outputs := []types.Output{}
+		p := proton.NewListServicePipelineOutputsPaginator(protonClient, &proton.ListServicePipelineOutputsInput{ServiceName: in.Name})
+		for p.HasMorePages() {
+			m := "proton.ListServicePipelineOutputs()"
+			debug(m)
+			out, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, true, fmt.Errorf("%s: %w", m, err)
+			}
+			outputs = append(outputs, out.Outputs...)
+		}
+		result = append(result, deploymentOutputs{Name: name + " pipeline", Outputs: outputs})
+	}
+	return result, true, nil
+}
+
+// gets the deployment of a service's pipeline, which proton reports on the service
+type servicePipeline struct{}
+
+func (servicePipeline) getDeployment(ctx context.Context, c ProtonAPI, d templateDeployment) (*templateDeployment, error) {
+	m := "proton.GetService()"
+	debug(m)
+	out, err := c.GetService(ctx, &proton.GetServiceInput{Name: &d.Service})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	p := out.Service.Pipeline
+	if p == nil {
+		return nil, fmt.Errorf("service %s has no pipeline", d.Service)
+	}
+	return &templateDeployment{
+		Name:             d.Name,
+		Service:          d.Service,
+		MajorVersion:     aws.ToString(p.TemplateMajorVersion),
+		MinorVersion:     aws.ToString(p.TemplateMinorVersion),
+		DeploymentStatus: p.DeploymentStatus,
+		StatusMessage:    aws.ToString(p.DeploymentStatusMessage),
+	}, nil
+}
+
+// prints a table of outputs for each deployment
+func printDeploymentOutputs(w io.Writer, deployments []deploymentOutputs) {
+	for _, d := range deployments {
+		fmt.Fprintf(w, "\n%s outputs:\n", d.Name)
+		if len(d.Outputs) == 0 {
+			fmt.Fprintln(w, "none")
+			continue
+		}
+		tw := newTableWriter(w)
+		fmt.Fprintln(tw, "OUTPUT\tVALUE")
+		for _, o := range d.Outputs {
+			fmt.Fprintf(tw, "%s\t%s\n", aws.ToString(o.Key), cell(aws.ToString(o.ValueString)))
+		}
+		tw.Flush()
+	}
+}
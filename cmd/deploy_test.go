@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+const testEnvironmentSchema = `schema:
+  format:
+    openapi: "3.0.0"
+  environment_input_type: EnvironmentInput
+  types:
+    EnvironmentInput:
+      type: object
+      properties:
+        vpc_cidr:
+          type: string
+          default: 10.0.0.0/16
+        azs:
+          type: integer
+      required:
+        - azs
+`
+
+func TestParseDeployTemplate(t *testing.T) {
+	name, major, minor, err := parseDeployTemplate("vpc:1")
+	if err != nil || name != "vpc" || major != "1" || minor != "" {
+		t.Errorf("unexpected result %s %s %s %v", name, major, minor, err)
+	}
+	name, major, minor, err = parseDeployTemplate("vpc:2.3")
+	if err != nil || name != "vpc" || major != "2" || minor != "3" {
+		t.Errorf("unexpected result %s %s %s %v", name, major, minor, err)
+	}
+	for _, s := range []string{"vpc", "vpc:", ":1", "vpc:a", "vpc:1.", "vpc:1.b"} {
+		if _, _, _, err := parseDeployTemplate(s); err == nil {
+			t.Error("expecting an error for", s)
+		}
+	}
+}
+
+func TestValidateEnvironmentSpec(t *testing.T) {
+	schema, err := parseTemplateSchema([]byte(testEnvironmentSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateEnvironmentSpec(schema, []byte("proton: EnvironmentSpec\nspec:\n  azs: 2\n")); err != nil {
+		t.Errorf("expecting a valid spec, got %v", err)
+	}
+	err = validateEnvironmentSpec(schema, []byte("proton: ServiceSpec\nspec:\n  vpc_cidr: 10\n"))
+	if err == nil {
+		t.Fatal("expecting problems")
+	}
+	for _, expected := range []string{"proton: must be EnvironmentSpec", "spec.azs: is required", "spec.vpc_cidr: 10 is not a valid string"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting %q in:\n%v", expected, err)
+		}
+	}
+}
+
+func TestValidateServiceSpec(t *testing.T) {
+	schema, err := parseTemplateSchema([]byte(testServiceSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := `proton: ServiceSpec
+pipeline:
+  unit_tests: true
+instances:
+  - name: dev
+    environment: dev-env
+    spec:
+      image: nginx
+`
+	if err := validateServiceSpec(schema, []byte(valid)); err != nil {
+		t.Errorf("expecting a valid spec, got %v", err)
+	}
+
+	invalid := `proton: ServiceSpec
+pipeline:
+  unit_tests: "yes"
+instances:
+  - name: dev
+    spec:
+      image: nginx
+  - name: prod
+    environment: prod-env
+    spec:
+      port: 80
+`
+	err = validateServiceSpec(schema, []byte(invalid))
+	if err == nil {
+		t.Fatal("expecting problems")
+	}
+	for _, expected := range []string{
+		"instances[0]: name and environment are required",
+		"prod.spec.image: is required",
+		"pipeline.unit_tests: yes is not a valid boolean",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting %q in:\n%v", expected, err)
+		}
+	}
+}
+
+func TestDeployEnvironment(t *testing.T) {
+	client := newFakeProton()
+	client.outputs["test-env"] = []types.Output{{Key: aws.String("VpcId"), ValueString: aws.String("vpc-123")}}
+
+	var out bytes.Buffer
+	outputs, created, err := deployEnvironment(context.Background(), client, &proton.CreateEnvironmentInput{
+		Name:                 aws.String("test-env"),
+		TemplateName:         aws.String("vpc"),
+		TemplateMajorVersion: aws.String("1"),
+		Spec:                 aws.String("proton: EnvironmentSpec\n"),
+	}, time.Millisecond, time.Millisecond, &out)
+	if err != nil || !created {
+		t.Fatal(created, err)
+	}
+	if out.String() != "test-env SUCCEEDED\n" {
+		t.Errorf("unexpected status output:\n%s", out.String())
+	}
+
+	out.Reset()
+	printDeploymentOutputs(&out, outputs)
+	if !strings.Contains(out.String(), "test-env outputs:") || !strings.Contains(out.String(), "vpc-123") {
+		t.Errorf("expecting outputs in:\n%s", out.String())
+	}
+
+	//a second environment that fails to deploy
+	client.deploymentFailures["bad-env"] = "stack rollback"
+	_, created, err = deployEnvironment(context.Background(), client, &proton.CreateEnvironmentInput{
+		Name:                 aws.String("bad-env"),
+		TemplateName:         aws.String("vpc"),
+		TemplateMajorVersion: aws.String("1"),
+	}, time.Millisecond, time.Millisecond, &out)
+	if !errors.Is(err, errDeploymentFailed) || !strings.Contains(err.Error(), "stack rollback") {
+		t.Errorf("expecting a failed deployment, got %v", err)
+	}
+	if !created {
+		t.Error("expecting a failed deployment to be reported as created")
+	}
+
+	//an environment that already exists isn't created
+	_, created, err = deployEnvironment(context.Background(), client, &proton.CreateEnvironmentInput{
+		Name:                 aws.String("test-env"),
+		TemplateName:         aws.String("vpc"),
+		TemplateMajorVersion: aws.String("1"),
+	}, time.Millisecond, time.Millisecond, &out)
+	var conflict *types.ConflictException
+	if !errors.As(err, &conflict) || created {
+		t.Errorf("expecting a conflict and nothing created, got %v %v", created, err)
+	}
+}
+
+func TestDeployService(t *testing.T) {
+	client := newFakeProton()
+	client.outputs["api/dev"] = []types.Output{{Key: aws.String("Url"), ValueString: aws.String("https://dev")}}
+	spec := `proton: ServiceSpec
+instances:
+  - name: dev
+    environment: dev-env
+  - name: prod
+    environment: prod-env
+`
+	var out bytes.Buffer
+	outputs, created, err := deployService(context.Background(), client, &proton.CreateServiceInput{
+		Name:                 aws.String("api"),
+		TemplateName:         aws.String("api"),
+		TemplateMajorVersion: aws.String("1"),
+		Spec:                 aws.String(spec),
+		RepositoryId:         aws.String("my-org/api"),
+	}, true, time.Millisecond, time.Millisecond, &out)
+	if err != nil || !created {
+		t.Fatal(created, err)
+	}
+	if out.String() != "api ACTIVE\napi/dev SUCCEEDED\napi/prod SUCCEEDED\napi/pipeline SUCCEEDED\n" {
+		t.Errorf("unexpected status output:\n%s", out.String())
+	}
+	names := []string{}
+	for _, o := range outputs {
+		names = append(names, o.Name)
+	}
+	if strings.Join(names, ",") != "api/dev,api/prod,api pipeline" {
+		t.Errorf("unexpected outputs %v", names)
+	}
+	if len(outputs[0].Outputs) != 1 {
+		t.Errorf("expecting dev's url output, got %v", outputs[0].Outputs)
+	}
+
+	//a pipeline that fails to deploy fails the service before its outputs are read
+	client.deploymentFailures["web/pipeline"] = "build failed"
+	client.calls = nil
+	_, created, err = deployService(context.Background(), client, &proton.CreateServiceInput{
+		Name:                 aws.String("web"),
+		TemplateName:         aws.String("api"),
+		TemplateMajorVersion: aws.String("1"),
+		Spec:                 aws.String(spec),
+		RepositoryId:         aws.String("my-org/web"),
+	}, true, time.Millisecond, time.Millisecond, &out)
+	if !errors.Is(err, errDeploymentFailed) || !strings.Contains(err.Error(), "web/pipeline: deployment failed: FAILED: build failed") || !created {
+		t.Errorf("expecting a failed pipeline, got %v %v", created, err)
+	}
+	if internalCalled(client, "ListServicePipelineOutputs") {
+		t.Error("not expecting pipeline outputs to be read for a failed pipeline")
+	}
+
+	client.deploymentFailures["broken"] = "invalid spec"
+	_, _, err = deployService(context.Background(), client, &proton.CreateServiceInput{
+		Name:                 aws.String("broken"),
+		TemplateName:         aws.String("api"),
+		TemplateMajorVersion: aws.String("1"),
+		Spec:                 aws.String(spec),
+	}, false, time.Millisecond, time.Millisecond, &out)
+	if !errors.Is(err, errDeploymentFailed) || !strings.Contains(err.Error(), "CREATE_FAILED: invalid spec") {
+		t.Errorf("expecting a failed service, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/spf13/cobra"
+)
+
+const (
+	//proton has no deprecated status, so deprecation is recorded as a tag
+	deprecatedTagKey = "protonizer-deprecated"
+
+	//prefix added to a deprecated version's description
+	deprecatedDescriptionPrefix = "DEPRECATED"
+)
+
+var (
+	flagDeprecateMessage string
+	flagDeprecateForce   bool
+)
+
+var deprecateCmd = &cobra.Command{
+	Use:   "deprecate <template> <major.minor>...",
+	Short: "Marks template versions as deprecated",
+	Long: `Marks template versions as deprecated.
+Proton has no deprecated status, so deprecated versions are tagged with ` + deprecatedTagKey + `
+and their description is prefixed with "` + deprecatedDescriptionPrefix + `".
+The recommended version can't be deprecated unless --force is used.`,
+	Args: cobra.MinimumNArgs(2),
+	Run:  doDeprecate,
+	Example: `
+# Deprecate a version
+protonizer deprecate my_template 1.2
+
+# Deprecate several versions with a message
+protonizer deprecate my_template 1.0 1.1 1.2 --message "use 1.3 or later"`,
+}
+
+func init() {
+	deprecateCmd.Flags().StringVarP(&flagDeprecateMessage, "message", "m", "",
+		"A message explaining the deprecation, added to the version description")
+	deprecateCmd.Flags().BoolVar(&flagDeprecateForce, "force", false,
+		"Deprecate the template's recommended version")
+	rootCmd.AddCommand(deprecateCmd)
+}
+
+// returned when the recommended version would be deprecated without --force
+var errDeprecateRecommended = errors.New("the recommended version can't be deprecated without --force")
+
+func doDeprecate(cmd *cobra.Command, args []string) {
+	name := args[0]
+	for _, arg := range args[1:] {
+		if _, _, err := parseTemplateVersion(arg); err != nil {
+			errorExit(err)
+		}
+	}
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	versions, err := deprecateTemplateVersions(ctx, newProtonClient(cfg), name, args[1:], flagDeprecateMessage, flagDeprecateForce)
+	for _, version := range versions {
+		fmt.Printf("deprecated %s:%s.%s \n", name, version.MajorVersion, version.MinorVersion)
+	}
+	if errors.Is(err, errTemplateNotFound) || errors.Is(err, errTemplateVersionNotFound) || errors.Is(err, errDeprecateRecommended) {
+		errorExit(err)
+	}
+	handleError("deprecating template versions", err)
+}
+
+// deprecates template versions in the format major.minor, returning those deprecated.
+// every version is checked before any are changed
+func deprecateTemplateVersions(ctx context.Context, protonClient ProtonAPI,
+	name string, args []string, message string, force bool) ([]*templateVersionInfo, error) {
+
+	template, kind, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		return nil, err
+	}
+
+	//validate every version before changing anything
+	versions := []*templateVersionInfo{}
+	for _, arg := range args {
+		major, minor, err := parseTemplateVersion(arg)
+		if err != nil {
+			return nil, err
+		}
+		if arg == template.RecommendedVersion && !force {
+			return nil, fmt.Errorf("%w: %s:%s is the recommended version", errDeprecateRecommended, name, arg)
+		}
+		version, err := kind.getVersion(ctx, protonClient, name, major, minor)
+		if err != nil {
+			if isNotFound(err) {
+				return nil, fmt.Errorf("%w: %s:%s", errTemplateVersionNotFound, name, arg)
+			}
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	deprecated := []*templateVersionInfo{}
+	for _, version := range versions {
+		if err := deprecateTemplateVersion(ctx, protonClient, kind, name, version, message); err != nil {
+			return deprecated, err
+		}
+		deprecated = append(deprecated, version)
+	}
+	return deprecated, nil
+}
+
+// tags a template version as deprecated and prefixes its description
+func deprecateTemplateVersion(ctx context.Context, protonClient ProtonAPI,
+	kind templateKind, name string, version *templateVersionInfo, message string) error {
+
+	m := "proton.TagResource()"
+	debug(m)
+	_, err := protonClient.TagResource(ctx, &proton.TagResourceInput{
+		ResourceArn: &version.Arn,
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(deprecatedTagKey),
+				Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+
+	desc := deprecatedDescription(version.Description, message)
+	return kind.updateVersion(ctx, protonClient, name,
+		version.MajorVersion, version.MinorVersion, "", &desc)
+}
+
+// returns a version description marked as deprecated, in the format:
+// DEPRECATED[: message][ | original description].
+// re-deprecating a version replaces the previous message
+func deprecatedDescription(description, message string) string {
+	if strings.HasPrefix(description, deprecatedDescriptionPrefix) {
+		//previously deprecated: keep the original description
+		original := ""
+		if i := strings.Index(description, " | "); i != -1 {
+			original = description[i+3:]
+		}
+		description = original
+	}
+	result := deprecatedDescriptionPrefix
+	if message != "" {
+		result += ": " + message
+	}
+	if description != "" {
+		result += " | " + description
+	}
+	//proton descriptions are limited to 500 characters. trim whole runes so
+	//a multi-byte character is never split
+	for len(result) > 500 {
+		_, size := utf8.DecodeLastRuneInString(result)
+		result = result[:len(result)-size]
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func TestDeprecatedDescription(t *testing.T) {
+	cases := []struct {
+		description string
+		message     string
+		expected    string
+	}{
+		{"", "", "DEPRECATED"},
+		{"published by proton cli", "", "DEPRECATED | published by proton cli"},
+		{"published by proton cli", "use 1.3", "DEPRECATED: use 1.3 | published by proton cli"},
+		{"DEPRECATED: use 1.3 | published by proton cli", "use 2.0", "DEPRECATED: use 2.0 | published by proton cli"},
+		{"DEPRECATED", "use 2.0", "DEPRECATED: use 2.0"},
+	}
+	for _, c := range cases {
+		result := deprecatedDescription(c.description, c.message)
+		if result != c.expected {
+			t.Errorf("expecting %q, got %q", c.expected, result)
+		}
+	}
+}
+
+func TestDeprecatedDescriptionTruncated(t *testing.T) {
+	//multi-byte characters straddling the limit aren't split
+	result := deprecatedDescription(strings.Repeat("é", 300), "use 2.0")
+	if len(result) > 500 || !utf8.ValidString(result) {
+		t.Errorf("expecting valid utf-8 within 500 bytes, got %d bytes: %q", len(result), result)
+	}
+	if !strings.HasPrefix(result, "DEPRECATED: use 2.0 | éé") || len(result) < 499 {
+		t.Errorf("expecting the description to be trimmed at the limit, got %q", result)
+	}
+}
+
+func TestDeprecateTemplateVersions(t *testing.T) {
+	client := newFakeProton()
+	template := client.addTemplate("service", "api")
+	template.recommended = "1.0"
+	v0 := client.addVersion("service", "api", "1", "0", types.TemplateVersionStatusPublished)
+	v0.description = "first"
+	client.addVersion("service", "api", "1", "1", types.TemplateVersionStatusDraft)
+	ctx := context.Background()
+
+	//the recommended version needs --force, and nothing is changed
+	_, err := deprecateTemplateVersions(ctx, client, "api", []string{"1.0"}, "use 1.1", false)
+	if !errors.Is(err, errDeprecateRecommended) {
+		t.Errorf("expecting an error deprecating the recommended version, got %v", err)
+	}
+	if internalCalled(client, "TagResource") {
+		t.Error("not expecting the recommended version to be deprecated")
+	}
+
+	//once 1.1 is promoted it's recommended instead, so 1.0 can be deprecated
+	if err := promoteTemplateVersion(ctx, client, "api", "1", "1", ""); err != nil {
+		t.Fatal(err)
+	}
+	deprecated, err := deprecateTemplateVersions(ctx, client, "api", []string{"1.0"}, "use 1.1", false)
+	if err != nil || len(deprecated) != 1 {
+		t.Fatal(deprecated, err)
+	}
+	if v0.description != "DEPRECATED: use 1.1 | first" {
+		t.Errorf("unexpected description %q", v0.description)
+	}
+	if _, tagged := client.tags[v0.arn][deprecatedTagKey]; !tagged {
+		t.Errorf("expecting 1.0 to be tagged as deprecated, got %v", client.tags[v0.arn])
+	}
+
+	//every version is checked before any are deprecated
+	v1, _ := client.getVersion("service", "api", "1", "1")
+	_, err = deprecateTemplateVersions(ctx, client, "api", []string{"1.1", "1.9"}, "", true)
+	if !errors.Is(err, errTemplateVersionNotFound) {
+		t.Errorf("expecting 1.9 not to be found, got %v", err)
+	}
+	if strings.HasPrefix(v1.description, deprecatedDescriptionPrefix) {
+		t.Error("not expecting 1.1 to be deprecated when 1.9 doesn't exist")
+	}
+
+	//the recommended version can be deprecated with --force
+	if _, err := deprecateTemplateVersions(ctx, client, "api", []string{"1.1"}, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(v1.description, deprecatedDescriptionPrefix) {
+		t.Errorf("expecting 1.1 to be deprecated, got %q", v1.description)
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagDescribeOutput string
+)
+
+var describeCmd = &cobra.Command{
+	Use:   "describe <template>[:major.minor]",
+	Short: "Describes a template or template version",
+	Long: `Describes a template and its versions, or a single template version
+including its compatible environments and schema.`,
+	Args: cobra.ExactArgs(1),
+	Run:  doDescribe,
+	Example: `
+# Describe a template and list its versions
+protonizer describe my_template
+
+# Describe a template version, including its schema
+protonizer describe my_template:1.3
+
+# Describe a template version as yaml
+protonizer describe my_template:1.3 -o yaml`,
+}
+
+// a template and its versions, as shown by describe
+type templateDescription struct {
+	templateInfo `yaml:",inline"`
+	Versions     []templateVersionInfo `json:"versions" yaml:"versions"`
+}
+
+// a template version and its template, as shown by describe
+type templateVersionDescription struct {
+	templateVersionInfo `yaml:",inline"`
+	Template            string `json:"template" yaml:"template"`
+	Type                string `json:"type" yaml:"type"`
+	Recommended         bool   `json:"recommended" yaml:"recommended"`
+}
+
+func init() {
+	describeCmd.Flags().StringVarP(&flagDescribeOutput, "output", "o", outputTable,
+		"The output format: table, json or yaml")
+	rootCmd.AddCommand(describeCmd)
+}
+
+func doDescribe(cmd *cobra.Command, args []string) {
+	if err := validateOutputFormat(flagDescribeOutput); err != nil {
+		errorExit(err)
+	}
+	name, version, hasVersion := strings.Cut(args[0], ":")
+	var major, minor string
+	if hasVersion {
+		var err error
+		major, minor, err = parseTemplateVersion(version)
+		if err != nil {
+			errorExit(err)
+		}
+	}
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	protonClient := newProtonClient(cfg)
+
+	template, kind, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		if errors.Is(err, errTemplateNotFound) {
+			errorExit(err)
+		}
+		handleError("getting template", err)
+	}
+
+	if !hasVersion {
+		versions, err := listAllTemplateVersions(ctx, protonClient, kind, name)
+		handleError("listing template versions", err)
+		sortTemplateVersions(versions)
+		err = printTemplateDescription(os.Stdout, &templateDescription{*template, versions}, flagDescribeOutput)
+		handleError("printing template", err)
+		return
+	}
+
+	v, err := kind.getVersion(ctx, protonClient, name, major, minor)
+	if err != nil {
+		if isNotFound(err) {
+			errorExit(fmt.Sprintf("template version %s:%s.%s not found", name, major, minor))
+		}
+		handleError("getting template version", err)
+	}
+	desc := &templateVersionDescription{
+		templateVersionInfo: *v,
+		Template:            name,
+		Type:                template.Type,
+		Recommended:         version == template.RecommendedVersion,
+	}
+	err = printTemplateVersionDescription(os.Stdout, desc, flagDescribeOutput)
+	handleError("printing template version", err)
+}
+
+func printTemplateDescription(w io.Writer, desc *templateDescription, format string) error {
+	return printOutput(w, format, desc, func(w io.Writer) {
+		fmt.Fprintf(w, "Name:\t%s\n", desc.Name)
+		fmt.Fprintf(w, "Type:\t%s\n", desc.Type)
+		fmt.Fprintf(w, "ARN:\t%s\n", desc.Arn)
+		fmt.Fprintf(w, "Display name:\t%s\n", cell(desc.DisplayName))
+		fmt.Fprintf(w, "Description:\t%s\n", cell(desc.Description))
+		fmt.Fprintf(w, "Provisioning:\t%s\n", cell(string(desc.Provisioning)))
+		fmt.Fprintf(w, "Encryption key:\t%s\n", cell(desc.EncryptionKey))
+		fmt.Fprintf(w, "Recommended version:\t%s\n", cell(desc.RecommendedVersion))
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "VERSION\tSTATUS\tRECOMMENDED\tDESCRIPTION")
+		for _, v := range desc.Versions {
+			version := v.MajorVersion + "." + v.MinorVersion
+			recommended := ""
+			if version == desc.RecommendedVersion {
+				recommended = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", version, v.Status, cell(recommended), cell(v.Description))
+		}
+	})
+}
+
+func printTemplateVersionDescription(w io.Writer, desc *templateVersionDescription, format string) error {
+	return printOutput(w, format, desc, func(w io.Writer) {
+		fmt.Fprintf(w, "Template:\t%s\n", desc.Template)
+		fmt.Fprintf(w, "Type:\t%s\n", desc.Type)
+		fmt.Fprintf(w, "Version:\t%s.%s\n", desc.MajorVersion, desc.MinorVersion)
+		fmt.Fprintf(w, "ARN:\t%s\n", desc.Arn)
+		fmt.Fprintf(w, "Status:\t%s\n", desc.Status)
+		if desc.StatusMessage != "" {
+			fmt.Fprintf(w, "Status message:\t%s\n", desc.StatusMessage)
+		}
+		fmt.Fprintf(w, "Recommended:\t%t\n", desc.Recommended)
+		fmt.Fprintf(w, "Description:\t%s\n", cell(desc.Description))
+		if desc.Type == "service" {
+			fmt.Fprintf(w, "Compatible environments:\t%s\n", cell(strings.Join(desc.CompatibleEnvironments, ", ")))
+		}
+
+		//the schema is printed as is, outside of the aligned columns
+		fmt.Fprintln(w, "Schema:")
+		for _, line := range strings.Split(strings.TrimRight(desc.Schema, "\n"), "\n") {
+			fmt.Fprintln(w, "  "+strings.ReplaceAll(line, "\t", "  "))
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/spf13/cobra"
+)
+
+var flagDestroyTimeout time.Duration
+
+var destroyCmd = &cobra.Command{
+	Use:   "destroy",
+	Short: "Deletes environments and services created by deploy",
+	Long: `Deletes environments and services created by deploy,
+waiting until proton has torn down their infrastructure.`,
+}
+
+var destroyEnvironmentCmd = &cobra.Command{
+	Use:   "environment <name>",
+	Short: "Deletes an environment",
+	Args:  cobra.ExactArgs(1),
+	Run:   doDestroyEnvironment,
+	Example: `
+# Delete test-env
+protonizer destroy environment test-env`,
+}
+
+var destroyServiceCmd = &cobra.Command{
+	Use:   "service <name>",
+	Short: "Deletes a service, including its instances and pipeline",
+	Args:  cobra.ExactArgs(1),
+	Run:   doDestroyService,
+	Example: `
+# Delete test-svc
+protonizer destroy service test-svc`,
+}
+
+// returned when a resource fails to delete
+var errDeleteFailed = errors.New("delete failed")
+
+func init() {
+	for _, c := range []*cobra.Command{destroyEnvironmentCmd, destroyServiceCmd} {
+		c.Flags().DurationVar(&flagDestroyTimeout, "timeout", 60*time.Minute,
+			"How long to wait for the delete to finish")
+	}
+	destroyCmd.AddCommand(destroyEnvironmentCmd)
+	destroyCmd.AddCommand(destroyServiceCmd)
+	rootCmd.AddCommand(destroyCmd)
+}
+
+func doDestroyEnvironment(cmd *cobra.Command, args []string) {
+	name := args[0]
+	cfg := getAWSConfig()
+	ctx, cancel := context.WithTimeout(context.Background(), flagDestroyTimeout)
+	defer cancel()
+
+	err := destroyEnvironment(ctx, newProtonClient(cfg), name, pollInitialDelay, pollMaxDelay, os.Stdout)
+	if err != nil {
+		if isNotFound(err) {
+			errorExit(fmt.Sprintf("environment %s not found", name))
+		}
+		if errors.Is(err, errDeleteFailed) {
+			errorExit(err)
+		}
+		handleError("destroying environment", err)
+	}
+	fmt.Printf("deleted environment %s \n", name)
+}
+
+func doDestroyService(cmd *cobra.Command, args []string) {
+	name := args[0]
+	cfg := getAWSConfig()
+	ctx, cancel := context.WithTimeout(context.Background(), flagDestroyTimeout)
+	defer cancel()
+
+	err := destroyService(ctx, newProtonClient(cfg), name, pollInitialDelay, pollMaxDelay, os.Stdout)
+	if err != nil {
+		if isNotFound(err) {
+			errorExit(fmt.Sprintf("service %s not found", name))
+		}
+		if errors.Is(err, errDeleteFailed) {
+			errorExit(err)
+		}
+		handleError("destroying service", err)
+	}
+	fmt.Printf("deleted service %s \n", name)
+}
+
+// deletes an environment and waits for it to be gone
+func destroyEnvironment(ctx context.Context, protonClient ProtonAPI, name string,
+	initial, max time.Duration, w io.Writer) error {
+
+	m := "proton.DeleteEnvironment()"
+	debug(m, name)
+	if _, err := protonClient.DeleteEnvironment(ctx, &proton.DeleteEnvironmentInput{Name: &name}); err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+
+	report := deploymentStatusWriter(w)
+	return pollWithBackoff(ctx, initial, max, func(ctx context.Context) (bool, error) {
+		d, err := environmentKind{}.getDeployment(ctx, protonClient, templateDeployment{Name: name})
+		if isNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		report(d)
+		if d.DeploymentStatus == types.DeploymentStatusDeleteFailed {
+			return false, fmt.Errorf("%w: %s: %s", errDeleteFailed, name, cell(d.StatusMessage))
+		}
+		return false, nil
+	})
+}
+
+// deletes a service, including its instances and pipeline, and waits for it to be gone
+func destroyService(ctx context.Context, protonClient ProtonAPI, name string,
+	initial, max time.Duration, w io.Writer) error {
+
+	m := "proton.DeleteService()"
+	debug(m, name)
+	if _, err := protonClient.DeleteService(ctx, &proton.DeleteServiceInput{Name: &name}); err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+
+	var last types.ServiceStatus
+	return pollWithBackoff(ctx, initial, max, func(ctx context.Context) (bool, error) {
+		m := "proton.GetService()"
+		debug(m)
+		out, err := protonClient.GetService(ctx, &proton.GetServiceInput{Name: &name})
+		if isNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", m, err)
+		}
+		s := out.Service
+		if s.Status != last {
+			last = s.Status
+			fmt.Fprintln(w, name, s.Status)
+		}
+		if s.Status == types.ServiceStatusDeleteFailed {
+			return false, fmt.Errorf("%w: %s: %s", errDeleteFailed, name, cell(aws.ToString(s.StatusMessage)))
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func TestDestroyEnvironment(t *testing.T) {
+	client := newFakeProton()
+	client.addEnvironment("test-env", "vpc", "1", "0")
+	client.addEnvironment("stuck-env", "vpc", "1", "0")
+	client.deleteFailures["stuck-env"] = "resources in use"
+
+	var out bytes.Buffer
+	if err := destroyEnvironment(context.Background(), client, "test-env", time.Millisecond, time.Millisecond, &out); err != nil {
+		t.Fatal(err)
+	}
+	err := destroyEnvironment(context.Background(), client, "test-env", time.Millisecond, time.Millisecond, &out)
+	if !isNotFound(err) {
+		t.Errorf("expecting a deleted environment not to be found, got %v", err)
+	}
+
+	err = destroyEnvironment(context.Background(), client, "stuck-env", time.Millisecond, time.Millisecond, &out)
+	if !errors.Is(err, errDeleteFailed) {
+		t.Errorf("expecting the delete to fail, got %v", err)
+	}
+}
+
+func TestDestroyService(t *testing.T) {
+	client := newFakeProton()
+	client.services["api"] = &types.Service{Name: aws.String("api"), Status: types.ServiceStatusActive}
+	client.addServiceInstance("api", "dev", "dev-env", "api", "1", "0")
+
+	var out bytes.Buffer
+	if err := destroyService(context.Background(), client, "api", time.Millisecond, time.Millisecond, &out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (serviceKind{}).getDeployment(context.Background(), client, templateDeployment{Service: "api", Name: "dev"}); !isNotFound(err) {
+		t.Errorf("expecting the service's instances to be deleted, got %v", err)
+	}
+
+	client.services["stuck"] = &types.Service{Name: aws.String("stuck"), Status: types.ServiceStatusActive}
+	client.deleteFailures["stuck"] = "pipeline in use"
+	err := destroyService(context.Background(), client, "stuck", time.Millisecond, time.Millisecond, &out)
+	if !errors.Is(err, errDeleteFailed) {
+		t.Errorf("expecting the delete to fail, got %v", err)
+	}
+}
@@ -0,0 +1,1049 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"gopkg.in/yaml.v3"
+)
+
+// an in-memory proton api for tests, safe for concurrent use.
+// template versions finish registering the first time they're read
+type fakeProton struct {
+	mu        sync.Mutex
+	templates map[string]*fakeTemplate
+	versions  map[string][]*fakeVersion
+	tags      map[string]map[string]string
+
+	//linked repositories and template sync, keyed by provider/name and type/name
+	repositories map[string]*types.Repository
+	syncConfigs  map[string]*types.TemplateSyncConfig
+	syncStatus   map[string]*proton.GetTemplateSyncStatusOutput
+
+	//service sync configs, keyed by service name
+	serviceSyncConfigs map[string]*serviceSyncConfig
+
+	//environments and service instances, in the order they were added
+	environments []*fakeDeployment
+	instances    []*fakeDeployment
+
+	//services created with CreateService, and deployment outputs keyed by
+	//environment, service/instance or service/pipeline
+	services map[string]*types.Service
+	outputs  map[string][]types.Output
+
+	//template names whose versions fail to register, with the status message
+	registrationFailures map[string]string
+
+	//environment, service instance and service/pipeline names whose deployments fail, with the status message
+	deploymentFailures map[string]string
+
+	//environment and service names that fail to delete, with the status message
+	deleteFailures map[string]string
+
+	//api calls made, in order
+	calls []string
+}
+
+type fakeTemplate struct {
+	arn           string
+	displayName   string
+	description   string
+	encryptionKey string
+	provisioning  types.Provisioning
+	recommended   string
+}
+
+type fakeVersion struct {
+	arn           string
+	major         string
+	minor         string
+	status        types.TemplateVersionStatus
+	statusMessage string
+	description   string
+	schema        string
+	compatible    []types.CompatibleEnvironmentTemplate
+}
+
+// an environment or service instance deployed from a template
+type fakeDeployment struct {
+	name        string
+	service     string
+	environment string
+	template    string
+	major       string
+	minor       string
+	status      types.DeploymentStatus
+	deleted     bool
+
+	statusMessage string
+}
+
+// the number of results in each page of list deployment calls,
+// small so that tests page through them
+const fakePageSize = 2
+
+var _ ProtonAPI = (*fakeProton)(nil)
+
+func newFakeProton() *fakeProton {
+	return &fakeProton{
+		templates: map[string]*fakeTemplate{},
+		versions:  map[string][]*fakeVersion{},
+		tags:      map[string]map[string]string{},
+
+		repositories: map[string]*types.Repository{},
+		syncConfigs:  map[string]*types.TemplateSyncConfig{},
+		syncStatus:   map[string]*proton.GetTemplateSyncStatusOutput{},
+
+		serviceSyncConfigs: map[string]*serviceSyncConfig{},
+
+		services: map[string]*types.Service{},
+		outputs:  map[string][]types.Output{},
+
+		registrationFailures: map[string]string{},
+		deploymentFailures:   map[string]string{},
+		deleteFailures:       map[string]string{},
+	}
+}
+
+func fakeKey(templateType, name string) string {
+	return templateType + "/" + name
+}
+
+func fakeNotFound(what string) error {
+	return &types.ResourceNotFoundException{Message: aws.String(what + " not found")}
+}
+
+// records an api call, locking the fake until the returned func is called
+func (f *fakeProton) call(name string) func() {
+	f.mu.Lock()
+	f.calls = append(f.calls, name)
+	return f.mu.Unlock
+}
+
+// adds a template, returning it so that tests can set it up further
+func (f *fakeProton) addTemplate(templateType, name string) *fakeTemplate {
+	t := &fakeTemplate{arn: fmt.Sprintf("arn:aws:proton:us-east-1:123456789012:%s-template/%s", templateType, name)}
+	f.templates[fakeKey(templateType, name)] = t
+	return t
+}
+
+// adds a template version with a status
+func (f *fakeProton) addVersion(templateType, name, major, minor string, status types.TemplateVersionStatus) *fakeVersion {
+	v := &fakeVersion{
+		arn:    fmt.Sprintf("arn:aws:proton:us-east-1:123456789012:%s-template/%s:%s.%s", templateType, name, major, minor),
+		major:  major,
+		minor:  minor,
+		status: status,
+	}
+	k := fakeKey(templateType, name)
+	f.versions[k] = append(f.versions[k], v)
+	return v
+}
+
+// adds an environment deployed from an environment template version
+func (f *fakeProton) addEnvironment(name, template, major, minor string) *fakeDeployment {
+	d := &fakeDeployment{name: name, environment: name, template: template, major: major, minor: minor,
+		status: types.DeploymentStatusSucceeded}
+	f.environments = append(f.environments, d)
+	return d
+}
+
+// adds a service instance deployed from a service template version
+func (f *fakeProton) addServiceInstance(service, name, environment, template, major, minor string) *fakeDeployment {
+	d := &fakeDeployment{name: name, service: service, environment: environment, template: template,
+		major: major, minor: minor, status: types.DeploymentStatusSucceeded}
+	f.instances = append(f.instances, d)
+	return d
+}
+
+// returns a deployment, finishing its deployment
+func (f *fakeProton) getDeployment(deployments []*fakeDeployment, service, name string) (*fakeDeployment, error) {
+	for _, d := range deployments {
+		if d.service == service && d.name == name && !d.deleted {
+			if d.status == types.DeploymentStatusDeleteInProgress {
+				msg, fail := f.deleteFailures[name]
+				if !fail {
+					d.deleted = true
+					return nil, fakeNotFound(name)
+				}
+				d.status = types.DeploymentStatusDeleteFailed
+				d.statusMessage = msg
+			}
+			if d.status == types.DeploymentStatusInProgress {
+				d.status = types.DeploymentStatusSucceeded
+				if msg, fail := f.deploymentFailures[name]; fail {
+					d.status = types.DeploymentStatusFailed
+					d.statusMessage = msg
+				}
+			}
+			return d, nil
+		}
+	}
+	return nil, fakeNotFound(name)
+}
+
+// starts deploying a template version
+func (f *fakeProton) updateDeployment(deployments []*fakeDeployment, service, name string,
+	deploymentType types.DeploymentUpdateType, major, minor *string) (*fakeDeployment, error) {
+	d, err := f.getDeployment(deployments, service, name)
+	if err != nil {
+		return nil, err
+	}
+	if d.status == types.DeploymentStatusInProgress {
+		return nil, &types.ConflictException{Message: aws.String(name + " is already deploying")}
+	}
+	if deploymentType == types.DeploymentUpdateTypeMinorVersion && aws.ToString(major) != d.major {
+		return nil, &types.ValidationException{Message: aws.String("major version changes require MAJOR_VERSION")}
+	}
+	d.major = aws.ToString(major)
+	d.minor = aws.ToString(minor)
+	d.status = types.DeploymentStatusInProgress
+	d.statusMessage = ""
+	return d, nil
+}
+
+// returns the bounds of a page of n results starting at a next token, and the following token
+func fakePage(n int, token *string) (int, int, *string) {
+	start, _ := strconv.Atoi(aws.ToString(token))
+	end := start + fakePageSize
+	if end >= n {
+		return start, n, nil
+	}
+	return start, end, aws.String(strconv.Itoa(end))
+}
+
+func (f *fakeProton) getTemplate(templateType, name string) (*fakeTemplate, error) {
+	t, ok := f.templates[fakeKey(templateType, name)]
+	if !ok {
+		return nil, fakeNotFound("template " + name)
+	}
+	return t, nil
+}
+
+func (f *fakeProton) createTemplate(templateType, name string, displayName, description, encryptionKey *string,
+	provisioning types.Provisioning, tags []types.Tag) (*fakeTemplate, error) {
+	if _, ok := f.templates[fakeKey(templateType, name)]; ok {
+		return nil, &types.ConflictException{Message: aws.String("template " + name + " already exists")}
+	}
+	t := f.addTemplate(templateType, name)
+	t.displayName = aws.ToString(displayName)
+	t.description = aws.ToString(description)
+	t.encryptionKey = aws.ToString(encryptionKey)
+	t.provisioning = provisioning
+	f.tag(t.arn, tags)
+	return t, nil
+}
+
+func (f *fakeProton) updateTemplate(templateType, name string, displayName, description *string) (*fakeTemplate, error) {
+	t, err := f.getTemplate(templateType, name)
+	if err != nil {
+		return nil, err
+	}
+	if displayName != nil {
+		t.displayName = *displayName
+	}
+	if description != nil {
+		t.description = *description
+	}
+	return t, nil
+}
+
+// returns a version, finishing its registration
+func (f *fakeProton) getVersion(templateType, name, major, minor string) (*fakeVersion, error) {
+	for _, v := range f.versions[fakeKey(templateType, name)] {
+		if v.major == major && v.minor == minor {
+			if v.status == types.TemplateVersionStatusRegistrationInProgress {
+				v.status = types.TemplateVersionStatusDraft
+				if msg, fail := f.registrationFailures[name]; fail {
+					v.status = types.TemplateVersionStatusRegistrationFailed
+					v.statusMessage = msg
+				}
+			}
+			return v, nil
+		}
+	}
+	return nil, fakeNotFound(fmt.Sprintf("template version %s:%s.%s", name, major, minor))
+}
+
+func (f *fakeProton) createVersion(templateType, name string, major, description *string, tags []types.Tag) (*fakeVersion, error) {
+	if _, err := f.getTemplate(templateType, name); err != nil {
+		return nil, err
+	}
+	next := 0
+	for _, v := range f.versions[fakeKey(templateType, name)] {
+		if n, _ := strconv.Atoi(v.minor); v.major == *major && n >= next {
+			next = n + 1
+		}
+	}
+	v := f.addVersion(templateType, name, *major, strconv.Itoa(next), types.TemplateVersionStatusRegistrationInProgress)
+	v.description = aws.ToString(description)
+	f.tag(v.arn, tags)
+	return v, nil
+}
+
+func (f *fakeProton) updateVersion(templateType, name, major, minor string,
+	status types.TemplateVersionStatus, description *string) (*fakeVersion, error) {
+	v, err := f.getVersion(templateType, name, major, minor)
+	if err != nil {
+		return nil, err
+	}
+	if status != "" {
+		v.status = status
+	}
+	if description != nil {
+		v.description = *description
+	}
+
+	//like proton, the latest published version becomes the recommended version
+	if t, err := f.getTemplate(templateType, name); err == nil && status == types.TemplateVersionStatusPublished {
+		recommendedMajor, recommendedMinor, _ := strings.Cut(t.recommended, ".")
+		if t.recommended == "" || compareTemplateVersions(major, minor, recommendedMajor, recommendedMinor) > 0 {
+			t.recommended = major + "." + minor
+		}
+	}
+	return v, nil
+}
+
+func (f *fakeProton) deleteVersion(templateType, name, major, minor string) (*fakeVersion, error) {
+	k := fakeKey(templateType, name)
+	for i, v := range f.versions[k] {
+		if v.major == major && v.minor == minor {
+			f.versions[k] = append(f.versions[k][:i], f.versions[k][i+1:]...)
+			return v, nil
+		}
+	}
+	return nil, fakeNotFound(fmt.Sprintf("template version %s:%s.%s", name, major, minor))
+}
+
+// lists the versions of a major version. like proton, without a major
+// version only the latest minor version of each major version is listed
+func (f *fakeProton) listVersions(templateType, name string, major *string) []*fakeVersion {
+	result := []*fakeVersion{}
+	latest := map[string]*fakeVersion{}
+	for _, v := range f.versions[fakeKey(templateType, name)] {
+		switch {
+		case major != nil && v.major == *major:
+			result = append(result, v)
+		case major == nil:
+			if l := latest[v.major]; l == nil || compareTemplateVersions(v.major, v.minor, l.major, l.minor) > 0 {
+				latest[v.major] = v
+			}
+		}
+	}
+	for _, v := range latest {
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].arn < result[j].arn
+	})
+	return result
+}
+
+// returns the names of the templates of a type, sorted
+func (f *fakeProton) listTemplates(templateType string) []string {
+	names := []string{}
+	prefix := fakeKey(templateType, "")
+	for k := range f.templates {
+		if strings.HasPrefix(k, prefix) {
+			names = append(names, strings.TrimPrefix(k, prefix))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fakeProton) tag(arn string, tags []types.Tag) {
+	if f.tags[arn] == nil {
+		f.tags[arn] = map[string]string{}
+	}
+	for _, t := range tags {
+		f.tags[arn][aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+}
+
+func (t *fakeTemplate) environmentTemplate(name string) *types.EnvironmentTemplate {
+	return &types.EnvironmentTemplate{
+		Arn:                aws.String(t.arn),
+		Name:               aws.String(name),
+		DisplayName:        aws.String(t.displayName),
+		Description:        aws.String(t.description),
+		EncryptionKey:      optionalString(t.encryptionKey),
+		Provisioning:       t.provisioning,
+		RecommendedVersion: optionalString(t.recommended),
+	}
+}
+
+func (t *fakeTemplate) serviceTemplate(name string) *types.ServiceTemplate {
+	return &types.ServiceTemplate{
+		Arn:                  aws.String(t.arn),
+		Name:                 aws.String(name),
+		DisplayName:          aws.String(t.displayName),
+		Description:          aws.String(t.description),
+		EncryptionKey:        optionalString(t.encryptionKey),
+		PipelineProvisioning: t.provisioning,
+		RecommendedVersion:   optionalString(t.recommended),
+	}
+}
+
+func (v *fakeVersion) environmentVersion(name string) *types.EnvironmentTemplateVersion {
+	return &types.EnvironmentTemplateVersion{
+		Arn:           aws.String(v.arn),
+		TemplateName:  aws.String(name),
+		MajorVersion:  aws.String(v.major),
+		MinorVersion:  aws.String(v.minor),
+		Status:        v.status,
+		StatusMessage: optionalString(v.statusMessage),
+		Description:   aws.String(v.description),
+		Schema:        optionalString(v.schema),
+	}
+}
+
+func (v *fakeVersion) serviceVersion(name string) *types.ServiceTemplateVersion {
+	return &types.ServiceTemplateVersion{
+		Arn:                            aws.String(v.arn),
+		TemplateName:                   aws.String(name),
+		MajorVersion:                   aws.String(v.major),
+		MinorVersion:                   aws.String(v.minor),
+		Status:                         v.status,
+		StatusMessage:                  optionalString(v.statusMessage),
+		Description:                    aws.String(v.description),
+		Schema:                         optionalString(v.schema),
+		CompatibleEnvironmentTemplates: v.compatible,
+	}
+}
+
+func (d *fakeDeployment) protonEnvironment() *types.Environment {
+	return &types.Environment{
+		Name:                    aws.String(d.name),
+		TemplateName:            aws.String(d.template),
+		TemplateMajorVersion:    aws.String(d.major),
+		TemplateMinorVersion:    aws.String(d.minor),
+		DeploymentStatus:        d.status,
+		DeploymentStatusMessage: optionalString(d.statusMessage),
+	}
+}
+
+func (d *fakeDeployment) protonServiceInstance() *types.ServiceInstance {
+	return &types.ServiceInstance{
+		Name:                    aws.String(d.name),
+		ServiceName:             aws.String(d.service),
+		EnvironmentName:         aws.String(d.environment),
+		TemplateName:            aws.String(d.template),
+		TemplateMajorVersion:    aws.String(d.major),
+		TemplateMinorVersion:    aws.String(d.minor),
+		DeploymentStatus:        d.status,
+		DeploymentStatusMessage: optionalString(d.statusMessage),
+	}
+}
+
+// environment templates
+
+func (f *fakeProton) ListEnvironmentTemplates(ctx context.Context, in *proton.ListEnvironmentTemplatesInput, optFns ...func(*proton.Options)) (*proton.ListEnvironmentTemplatesOutput, error) {
+	defer f.call("ListEnvironmentTemplates")()
+	out := &proton.ListEnvironmentTemplatesOutput{}
+	for _, name := range f.listTemplates("environment") {
+		t := f.templates[fakeKey("environment", name)]
+		out.Templates = append(out.Templates, types.EnvironmentTemplateSummary{
+			Arn:                aws.String(t.arn),
+			Name:               aws.String(name),
+			DisplayName:        aws.String(t.displayName),
+			Description:        aws.String(t.description),
+			Provisioning:       t.provisioning,
+			RecommendedVersion: optionalString(t.recommended),
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeProton) GetEnvironmentTemplate(ctx context.Context, in *proton.GetEnvironmentTemplateInput, optFns ...func(*proton.Options)) (*proton.GetEnvironmentTemplateOutput, error) {
+	defer f.call("GetEnvironmentTemplate")()
+	t, err := f.getTemplate("environment", *in.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.GetEnvironmentTemplateOutput{EnvironmentTemplate: t.environmentTemplate(*in.Name)}, nil
+}
+
+func (f *fakeProton) CreateEnvironmentTemplate(ctx context.Context, in *proton.CreateEnvironmentTemplateInput, optFns ...func(*proton.Options)) (*proton.CreateEnvironmentTemplateOutput, error) {
+	defer f.call("CreateEnvironmentTemplate")()
+	t, err := f.createTemplate("environment", *in.Name, in.DisplayName, in.Description, in.EncryptionKey, in.Provisioning, in.Tags)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.CreateEnvironmentTemplateOutput{EnvironmentTemplate: t.environmentTemplate(*in.Name)}, nil
+}
+
+func (f *fakeProton) UpdateEnvironmentTemplate(ctx context.Context, in *proton.UpdateEnvironmentTemplateInput, optFns ...func(*proton.Options)) (*proton.UpdateEnvironmentTemplateOutput, error) {
+	defer f.call("UpdateEnvironmentTemplate")()
+	t, err := f.updateTemplate("environment", *in.Name, in.DisplayName, in.Description)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.UpdateEnvironmentTemplateOutput{EnvironmentTemplate: t.environmentTemplate(*in.Name)}, nil
+}
+
+func (f *fakeProton) GetEnvironmentTemplateVersion(ctx context.Context, in *proton.GetEnvironmentTemplateVersionInput, optFns ...func(*proton.Options)) (*proton.GetEnvironmentTemplateVersionOutput, error) {
+	defer f.call("GetEnvironmentTemplateVersion")()
+	v, err := f.getVersion("environment", *in.TemplateName, *in.MajorVersion, *in.MinorVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.GetEnvironmentTemplateVersionOutput{EnvironmentTemplateVersion: v.environmentVersion(*in.TemplateName)}, nil
+}
+
+func (f *fakeProton) CreateEnvironmentTemplateVersion(ctx context.Context, in *proton.CreateEnvironmentTemplateVersionInput, optFns ...func(*proton.Options)) (*proton.CreateEnvironmentTemplateVersionOutput, error) {
+	defer f.call("CreateEnvironmentTemplateVersion")()
+	v, err := f.createVersion("environment", *in.TemplateName, in.MajorVersion, in.Description, in.Tags)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.CreateEnvironmentTemplateVersionOutput{EnvironmentTemplateVersion: v.environmentVersion(*in.TemplateName)}, nil
+}
+
+func (f *fakeProton) UpdateEnvironmentTemplateVersion(ctx context.Context, in *proton.UpdateEnvironmentTemplateVersionInput, optFns ...func(*proton.Options)) (*proton.UpdateEnvironmentTemplateVersionOutput, error) {
+	defer f.call("UpdateEnvironmentTemplateVersion")()
+	v, err := f.updateVersion("environment", *in.TemplateName, *in.MajorVersion, *in.MinorVersion, in.Status, in.Description)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.UpdateEnvironmentTemplateVersionOutput{EnvironmentTemplateVersion: v.environmentVersion(*in.TemplateName)}, nil
+}
+
+func (f *fakeProton) DeleteEnvironmentTemplateVersion(ctx context.Context, in *proton.DeleteEnvironmentTemplateVersionInput, optFns ...func(*proton.Options)) (*proton.DeleteEnvironmentTemplateVersionOutput, error) {
+	defer f.call("DeleteEnvironmentTemplateVersion")()
+	v, err := f.deleteVersion("environment", *in.TemplateName, *in.MajorVersion, *in.MinorVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.DeleteEnvironmentTemplateVersionOutput{EnvironmentTemplateVersion: v.environmentVersion(*in.TemplateName)}, nil
+}
+
+func (f *fakeProton) ListEnvironmentTemplateVersions(ctx context.Context, in *proton.ListEnvironmentTemplateVersionsInput, optFns ...func(*proton.Options)) (*proton.ListEnvironmentTemplateVersionsOutput, error) {
+	defer f.call("ListEnvironmentTemplateVersions")()
+	if _, err := f.getTemplate("environment", *in.TemplateName); err != nil {
+		return nil, err
+	}
+	out := &proton.ListEnvironmentTemplateVersionsOutput{}
+	for _, v := range f.listVersions("environment", *in.TemplateName, in.MajorVersion) {
+		out.TemplateVersions = append(out.TemplateVersions, types.EnvironmentTemplateVersionSummary{
+			Arn:           aws.String(v.arn),
+			TemplateName:  in.TemplateName,
+			MajorVersion:  aws.String(v.major),
+			MinorVersion:  aws.String(v.minor),
+			Status:        v.status,
+			StatusMessage: optionalString(v.statusMessage),
+			Description:   aws.String(v.description),
+		})
+	}
+	return out, nil
+}
+
+// service templates
+
+func (f *fakeProton) ListServiceTemplates(ctx context.Context, in *proton.ListServiceTemplatesInput, optFns ...func(*proton.Options)) (*proton.ListServiceTemplatesOutput, error) {
+	defer f.call("ListServiceTemplates")()
+	out := &proton.ListServiceTemplatesOutput{}
+	for _, name := range f.listTemplates("service") {
+		t := f.templates[fakeKey("service", name)]
+		out.Templates = append(out.Templates, types.ServiceTemplateSummary{
+			Arn:                  aws.String(t.arn),
+			Name:                 aws.String(name),
+			DisplayName:          aws.String(t.displayName),
+			Description:          aws.String(t.description),
+			PipelineProvisioning: t.provisioning,
+			RecommendedVersion:   optionalString(t.recommended),
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeProton) GetServiceTemplate(ctx context.Context, in *proton.GetServiceTemplateInput, optFns ...func(*proton.Options)) (*proton.GetServiceTemplateOutput, error) {
+	defer f.call("GetServiceTemplate")()
+	t, err := f.getTemplate("service", *in.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.GetServiceTemplateOutput{ServiceTemplate: t.serviceTemplate(*in.Name)}, nil
+}
+
+func (f *fakeProton) CreateServiceTemplate(ctx context.Context, in *proton.CreateServiceTemplateInput, optFns ...func(*proton.Options)) (*proton.CreateServiceTemplateOutput, error) {
+	defer f.call("CreateServiceTemplate")()
+	t, err := f.createTemplate("service", *in.Name, in.DisplayName, in.Description, in.EncryptionKey, in.PipelineProvisioning, in.Tags)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.CreateServiceTemplateOutput{ServiceTemplate: t.serviceTemplate(*in.Name)}, nil
+}
+
+func (f *fakeProton) UpdateServiceTemplate(ctx context.Context, in *proton.UpdateServiceTemplateInput, optFns ...func(*proton.Options)) (*proton.UpdateServiceTemplateOutput, error) {
+	defer f.call("UpdateServiceTemplate")()
+	t, err := f.updateTemplate("service", *in.Name, in.DisplayName, in.Description)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.UpdateServiceTemplateOutput{ServiceTemplate: t.serviceTemplate(*in.Name)}, nil
+}
+
+func (f *fakeProton) GetServiceTemplateVersion(ctx context.Context, in *proton.GetServiceTemplateVersionInput, optFns ...func(*proton.Options)) (*proton.GetServiceTemplateVersionOutput, error) {
+	defer f.call("GetServiceTemplateVersion")()
+	v, err := f.getVersion("service", *in.TemplateName, *in.MajorVersion, *in.MinorVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.GetServiceTemplateVersionOutput{ServiceTemplateVersion: v.serviceVersion(*in.TemplateName)}, nil
+}
+
+func (f *fakeProton) CreateServiceTemplateVersion(ctx context.Context, in *proton.CreateServiceTemplateVersionInput, optFns ...func(*proton.Options)) (*proton.CreateServiceTemplateVersionOutput, error) {
+	defer f.call("CreateServiceTemplateVersion")()
+	if len(in.CompatibleEnvironmentTemplates) == 0 {
+		return nil, &types.ValidationException{Message: aws.String("compatibleEnvironmentTemplates is required")}
+	}
+	v, err := f.createVersion("service", *in.TemplateName, in.MajorVersion, in.Description, in.Tags)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range in.CompatibleEnvironmentTemplates {
+		v.compatible = append(v.compatible, types.CompatibleEnvironmentTemplate{
+			TemplateName: c.TemplateName,
+			MajorVersion: c.MajorVersion,
+		})
+	}
+	return &proton.CreateServiceTemplateVersionOutput{ServiceTemplateVersion: v.serviceVersion(*in.TemplateName)}, nil
+}
+
+func (f *fakeProton) UpdateServiceTemplateVersion(ctx context.Context, in *proton.UpdateServiceTemplateVersionInput, optFns ...func(*proton.Options)) (*proton.UpdateServiceTemplateVersionOutput, error) {
+	defer f.call("UpdateServiceTemplateVersion")()
+	v, err := f.updateVersion("service", *in.TemplateName, *in.MajorVersion, *in.MinorVersion, in.Status, in.Description)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.UpdateServiceTemplateVersionOutput{ServiceTemplateVersion: v.serviceVersion(*in.TemplateName)}, nil
+}
+
+func (f *fakeProton) DeleteServiceTemplateVersion(ctx context.Context, in *proton.DeleteServiceTemplateVersionInput, optFns ...func(*proton.Options)) (*proton.DeleteServiceTemplateVersionOutput, error) {
+	defer f.call("DeleteServiceTemplateVersion")()
+	v, err := f.deleteVersion("service", *in.TemplateName, *in.MajorVersion, *in.MinorVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.DeleteServiceTemplateVersionOutput{ServiceTemplateVersion: v.serviceVersion(*in.TemplateName)}, nil
+}
+
+func (f *fakeProton) ListServiceTemplateVersions(ctx context.Context, in *proton.ListServiceTemplateVersionsInput, optFns ...func(*proton.Options)) (*proton.ListServiceTemplateVersionsOutput, error) {
+	defer f.call("ListServiceTemplateVersions")()
+	if _, err := f.getTemplate("service", *in.TemplateName); err != nil {
+		return nil, err
+	}
+	out := &proton.ListServiceTemplateVersionsOutput{}
+	for _, v := range f.listVersions("service", *in.TemplateName, in.MajorVersion) {
+		out.TemplateVersions = append(out.TemplateVersions, types.ServiceTemplateVersionSummary{
+			Arn:           aws.String(v.arn),
+			TemplateName:  in.TemplateName,
+			MajorVersion:  aws.String(v.major),
+			MinorVersion:  aws.String(v.minor),
+			Status:        v.status,
+			StatusMessage: optionalString(v.statusMessage),
+			Description:   aws.String(v.description),
+		})
+	}
+	return out, nil
+}
+
+// deployments
+
+func (f *fakeProton) ListEnvironments(ctx context.Context, in *proton.ListEnvironmentsInput, optFns ...func(*proton.Options)) (*proton.ListEnvironmentsOutput, error) {
+	defer f.call("ListEnvironments")()
+	matches := []*fakeDeployment{}
+	for _, e := range f.environments {
+		if e.deleted {
+			continue
+		}
+		//like the api, no filters lists every environment
+		matched := len(in.EnvironmentTemplates) == 0
+		for _, filter := range in.EnvironmentTemplates {
+			if filter.TemplateName == nil || filter.MajorVersion == nil {
+				return nil, fmt.Errorf("EnvironmentTemplateFilter: TemplateName and MajorVersion are required")
+			}
+			if e.template == *filter.TemplateName && e.major == *filter.MajorVersion {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, e)
+		}
+	}
+	start, end, next := fakePage(len(matches), in.NextToken)
+	out := &proton.ListEnvironmentsOutput{NextToken: next}
+	for _, e := range matches[start:end] {
+		out.Environments = append(out.Environments, types.EnvironmentSummary{
+			Name:                 aws.String(e.name),
+			TemplateName:         aws.String(e.template),
+			TemplateMajorVersion: aws.String(e.major),
+			TemplateMinorVersion: aws.String(e.minor),
+			DeploymentStatus:     e.status,
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeProton) GetEnvironment(ctx context.Context, in *proton.GetEnvironmentInput, optFns ...func(*proton.Options)) (*proton.GetEnvironmentOutput, error) {
+	defer f.call("GetEnvironment")()
+	e, err := f.getDeployment(f.environments, "", *in.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.GetEnvironmentOutput{Environment: e.protonEnvironment()}, nil
+}
+
+func (f *fakeProton) UpdateEnvironment(ctx context.Context, in *proton.UpdateEnvironmentInput, optFns ...func(*proton.Options)) (*proton.UpdateEnvironmentOutput, error) {
+	defer f.call("UpdateEnvironment")()
+	e, err := f.updateDeployment(f.environments, "", *in.Name, in.DeploymentType, in.TemplateMajorVersion, in.TemplateMinorVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.UpdateEnvironmentOutput{Environment: e.protonEnvironment()}, nil
+}
+
+func (f *fakeProton) ListServices(ctx context.Context, in *proton.ListServicesInput, optFns ...func(*proton.Options)) (*proton.ListServicesOutput, error) {
+	defer f.call("ListServices")()
+	services := []types.ServiceSummary{}
+	seen := map[string]bool{}
+	for _, i := range f.instances {
+		if !seen[i.service] {
+			seen[i.service] = true
+			services = append(services, types.ServiceSummary{
+				Name:         aws.String(i.service),
+				TemplateName: aws.String(i.template),
+				Status:       types.ServiceStatusActive,
+			})
+		}
+	}
+	start, end, next := fakePage(len(services), in.NextToken)
+	return &proton.ListServicesOutput{Services: services[start:end], NextToken: next}, nil
+}
+
+func (f *fakeProton) ListServiceInstances(ctx context.Context, in *proton.ListServiceInstancesInput, optFns ...func(*proton.Options)) (*proton.ListServiceInstancesOutput, error) {
+	defer f.call("ListServiceInstances")()
+	matches := []*fakeDeployment{}
+	for _, i := range f.instances {
+		if i.deleted || in.ServiceName != nil && i.service != *in.ServiceName {
+			continue
+		}
+		match := true
+		for _, filter := range in.Filters {
+			if filter.Key == types.ListServiceInstancesFilterByTemplateName && i.template != aws.ToString(filter.Value) {
+				match = false
+			}
+		}
+		if match {
+			matches = append(matches, i)
+		}
+	}
+	start, end, next := fakePage(len(matches), in.NextToken)
+	out := &proton.ListServiceInstancesOutput{NextToken: next}
+	for _, i := range matches[start:end] {
+		out.ServiceInstances = append(out.ServiceInstances, types.ServiceInstanceSummary{
+			Name:                 aws.String(i.name),
+			ServiceName:          aws.String(i.service),
+			EnvironmentName:      aws.String(i.environment),
+			TemplateName:         aws.String(i.template),
+			TemplateMajorVersion: aws.String(i.major),
+			TemplateMinorVersion: aws.String(i.minor),
+			DeploymentStatus:     i.status,
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeProton) GetServiceInstance(ctx context.Context, in *proton.GetServiceInstanceInput, optFns ...func(*proton.Options)) (*proton.GetServiceInstanceOutput, error) {
+	defer f.call("GetServiceInstance")()
+	i, err := f.getDeployment(f.instances, *in.ServiceName, *in.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.GetServiceInstanceOutput{ServiceInstance: i.protonServiceInstance()}, nil
+}
+
+func (f *fakeProton) UpdateServiceInstance(ctx context.Context, in *proton.UpdateServiceInstanceInput, optFns ...func(*proton.Options)) (*proton.UpdateServiceInstanceOutput, error) {
+	defer f.call("UpdateServiceInstance")()
+	i, err := f.updateDeployment(f.instances, *in.ServiceName, *in.Name, in.DeploymentType, in.TemplateMajorVersion, in.TemplateMinorVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &proton.UpdateServiceInstanceOutput{ServiceInstance: i.protonServiceInstance()}, nil
+}
+
+func (f *fakeProton) CreateEnvironment(ctx context.Context, in *proton.CreateEnvironmentInput, optFns ...func(*proton.Options)) (*proton.CreateEnvironmentOutput, error) {
+	defer f.call("CreateEnvironment")()
+	if _, err := f.getDeployment(f.environments, "", *in.Name); err == nil {
+		return nil, &types.ConflictException{Message: aws.String(*in.Name + " already exists")}
+	}
+	e := f.addEnvironment(*in.Name, *in.TemplateName, *in.TemplateMajorVersion, aws.ToString(in.TemplateMinorVersion))
+	e.status = types.DeploymentStatusInProgress
+	return &proton.CreateEnvironmentOutput{Environment: e.protonEnvironment()}, nil
+}
+
+func (f *fakeProton) DeleteEnvironment(ctx context.Context, in *proton.DeleteEnvironmentInput, optFns ...func(*proton.Options)) (*proton.DeleteEnvironmentOutput, error) {
+	defer f.call("DeleteEnvironment")()
+	e, err := f.getDeployment(f.environments, "", *in.Name)
+	if err != nil {
+		return nil, err
+	}
+	e.status = types.DeploymentStatusDeleteInProgress
+	return &proton.DeleteEnvironmentOutput{Environment: e.protonEnvironment()}, nil
+}
+
+func (f *fakeProton) ListEnvironmentOutputs(ctx context.Context, in *proton.ListEnvironmentOutputsInput, optFns ...func(*proton.Options)) (*proton.ListEnvironmentOutputsOutput, error) {
+	defer f.call("ListEnvironmentOutputs")()
+	return &proton.ListEnvironmentOutputsOutput{Outputs: f.outputs[*in.EnvironmentName]}, nil
+}
+
+// services finish creating the first time they're read, and
+// are gone the first time they're read after being deleted
+func (f *fakeProton) GetService(ctx context.Context, in *proton.GetServiceInput, optFns ...func(*proton.Options)) (*proton.GetServiceOutput, error) {
+	defer f.call("GetService")()
+	s, ok := f.services[*in.Name]
+	if !ok {
+		return nil, fakeNotFound("service " + *in.Name)
+	}
+	switch s.Status {
+	case types.ServiceStatusCreateInProgress:
+		s.Status = types.ServiceStatusActive
+		if msg, fail := f.deploymentFailures[*in.Name]; fail {
+			s.Status = types.ServiceStatusCreateFailed
+			s.StatusMessage = aws.String(msg)
+		}
+	case types.ServiceStatusDeleteInProgress:
+		delete(f.services, *in.Name)
+		for _, i := range f.instances {
+			if i.service == *in.Name {
+				i.deleted = true
+			}
+		}
+		return nil, fakeNotFound("service " + *in.Name)
+	}
+
+	//pipelines finish deploying the first time they're read once the service is active
+	if p := s.Pipeline; p != nil && s.Status == types.ServiceStatusActive && p.DeploymentStatus == types.DeploymentStatusInProgress {
+		p.DeploymentStatus = types.DeploymentStatusSucceeded
+		if msg, fail := f.deploymentFailures[*in.Name+"/pipeline"]; fail {
+			p.DeploymentStatus = types.DeploymentStatusFailed
+			p.DeploymentStatusMessage = aws.String(msg)
+		}
+	}
+	return &proton.GetServiceOutput{Service: s}, nil
+}
+
+func (f *fakeProton) CreateService(ctx context.Context, in *proton.CreateServiceInput, optFns ...func(*proton.Options)) (*proton.CreateServiceOutput, error) {
+	defer f.call("CreateService")()
+	if _, exists := f.services[*in.Name]; exists {
+		return nil, &types.ConflictException{Message: aws.String(*in.Name + " already exists")}
+	}
+	var spec serviceSpec
+	if err := yaml.Unmarshal([]byte(aws.ToString(in.Spec)), &spec); err != nil {
+		return nil, &types.ValidationException{Message: aws.String(err.Error())}
+	}
+	s := &types.Service{
+		Name:         in.Name,
+		TemplateName: in.TemplateName,
+		Spec:         in.Spec,
+		Status:       types.ServiceStatusCreateInProgress,
+	}
+	if in.RepositoryId != nil {
+		s.Pipeline = &types.ServicePipeline{DeploymentStatus: types.DeploymentStatusInProgress}
+	}
+	f.services[*in.Name] = s
+	for _, instance := range spec.Instances {
+		i := f.addServiceInstance(*in.Name, instance.Name, instance.Environment, *in.TemplateName,
+			*in.TemplateMajorVersion, aws.ToString(in.TemplateMinorVersion))
+		i.status = types.DeploymentStatusInProgress
+	}
+	return &proton.CreateServiceOutput{Service: s}, nil
+}
+
+func (f *fakeProton) DeleteService(ctx context.Context, in *proton.DeleteServiceInput, optFns ...func(*proton.Options)) (*proton.DeleteServiceOutput, error) {
+	defer f.call("DeleteService")()
+	s, ok := f.services[*in.Name]
+	if !ok {
+		return nil, fakeNotFound("service " + *in.Name)
+	}
+	s.Status = types.ServiceStatusDeleteInProgress
+	if msg, fail := f.deleteFailures[*in.Name]; fail {
+		s.Status = types.ServiceStatusDeleteFailed
+		s.StatusMessage = aws.String(msg)
+	}
+	return &proton.DeleteServiceOutput{Service: s}, nil
+}
+
+func (f *fakeProton) ListServiceInstanceOutputs(ctx context.Context, in *proton.ListServiceInstanceOutputsInput, optFns ...func(*proton.Options)) (*proton.ListServiceInstanceOutputsOutput, error) {
+	defer f.call("ListServiceInstanceOutputs")()
+	return &proton.ListServiceInstanceOutputsOutput{Outputs: f.outputs[*in.ServiceName+"/"+*in.ServiceInstanceName]}, nil
+}
+
+func (f *fakeProton) ListServicePipelineOutputs(ctx context.Context, in *proton.ListServicePipelineOutputsInput, optFns ...func(*proton.Options)) (*proton.ListServicePipelineOutputsOutput, error) {
+	defer f.call("ListServicePipelineOutputs")()
+	return &proton.ListServicePipelineOutputsOutput{Outputs: f.outputs[*in.ServiceName+"/pipeline"]}, nil
+}
+
+// repositories and template sync
+
+func (f *fakeProton) GetRepository(ctx context.Context, in *proton.GetRepositoryInput, optFns ...func(*proton.Options)) (*proton.GetRepositoryOutput, error) {
+	defer f.call("GetRepository")()
+	r, ok := f.repositories[string(in.Provider)+"/"+*in.Name]
+	if !ok {
+		return nil, fakeNotFound("repository " + *in.Name)
+	}
+	return &proton.GetRepositoryOutput{Repository: r}, nil
+}
+
+func (f *fakeProton) CreateRepository(ctx context.Context, in *proton.CreateRepositoryInput, optFns ...func(*proton.Options)) (*proton.CreateRepositoryOutput, error) {
+	defer f.call("CreateRepository")()
+	r := &types.Repository{Name: in.Name, Provider: in.Provider, ConnectionArn: in.ConnectionArn}
+	f.repositories[string(in.Provider)+"/"+*in.Name] = r
+	return &proton.CreateRepositoryOutput{Repository: r}, nil
+}
+
+func (f *fakeProton) GetTemplateSyncConfig(ctx context.Context, in *proton.GetTemplateSyncConfigInput, optFns ...func(*proton.Options)) (*proton.GetTemplateSyncConfigOutput, error) {
+	defer f.call("GetTemplateSyncConfig")()
+	c, ok := f.syncConfigs[string(in.TemplateType)+"/"+*in.TemplateName]
+	if !ok {
+		return nil, fakeNotFound("template sync config " + *in.TemplateName)
+	}
+	return &proton.GetTemplateSyncConfigOutput{TemplateSyncConfig: c}, nil
+}
+
+func (f *fakeProton) CreateTemplateSyncConfig(ctx context.Context, in *proton.CreateTemplateSyncConfigInput, optFns ...func(*proton.Options)) (*proton.CreateTemplateSyncConfigOutput, error) {
+	defer f.call("CreateTemplateSyncConfig")()
+	k := string(in.TemplateType) + "/" + *in.TemplateName
+	if _, ok := f.syncConfigs[k]; ok {
+		return nil, &types.ConflictException{Message: aws.String("template sync config already exists")}
+	}
+	f.syncConfigs[k] = &types.TemplateSyncConfig{
+		TemplateName:       in.TemplateName,
+		TemplateType:       in.TemplateType,
+		RepositoryProvider: in.RepositoryProvider,
+		RepositoryName:     in.RepositoryName,
+		Branch:             in.Branch,
+		Subdirectory:       in.Subdirectory,
+	}
+	return &proton.CreateTemplateSyncConfigOutput{TemplateSyncConfig: f.syncConfigs[k]}, nil
+}
+
+func (f *fakeProton) UpdateTemplateSyncConfig(ctx context.Context, in *proton.UpdateTemplateSyncConfigInput, optFns ...func(*proton.Options)) (*proton.UpdateTemplateSyncConfigOutput, error) {
+	defer f.call("UpdateTemplateSyncConfig")()
+	c, ok := f.syncConfigs[string(in.TemplateType)+"/"+*in.TemplateName]
+	if !ok {
+		return nil, fakeNotFound("template sync config " + *in.TemplateName)
+	}
+	c.RepositoryProvider = in.RepositoryProvider
+	c.RepositoryName = in.RepositoryName
+	c.Branch = in.Branch
+	c.Subdirectory = in.Subdirectory
+	return &proton.UpdateTemplateSyncConfigOutput{TemplateSyncConfig: c}, nil
+}
+
+func (f *fakeProton) GetTemplateSyncStatus(ctx context.Context, in *proton.GetTemplateSyncStatusInput, optFns ...func(*proton.Options)) (*proton.GetTemplateSyncStatusOutput, error) {
+	defer f.call("GetTemplateSyncStatus")()
+	out, ok := f.syncStatus[string(in.TemplateType)+"/"+*in.TemplateName+":"+*in.TemplateVersion]
+	if !ok {
+		return nil, fakeNotFound("template sync status " + *in.TemplateName)
+	}
+	return out, nil
+}
+
+func (f *fakeProton) GetServiceSyncConfig(ctx context.Context, in *getServiceSyncConfigInput, optFns ...func(*proton.Options)) (*getServiceSyncConfigOutput, error) {
+	defer f.call("GetServiceSyncConfig")()
+	c, ok := f.serviceSyncConfigs[*in.ServiceName]
+	if !ok {
+		return nil, fakeNotFound("service sync config " + *in.ServiceName)
+	}
+	return &getServiceSyncConfigOutput{ServiceSyncConfig: c}, nil
+}
+
+func (f *fakeProton) CreateServiceSyncConfig(ctx context.Context, in *createServiceSyncConfigInput, optFns ...func(*proton.Options)) (*createServiceSyncConfigOutput, error) {
+	defer f.call("CreateServiceSyncConfig")()
+	if _, ok := f.serviceSyncConfigs[*in.ServiceName]; ok {
+		return nil, &types.ConflictException{Message: aws.String("service sync config already exists")}
+	}
+	f.serviceSyncConfigs[*in.ServiceName] = &serviceSyncConfig{
+		ServiceName:        in.ServiceName,
+		RepositoryProvider: in.RepositoryProvider,
+		RepositoryName:     in.RepositoryName,
+		Branch:             in.Branch,
+		FilePath:           in.FilePath,
+	}
+	return &createServiceSyncConfigOutput{ServiceSyncConfig: f.serviceSyncConfigs[*in.ServiceName]}, nil
+}
+
+func (f *fakeProton) UpdateServiceSyncConfig(ctx context.Context, in *updateServiceSyncConfigInput, optFns ...func(*proton.Options)) (*updateServiceSyncConfigOutput, error) {
+	defer f.call("UpdateServiceSyncConfig")()
+	c, ok := f.serviceSyncConfigs[*in.ServiceName]
+	if !ok {
+		return nil, fakeNotFound("service sync config " + *in.ServiceName)
+	}
+	c.RepositoryProvider = in.RepositoryProvider
+	c.RepositoryName = in.RepositoryName
+	c.Branch = in.Branch
+	c.FilePath = in.FilePath
+	return &updateServiceSyncConfigOutput{ServiceSyncConfig: c}, nil
+}
+
+func (f *fakeProton) TagResource(ctx context.Context, in *proton.TagResourceInput, optFns ...func(*proton.Options)) (*proton.TagResourceOutput, error) {
+	defer f.call("TagResource")()
+	f.tag(*in.ResourceArn, in.Tags)
+	return &proton.TagResourceOutput{}, nil
+}
+
+func (f *fakeProton) ListTagsForResource(ctx context.Context, in *proton.ListTagsForResourceInput, optFns ...func(*proton.Options)) (*proton.ListTagsForResourceOutput, error) {
+	defer f.call("ListTagsForResource")()
+	return &proton.ListTagsForResourceOutput{Tags: protonTags(f.tags[*in.ResourceArn])}, nil
+}
+
+// an in-memory s3 api for tests, supporting single part uploads
+type fakeS3 struct {
+	S3API
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}}
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[*in.Bucket+"/"+*in.Key] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.objects[*in.Bucket+"/"+*in.Key]
+	if !ok {
+		return nil, &s3types.NoSuchKey{Message: aws.String("the specified key does not exist")}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+var _ manager.UploadAPIClient = (*fakeS3)(nil)
+var _ S3API = (*fakeS3)(nil)
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// the file proton writes the inputs of codebuild provisioned templates to
+const protonInputsFile = "proton-inputs.json"
+
+var (
+	flagInputsSpec                    string
+	flagInputsDir                     string
+	flagInputsEnvName                 string
+	flagInputsName                    string
+	flagInputsInstance                string
+	flagInputsEnvOutputs              string
+	flagInputsAccountID               string
+	flagInputsRepositoryConnectionArn string
+	flagInputsRepositoryID            string
+	flagInputsBranch                  string
+	flagInputsOut                     string
+)
+
+var inputsCmd = &cobra.Command{
+	Use:   "inputs",
+	Short: "Generates the " + protonInputsFile + " proton passes to codebuild provisioned templates",
+	Long: `Generates the ` + protonInputsFile + ` proton passes to codebuild provisioned templates, from a spec file.
+
+This is the file the terraform manifest passes to terraform with -var-file, so terraform
+can run locally against variables.env.tf or variables.svc.tf:
+
+  environment:      {"environment": {"name", "inputs"}}
+  service instance: {"environment": {"name", "account_id", "outputs"},
+                     "service": {"name", "repository_id", "repository_connection_arn", "branch_name"},
+                     "service_instance": {"name", "inputs"}}
+
+The spec is validated against the template's schema/schema.yaml, and schema defaults are
+applied to inputs that aren't in the spec. For service templates, the inputs are for the
+instance in the spec that's deployed to --env-name. Use --instance if there's more than one.`,
+	Args: cobra.NoArgs,
+	Run:  doInputs,
+	Example: `
+# Generate the inputs of an environment named dev and run terraform plan with them
+protonizer inputs --spec spec.yaml --env-name dev --out proton-inputs.json
+terraform plan -var-file=proton-inputs.json
+
+# Generate the inputs of a service instance deployed to dev, with dev's outputs
+protonizer inputs --dir my_svc_template/v1 --spec spec.yaml --env-name dev --name api --env-outputs outputs.json`,
+}
+
+// returned when a spec has no service instance to generate inputs for
+var errInputsInstance = errors.New("unable to select a service instance")
+
+func init() {
+	inputsCmd.Flags().StringVarP(&flagInputsSpec, "spec", "s", "",
+		"The environment or service spec file (required)")
+	inputsCmd.MarkFlagRequired("spec")
+	inputsCmd.Flags().StringVar(&flagInputsDir, "dir", ".",
+		"The local template directory")
+	inputsCmd.Flags().StringVarP(&flagInputsEnvName, "env-name", "e", "",
+		"The name of the environment, or for service templates the environment the instance is deployed to (required)")
+	inputsCmd.MarkFlagRequired("env-name")
+	inputsCmd.Flags().StringVarP(&flagInputsName, "name", "n", "test",
+		"The name of the service")
+	inputsCmd.Flags().StringVar(&flagInputsInstance, "instance", "",
+		"The service instance, if more than one in the spec is deployed to --env-name")
+	inputsCmd.Flags().StringVar(&flagInputsEnvOutputs, "env-outputs", "",
+		"A json or yaml file of the outputs of the environment the service instance is deployed to")
+	inputsCmd.Flags().StringVar(&flagInputsAccountID, "account-id", "123456789012",
+		"The account id of the environment")
+	inputsCmd.Flags().StringVar(&flagInputsRepositoryConnectionArn, "repository-connection-arn", "",
+		"The CodeStar connection of the service's source repository")
+	inputsCmd.Flags().StringVar(&flagInputsRepositoryID, "repository-id", "",
+		"The service's source repository, in the format owner/repo")
+	inputsCmd.Flags().StringVar(&flagInputsBranch, "branch", "",
+		"The branch of the service's source repository")
+	inputsCmd.Flags().StringVarP(&flagInputsOut, "out", "o", "",
+		"The file to write the inputs to. Defaults to stdout")
+	rootCmd.AddCommand(inputsCmd)
+}
+
+func doInputs(cmd *cobra.Command, args []string) {
+	config, err := readProtonYAMLFile(filepath.Join(flagInputsDir, "proton.yaml"))
+	handleError("reading proton.yaml", err)
+	schema, err := readLocalSchema(flagInputsDir)
+	handleError("reading schema", err)
+	spec, err := os.ReadFile(flagInputsSpec)
+	handleError("reading spec", err)
+	validate := validateServiceSpec
+	if config.Type == "environment" {
+		validate = validateEnvironmentSpec
+	}
+	if err := validate(schema, spec); err != nil {
+		errorExit(fmt.Sprintf("%s is not valid:\n%v", flagInputsSpec, err))
+	}
+
+	opts := protonContextOptions{
+		name:                    flagInputsName,
+		accountID:               flagInputsAccountID,
+		repositoryConnectionArn: flagInputsRepositoryConnectionArn,
+		repositoryID:            flagInputsRepositoryID,
+		branch:                  flagInputsBranch,
+	}
+	if flagInputsEnvOutputs != "" {
+		opts.environmentOutputs, err = readEnvironmentOutputs(flagInputsEnvOutputs)
+		handleError("reading environment outputs", err)
+	}
+
+	c, err := protonInputsContext(config.Type, schema, spec, flagInputsEnvName, flagInputsInstance, opts)
+	if errors.Is(err, errInputsInstance) {
+		errorExit(err)
+	}
+	handleError("generating inputs", err)
+	b, err := protonInputs(c)
+	handleError("generating inputs", err)
+
+	if flagInputsOut == "" {
+		fmt.Print(string(b))
+		return
+	}
+	handleError("writing inputs", os.WriteFile(flagInputsOut, b, 0644))
+	fmt.Println("wrote", flagInputsOut)
+}
+
+// returns the context proton provisions a codebuild template with. for service templates,
+// it's the instance deployed to envName, optionally selected by name
+func protonInputsContext(templateType string, schema *templateSchema, spec []byte, envName, instance string, opts protonContextOptions) (*protonContext, error) {
+	if templateType == "environment" {
+		opts.name = envName
+		return environmentContext(schema, spec, opts)
+	}
+
+	instances, err := serviceInstanceContexts(schema, spec, opts)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*protonContext
+	var names []string
+	for _, c := range instances {
+		environment, _ := c.Values["environment"].(map[string]interface{})
+		if environment["name"] == envName && (instance == "" || c.Name == instance) {
+			matches = append(matches, c)
+			names = append(names, c.Name)
+		}
+	}
+	switch {
+	case len(matches) == 0 && instance != "":
+		return nil, fmt.Errorf("%w: the spec has no instance %s deployed to environment %s",
+			errInputsInstance, instance, envName)
+	case len(matches) == 0:
+		return nil, fmt.Errorf("%w: the spec has no instances deployed to environment %s", errInputsInstance, envName)
+	case len(matches) > 1:
+		return nil, fmt.Errorf("%w: instances %s are deployed to environment %s. use --instance to choose one",
+			errInputsInstance, strings.Join(names, ", "), envName)
+	}
+	return matches[0], nil
+}
+
+// returns the contents of proton-inputs.json for a context
+func protonInputs(c *protonContext) ([]byte, error) {
+	b, err := json.MarshalIndent(c.Values, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestProtonInputsEnvironment(t *testing.T) {
+	schema, err := parseTemplateSchema([]byte(testEnvironmentSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := "proton: EnvironmentSpec\nspec:\n  azs: 2\n"
+	c, err := protonInputsContext("environment", schema, []byte(spec), "dev", "", protonContextOptions{name: "ignored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := protonInputs(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "environment": {
+    "inputs": {
+      "azs": 2,
+      "vpc_cidr": "10.0.0.0/16"
+    },
+    "name": "dev"
+  }
+}
+`
+	if string(actual) != expected {
+		t.Errorf("expecting:\n%s\ngot:\n%s", expected, actual)
+	}
+	checkTerraformVariables(t, "env", c)
+}
+
+func TestProtonInputsService(t *testing.T) {
+	schema, err := parseTemplateSchema([]byte(testServiceSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := []byte(`proton: ServiceSpec
+instances:
+  - name: dev
+    environment: dev
+    spec:
+      image: nginx
+      subnets: [a, b]
+  - name: prod
+    environment: prod
+    spec:
+      image: nginx
+      port: 8080
+  - name: prod-canary
+    environment: prod
+    spec:
+      image: nginx:next
+`)
+	opts := protonContextOptions{
+		name:               "api",
+		accountID:          "123456789012",
+		environmentOutputs: map[string]interface{}{"ClusterName": "main"},
+		repositoryID:       "org/api",
+		branch:             "main",
+	}
+	c, err := protonInputsContext("service", schema, spec, "dev", "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := protonInputs(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "environment": {
+    "account_id": "123456789012",
+    "name": "dev",
+    "outputs": {
+      "ClusterName": "main"
+    }
+  },
+  "service": {
+    "branch_name": "main",
+    "name": "api",
+    "repository_connection_arn": "",
+    "repository_id": "org/api"
+  },
+  "service_instance": {
+    "inputs": {
+      "image": "nginx",
+      "port": 80,
+      "size": "small",
+      "subnets": [
+        "a",
+        "b"
+      ]
+    },
+    "name": "dev"
+  }
+}
+`
+	if string(actual) != expected {
+		t.Errorf("expecting:\n%s\ngot:\n%s", expected, actual)
+	}
+	checkTerraformVariables(t, "svc", c)
+
+	//instances are chosen by environment, then by name
+	if _, err := protonInputsContext("service", schema, spec, "prod", "", opts); !errors.Is(err, errInputsInstance) {
+		t.Errorf("expecting an error for two instances in prod, got %v", err)
+	}
+	c, err = protonInputsContext("service", schema, spec, "prod", "prod-canary", opts)
+	if err != nil || c.Name != "prod-canary" {
+		t.Errorf("expecting prod-canary, got %v %v", c, err)
+	}
+	for _, args := range [][]string{{"staging", ""}, {"dev", "prod"}} {
+		if _, err := protonInputsContext("service", schema, spec, args[0], args[1], opts); !errors.Is(err, errInputsInstance) {
+			t.Errorf("%v: expecting an error, got %v", args, err)
+		}
+	}
+}
+
+// checks that the inputs have the variables and attributes of the terraform scaffold's variables
+func checkTerraformVariables(t *testing.T, templateType string, c *protonContext) {
+	t.Helper()
+	tf := string(readTemplateFS("infrastructure/codebuild/terraform/variables.%s.tf", templateType))
+	for _, block := range regexp.MustCompile(`(?s)variable "(\w+)" \{.*?\n\}`).FindAllStringSubmatch(tf, -1) {
+		if block[1] == "region" {
+			continue
+		}
+		value, ok := c.Values[block[1]].(map[string]interface{})
+		if !ok {
+			t.Errorf("expecting variable %s in the inputs", block[1])
+			continue
+		}
+		var expected, actual []string
+		for _, m := range regexp.MustCompile(`(?m)^\s+(\w+)\s+= (string|any|map\(string\))$`).FindAllStringSubmatch(block[0], -1) {
+			expected = append(expected, m[1])
+		}
+		for k := range value {
+			actual = append(actual, k)
+		}
+		sort.Strings(expected)
+		sort.Strings(actual)
+		if strings.Join(actual, ",") != strings.Join(expected, ",") {
+			t.Errorf("%s: expecting attributes %v, got %v", block[1], expected, actual)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+// a kind of proton template, such as an environment or service template.
+// kinds wrap the type-specific proton sdk calls so that commands
+// can be written once for every kind of template.
+// new kinds are added to templateKinds
+type templateKind interface {
+
+	//the template type, as used in proton.yaml
+	templateType() string
+
+	getTemplate(ctx context.Context, c ProtonAPI, name string) (*templateInfo, error)
+	listTemplates(ctx context.Context, c ProtonAPI) ([]templateInfo, error)
+	createTemplate(ctx context.Context, c ProtonAPI, plan *publishPlan) error
+
+	createVersion(ctx context.Context, c ProtonAPI, plan *publishPlan) (*templateVersionInfo, error)
+	getVersion(ctx context.Context, c ProtonAPI, name, major, minor string) (*templateVersionInfo, error)
+	listVersions(ctx context.Context, c ProtonAPI, name, major string) ([]templateVersionInfo, error)
+	updateVersion(ctx context.Context, c ProtonAPI, name, major, minor string, status types.TemplateVersionStatus, description *string) error
+	deleteVersion(ctx context.Context, c ProtonAPI, name, major, minor string) error
+
+	//the environments or service instances using the template
+	listDeployments(ctx context.Context, c ProtonAPI, name string) ([]templateDeployment, error)
+	getDeployment(ctx context.Context, c ProtonAPI, d templateDeployment) (*templateDeployment, error)
+	updateDeployment(ctx context.Context, c ProtonAPI, d templateDeployment, major, minor string, deploymentType types.DeploymentUpdateType) error
+}
+
+// supported template kinds, in lookup order
+var templateKinds = []templateKind{
+	environmentKind{},
+	serviceKind{},
+}
+
+// returns the kind for a template type
+func kindFor(templateType string) (templateKind, error) {
+	for _, k := range templateKinds {
+		if k.templateType() == templateType {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("template type: %s is invalid. only environment and service are supported", templateType)
+}
+
+// the proton s3 source for a plan's bundle
+func planSource(plan *publishPlan) types.TemplateVersionSourceInput {
+	return &types.TemplateVersionSourceInputMemberS3{
+		Value: types.S3ObjectSource{
+			Bucket: aws.String(plan.Bucket),
+			Key:    aws.String(plan.Key),
+		},
+	}
+}
+
+// environment templates
+type environmentKind struct{}
+
+func (environmentKind) templateType() string { return "environment" }
+
+func (environmentKind) getTemplate(ctx context.Context, c ProtonAPI, name string) (*templateInfo, error) {
+	m := "proton.GetEnvironmentTemplate()"
+	debug(m)
+	out, err := c.GetEnvironmentTemplate(ctx, &proton.GetEnvironmentTemplateInput{Name: &name})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	t := out.EnvironmentTemplate
+	return &templateInfo{
+		Name:               name,
+		Type:               "environment",
+		Arn:                aws.ToString(t.Arn),
+		DisplayName:        aws.ToString(t.DisplayName),
+		Description:        aws.ToString(t.Description),
+		EncryptionKey:      aws.ToString(t.EncryptionKey),
+		Provisioning:       t.Provisioning,
+		RecommendedVersion: aws.ToString(t.RecommendedVersion),
+	}, nil
+}
+
+func (environmentKind) listTemplates(ctx context.Context, c ProtonAPI) ([]templateInfo, error) {
+	m := "proton.ListEnvironmentTemplates()"
+	debug(m)
+	result := []templateInfo{}
+	p := proton.NewListEnvironmentTemplatesPaginator(c, &proton.ListEnvironmentTemplatesInput{})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, t := range page.Templates {
+			result = append(result, templateInfo{
+				Name:               aws.ToString(t.Name),
+				Type:               "environment",
+				Arn:                aws.ToString(t.Arn),
+				DisplayName:        aws.ToString(t.DisplayName),
+				Description:        aws.ToString(t.Description),
+				Provisioning:       t.Provisioning,
+				RecommendedVersion: aws.ToString(t.RecommendedVersion),
+			})
+		}
+	}
+	return result, nil
+}
+
+func (environmentKind) createTemplate(ctx context.Context, c ProtonAPI, plan *publishPlan) error {
+	m := "proton.CreateEnvironmentTemplate()"
+	debug(m)
+	_, err := c.CreateEnvironmentTemplate(ctx, &proton.CreateEnvironmentTemplateInput{
+		Name:          &plan.Name,
+		Description:   &plan.Description,
+		DisplayName:   &plan.DisplayName,
+		EncryptionKey: optionalString(plan.EncryptionKey),
+		Provisioning:  plan.Provisioning,
+		Tags:          protonTags(plan.TemplateTags),
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
+
+func (environmentKind) createVersion(ctx context.Context, c ProtonAPI, plan *publishPlan) (*templateVersionInfo, error) {
+	m := "proton.CreateEnvironmentTemplateVersion()"
+	debug(m)
+	out, err := c.CreateEnvironmentTemplateVersion(ctx, &proton.CreateEnvironmentTemplateVersionInput{
+		TemplateName: &plan.Name,
+		MajorVersion: &plan.MajorVersion,
+		Source:       planSource(plan),
+		Description:  &plan.VersionDescription,
+		Tags:         protonTags(plan.VersionTags),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	return environmentVersionInfo(out.EnvironmentTemplateVersion), nil
+}
+
+func (environmentKind) getVersion(ctx context.Context, c ProtonAPI, name, major, minor string) (*templateVersionInfo, error) {
+	m := "proton.GetEnvironmentTemplateVersion()"
+	debug(m)
+	out, err := c.GetEnvironmentTemplateVersion(ctx, &proton.GetEnvironmentTemplateVersionInput{
+		TemplateName: &name,
+		MajorVersion: &major,
+		MinorVersion: &minor,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	return environmentVersionInfo(out.EnvironmentTemplateVersion), nil
+}
+
+func (environmentKind) listVersions(ctx context.Context, c ProtonAPI, name, major string) ([]templateVersionInfo, error) {
+	m := "proton.ListEnvironmentTemplateVersions()"
+	debug(m)
+	result := []templateVersionInfo{}
+	p := proton.NewListEnvironmentTemplateVersionsPaginator(c, &proton.ListEnvironmentTemplateVersionsInput{
+		TemplateName: &name,
+		MajorVersion: optionalString(major),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, v := range page.TemplateVersions {
+			result = append(result, templateVersionInfo{
+				Arn:           aws.ToString(v.Arn),
+				MajorVersion:  aws.ToString(v.MajorVersion),
+				MinorVersion:  aws.ToString(v.MinorVersion),
+				Status:        v.Status,
+				StatusMessage: aws.ToString(v.StatusMessage),
+				Description:   aws.ToString(v.Description),
+			})
+		}
+	}
+	return result, nil
+}
+
+func (environmentKind) updateVersion(ctx context.Context, c ProtonAPI, name, major, minor string,
+	status types.TemplateVersionStatus, description *string) error {
+
+	m := "proton.UpdateEnvironmentTemplateVersion()"
+	debug(m)
+	_, err := c.UpdateEnvironmentTemplateVersion(ctx, &proton.UpdateEnvironmentTemplateVersionInput{
+		TemplateName: &name,
+		MajorVersion: &major,
+		MinorVersion: &minor,
+		Status:       status,
+		Description:  description,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
+
+func (environmentKind) deleteVersion(ctx context.Context, c ProtonAPI, name, major, minor string) error {
+	m := "proton.DeleteEnvironmentTemplateVersion()"
+	debug(m)
+	_, err := c.DeleteEnvironmentTemplateVersion(ctx, &proton.DeleteEnvironmentTemplateVersionInput{
+		TemplateName: &name,
+		MajorVersion: &major,
+		MinorVersion: &minor,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
+
+func environmentVersionInfo(v *types.EnvironmentTemplateVersion) *templateVersionInfo {
+	return &templateVersionInfo{
+		Arn:           aws.ToString(v.Arn),
+		MajorVersion:  aws.ToString(v.MajorVersion),
+		MinorVersion:  aws.ToString(v.MinorVersion),
+		Status:        v.Status,
+		StatusMessage: aws.ToString(v.StatusMessage),
+		Description:   aws.ToString(v.Description),
+		Schema:        aws.ToString(v.Schema),
+	}
+}
+
+func (environmentKind) listDeployments(ctx context.Context, c ProtonAPI, name string) ([]templateDeployment, error) {
+	m := "proton.ListEnvironments()"
+	debug(m)
+	result := []templateDeployment{}
+	//the api's template filter requires a major version, so environments are filtered here
+	p := proton.NewListEnvironmentsPaginator(c, &proton.ListEnvironmentsInput{})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, e := range page.Environments {
+			if aws.ToString(e.TemplateName) != name {
+				continue
+			}
+			result = append(result, templateDeployment{
+				Name:             aws.ToString(e.Name),
+				Environment:      aws.ToString(e.Name),
+				MajorVersion:     aws.ToString(e.TemplateMajorVersion),
+				MinorVersion:     aws.ToString(e.TemplateMinorVersion),
+				DeploymentStatus: e.DeploymentStatus,
+			})
+		}
+	}
+	return result, nil
+}
+
+func (environmentKind) getDeployment(ctx context.Context, c ProtonAPI, d templateDeployment) (*templateDeployment, error) {
+	m := "proton.GetEnvironment()"
+	debug(m)
+	out, err := c.GetEnvironment(ctx, &proton.GetEnvironmentInput{Name: &d.Name})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	e := out.Environment
+	return &templateDeployment{
+		Name:             aws.ToString(e.Name),
+		Environment:      aws.ToString(e.Name),
+		MajorVersion:     aws.ToString(e.TemplateMajorVersion),
+		MinorVersion:     aws.ToString(e.TemplateMinorVersion),
+		DeploymentStatus: e.DeploymentStatus,
+		StatusMessage:    aws.ToString(e.DeploymentStatusMessage),
+	}, nil
+}
+
+func (environmentKind) updateDeployment(ctx context.Context, c ProtonAPI, d templateDeployment,
+	major, minor string, deploymentType types.DeploymentUpdateType) error {
+
+	m := "proton.UpdateEnvironment()"
+	debug(m)
+	_, err := c.UpdateEnvironment(ctx, &proton.UpdateEnvironmentInput{
+		Name:                 &d.Name,
+		DeploymentType:       deploymentType,
+		TemplateMajorVersion: &major,
+		TemplateMinorVersion: &minor,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
+
+// service templates
+type serviceKind struct{}
+
+func (serviceKind) templateType() string { return "service" }
+
+func (serviceKind) getTemplate(ctx context.Context, c ProtonAPI, name string) (*templateInfo, error) {
+	m := "proton.GetServiceTemplate()"
+	debug(m)
+	out, err := c.GetServiceTemplate(ctx, &proton.GetServiceTemplateInput{Name: &name})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	t := out.ServiceTemplate
+	return &templateInfo{
+		Name:               name,
+		Type:               "service",
+		Arn:                aws.ToString(t.Arn),
+		DisplayName:        aws.ToString(t.DisplayName),
+		Description:        aws.ToString(t.Description),
+		EncryptionKey:      aws.ToString(t.EncryptionKey),
+		Provisioning:       t.PipelineProvisioning,
+		RecommendedVersion: aws.ToString(t.RecommendedVersion),
+	}, nil
+}
+
+func (serviceKind) listTemplates(ctx context.Context, c ProtonAPI) ([]templateInfo, error) {
+	m := "proton.ListServiceTemplates()"
+	debug(m)
+	result := []templateInfo{}
+	p := proton.NewListServiceTemplatesPaginator(c, &proton.ListServiceTemplatesInput{})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, t := range page.Templates {
+			result = append(result, templateInfo{
+				Name:               aws.ToString(t.Name),
+				Type:               "service",
+				Arn:                aws.ToString(t.Arn),
+				DisplayName:        aws.ToString(t.DisplayName),
+				Description:        aws.ToString(t.Description),
+				Provisioning:       t.PipelineProvisioning,
+				RecommendedVersion: aws.ToString(t.RecommendedVersion),
+			})
+		}
+	}
+	return result, nil
+}
+
+func (serviceKind) createTemplate(ctx context.Context, c ProtonAPI, plan *publishPlan) error {
+	m := "proton.CreateServiceTemplate()"
+	debug(m)
+	_, err := c.CreateServiceTemplate(ctx, &proton.CreateServiceTemplateInput{
+		Name:                 &plan.Name,
+		Description:          &plan.Description,
+		DisplayName:          &plan.DisplayName,
+		EncryptionKey:        optionalString(plan.EncryptionKey),
+		PipelineProvisioning: plan.Provisioning,
+		Tags:                 protonTags(plan.TemplateTags),
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
+
+func (serviceKind) createVersion(ctx context.Context, c ProtonAPI, plan *publishPlan) (*templateVersionInfo, error) {
+	compatibleEnvs, err := parseCompatibleEnvironments(plan.CompatibleEnvironments)
+	if err != nil {
+		return nil, err
+	}
+	m := "proton.CreateServiceTemplateVersion()"
+	debug(m)
+	out, err := c.CreateServiceTemplateVersion(ctx, &proton.CreateServiceTemplateVersionInput{
+		TemplateName:                   &plan.Name,
+		MajorVersion:                   &plan.MajorVersion,
+		Source:                         planSource(plan),
+		CompatibleEnvironmentTemplates: compatibleEnvs,
+		Description:                    &plan.VersionDescription,
+		Tags:                           protonTags(plan.VersionTags),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	return serviceVersionInfo(out.ServiceTemplateVersion), nil
+}
+
+func (serviceKind) getVersion(ctx context.Context, c ProtonAPI, name, major, minor string) (*templateVersionInfo, error) {
+	m := "proton.GetServiceTemplateVersion()"
+	debug(m)
+	out, err := c.GetServiceTemplateVersion(ctx, &proton.GetServiceTemplateVersionInput{
+		TemplateName: &name,
+		MajorVersion: &major,
+		MinorVersion: &minor,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	return serviceVersionInfo(out.ServiceTemplateVersion), nil
+}
+
+func (serviceKind) listVersions(ctx context.Context, c ProtonAPI, name, major string) ([]templateVersionInfo, error) {
+	m := "proton.ListServiceTemplateVersions()"
+	debug(m)
+	result := []templateVersionInfo{}
+	p := proton.NewListServiceTemplateVersionsPaginator(c, &proton.ListServiceTemplateVersionsInput{
+		TemplateName: &name,
+		MajorVersion: optionalString(major),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, v := range page.TemplateVersions {
+			result = append(result, templateVersionInfo{
+				Arn:           aws.ToString(v.Arn),
+				MajorVersion:  aws.ToString(v.MajorVersion),
+				MinorVersion:  aws.ToString(v.MinorVersion),
+				Status:        v.Status,
+				StatusMessage: aws.ToString(v.StatusMessage),
+				Description:   aws.ToString(v.Description),
+			})
+		}
+	}
+	return result, nil
+}
+
+func (serviceKind) updateVersion(ctx context.Context, c ProtonAPI, name, major, minor string,
+	status types.TemplateVersionStatus, description *string) error {
+
+	m := "proton.UpdateServiceTemplateVersion()"
+	debug(m)
+	_, err := c.UpdateServiceTemplateVersion(ctx, &proton.UpdateServiceTemplateVersionInput{
+		TemplateName: &name,
+		MajorVersion: &major,
+		MinorVersion: &minor,
+		Status:       status,
+		Description:  description,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
+
+func (serviceKind) deleteVersion(ctx context.Context, c ProtonAPI, name, major, minor string) error {
+	m := "proton.DeleteServiceTemplateVersion()"
+	debug(m)
+	_, err := c.DeleteServiceTemplateVersion(ctx, &proton.DeleteServiceTemplateVersionInput{
+		TemplateName: &name,
+		MajorVersion: &major,
+		MinorVersion: &minor,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
+
+func serviceVersionInfo(v *types.ServiceTemplateVersion) *templateVersionInfo {
+	result := &templateVersionInfo{
+		Arn:           aws.ToString(v.Arn),
+		MajorVersion:  aws.ToString(v.MajorVersion),
+		MinorVersion:  aws.ToString(v.MinorVersion),
+		Status:        v.Status,
+		StatusMessage: aws.ToString(v.StatusMessage),
+		Description:   aws.ToString(v.Description),
+		Schema:        aws.ToString(v.Schema),
+	}
+	for _, c := range v.CompatibleEnvironmentTemplates {
+		result.CompatibleEnvironments = append(result.CompatibleEnvironments,
+			fmt.Sprintf("%s:%s", aws.ToString(c.TemplateName), aws.ToString(c.MajorVersion)))
+	}
+	return result
+}
+
+func (serviceKind) listDeployments(ctx context.Context, c ProtonAPI, name string) ([]templateDeployment, error) {
+	m := "proton.ListServices()"
+	debug(m)
+
+	//services can't be filtered by template, so filter them here
+	services := []string{}
+	p := proton.NewListServicesPaginator(c, &proton.ListServicesInput{})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, s := range page.Services {
+			if aws.ToString(s.TemplateName) == name {
+				services = append(services, aws.ToString(s.Name))
+			}
+		}
+	}
+
+	m = "proton.ListServiceInstances()"
+	result := []templateDeployment{}
+	for _, service := range services {
+		debugFmt("%s: %s", m, service)
+		p := proton.NewListServiceInstancesPaginator(c, &proton.ListServiceInstancesInput{
+			ServiceName: aws.String(service),
+			Filters: []types.ListServiceInstancesFilter{
+				{Key: types.ListServiceInstancesFilterByTemplateName, Value: &name},
+			},
+		})
+		for p.HasMorePages() {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", m, err)
+			}
+			for _, i := range page.ServiceInstances {
+				result = append(result, templateDeployment{
+					Name:             aws.ToString(i.Name),
+					Service:          aws.ToString(i.ServiceName),
+					Environment:      aws.ToString(i.EnvironmentName),
+					MajorVersion:     aws.ToString(i.TemplateMajorVersion),
+					MinorVersion:     aws.ToString(i.TemplateMinorVersion),
+					DeploymentStatus: i.DeploymentStatus,
+				})
+			}
+		}
+	}
+	return result, nil
+}
+
+func (serviceKind) getDeployment(ctx context.Context, c ProtonAPI, d templateDeployment) (*templateDeployment, error) {
+	m := "proton.GetServiceInstance()"
+	debug(m)
+	out, err := c.GetServiceInstance(ctx, &proton.GetServiceInstanceInput{
+		Name:        &d.Name,
+		ServiceName: &d.Service,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	i := out.ServiceInstance
+	return &templateDeployment{
+		Name:             aws.ToString(i.Name),
+		Service:          aws.ToString(i.ServiceName),
+		Environment:      aws.ToString(i.EnvironmentName),
+		MajorVersion:     aws.ToString(i.TemplateMajorVersion),
+		MinorVersion:     aws.ToString(i.TemplateMinorVersion),
+		DeploymentStatus: i.DeploymentStatus,
+		StatusMessage:    aws.ToString(i.DeploymentStatusMessage),
+	}, nil
+}
+
+func (serviceKind) updateDeployment(ctx context.Context, c ProtonAPI, d templateDeployment,
+	major, minor string, deploymentType types.DeploymentUpdateType) error {
+
+	m := "proton.UpdateServiceInstance()"
+	debug(m)
+	_, err := c.UpdateServiceInstance(ctx, &proton.UpdateServiceInstanceInput{
+		Name:                 &d.Name,
+		ServiceName:          &d.Service,
+		DeploymentType:       deploymentType,
+		TemplateMajorVersion: &major,
+		TemplateMinorVersion: &minor,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
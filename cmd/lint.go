@@ -0,0 +1,449 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// matches compatible environments in the format name:major
+var compatibleEnvironmentPattern = regexp.MustCompile(`^[0-9A-Za-z]+[0-9A-Za-z_\-]*:[0-9]+$`)
+
+// matches the line number in yaml parse errors
+var yamlErrorLinePattern = regexp.MustCompile(`line ([0-9]+)`)
+
+// the openapi types supported in template schemas
+var schemaPropertyTypes = []string{"string", "number", "integer", "boolean", "array", "object"}
+
+var (
+	flagLintPolicy         string
+	flagLintPolicySeverity string
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [template dir]",
+	Short: "Checks a template directory for problems before it's published",
+	Long: `Checks a template directory for problems before it's published, without calling AWS:
+
+- proton.yaml parses and its settings are valid
+- compatibleEnvironments entries use the format name:major
+- schema/schema.yaml is a valid proton openapi schema with the right *_input_type for the template type
+- manifest.yaml files are in the right infrastructure directories for the template type
+- shell scripts are executable, unless their manifest makes them executable
+- terraform and cloudformation resources follow the built-in and custom policy rules
+- terraform and jinja cloudformation only reference inputs declared in the schema,
+  every declared input is referenced, and environment.outputs references exist in
+  compatible environment templates found at ../../<name>/v<major>
+
+Every problem is reported with its file and line. Defaults to the current directory.
+Policy violations below --policy-severity are reported but don't fail lint.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  doLint,
+	Example: `
+# Lint the template in the current directory
+protonizer lint
+
+# Lint a template directory
+protonizer lint my_template/v1
+
+# Lint with a shared policy file, failing on policy warnings too
+protonizer lint --policy ../policy.yaml --policy-severity warning`,
+}
+
+// a problem found by lint. line is 0 when the problem isn't on a specific line
+type lintFinding struct {
+	File    string
+	Line    int
+	Message string
+
+	//set for policy violations. other problems always fail lint
+	Severity string
+}
+
+func (f lintFinding) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("%s: %s", f.File, f.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", f.File, f.Line, f.Message)
+}
+
+// returns true if the finding fails lint at a policy severity
+func (f lintFinding) fails(severity string) bool {
+	return f.Severity == "" || policySeverityRank(f.Severity) >= policySeverityRank(severity)
+}
+
+// collects lint findings for a template directory
+type templateLint struct {
+	dir      string
+	findings []lintFinding
+}
+
+func (l *templateLint) add(file string, line int, format string, a ...interface{}) {
+	l.findings = append(l.findings, lintFinding{File: file, Line: line, Message: fmt.Sprintf(format, a...)})
+}
+
+func init() {
+	lintCmd.Flags().StringVar(&flagLintPolicy, "policy", "",
+		"A file of policy rules that add to, override or disable the built-in rules. Defaults to "+policyFile+" in the template directory")
+	lintCmd.Flags().StringVar(&flagLintPolicySeverity, "policy-severity", defaultPolicySeverity,
+		"The minimum policy rule severity that fails lint: "+strings.Join(policySeverities, ", "))
+	rootCmd.AddCommand(lintCmd)
+}
+
+func doLint(cmd *cobra.Command, args []string) {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		errorExit(fmt.Sprintf("%s is not a template directory", dir))
+	}
+	if policySeverityRank(flagLintPolicySeverity) < 0 {
+		errorExit(fmt.Sprintf("policy severity: %s is invalid. only %s are supported",
+			flagLintPolicySeverity, strings.Join(policySeverities, ", ")))
+	}
+	rules, err := loadPolicyRules(dir, flagLintPolicy)
+	if err != nil {
+		errorExit(err)
+	}
+
+	findings := lintTemplate(dir, rules)
+	problems := 0
+	for _, f := range findings {
+		f.File = filepath.Join(dir, filepath.FromSlash(f.File))
+		fmt.Println(f)
+		if f.fails(flagLintPolicySeverity) {
+			problems++
+		}
+	}
+	if problems > 0 {
+		errorExit(fmt.Sprintf("\n%d problem(s) found", problems))
+	}
+	if len(findings) > 0 {
+		fmt.Printf("\nno problems found at or above %s severity \n", flagLintPolicySeverity)
+		return
+	}
+	fmt.Println("no problems found")
+}
+
+// lints a template directory, returning every problem found sorted by file and line.
+// resources are checked against the policy rules, if any
+func lintTemplate(dir string, rules []policyRule) []lintFinding {
+	l := &templateLint{dir: dir}
+	config := l.lintProtonConfig()
+	if config != nil && (config.Type == "environment" || config.Type == "service") {
+		l.lintSchema(config.Type)
+		l.lintManifests(config.Type)
+		l.lintInputReferences(config)
+	}
+	l.lintScripts()
+	l.lintPolicy(rules)
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		a, b := l.findings[i], l.findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return l.findings
+}
+
+// reads a yaml file into a node, recording a finding if it's missing or doesn't parse
+func (l *templateLint) readYAML(file string) *yaml.Node {
+	b, err := os.ReadFile(filepath.Join(l.dir, filepath.FromSlash(file)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			l.add(file, 0, "is missing")
+		} else {
+			l.add(file, 0, "%v", err)
+		}
+		return nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		l.add(file, yamlErrorLine(err), "%v", err)
+		return nil
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		l.add(file, 1, "must be a yaml mapping")
+		return nil
+	}
+	return doc.Content[0]
+}
+
+// checks proton.yaml, returning its settings if it parses
+func (l *templateLint) lintProtonConfig() *protonConfigData {
+	const file = "proton.yaml"
+	root := l.readYAML(file)
+	if root == nil {
+		return nil
+	}
+	var c protonConfigData
+	if err := root.Decode(&c); err != nil {
+		l.add(file, yamlErrorLine(err), "%v", err)
+		return nil
+	}
+
+	//type and compatible environments are checked here so their lines can be reported
+	_, typeValue := yamlMappingValue(root, "type")
+	switch {
+	case typeValue == nil:
+		l.add(file, 0, "type: is required")
+	case c.Type != "environment" && c.Type != "service":
+		l.add(file, typeValue.Line, "type: %q is invalid. only environment and service are supported", c.Type)
+	}
+	envsKey, envsValue := yamlMappingValue(root, "compatibleEnvironments")
+	if envsValue != nil {
+		if c.Type == "environment" {
+			l.add(file, envsKey.Line, "compatibleEnvironments: only supported for service templates")
+		}
+		for _, e := range envsValue.Content {
+			if !compatibleEnvironmentPattern.MatchString(e.Value) {
+				l.add(file, e.Line, "compatibleEnvironments: %q must use the format name:major", e.Value)
+			}
+		}
+	}
+
+	//everything else proton.yaml validation checks, on the line of its setting
+	if err := c.validate(); err != nil {
+		for _, p := range strings.Split(err.Error(), "\n") {
+			key, _, _ := strings.Cut(p, ":")
+			key, _, _ = strings.Cut(key, "[")
+			if key == "type" || key == "compatibleEnvironments" {
+				continue
+			}
+			line := 0
+			if k, _ := yamlMappingValue(root, key); k != nil {
+				line = k.Line
+			}
+			l.add(file, line, "%s", p)
+		}
+	}
+	return &c
+}
+
+// checks that schema/schema.yaml is a valid proton openapi schema for the template type
+func (l *templateLint) lintSchema(templateType string) {
+	const file = "schema/schema.yaml"
+	root := l.readYAML(file)
+	if root == nil {
+		return
+	}
+	schemaKey, schema := yamlMappingValue(root, "schema")
+	if schema == nil || schema.Kind != yaml.MappingNode {
+		l.add(file, 1, "schema: is required and must be a mapping")
+		return
+	}
+
+	_, format := yamlMappingValue(schema, "format")
+	_, openapi := yamlMappingValue(format, "openapi")
+	switch {
+	case openapi == nil:
+		l.add(file, schemaKey.Line, "schema.format.openapi: is required")
+	case openapi.Value != "3.0.0":
+		l.add(file, openapi.Line, "schema.format.openapi: %q is invalid. only 3.0.0 is supported", openapi.Value)
+	}
+
+	//which input types are allowed depends on the template type
+	_, types := yamlMappingValue(schema, "types")
+	hasPipeline := l.exists("pipeline_infrastructure")
+	inputTypes := []struct {
+		key     string
+		allowed bool
+		needed  bool
+		reason  string
+	}{
+		{"environment_input_type", templateType == "environment", templateType == "environment", "environment templates"},
+		{"service_input_type", templateType == "service", templateType == "service", "service templates"},
+		{"pipeline_input_type", templateType == "service" && hasPipeline, false, "service templates with a pipeline_infrastructure directory"},
+	}
+	for _, it := range inputTypes {
+		key, value := yamlMappingValue(schema, it.key)
+		switch {
+		case value == nil && it.needed:
+			l.add(file, schemaKey.Line, "schema.%s: is required for %s", it.key, it.reason)
+		case value == nil:
+		case !it.allowed:
+			l.add(file, key.Line, "schema.%s: is only supported for %s", it.key, it.reason)
+		default:
+			if _, t := yamlMappingValue(types, value.Value); t == nil {
+				l.add(file, value.Line, "schema.%s: type %s is not defined in schema.types", it.key, value.Value)
+			}
+		}
+	}
+
+	if types == nil {
+		return
+	}
+	for i := 0; i+1 < len(types.Content); i += 2 {
+		l.lintSchemaType(file, "schema.types."+types.Content[i].Value, types.Content[i], types.Content[i+1])
+	}
+}
+
+// checks an object type in a template schema
+func (l *templateLint) lintSchemaType(file, path string, key, node *yaml.Node) {
+	_, t := yamlMappingValue(node, "type")
+	if t == nil || t.Value != "object" {
+		l.add(file, key.Line, "%s: must be of type object", path)
+	}
+	_, properties := yamlMappingValue(node, "properties")
+	for i := 0; properties != nil && i+1 < len(properties.Content); i += 2 {
+		name, p := properties.Content[i], properties.Content[i+1]
+		propertyPath := path + ".properties." + name.Value
+
+		var property schemaType
+		if err := p.Decode(&property); err != nil {
+			l.add(file, yamlErrorLine(err), "%s: %v", propertyPath, err)
+			continue
+		}
+		if !SliceContains(&schemaPropertyTypes, property.Type, false) {
+			l.add(file, name.Line, "%s: type %q is invalid. only %s are supported",
+				propertyPath, property.Type, strings.Join(schemaPropertyTypes, ", "))
+			continue
+		}
+		if defaultKey, _ := yamlMappingValue(p, "default"); defaultKey != nil {
+			for _, problem := range property.validateValue(property.Default, propertyPath+".default") {
+				l.add(file, defaultKey.Line, "%s", problem)
+			}
+		}
+	}
+	_, required := yamlMappingValue(node, "required")
+	for _, r := range contentOf(required) {
+		if k, _ := yamlMappingValue(properties, r.Value); k == nil {
+			l.add(file, r.Line, "%s.required: %s is not defined in properties", path, r.Value)
+		}
+	}
+}
+
+// checks that manifests are in the infrastructure directories for the template type
+func (l *templateLint) lintManifests(templateType string) {
+	dirs := map[string]bool{
+		protonInfrastructureDirEnv: templateType == "environment",
+		protonInfrastructureDirSvc: templateType == "service",
+		"pipeline_infrastructure":  templateType == "service",
+	}
+	for dir, allowed := range dirs {
+		if !allowed && l.exists(dir) {
+			l.add(dir, 0, "is ignored for %s templates, which use %s/", templateType, getInfrastructureDirectory(templateType))
+		}
+	}
+
+	required := []string{getInfrastructureDirectory(templateType)}
+	if templateType == "service" && l.exists("pipeline_infrastructure") {
+		required = append(required, "pipeline_infrastructure")
+	}
+	for _, dir := range required {
+		file := dir + "/manifest.yaml"
+		root := l.readYAML(file)
+		if root == nil {
+			continue
+		}
+		if k, _ := yamlMappingValue(root, "infrastructure"); k == nil {
+			l.add(file, 1, "infrastructure: is required")
+		}
+	}
+}
+
+// checks that shell scripts are executable
+func (l *templateLint) lintScripts() {
+	filepath.WalkDir(l.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != l.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".sh" {
+			return nil
+		}
+		info, err := d.Info()
+		if err == nil && info.Mode().Perm()&0111 == 0 && !chmodedByManifest(p) {
+			rel, _ := filepath.Rel(l.dir, p)
+			l.add(filepath.ToSlash(rel), 0, "shell script is not executable. run chmod +x on it")
+		}
+		return nil
+	})
+}
+
+// returns true if the manifest next to a script makes it executable before running it
+func chmodedByManifest(script string) bool {
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(script), "manifest.yaml"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.Contains(line, "chmod +x") && strings.Contains(line, filepath.Base(script)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checks the infrastructure as code's resources against policy rules
+func (l *templateLint) lintPolicy(rules []policyRule) {
+	if len(rules) == 0 {
+		return
+	}
+	resources, err := templatePolicyResources(l.dir)
+	if err != nil {
+		l.add(".", 0, "%v", err)
+		return
+	}
+	for _, v := range evaluatePolicy(rules, resources) {
+		l.findings = append(l.findings, lintFinding{
+			File:     v.Resource.File,
+			Line:     v.Resource.Line,
+			Message:  v.message(),
+			Severity: v.Rule.Severity,
+		})
+	}
+}
+
+// returns true if a path exists in the template directory
+func (l *templateLint) exists(rel string) bool {
+	_, err := os.Stat(filepath.Join(l.dir, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+// returns the key and value nodes for a key in a yaml mapping, or nils if it isn't set
+func yamlMappingValue(n *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// returns the items of a yaml sequence, or nil if n isn't a sequence
+func contentOf(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return n.Content
+}
+
+// returns the line number of a yaml error, or 0 if it doesn't have one
+func yamlErrorLine(err error) int {
+	match := yamlErrorLinePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(match[1])
+	return line
+}
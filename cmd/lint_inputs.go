@@ -0,0 +1,406 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// matches jinja expression and statement tags
+var jinjaTagPattern = regexp.MustCompile(`(?s)\{\{.*?\}\}|\{%.*?%\}`)
+
+// matches input and output references inside a jinja tag, for example
+// environment.inputs.name, service_instances[0].inputs.name or environment.outputs["name"]
+var jinjaReferencePattern = regexp.MustCompile(
+	`(?:^|[^\w.])(environment|service_instance|pipeline|service_instances\s*\[[^\]]*\])\.(inputs|outputs)(?:\.(\w+)|\[\s*["'](\w+)["']\s*\])`)
+
+// an input or output of a proton resource referenced by infrastructure as code
+type iacReference struct {
+	File      string
+	Line      int
+	Namespace string
+	Kind      string
+	Name      string
+}
+
+func (r iacReference) String() string {
+	return fmt.Sprintf("%s.%s.%s", r.Namespace, r.Kind, r.Name)
+}
+
+// an infrastructure directory and the schema input types its namespaces read inputs from
+type iacDirectory struct {
+	dir        string
+	inputTypes map[string]string
+	outputs    bool
+}
+
+// cross checks input and output references in the infrastructure as code against the schema
+// and the outputs of compatible environment templates found next to the template
+func (l *templateLint) lintInputReferences(config *protonConfigData) {
+	b, err := os.ReadFile(filepath.Join(l.dir, "schema", "schema.yaml"))
+	if err != nil {
+		return
+	}
+	schema, err := parseTemplateSchema(b)
+	if err != nil {
+		return
+	}
+	var schemaDoc yaml.Node
+	yaml.Unmarshal(b, &schemaDoc)
+
+	var dirs []iacDirectory
+	if config.Type == "environment" {
+		dirs = []iacDirectory{
+			{dir: protonInfrastructureDirEnv, inputTypes: map[string]string{"environment": schema.Schema.EnvironmentInputType}},
+		}
+	} else {
+		dirs = []iacDirectory{
+			{dir: protonInfrastructureDirSvc, outputs: true,
+				inputTypes: map[string]string{"service_instance": schema.Schema.ServiceInputType}},
+			{dir: "pipeline_infrastructure", inputTypes: map[string]string{
+				"pipeline":          schema.Schema.PipelineInputType,
+				"service_instances": schema.Schema.ServiceInputType,
+			}},
+		}
+	}
+
+	//input type -> input name -> referenced
+	used := map[string]map[string]bool{}
+	var outputRefs []iacReference
+	scanned := false
+	for _, d := range dirs {
+		refs, ok := l.iacReferences(d.dir)
+		if !ok {
+			continue
+		}
+		scanned = true
+		for _, typeName := range d.inputTypes {
+			if typeName != "" && used[typeName] == nil {
+				used[typeName] = map[string]bool{}
+			}
+		}
+		for _, r := range refs {
+			if r.Kind == "outputs" {
+				if r.Namespace == "environment" && d.outputs {
+					outputRefs = append(outputRefs, r)
+				}
+				continue
+			}
+			typeName, ok := d.inputTypes[r.Namespace]
+			if !ok {
+				l.add(r.File, r.Line, "%s: %s inputs are not available in %s/", r, r.Namespace, d.dir)
+				continue
+			}
+			t, err := schema.inputType(typeName)
+			if err != nil || t == nil {
+				l.add(r.File, r.Line, "%s: the schema doesn't define an input type for %s inputs", r, r.Namespace)
+				continue
+			}
+			if _, ok := t.Properties[r.Name]; !ok {
+				l.add(r.File, r.Line, "%s: is not defined in schema.types.%s", r, typeName)
+				continue
+			}
+			used[typeName][r.Name] = true
+		}
+	}
+
+	//only report unused inputs when there's infrastructure as code to check against
+	if scanned {
+		typeNames := []string{}
+		for typeName := range used {
+			typeNames = append(typeNames, typeName)
+		}
+		sort.Strings(typeNames)
+		for _, typeName := range typeNames {
+			t, err := schema.inputType(typeName)
+			if err != nil || t == nil {
+				continue
+			}
+			_, types := yamlMappingValue(contentRoot(&schemaDoc), "schema")
+			_, types = yamlMappingValue(types, "types")
+			_, node := yamlMappingValue(types, typeName)
+			_, properties := yamlMappingValue(node, "properties")
+			names := []string{}
+			for name := range t.Properties {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if used[typeName][name] {
+					continue
+				}
+				line := 0
+				if k, _ := yamlMappingValue(properties, name); k != nil {
+					line = k.Line
+				}
+				l.add("schema/schema.yaml", line, "schema.types.%s.properties.%s: is never referenced by the infrastructure", typeName, name)
+			}
+		}
+	}
+
+	l.lintEnvironmentOutputs(config, outputRefs)
+}
+
+// checks environment.outputs references against the outputs of each compatible
+// environment template that can be found locally at ../../<name>/v<major>
+func (l *templateLint) lintEnvironmentOutputs(config *protonConfigData, refs []iacReference) {
+	if len(refs) == 0 {
+		return
+	}
+	for _, e := range config.CompatibleEnvironments {
+		name, major, found := strings.Cut(e, ":")
+		if !found {
+			continue
+		}
+		envDir := filepath.Join(l.dir, "..", "..", name, "v"+major)
+		outputs, ok := iacOutputs(filepath.Join(envDir, protonInfrastructureDirEnv))
+		if !ok {
+			debug("skipping output checks for", e, "which wasn't found at", envDir)
+			continue
+		}
+		for _, r := range refs {
+			if !outputs[r.Name] {
+				l.add(r.File, r.Line, "%s: is not an output of compatible environment %s", r, e)
+			}
+		}
+	}
+}
+
+// returns the input and output references in the infrastructure as code files at the top
+// level of an infrastructure directory, and whether it has any infrastructure as code.
+// modules in subdirectories can't read proton's variables so they aren't scanned
+func (l *templateLint) iacReferences(dir string) ([]iacReference, bool) {
+	entries, err := os.ReadDir(filepath.Join(l.dir, dir))
+	if err != nil {
+		return nil, false
+	}
+	var refs []iacReference
+	found := false
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == "manifest.yaml" {
+			continue
+		}
+		file := dir + "/" + entry.Name()
+		isTF := filepath.Ext(entry.Name()) == ".tf"
+		if !isTF && !isCloudFormationFile(entry.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(l.dir, filepath.FromSlash(file)))
+		if err != nil {
+			l.add(file, 0, "%v", err)
+			continue
+		}
+		found = true
+		if isTF {
+			r, diags := tfReferences(file, b)
+			for _, d := range diags {
+				if d.Severity != hcl.DiagError {
+					continue
+				}
+				line := 0
+				if d.Subject != nil {
+					line = d.Subject.Start.Line
+				}
+				l.add(file, line, "%s", d.Summary)
+			}
+			refs = append(refs, r...)
+		} else {
+			refs = append(refs, jinjaReferences(file, b)...)
+		}
+	}
+	return refs, found
+}
+
+// returns true for files that may hold (jinja) cloudformation
+func isCloudFormationFile(name string) bool {
+	name = strings.TrimSuffix(name, ".jinja")
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// parses a terraform file and returns the proton input and output references in it
+func tfReferences(file string, b []byte) ([]iacReference, hcl.Diagnostics) {
+	f, diags := hclsyntax.ParseConfig(b, file, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	var refs []iacReference
+	hclsyntax.VisitAll(f.Body.(*hclsyntax.Body), func(n hclsyntax.Node) hcl.Diagnostics {
+		e, ok := n.(*hclsyntax.ScopeTraversalExpr)
+		if !ok {
+			return nil
+		}
+		if r, ok := traversalReference(e.Traversal); ok {
+			r.File = file
+			r.Line = e.SrcRange.Start.Line
+			refs = append(refs, r)
+		}
+		return nil
+	})
+	return refs, nil
+}
+
+// converts a traversal like var.environment.inputs.name to a reference
+func traversalReference(t hcl.Traversal) (iacReference, bool) {
+	var r iacReference
+	if len(t) < 4 || t.RootName() != "var" {
+		return r, false
+	}
+	namespace, ok := t[1].(hcl.TraverseAttr)
+	if !ok {
+		return r, false
+	}
+	r.Namespace = namespace.Name
+	rest := t[2:]
+	//skip the index into service_instances
+	for len(rest) > 0 {
+		if _, isIndex := rest[0].(hcl.TraverseIndex); !isIndex {
+			break
+		}
+		rest = rest[1:]
+	}
+	if len(rest) < 2 {
+		return r, false
+	}
+	kind, ok := rest[0].(hcl.TraverseAttr)
+	if !ok || (kind.Name != "inputs" && kind.Name != "outputs") {
+		return r, false
+	}
+	r.Kind = kind.Name
+	switch step := rest[1].(type) {
+	case hcl.TraverseAttr:
+		r.Name = step.Name
+	case hcl.TraverseIndex:
+		if step.Key.Type() != cty.String || !step.Key.IsKnown() || step.Key.IsNull() {
+			return r, false
+		}
+		r.Name = step.Key.AsString()
+	default:
+		return r, false
+	}
+	return r, true
+}
+
+// returns the proton input and output references in the jinja tags of a file
+func jinjaReferences(file string, b []byte) []iacReference {
+	var refs []iacReference
+	for _, tag := range jinjaTagPattern.FindAllIndex(b, -1) {
+		for _, m := range jinjaReferencePattern.FindAllSubmatchIndex(b[tag[0]:tag[1]], -1) {
+			group := func(i int) string {
+				if m[2*i] < 0 {
+					return ""
+				}
+				return string(b[tag[0]+m[2*i] : tag[0]+m[2*i+1]])
+			}
+			r := iacReference{
+				File:      file,
+				Line:      bytes.Count(b[:tag[0]+m[2]], []byte("\n")) + 1,
+				Namespace: group(1),
+				Kind:      group(2),
+				Name:      group(3) + group(4),
+			}
+			if strings.HasPrefix(r.Namespace, "service_instances") {
+				r.Namespace = "service_instances"
+			}
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
+
+// returns the names of the outputs declared by the infrastructure as code in an
+// environment's infrastructure directory, and whether it has any infrastructure as code
+func iacOutputs(dir string) (map[string]bool, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false
+	}
+	outputs := map[string]bool{}
+	found := false
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == "manifest.yaml" {
+			continue
+		}
+		isTF := filepath.Ext(entry.Name()) == ".tf"
+		if !isTF && !isCloudFormationFile(entry.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if isTF {
+			f, diags := hclsyntax.ParseConfig(b, entry.Name(), hcl.Pos{Line: 1, Column: 1})
+			if diags.HasErrors() {
+				return nil, false
+			}
+			found = true
+			for _, block := range f.Body.(*hclsyntax.Body).Blocks {
+				if block.Type == "output" && len(block.Labels) == 1 {
+					outputs[block.Labels[0]] = true
+				}
+			}
+			continue
+		}
+		found = true
+		for name := range cloudFormationOutputs(b) {
+			outputs[name] = true
+		}
+	}
+	return outputs, found
+}
+
+// returns the keys of the top level Outputs section of a (jinja) cloudformation template.
+// the lines are scanned rather than parsed since jinja templates often aren't valid yaml
+func cloudFormationOutputs(b []byte) map[string]bool {
+	outputs := map[string]bool{}
+	inOutputs := false
+	indent := -1
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "{%") {
+			continue
+		}
+		lineIndent := len(line) - len(strings.TrimLeft(line, " "))
+		if lineIndent == 0 {
+			inOutputs = trimmed == "Outputs:"
+			indent = -1
+			continue
+		}
+		if !inOutputs {
+			continue
+		}
+		if indent < 0 {
+			indent = lineIndent
+		}
+		if lineIndent == indent {
+			if key, _, found := strings.Cut(trimmed, ":"); found {
+				outputs[strings.Trim(key, `"'`)] = true
+			}
+		}
+	}
+	return outputs
+}
+
+// returns the top level node of a parsed yaml document
+func contentRoot(doc *yaml.Node) *yaml.Node {
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	return doc.Content[0]
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintInputReferencesTerraform(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml":                  "name: vpc\ntype: environment\n",
+		"schema/schema.yaml":           testEnvironmentSchema,
+		"infrastructure/manifest.yaml": "infrastructure: {}\n",
+		"infrastructure/main.tf": `module "vpc" {
+  source = "./src"
+  cidr   = var.environment.inputs.vpc_cidr
+  name   = "${var.environment.name}-${var.environment.inputs["foo"]}"
+}
+`,
+		//modules can't read proton's variables
+		"infrastructure/src/main.tf": "locals {\n  x = var.environment.inputs.bar\n}\n",
+	})
+
+	var actual []string
+	for _, f := range lintTemplate(dir, nil) {
+		actual = append(actual, f.String())
+	}
+	expected := []string{
+		"infrastructure/main.tf:4: environment.inputs.foo: is not defined in schema.types.EnvironmentInput",
+		"schema/schema.yaml:12: schema.types.EnvironmentInput.properties.azs: is never referenced by the infrastructure",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestLintInputReferencesJinja(t *testing.T) {
+	root := t.TempDir()
+	internalWriteFiles(t, filepath.Join(root, "vpc", "v1"), map[string]string{
+		"infrastructure/cloudformation.yaml": `Resources:
+  VPC:
+    Type: AWS::EC2::VPC
+Outputs:
+  VpcId:
+    Value: !Ref VPC
+  {% if environment.inputs.public %}
+  PublicSubnet:
+    Value: x
+  {% endif %}
+`,
+	})
+	dir := filepath.Join(root, "api", "v1")
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml": "name: api\ntype: service\ncompatibleEnvironments:\n  - vpc:1\n  - other:1\n",
+		"schema/schema.yaml": `schema:
+  format:
+    openapi: "3.0.0"
+  service_input_type: ServiceInput
+  types:
+    ServiceInput:
+      type: object
+      properties:
+        port:
+          type: number
+        image:
+          type: string
+`,
+		"instance_infrastructure/manifest.yaml": "infrastructure: {}\n",
+		"instance_infrastructure/cloudformation.yaml": `Resources:
+  Service:
+    Type: AWS::ECS::Service
+    Properties:
+      ServiceName: '{{ service.name }}-{{ service_instance.name }}'
+      Port: {{ service_instance.inputs.port }}
+      Vpc: {{ environment.outputs.VpcId }}
+      Subnet: {{ environment.outputs["PublicSubnet"] }}
+      Cluster: {{ environment.outputs.ClusterName }}
+      {% if service_instance.inputs.bar %}Bar: true{% endif %}
+`,
+	})
+
+	var actual []string
+	for _, f := range lintTemplate(dir, nil) {
+		actual = append(actual, f.String())
+	}
+	expected := []string{
+		"instance_infrastructure/cloudformation.yaml:9: environment.outputs.ClusterName: is not an output of compatible environment vpc:1",
+		"instance_infrastructure/cloudformation.yaml:10: service_instance.inputs.bar: is not defined in schema.types.ServiceInput",
+		"schema/schema.yaml:11: schema.types.ServiceInput.properties.image: is never referenced by the infrastructure",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestLintInputReferencesPipeline(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml": "name: api\ntype: service\n",
+		"schema/schema.yaml": `schema:
+  format:
+    openapi: "3.0.0"
+  service_input_type: ServiceInput
+  pipeline_input_type: PipelineInput
+  types:
+    ServiceInput:
+      type: object
+      properties:
+        image:
+          type: string
+    PipelineInput:
+      type: object
+      properties:
+        dockerfile:
+          type: string
+`,
+		"instance_infrastructure/manifest.yaml": "infrastructure: {}\n",
+		"pipeline_infrastructure/manifest.yaml": "infrastructure: {}\n",
+		"pipeline_infrastructure/main.tf": `locals {
+  dockerfile = var.pipeline.inputs.dockerfile
+  image      = var.service_instances[0].inputs.image
+  env        = var.environment.inputs.name
+}
+`,
+	})
+
+	var actual []string
+	for _, f := range lintTemplate(dir, nil) {
+		actual = append(actual, f.String())
+	}
+	expected := []string{
+		"pipeline_infrastructure/main.tf:4: environment.inputs.name: environment inputs are not available in pipeline_infrastructure/",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestCloudFormationOutputs(t *testing.T) {
+	outputs := cloudFormationOutputs([]byte(`Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+Outputs:
+  # the bucket
+  BucketName:
+    Value: !Ref Bucket
+    Export:
+      Name: exported
+  "BucketArn":
+    Value: !GetAtt Bucket.Arn
+Parameters: {}
+`))
+	if len(outputs) != 2 || !outputs["BucketName"] || !outputs["BucketArn"] {
+		t.Errorf("unexpected outputs %v", outputs)
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintTemplate(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml":        "name: vpc\ntype: environment\npublishBucket: my-bucket\n",
+		"schema/schema.yaml": testEnvironmentSchema,
+		"infrastructure/manifest.yaml": `infrastructure:
+  templates:
+    - rendering_engine: codebuild
+      settings:
+        provision:
+          - chmod +x ./output.sh && ./output.sh
+`,
+		"infrastructure/install.sh": "#!/bin/bash\n",
+		"infrastructure/output.sh":  "#!/bin/bash\n",
+	})
+	if err := os.Chmod(filepath.Join(dir, "infrastructure", "install.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	//output.sh isn't executable, but the manifest makes it executable
+	if findings := lintTemplate(dir, nil); len(findings) != 0 {
+		t.Errorf("expecting no problems, got %v", findings)
+	}
+}
+
+func TestLintTemplateProblems(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml": `name: api
+type: service
+compatibleEnvironments:
+  - vpc:1
+  - vpc
+  - vpc:latest
+encryptionKey: alias/my-key
+`,
+		"schema/schema.yaml": `schema:
+  format:
+    openapi: "3.1.0"
+  environment_input_type: EnvironmentInput
+  pipeline_input_type: PipelineInput
+  types:
+    ServiceInput:
+      type: object
+      properties:
+        port:
+          type: int
+        image:
+          type: string
+          default: 1
+      required:
+        - image
+        - name
+`,
+		"infrastructure/manifest.yaml": "infrastructure: {}\n",
+		"scripts/build.sh":             "#!/bin/bash\n",
+	})
+
+	var actual []string
+	for _, f := range lintTemplate(dir, nil) {
+		actual = append(actual, f.String())
+	}
+	expected := []string{
+		"infrastructure: is ignored for service templates, which use instance_infrastructure/",
+		"instance_infrastructure/manifest.yaml: is missing",
+		"proton.yaml:5: compatibleEnvironments: \"vpc\" must use the format name:major",
+		"proton.yaml:6: compatibleEnvironments: \"vpc:latest\" must use the format name:major",
+		"proton.yaml:7: encryptionKey: \"alias/my-key\" must be a KMS key ARN",
+		"schema/schema.yaml:1: schema.service_input_type: is required for service templates",
+		"schema/schema.yaml:3: schema.format.openapi: \"3.1.0\" is invalid. only 3.0.0 is supported",
+		"schema/schema.yaml:4: schema.environment_input_type: is only supported for environment templates",
+		"schema/schema.yaml:5: schema.pipeline_input_type: is only supported for service templates with a pipeline_infrastructure directory",
+		"schema/schema.yaml:10: schema.types.ServiceInput.properties.port: type \"int\" is invalid. only string, number, integer, boolean, array, object are supported",
+		"schema/schema.yaml:14: schema.types.ServiceInput.properties.image.default: 1 is not a valid string",
+		"schema/schema.yaml:17: schema.types.ServiceInput.required: name is not defined in properties",
+		"scripts/build.sh: shell script is not executable. run chmod +x on it",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestLintTemplateUnparsable(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml": "name: api\ntype: [service\n",
+	})
+	findings := lintTemplate(dir, nil)
+	if len(findings) != 1 || findings[0].File != "proton.yaml" || findings[0].Line == 0 {
+		t.Errorf("expecting a parse error with a line, got %v", findings)
+	}
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagListOutput       string
+	flagListTemplateType string
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists proton templates and template versions",
+	Long:  "Lists proton templates and template versions",
+	Example: `
+# List every template
+protonizer list templates
+
+# List service templates as json
+protonizer list templates --type service -o json
+
+# List the versions of a template
+protonizer list versions my_template`,
+}
+
+var listTemplatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Lists environment and service templates",
+	Args:  cobra.NoArgs,
+	Run:   doListTemplates,
+}
+
+var listVersionsCmd = &cobra.Command{
+	Use:   "versions <template>",
+	Short: "Lists the versions of a template",
+	Args:  cobra.ExactArgs(1),
+	Run:   doListVersions,
+}
+
+func init() {
+	listCmd.PersistentFlags().StringVarP(&flagListOutput, "output", "o", outputTable,
+		"The output format: table, json or yaml")
+	listTemplatesCmd.Flags().StringVar(&flagListTemplateType, "type", "",
+		"Only list templates of this type: environment or service")
+	listCmd.AddCommand(listTemplatesCmd)
+	listCmd.AddCommand(listVersionsCmd)
+	rootCmd.AddCommand(listCmd)
+}
+
+func doListTemplates(cmd *cobra.Command, args []string) {
+	if err := validateOutputFormat(flagListOutput); err != nil {
+		errorExit(err)
+	}
+	kinds := templateKinds
+	if flagListTemplateType != "" {
+		kind, err := kindFor(flagListTemplateType)
+		if err != nil {
+			errorExit(err)
+		}
+		kinds = []templateKind{kind}
+	}
+
+	cfg := getAWSConfig()
+	templates, err := listAllTemplates(context.Background(), newProtonClient(cfg), kinds)
+	handleError("listing templates", err)
+
+	err = printTemplates(os.Stdout, templates, flagListOutput)
+	handleError("printing templates", err)
+}
+
+func doListVersions(cmd *cobra.Command, args []string) {
+	if err := validateOutputFormat(flagListOutput); err != nil {
+		errorExit(err)
+	}
+	name := args[0]
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	protonClient := newProtonClient(cfg)
+
+	template, kind, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		if errors.Is(err, errTemplateNotFound) {
+			errorExit(err)
+		}
+		handleError("getting template", err)
+	}
+	versions, err := listAllTemplateVersions(ctx, protonClient, kind, name)
+	handleError("listing template versions", err)
+	sortTemplateVersions(versions)
+
+	err = printTemplateVersions(os.Stdout, template, versions, flagListOutput)
+	handleError("printing template versions", err)
+}
+
+// lists the templates of each kind, sorted by type and name
+func listAllTemplates(ctx context.Context, protonClient ProtonAPI, kinds []templateKind) ([]templateInfo, error) {
+	result := []templateInfo{}
+	for _, kind := range kinds {
+		templates, err := kind.listTemplates(ctx, protonClient)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, templates...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Type != result[j].Type {
+			return result[i].Type < result[j].Type
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// sorts template versions by major then minor version
+func sortTemplateVersions(versions []templateVersionInfo) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, b := versions[i], versions[j]
+		return compareTemplateVersions(a.MajorVersion, a.MinorVersion, b.MajorVersion, b.MinorVersion) < 0
+	})
+}
+
+func printTemplates(w io.Writer, templates []templateInfo, format string) error {
+	return printOutput(w, format, templates, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tTYPE\tRECOMMENDED\tDISPLAY NAME")
+		for _, t := range templates {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.Name, t.Type, cell(t.RecommendedVersion), cell(t.DisplayName))
+		}
+	})
+}
+
+func printTemplateVersions(w io.Writer, template *templateInfo, versions []templateVersionInfo, format string) error {
+	return printOutput(w, format, versions, func(w io.Writer) {
+		fmt.Fprintln(w, "VERSION\tSTATUS\tRECOMMENDED\tDESCRIPTION")
+		for _, v := range versions {
+			version := v.MajorVersion + "." + v.MinorVersion
+			recommended := ""
+			if version == template.RecommendedVersion {
+				recommended = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", version, v.Status, cell(recommended), cell(v.Description))
+		}
+	})
+}
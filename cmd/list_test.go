@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"gopkg.in/yaml.v3"
+)
+
+func TestListAllTemplates(t *testing.T) {
+	client := newFakeProton()
+	client.addTemplate("service", "api").recommended = "1.0"
+	client.addTemplate("environment", "vpc")
+	client.addTemplate("environment", "cluster")
+
+	templates, err := listAllTemplates(context.Background(), client, templateKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, template := range templates {
+		names = append(names, template.Type+"/"+template.Name)
+	}
+	expected := "environment/cluster environment/vpc service/api"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("expecting %s, got %v", expected, names)
+	}
+	if templates[2].RecommendedVersion != "1.0" {
+		t.Errorf("expecting recommended version 1.0, got %q", templates[2].RecommendedVersion)
+	}
+
+	templates, err = listAllTemplates(context.Background(), client, []templateKind{serviceKind{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0].Name != "api" {
+		t.Errorf("expecting only the service template, got %v", templates)
+	}
+}
+
+func TestPrintTemplateVersions(t *testing.T) {
+	template := &templateInfo{Name: "api", Type: "service", RecommendedVersion: "1.2"}
+	versions := []templateVersionInfo{
+		{MajorVersion: "1", MinorVersion: "10", Status: types.TemplateVersionStatusDraft},
+		{MajorVersion: "1", MinorVersion: "2", Status: types.TemplateVersionStatusPublished, Description: "current"},
+		{MajorVersion: "0", MinorVersion: "9", Status: types.TemplateVersionStatusPublished},
+	}
+	sortTemplateVersions(versions)
+	if versions[0].MinorVersion != "9" || versions[2].MinorVersion != "10" {
+		t.Errorf("expecting versions sorted numerically, got %v", versions)
+	}
+
+	var out bytes.Buffer
+	if err := printTemplateVersions(&out, template, versions, outputTable); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`1\.2\s+PUBLISHED\s+yes\s+current`).MatchString(out.String()) {
+		t.Errorf("expecting 1.2 to be recommended, got:\n%s", out.String())
+	}
+	if !regexp.MustCompile(`1\.10\s+DRAFT\s+-\s+-`).MatchString(out.String()) {
+		t.Errorf("expecting 1.10 to be a draft, got:\n%s", out.String())
+	}
+}
+
+func TestListAllTemplateVersions(t *testing.T) {
+	client := newFakeProton()
+	client.addTemplate("environment", "vpc")
+	client.addVersion("environment", "vpc", "1", "0", types.TemplateVersionStatusPublished)
+	client.addVersion("environment", "vpc", "1", "1", types.TemplateVersionStatusPublished)
+	client.addVersion("environment", "vpc", "2", "0", types.TemplateVersionStatusDraft)
+
+	//proton only lists the latest minor version of each major version without one
+	majors, err := environmentKind{}.listVersions(context.Background(), client, "vpc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(majors) != 2 {
+		t.Errorf("expecting the latest version of 2 major versions, got %v", majors)
+	}
+
+	//list versions and describe show every minor version
+	versions, err := listAllTemplateVersions(context.Background(), client, environmentKind{}, "vpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortTemplateVersions(versions)
+	actual := []string{}
+	for _, v := range versions {
+		actual = append(actual, v.MajorVersion+"."+v.MinorVersion)
+	}
+	if strings.Join(actual, ",") != "1.0,1.1,2.0" {
+		t.Errorf("expecting 1.0,1.1,2.0, got %v", actual)
+	}
+
+	var out bytes.Buffer
+	desc := &templateDescription{templateInfo{Name: "vpc", Type: "environment"}, versions}
+	if err := printTemplateDescription(&out, desc, outputTable); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`1\.0\s+PUBLISHED`).MatchString(out.String()) {
+		t.Errorf("expecting 1.0 to be described, got:\n%s", out.String())
+	}
+}
+
+func TestPrintTemplatesFormats(t *testing.T) {
+	templates := []templateInfo{{Name: "vpc", Type: "environment", RecommendedVersion: "2.0"}}
+
+	var out bytes.Buffer
+	if err := printTemplates(&out, templates, outputJSON); err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON []templateInfo
+	if err := json.Unmarshal(out.Bytes(), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if len(fromJSON) != 1 || fromJSON[0].RecommendedVersion != "2.0" {
+		t.Errorf("unexpected json output: %s", out.String())
+	}
+
+	out.Reset()
+	if err := printTemplates(&out, templates, outputYAML); err != nil {
+		t.Fatal(err)
+	}
+	var fromYAML []templateInfo
+	if err := yaml.Unmarshal(out.Bytes(), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if len(fromYAML) != 1 || fromYAML[0].Name != "vpc" {
+		t.Errorf("unexpected yaml output: %s", out.String())
+	}
+}
+
+func TestDescribeTemplateVersion(t *testing.T) {
+	client := newFakeProton()
+	client.addTemplate("service", "api").recommended = "1.0"
+	v := client.addVersion("service", "api", "1", "0", types.TemplateVersionStatusPublished)
+	v.schema = "schema:\n  format:\n    openapi: \"3.0.0\"\n"
+	v.compatible = []types.CompatibleEnvironmentTemplate{
+		{TemplateName: aws.String("vpc"), MajorVersion: aws.String("2")},
+	}
+
+	info, err := serviceKind{}.getVersion(context.Background(), client, "api", "1", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := &templateVersionDescription{templateVersionInfo: *info, Template: "api", Type: "service", Recommended: true}
+
+	var out bytes.Buffer
+	if err := printTemplateVersionDescription(&out, desc, outputTable); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"vpc:2", "openapi: \"3.0.0\"", "Recommended:"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expecting output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := printTemplateVersionDescription(&out, desc, outputYAML); err != nil {
+		t.Fatal(err)
+	}
+	var fromYAML map[string]interface{}
+	if err := yaml.Unmarshal(out.Bytes(), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if fromYAML["template"] != "api" || fromYAML["majorVersion"] != "1" || fromYAML["recommended"] != true {
+		t.Errorf("expecting template fields inline, got:\n%s", out.String())
+	}
+}
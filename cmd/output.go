@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// output formats for read-only commands
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// returns an error if an output format isn't supported
+func validateOutputFormat(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("output format: %s is invalid. only %s, %s and %s are supported",
+		format, outputTable, outputJSON, outputYAML)
+}
+
+// returns a writer that aligns tab separated columns
+func newTableWriter(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
+
+// prints v as json or yaml, or as a table using printTable
+func printOutput(w io.Writer, format string, v interface{}, printTable func(w io.Writer)) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(v)
+	}
+	tw := newTableWriter(w)
+	printTable(tw)
+	return tw.Flush()
+}
+
+// returns s, or - if it's empty, for table cells
+func cell(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
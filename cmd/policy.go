@@ -0,0 +1,648 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// default name of the file with policy rules that add to, override or disable the built-in rules
+const policyFile = ".protonizer-policy.yaml"
+
+// policy rule severities, from lowest to highest
+var policySeverities = []string{"info", "warning", "error"}
+
+// the severity policy violations block publishing at by default
+const defaultPolicySeverity = "error"
+
+// a set of policy rules
+type policyConfig struct {
+	//ids of built-in rules to turn off
+	Disable []string     `yaml:"disable,omitempty"`
+	Rules   []policyRule `yaml:"rules"`
+}
+
+// a compliance rule evaluated against the resources in a template's infrastructure as code
+type policyRule struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description"`
+	Severity    string `yaml:"severity"`
+
+	//resource types the rule applies to. these can be globs like AWS::RDS::*
+	Resources []string `yaml:"resources"`
+
+	//a violation is reported when require is false or deny is true
+	Require *policyCondition `yaml:"require,omitempty"`
+	Deny    *policyCondition `yaml:"deny,omitempty"`
+}
+
+// a rule condition, written as an expression string or as yaml
+type policyCondition struct {
+	expr policyExpr
+}
+
+// the yaml form of a condition
+type policyConditionYAML struct {
+	Path      string             `yaml:"path"`
+	Exists    *bool              `yaml:"exists"`
+	Equals    interface{}        `yaml:"equals"`
+	NotEquals interface{}        `yaml:"not_equals"`
+	Matches   string             `yaml:"matches"`
+	All       []*policyCondition `yaml:"all"`
+	Any       []*policyCondition `yaml:"any"`
+	Not       *policyCondition   `yaml:"not"`
+}
+
+func (c *policyCondition) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		e, err := parsePolicyExpr(n.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		c.expr = e
+		return nil
+	}
+
+	var y policyConditionYAML
+	if err := n.Decode(&y); err != nil {
+		return err
+	}
+	var exprs []policyExpr
+	var p policyExpr
+	if y.Path != "" {
+		pp, err := parsePolicyPath(y.Path)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		p = pp
+	}
+	needsPath := y.Exists != nil || y.Equals != nil || y.NotEquals != nil || y.Matches != ""
+	if needsPath && p == nil {
+		return fmt.Errorf("line %d: path is required with exists, equals, not_equals and matches", n.Line)
+	}
+	if y.Exists != nil {
+		var e policyExpr = &policyCall{name: "exists", args: []policyExpr{p}}
+		if !*y.Exists {
+			e = &policyNot{x: e}
+		}
+		exprs = append(exprs, e)
+	}
+	if y.Equals != nil {
+		exprs = append(exprs, &policyBinary{op: "==", left: p, right: &policyLiteral{value: y.Equals}})
+	}
+	if y.NotEquals != nil {
+		exprs = append(exprs, &policyBinary{op: "!=", left: p, right: &policyLiteral{value: y.NotEquals}})
+	}
+	if y.Matches != "" {
+		e, err := parsePolicyExpr(y.Path + " =~ " + fmt.Sprintf("%q", y.Matches))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		exprs = append(exprs, e)
+	}
+	if p != nil && !needsPath {
+		exprs = append(exprs, p)
+	}
+	for _, c := range y.All {
+		exprs = append(exprs, c.expr)
+	}
+	if len(y.Any) > 0 {
+		var e policyExpr = y.Any[0].expr
+		for _, c := range y.Any[1:] {
+			e = &policyBinary{op: "||", left: e, right: c.expr}
+		}
+		exprs = append(exprs, e)
+	}
+	if y.Not != nil {
+		exprs = append(exprs, &policyNot{x: y.Not.expr})
+	}
+	if len(exprs) == 0 {
+		return fmt.Errorf("line %d: condition is empty", n.Line)
+	}
+	c.expr = exprs[0]
+	for _, e := range exprs[1:] {
+		c.expr = &policyBinary{op: "&&", left: c.expr, right: e}
+	}
+	return nil
+}
+
+// returns the problems with a rule
+func (r *policyRule) validate() []string {
+	var problems []string
+	if r.ID == "" {
+		problems = append(problems, "id: is required")
+	}
+	if policySeverityRank(r.Severity) < 0 {
+		problems = append(problems, fmt.Sprintf("severity: %q is invalid. only %s are supported",
+			r.Severity, strings.Join(policySeverities, ", ")))
+	}
+	if len(r.Resources) == 0 {
+		problems = append(problems, "resources: at least one resource type is required")
+	}
+	for _, pattern := range r.Resources {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("resources: %q is not a valid glob", pattern))
+		}
+	}
+	if (r.Require == nil) == (r.Deny == nil) {
+		problems = append(problems, "one of require or deny is required")
+	}
+	return problems
+}
+
+// returns true if the rule applies to a resource type
+func (r *policyRule) appliesTo(resourceType string) bool {
+	for _, pattern := range r.Resources {
+		if ok, _ := path.Match(pattern, resourceType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the position of a severity in policySeverities, or -1 if it's invalid
+func policySeverityRank(severity string) int {
+	for i, s := range policySeverities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// parses policy rules, defaulting their severity to error
+func parsePolicyConfig(b []byte) (*policyConfig, error) {
+	var c policyConfig
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	var problems []string
+	ids := map[string]bool{}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.Severity == "" {
+			r.Severity = defaultPolicySeverity
+		}
+		for _, p := range r.validate() {
+			problems = append(problems, fmt.Sprintf("rules[%d] %s: %s", i, r.ID, p))
+		}
+		if ids[r.ID] {
+			problems = append(problems, fmt.Sprintf("rules[%d] %s: id is used by another rule", i, r.ID))
+		}
+		ids[r.ID] = true
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+	return &c, nil
+}
+
+// returns the built-in rules merged with the rules in a policy file. rules in the file
+// replace built-in rules with the same id. a missing policy file in the template
+// directory results in just the built-in rules, but a missing file that was asked for is an error
+func loadPolicyRules(dir, file string) ([]policyRule, error) {
+	builtin, err := parsePolicyConfig(readTemplateFS("policy/rules.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing built-in policy rules: %w", err)
+	}
+	if file == "" {
+		file = filepath.Join(dir, policyFile)
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return builtin.Rules, nil
+		}
+	}
+
+	m := "reading policy rules: " + file
+	debug(m)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	custom, err := parsePolicyConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	var rules []policyRule
+	for _, r := range builtin.Rules {
+		if SliceContains(&custom.Disable, r.ID, true) {
+			continue
+		}
+		overridden := false
+		for _, c := range custom.Rules {
+			overridden = overridden || c.ID == r.ID
+		}
+		if !overridden {
+			rules = append(rules, r)
+		}
+	}
+	return append(rules, custom.Rules...), nil
+}
+
+// a resource (or provider or data source) declared in infrastructure as code
+type policyResource struct {
+	File string
+	Line int
+
+	//resource types are aws_s3_bucket, data.aws_iam_policy_document and
+	//provider.aws for terraform, and AWS::S3::Bucket for cloudformation
+	Type       string
+	Name       string
+	Attributes map[string]interface{}
+
+	//terraform addresses the resource refers to, for example aws_s3_bucket.logs
+	references map[string]bool
+}
+
+func (r *policyResource) String() string {
+	if r.Name == "" {
+		return r.Type
+	}
+	return r.Type + " " + r.Name
+}
+
+// returns the terraform address other resources use to refer to this one,
+// or "" for cloudformation resources and providers
+func (r *policyResource) address() string {
+	if strings.Contains(r.Type, "::") || strings.HasPrefix(r.Type, "provider.") {
+		return ""
+	}
+	return r.Type + "." + r.Name
+}
+
+// returns the directory (terraform module) that declares the resource
+func (r *policyResource) dir() string {
+	return path.Dir(r.File)
+}
+
+// a resource that breaks a policy rule
+type policyViolation struct {
+	Rule     policyRule
+	Resource *policyResource
+}
+
+func (v policyViolation) String() string {
+	return fmt.Sprintf("%s:%d: %s", v.Resource.File, v.Resource.Line, v.message())
+}
+
+// describes the violation, without its location
+func (v policyViolation) message() string {
+	return fmt.Sprintf("policy %s (%s): %s: %s", v.Rule.ID, v.Rule.Severity, v.Resource, v.Rule.Description)
+}
+
+// evaluates rules against resources, returning the violations in resource order
+func evaluatePolicy(rules []policyRule, resources []*policyResource) []policyViolation {
+	var result []policyViolation
+	for _, resource := range resources {
+		c := &policyEvalContext{resource: resource, resources: resources}
+		for _, rule := range rules {
+			if !rule.appliesTo(resource.Type) {
+				continue
+			}
+			violated := false
+			if rule.Require != nil {
+				violated = !policyTruthy(rule.Require.expr.eval(c))
+			} else {
+				violated = policyTruthy(rule.Deny.expr.eval(c))
+			}
+			if violated {
+				result = append(result, policyViolation{Rule: rule, Resource: resource})
+			}
+		}
+	}
+	return result
+}
+
+// returns the resources declared in the terraform and cloudformation files in a
+// template's infrastructure directories, including terraform modules in subdirectories.
+// files that don't parse are skipped, since lint reports them
+func templatePolicyResources(dir string) ([]*policyResource, error) {
+	var resources []*policyResource
+	for _, infraDir := range []string{protonInfrastructureDirEnv, protonInfrastructureDirSvc, "pipeline_infrastructure"} {
+		root := filepath.Join(dir, infraDir)
+		if _, err := os.Stat(root); err != nil {
+			continue
+		}
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			isTF := filepath.Ext(p) == ".tf"
+			if !isTF && (!isCloudFormationFile(d.Name()) || d.Name() == "manifest.yaml") {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if isTF {
+				resources = append(resources, tfPolicyResources(filepath.ToSlash(rel), b)...)
+			} else {
+				resources = append(resources, cloudFormationPolicyResources(filepath.ToSlash(rel), b)...)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(resources, func(i, j int) bool {
+		if resources[i].File != resources[j].File {
+			return resources[i].File < resources[j].File
+		}
+		return resources[i].Line < resources[j].Line
+	})
+	return resources, nil
+}
+
+// returns the resources, data sources, providers and modules in a terraform file
+func tfPolicyResources(file string, b []byte) []*policyResource {
+	f, diags := hclsyntax.ParseConfig(b, file, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		debug("skipping policy checks for", file, diags.Error())
+		return nil
+	}
+	var result []*policyResource
+	for _, block := range f.Body.(*hclsyntax.Body).Blocks {
+		r := &policyResource{File: file, Line: block.DefRange().Start.Line}
+		switch {
+		case block.Type == "resource" && len(block.Labels) == 2:
+			r.Type, r.Name = block.Labels[0], block.Labels[1]
+		case block.Type == "data" && len(block.Labels) == 2:
+			r.Type, r.Name = "data."+block.Labels[0], block.Labels[1]
+		case block.Type == "provider" && len(block.Labels) == 1:
+			r.Type = "provider." + block.Labels[0]
+		case block.Type == "module" && len(block.Labels) == 1:
+			r.Type, r.Name = "module", block.Labels[0]
+		default:
+			continue
+		}
+		r.Attributes = hclBodyValue(block.Body, b)
+		if alias, ok := r.Attributes["alias"].(string); ok && block.Type == "provider" {
+			r.Name = alias
+		}
+		r.references = map[string]bool{}
+		hclsyntax.VisitAll(block.Body, func(n hclsyntax.Node) hcl.Diagnostics {
+			if e, ok := n.(*hclsyntax.ScopeTraversalExpr); ok {
+				r.references[traversalAddress(e.Traversal)] = true
+			}
+			return nil
+		})
+		result = append(result, r)
+	}
+	return result
+}
+
+// returns the resource address a traversal refers to, for example
+// aws_s3_bucket.logs for aws_s3_bucket.logs.id
+func traversalAddress(t hcl.Traversal) string {
+	parts := []string{t.RootName()}
+	want := 2
+	if t.RootName() == "data" {
+		want = 3
+	}
+	for _, step := range t[1:] {
+		attr, ok := step.(hcl.TraverseAttr)
+		if !ok || len(parts) == want {
+			break
+		}
+		parts = append(parts, attr.Name)
+	}
+	return strings.Join(parts, ".")
+}
+
+// converts a terraform block body to a map. nested blocks become lists of maps
+func hclBodyValue(body *hclsyntax.Body, src []byte) map[string]interface{} {
+	result := map[string]interface{}{}
+	for name, attr := range body.Attributes {
+		result[name] = hclExprValue(attr.Expr, src)
+	}
+	for _, block := range body.Blocks {
+		list, _ := result[block.Type].([]interface{})
+		result[block.Type] = append(list, hclBodyValue(block.Body, src))
+	}
+	return result
+}
+
+// converts a terraform expression to a value. literals are converted to their values,
+// jsonencode() to its argument, and anything that needs evaluating to its source text
+func hclExprValue(e hclsyntax.Expression, src []byte) interface{} {
+	switch e := e.(type) {
+	case *hclsyntax.ObjectConsExpr:
+		result := map[string]interface{}{}
+		for _, item := range e.Items {
+			k, diags := item.KeyExpr.Value(nil)
+			if diags.HasErrors() || !k.IsKnown() || k.IsNull() || k.Type() != cty.String {
+				continue
+			}
+			result[k.AsString()] = hclExprValue(item.ValueExpr, src)
+		}
+		return result
+	case *hclsyntax.TupleConsExpr:
+		result := []interface{}{}
+		for _, item := range e.Exprs {
+			result = append(result, hclExprValue(item, src))
+		}
+		return result
+	case *hclsyntax.FunctionCallExpr:
+		if e.Name == "jsonencode" && len(e.Args) == 1 {
+			return hclExprValue(e.Args[0], src)
+		}
+	}
+	v, diags := e.Value(nil)
+	if !diags.HasErrors() && v.IsWhollyKnown() {
+		if result, ok := ctyValue(v); ok {
+			return result
+		}
+	}
+	return string(e.Range().SliceBytes(src))
+}
+
+// converts a known cty value to a go value
+func ctyValue(v cty.Value) (interface{}, bool) {
+	if v.IsNull() {
+		return nil, true
+	}
+	t := v.Type()
+	switch {
+	case t == cty.String:
+		return v.AsString(), true
+	case t == cty.Number:
+		f, _ := v.AsBigFloat().Float64()
+		return f, true
+	case t == cty.Bool:
+		return v.True(), true
+	case t.IsListType() || t.IsTupleType() || t.IsSetType():
+		result := []interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, item := it.Element()
+			converted, ok := ctyValue(item)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, converted)
+		}
+		return result, true
+	case t.IsMapType() || t.IsObjectType():
+		result := map[string]interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			k, item := it.Element()
+			converted, ok := ctyValue(item)
+			if !ok {
+				return nil, false
+			}
+			result[k.AsString()] = converted
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// returns the resources in a (jinja) cloudformation template
+func cloudFormationPolicyResources(file string, b []byte) []*policyResource {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(stripJinja(b), &doc); err != nil {
+		debug("skipping policy checks for", file, err)
+		return nil
+	}
+	_, resources := yamlMappingValue(contentRoot(&doc), "Resources")
+	if resources == nil || resources.Kind != yaml.MappingNode {
+		return nil
+	}
+	var result []*policyResource
+	for i := 0; i+1 < len(resources.Content); i += 2 {
+		key, value := resources.Content[i], resources.Content[i+1]
+		attributes, _ := yamlNodeValue(value).(map[string]interface{})
+		resourceType, _ := attributes["Type"].(string)
+		result = append(result, &policyResource{
+			File:       file,
+			Line:       key.Line,
+			Type:       resourceType,
+			Name:       key.Value,
+			Attributes: attributes,
+		})
+	}
+	return result
+}
+
+// replaces jinja tags so that a jinja cloudformation template parses as yaml.
+// expressions become a placeholder value and statements are removed,
+// keeping line numbers the same
+func stripJinja(b []byte) []byte {
+	return jinjaTagPattern.ReplaceAllFunc(b, func(tag []byte) []byte {
+		newlines := bytes.Repeat([]byte("\n"), bytes.Count(tag, []byte("\n")))
+		if bytes.HasPrefix(tag, []byte("{{")) {
+			return append([]byte("jinja"), newlines...)
+		}
+		return newlines
+	})
+}
+
+// converts a yaml node to a go value. cloudformation short form intrinsic functions
+// like !Ref x are converted to their long form, for example {Ref: x}
+func yamlNodeValue(n *yaml.Node) interface{} {
+	if n.Kind == yaml.AliasNode {
+		return yamlNodeValue(n.Alias)
+	}
+	if strings.HasPrefix(n.Tag, "!") && !strings.HasPrefix(n.Tag, "!!") {
+		function := strings.TrimPrefix(n.Tag, "!")
+		if function != "Ref" && function != "Condition" {
+			function = "Fn::" + function
+		}
+		untagged := *n
+		untagged.Tag = ""
+		if n.Kind == yaml.ScalarNode {
+			untagged.Tag = "!!str"
+		}
+		return map[string]interface{}{function: yamlNodeValue(&untagged)}
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		result := map[string]interface{}{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			result[n.Content[i].Value] = yamlNodeValue(n.Content[i+1])
+		}
+		return result
+	case yaml.SequenceNode:
+		result := []interface{}{}
+		for _, item := range n.Content {
+			result = append(result, yamlNodeValue(item))
+		}
+		return result
+	}
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return n.Value
+	}
+	return v
+}
+
+// returned when a template breaks policy rules at or above the blocking severity
+type policyError struct {
+	violations []policyViolation
+	severity   string
+	operation  string
+}
+
+func (e *policyError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "policy check found %d violation(s) at or above %s severity, refusing to %s:\n",
+		len(e.violations), e.severity, e.operation)
+	for _, v := range e.violations {
+		fmt.Fprintln(&sb, "  "+v.String())
+	}
+	fmt.Fprintf(&sb, "\nto change a rule's severity or disable it, add it to %s", policyFile)
+	return sb.String()
+}
+
+// checks a template directory against policy rules before an operation,
+// returning a *policyError listing violations at or above the severity
+func checkTemplatePolicy(dir, file, severity, operation string) error {
+	if severity == "" {
+		severity = defaultPolicySeverity
+	}
+	rules, err := loadPolicyRules(dir, file)
+	if err != nil {
+		return err
+	}
+
+	m := "checking template against policy rules: " + dir
+	debug(m)
+	resources, err := templatePolicyResources(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	var blocking []policyViolation
+	for _, v := range evaluatePolicy(rules, resources) {
+		if policySeverityRank(v.Rule.Severity) >= policySeverityRank(severity) {
+			blocking = append(blocking, v)
+		} else {
+			debug("policy:", v)
+		}
+	}
+	if len(blocking) > 0 {
+		return &policyError{violations: blocking, severity: severity, operation: operation}
+	}
+	return nil
+}
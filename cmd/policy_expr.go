@@ -0,0 +1,609 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a parsed policy expression. expressions are evaluated against a resource's
+// attributes and return every value they match, so that paths that fan out over
+// lists behave like rego's iteration: a comparison is true if any value matches
+type policyExpr interface {
+	eval(c *policyEvalContext) []interface{}
+}
+
+// what a policy expression is evaluated against
+type policyEvalContext struct {
+	resource  *policyResource
+	resources []*policyResource
+}
+
+// a path into a resource's attributes, for example Properties.PolicyDocument.Statement[*].Action
+type policyPath struct {
+	segments []string
+}
+
+// a string, number, boolean or null literal
+type policyLiteral struct {
+	value interface{}
+}
+
+// a function call, for example exists(Properties.BucketEncryption)
+type policyCall struct {
+	name string
+	args []policyExpr
+}
+
+// a binary operator
+type policyBinary struct {
+	op          string
+	left, right policyExpr
+	pattern     *regexp.Regexp
+}
+
+// the ! operator
+type policyNot struct {
+	x policyExpr
+}
+
+// the functions policy expressions can call, and how many arguments they take
+var policyFunctions = map[string]int{
+	"exists":        1,
+	"count":         1,
+	"keys":          1,
+	"lower":         1,
+	"referenced_by": 1,
+}
+
+// parses a policy expression such as
+//
+//	exists(Properties.BucketEncryption) || DeletionPolicy == "Retain"
+func parsePolicyExpr(s string) (policyExpr, error) {
+	p := &policyParser{input: s}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tokens[p.pos].text, p.tokens[p.pos].offset+1)
+	}
+	return e, nil
+}
+
+type policyToken struct {
+	kind   string //op, string, number, ident
+	text   string
+	offset int
+}
+
+type policyParser struct {
+	input  string
+	tokens []policyToken
+	pos    int
+}
+
+var policyOperators = []string{"&&", "||", "==", "!=", "=~", "!~", "<=", ">=", "<", ">", "!", "(", ")", ","}
+
+func isPolicyIdentChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == '*':
+		return true
+	case first:
+		return false
+	case c >= '0' && c <= '9', c == ':', c == '-', c == '.', c == '[', c == ']':
+		return true
+	}
+	return false
+}
+
+func (p *policyParser) tokenize() error {
+	s := p.input
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			p.tokens = append(p.tokens, policyToken{"string", s[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			text, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return fmt.Errorf("invalid string at position %d: %w", i+1, err)
+			}
+			p.tokens = append(p.tokens, policyToken{"string", text, i})
+			i = end + 1
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			end := i + 1
+			for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.') {
+				end++
+			}
+			p.tokens = append(p.tokens, policyToken{"number", s[i:end], i})
+			i = end
+		case isPolicyIdentChar(c, true):
+			end := i + 1
+			for end < len(s) && isPolicyIdentChar(s[end], false) {
+				end++
+			}
+			p.tokens = append(p.tokens, policyToken{"ident", s[i:end], i})
+			i = end
+		default:
+			matched := false
+			for _, op := range policyOperators {
+				if strings.HasPrefix(s[i:], op) {
+					p.tokens = append(p.tokens, policyToken{"op", op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Errorf("unexpected %q at position %d", c, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// returns true and advances if the next token is the operator op
+func (p *policyParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == "op" && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *policyParser) parseOr() (policyExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &policyBinary{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseAnd() (policyExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &policyBinary{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseNot() (policyExpr, error) {
+	if p.accept("!") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &policyNot{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *policyParser) parseComparison() (policyExpr, error) {
+	left, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "=~", "!~", "<=", ">=", "<", ">"} {
+		if !p.accept(op) {
+			continue
+		}
+		right, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		b := &policyBinary{op: op, left: left, right: right}
+		if op == "=~" || op == "!~" {
+			lit, ok := right.(*policyLiteral)
+			s, isString := lit.stringValue()
+			if !ok || !isString {
+				return nil, fmt.Errorf("%s must be followed by a regular expression string", op)
+			}
+			if b.pattern, err = regexp.Compile(s); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseValue() (policyExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case "string":
+		return &policyLiteral{value: t.text}, nil
+	case "number":
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.offset+1)
+		}
+		return &policyLiteral{value: n}, nil
+	case "ident":
+		switch t.text {
+		case "true":
+			return &policyLiteral{value: true}, nil
+		case "false":
+			return &policyLiteral{value: false}, nil
+		case "null":
+			return &policyLiteral{value: nil}, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		return parsePolicyPath(t.text)
+	case "op":
+		if t.text == "(" {
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, fmt.Errorf("missing ) for ( at position %d", t.offset+1)
+			}
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.offset+1)
+}
+
+func (p *policyParser) parseCall(name policyToken) (policyExpr, error) {
+	arity, ok := policyFunctions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at position %d", name.text, name.offset+1)
+	}
+	call := &policyCall{name: name.text}
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, fmt.Errorf("missing ) for %s( at position %d", name.text, name.offset+1)
+			}
+		}
+	}
+	if len(call.args) != arity {
+		return nil, fmt.Errorf("%s takes %d argument(s), got %d", name.text, arity, len(call.args))
+	}
+	return call, nil
+}
+
+// parses a path like a.b[0].c[*] into the segments a, b, [0], c, [*]
+func parsePolicyPath(s string) (*policyPath, error) {
+	p := &policyPath{}
+	for _, part := range strings.Split(s, ".") {
+		key := part
+		var indexes []string
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			key = part[:i]
+			rest := part[i:]
+			for rest != "" {
+				end := strings.IndexByte(rest, ']')
+				if rest[0] != '[' || end < 0 {
+					return nil, fmt.Errorf("invalid path %s", s)
+				}
+				index := rest[1:end]
+				if _, err := strconv.Atoi(index); err != nil && index != "*" {
+					return nil, fmt.Errorf("invalid index [%s] in path %s. use a number or *", index, s)
+				}
+				indexes = append(indexes, "["+index+"]")
+				rest = rest[end+1:]
+			}
+		}
+		if key == "" && len(indexes) == 0 {
+			return nil, fmt.Errorf("invalid path %s", s)
+		}
+		if key != "" {
+			p.segments = append(p.segments, key)
+		}
+		p.segments = append(p.segments, indexes...)
+	}
+	return p, nil
+}
+
+// returns the literal's value if it's a string
+func (l *policyLiteral) stringValue() (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	s, ok := l.value.(string)
+	return s, ok
+}
+
+func (l *policyLiteral) eval(c *policyEvalContext) []interface{} {
+	return []interface{}{l.value}
+}
+
+func (p *policyPath) eval(c *policyEvalContext) []interface{} {
+	values := []interface{}{c.resource.Attributes}
+	for _, segment := range p.segments {
+		var next []interface{}
+		for _, v := range values {
+			next = append(next, policyStep(v, segment)...)
+		}
+		values = next
+	}
+	return values
+}
+
+// returns the values a path segment selects from a value. keys are applied to
+// every item of a list, and strings holding json documents are decoded first
+func policyStep(v interface{}, segment string) []interface{} {
+	if s, ok := v.(string); ok && (strings.HasPrefix(strings.TrimSpace(s), "{") || strings.HasPrefix(strings.TrimSpace(s), "[")) {
+		var decoded interface{}
+		if json.Unmarshal([]byte(s), &decoded) == nil {
+			v = decoded
+		}
+	}
+	if strings.HasPrefix(segment, "[") {
+		index := segment[1 : len(segment)-1]
+		list, ok := v.([]interface{})
+		switch {
+		case !ok && index == "*":
+			return []interface{}{v}
+		case !ok:
+			return nil
+		case index == "*":
+			return list
+		}
+		i, _ := strconv.Atoi(index)
+		if i < 0 || i >= len(list) {
+			return nil
+		}
+		return []interface{}{list[i]}
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if segment == "*" {
+			var result []interface{}
+			for _, k := range sortedKeys(v) {
+				result = append(result, v[k])
+			}
+			return result
+		}
+		if value, ok := v[segment]; ok {
+			return []interface{}{value}
+		}
+	case []interface{}:
+		var result []interface{}
+		for _, item := range v {
+			result = append(result, policyStep(item, segment)...)
+		}
+		return result
+	}
+	return nil
+}
+
+func (f *policyCall) eval(c *policyEvalContext) []interface{} {
+	values := f.args[0].eval(c)
+	switch f.name {
+	case "exists":
+		for _, v := range values {
+			if v != nil {
+				return []interface{}{true}
+			}
+		}
+		return []interface{}{false}
+	case "count":
+		n := 0
+		for _, v := range flattenPolicyValues(values) {
+			if v != nil {
+				n++
+			}
+		}
+		return []interface{}{float64(n)}
+	case "keys":
+		var result []interface{}
+		for _, v := range values {
+			if m, ok := v.(map[string]interface{}); ok {
+				for _, k := range sortedKeys(m) {
+					result = append(result, k)
+				}
+			}
+		}
+		return result
+	case "lower":
+		var result []interface{}
+		for _, v := range flattenPolicyValues(values) {
+			if s, ok := v.(string); ok {
+				v = strings.ToLower(s)
+			}
+			result = append(result, v)
+		}
+		return result
+	case "referenced_by":
+		//true if a terraform resource of the given type refers to this resource
+		address := c.resource.address()
+		for _, v := range values {
+			pattern, ok := v.(string)
+			if !ok || address == "" {
+				continue
+			}
+			for _, r := range c.resources {
+				matched, _ := path.Match(pattern, r.Type)
+				if matched && r.dir() == c.resource.dir() && r.references[address] {
+					return []interface{}{true}
+				}
+			}
+		}
+		return []interface{}{false}
+	}
+	return nil
+}
+
+func (b *policyBinary) eval(c *policyEvalContext) []interface{} {
+	switch b.op {
+	case "&&":
+		return []interface{}{policyTruthy(b.left.eval(c)) && policyTruthy(b.right.eval(c))}
+	case "||":
+		return []interface{}{policyTruthy(b.left.eval(c)) || policyTruthy(b.right.eval(c))}
+	}
+	left := flattenPolicyValues(b.left.eval(c))
+	right := flattenPolicyValues(b.right.eval(c))
+	match := false
+	for _, l := range left {
+		for _, r := range right {
+			if policyCompare(b, l, r) {
+				match = true
+			}
+		}
+	}
+	if b.op == "!=" || b.op == "!~" {
+		return []interface{}{!match}
+	}
+	return []interface{}{match}
+}
+
+// compares two scalar values with a binary operator. != and !~ are
+// evaluated as the negation of == and =~ over every value
+func policyCompare(b *policyBinary, l, r interface{}) bool {
+	switch b.op {
+	case "==", "!=":
+		return policyScalar(l) == policyScalar(r)
+	case "=~", "!~":
+		s, ok := policyScalarString(l)
+		return ok && b.pattern.MatchString(s)
+	}
+	ln, lok := number(l)
+	rn, rok := number(r)
+	if !lok || !rok {
+		return false
+	}
+	switch b.op {
+	case "<":
+		return ln < rn
+	case "<=":
+		return ln <= rn
+	case ">":
+		return ln > rn
+	case ">=":
+		return ln >= rn
+	}
+	return false
+}
+
+func (n *policyNot) eval(c *policyEvalContext) []interface{} {
+	return []interface{}{!policyTruthy(n.x.eval(c))}
+}
+
+// returns true if any value is set and isn't false or empty
+func policyTruthy(values []interface{}) bool {
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+		case bool:
+			if v {
+				return true
+			}
+		case string:
+			if v != "" {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// expands lists so that comparisons apply to their items
+func flattenPolicyValues(values []interface{}) []interface{} {
+	var result []interface{}
+	for _, v := range values {
+		if list, ok := v.([]interface{}); ok {
+			result = append(result, flattenPolicyValues(list)...)
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+// returns a comparable form of a scalar. numbers are normalized so that 1 == 1.0,
+// and booleans compare equal to the strings cloudformation often uses for them
+func policyScalar(v interface{}) string {
+	if n, ok := number(v); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	if v == nil {
+		return "null"
+	}
+	if s, ok := policyScalarString(v); ok {
+		return s
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// returns a scalar as a string
+func policyScalarString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	}
+	if n, ok := number(v); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	}
+	return fmt.Sprint(v), true
+}
+
+// returns the keys of a map in sorted order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyExpr(t *testing.T) {
+	resource := &policyResource{Attributes: map[string]interface{}{
+		"DeletionPolicy": "Retain",
+		"Properties": map[string]interface{}{
+			"Port":    8080,
+			"Enabled": "true",
+			"Tags": map[string]interface{}{
+				"Cost-Center": "1234",
+			},
+			"Statement": []interface{}{
+				map[string]interface{}{"Action": "s3:GetObject"},
+				map[string]interface{}{"Action": []interface{}{"s3:PutObject", "sqs:*"}},
+			},
+			"Policy": `{"Statement": [{"Action": "*"}]}`,
+		},
+	}}
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`DeletionPolicy == "Retain"`, true},
+		{`DeletionPolicy != 'Retain'`, false},
+		{`exists(Properties.Tags)`, true},
+		{`exists(Properties.Missing)`, false},
+		{`!exists(Properties.Missing) && Properties.Port >= 8000`, true},
+		{`Properties.Port == 8080.0`, true},
+		{`Properties.Port < 80 || Properties.Enabled == true`, true},
+		{`keys(Properties.Tags) =~ '(?i)^cost-center$'`, true},
+		{`lower(Properties.Tags.*) == "1234"`, true},
+		{`Properties.Statement.Action =~ '^(\*|[^:]+:\*)$'`, true},
+		{`Properties.Statement[0].Action =~ ':\*$'`, false},
+		{`Properties.Statement[*].Action == "s3:PutObject"`, true},
+		{`count(Properties.Statement.Action) == 3`, true},
+		{`Properties.Policy.Statement.Action == "*"`, true},
+		{`Properties.Missing`, false},
+		{`(Properties.Port == 1 || Properties.Port == 8080) && !(DeletionPolicy == "Delete")`, true},
+	}
+	for _, test := range tests {
+		e, err := parsePolicyExpr(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		actual := policyTruthy(e.eval(&policyEvalContext{resource: resource}))
+		if actual != test.expected {
+			t.Errorf("%s: expecting %v, got %v", test.expr, test.expected, actual)
+		}
+	}
+}
+
+func TestPolicyExprInvalid(t *testing.T) {
+	for _, expr := range []string{
+		`DeletionPolicy ==`,
+		`exists(a, b)`,
+		`unknown(a)`,
+		`a =~ b`,
+		`a =~ '('`,
+		`(a == 1`,
+		`a[x] == 1`,
+		`"unterminated`,
+		`a == 1 b`,
+	} {
+		if _, err := parsePolicyExpr(expr); err == nil {
+			t.Errorf("%s: expecting an error", expr)
+		}
+	}
+}
+
+func TestLintPolicyCloudFormation(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml": "name: vpc\ntype: environment\n",
+		"infrastructure/cloudformation.yaml": `Resources:
+  Logs:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: '{{ environment.name }}-logs'
+  {% if environment.inputs.table %}
+  Table:
+    Type: AWS::DynamoDB::Table
+    DeletionPolicy: Retain
+  {% endif %}
+  Role:
+    Type: AWS::IAM::Role
+    Properties:
+      Policies:
+        - PolicyName: all
+          PolicyDocument:
+            Statement:
+              - Effect: Allow
+                Action: [s3:*]
+                Resource: !GetAtt Logs.Arn
+`,
+	})
+	rules, err := loadPolicyRules(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, f := range lintTemplate(dir, rules) {
+		if f.Severity != "" {
+			actual = append(actual, f.String())
+		}
+	}
+	expected := []string{
+		"infrastructure/cloudformation.yaml:2: policy s3-bucket-encryption (error): AWS::S3::Bucket Logs: S3 buckets must be encrypted",
+		"infrastructure/cloudformation.yaml:2: policy stateful-deletion-policy-retain (error): AWS::S3::Bucket Logs: stateful resources must have DeletionPolicy Retain so their data survives a stack delete",
+		"infrastructure/cloudformation.yaml:11: policy no-wildcard-iam-actions (error): AWS::IAM::Role Role: IAM policy statements must not use wildcard actions such as * or s3:*",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestLintPolicyTerraform(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml": "name: vpc\ntype: environment\n",
+		"infrastructure/main.tf": `provider "aws" {
+  default_tags {
+    tags = {
+      "proton:environment" = var.environment.name
+      cost_center          = "1234"
+    }
+  }
+}
+
+provider "aws" {
+  alias = "west"
+}
+
+resource "aws_s3_bucket" "logs" {
+  bucket = "${var.environment.name}-logs"
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "logs" {
+  bucket = aws_s3_bucket.logs.id
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
+    }
+  }
+}
+
+module "data" {
+  source = "./data"
+}
+`,
+		"infrastructure/data/main.tf": `resource "aws_s3_bucket" "data" {
+  bucket = "data"
+}
+
+data "aws_iam_policy_document" "read" {
+  statement {
+    actions   = ["s3:GetObject"]
+    resources = ["*"]
+  }
+}
+
+resource "aws_iam_policy" "admin" {
+  policy = jsonencode({
+    Statement = [{
+      Effect   = "Allow"
+      Action   = "*"
+      Resource = "*"
+    }]
+  })
+}
+`,
+	})
+	rules, err := loadPolicyRules(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, f := range lintTemplate(dir, rules) {
+		if f.Severity != "" {
+			actual = append(actual, f.String())
+		}
+	}
+	expected := []string{
+		"infrastructure/data/main.tf:1: policy s3-bucket-encryption (error): aws_s3_bucket data: S3 buckets must be encrypted",
+		"infrastructure/data/main.tf:12: policy no-wildcard-iam-actions (error): aws_iam_policy admin: IAM policy statements must not use wildcard actions such as * or s3:*",
+		"infrastructure/main.tf:10: policy default-tags-cost-center (warning): provider.aws west: the aws provider's default_tags must include a cost-center tag",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestLoadPolicyRules(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		policyFile: `disable:
+  - no-wildcard-iam-actions
+rules:
+  - id: default-tags-cost-center
+    description: cost center tag
+    severity: info
+    resources: [provider.aws]
+    require: exists(default_tags.tags.cost-center)
+  - id: no-public-buckets
+    description: buckets must not be public
+    resources: [AWS::S3::*]
+    deny:
+      any:
+        - path: Properties.AccessControl
+          matches: ^Public
+        - path: Properties.PublicAccessBlockConfiguration.BlockPublicAcls
+          equals: false
+`,
+	})
+	rules, err := loadPolicyRules(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	severities := map[string]string{}
+	for _, r := range rules {
+		severities[r.ID] = r.Severity
+	}
+	expected := map[string]string{
+		"s3-bucket-encryption":            "error",
+		"stateful-deletion-policy-retain": "error",
+		"default-tags-cost-center":        "info",
+		"no-public-buckets":               "error",
+	}
+	if len(severities) != len(expected) {
+		t.Errorf("expecting rules %v, got %v", expected, severities)
+	}
+	for id, severity := range expected {
+		if severities[id] != severity {
+			t.Errorf("%s: expecting severity %s, got %s", id, severity, severities[id])
+		}
+	}
+
+	//the yaml form of a condition
+	resources := cloudFormationPolicyResources("cloudformation.yaml", []byte(`Resources:
+  Public:
+    Type: AWS::S3::Bucket
+    Properties:
+      AccessControl: PublicRead
+  Private:
+    Type: AWS::S3::Bucket
+    Properties:
+      PublicAccessBlockConfiguration:
+        BlockPublicAcls: true
+`))
+	var violations []string
+	for _, v := range evaluatePolicy(rules, resources) {
+		if v.Rule.ID == "no-public-buckets" {
+			violations = append(violations, v.Resource.Name)
+		}
+	}
+	if strings.Join(violations, ",") != "Public" {
+		t.Errorf("expecting only Public to be public, got %v", violations)
+	}
+
+	//a policy file that was asked for must exist
+	if _, err := loadPolicyRules(dir, filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("expecting an error for a missing policy file")
+	}
+}
+
+func TestParsePolicyConfigInvalid(t *testing.T) {
+	_, err := parsePolicyConfig([]byte(`rules:
+  - id: a
+    severity: critical
+    resources: [aws_s3_bucket]
+  - id: a
+    resources: []
+    require: exists(x)
+    deny: exists(y)
+`))
+	if err == nil {
+		t.Fatal("expecting an error")
+	}
+	for _, problem := range []string{
+		`rules[0] a: severity: "critical" is invalid`,
+		"rules[0] a: one of require or deny is required",
+		"rules[1] a: resources: at least one resource type is required",
+		"rules[1] a: one of require or deny is required",
+		"rules[1] a: id is used by another rule",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expecting %q in:\n%v", problem, err)
+		}
+	}
+}
+
+func TestPublishBlockedByPolicy(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"schema/schema.yaml": "schema:\n",
+		"infrastructure/cloudformation.yaml": `Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    DeletionPolicy: Retain
+`,
+		policyFile: `rules:
+  - id: s3-bucket-encryption
+    description: S3 buckets should be encrypted
+    severity: warning
+    resources: [AWS::S3::Bucket]
+    require: exists(Properties.BucketEncryption)
+`,
+	})
+	protonClient := newFakeProton()
+	s3Client := newFakeS3()
+	config := &protonConfigData{Name: "my_env", Type: "environment", PublishBucket: "my-bucket"}
+
+	//warnings block publishing at warning severity
+	opts := publishOptions{region: "us-east-1", timeout: time.Minute, policySeverity: "warning"}
+	_, err := publishTemplateDir(context.Background(), protonClient, s3Client, config, dir, opts)
+	var policyErr *policyError
+	if !errors.As(err, &policyErr) || len(policyErr.violations) != 1 {
+		t.Fatal("expecting a policy error, got", err)
+	}
+	if len(protonClient.versions[fakeKey("environment", "my_env")]) != 0 {
+		t.Error("expecting nothing to be published")
+	}
+
+	//but not at the default error severity
+	opts.policySeverity = ""
+	if _, err := publishTemplateDir(context.Background(), protonClient, s3Client, config, dir, opts); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+const (
+	//registration polling backoff
+	pollInitialDelay = 2 * time.Second
+	pollMaxDelay     = 30 * time.Second
+
+	//max attempts for each aws api call, including throttled calls
+	awsMaxAttempts = 10
+
+	//how long cleanup is allowed to take after a publish is interrupted
+	cleanupTimeout = 30 * time.Second
+)
+
+// returned when a template version fails to register
+var errRegistrationFailed = errors.New("template version registration failed")
+
+// returned when a deployment doesn't succeed
+var errDeploymentFailed = errors.New("deployment failed")
+
+// calls check until it reports done, the context ends, or it returns an error.
+// waits between calls back off exponentially with jitter
+// and throttling errors are retried rather than returned
+func pollWithBackoff(ctx context.Context, initial, max time.Duration, check func(context.Context) (bool, error)) error {
+	delay := initial
+	for {
+		done, err := check(ctx)
+		if err != nil && !isThrottling(err) {
+			return err
+		}
+		if err != nil {
+			debug("throttled, backing off:", err)
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jitter(delay)):
+		}
+
+		delay *= 2
+		if delay > max {
+			delay = max
+		}
+	}
+}
+
+// returns a random duration between d/2 and d
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// returns true if an error is an aws throttling error
+func isThrottling(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// waits for a template version to finish registering
+func waitForRegistration(ctx context.Context, protonClient ProtonAPI, kind templateKind, name, major, minor string) error {
+	debug("waiting for registration to complete")
+	return pollWithBackoff(ctx, pollInitialDelay, pollMaxDelay, func(ctx context.Context) (bool, error) {
+		ver, err := kind.getVersion(ctx, protonClient, name, major, minor)
+		if err != nil {
+			return false, err
+		}
+		debug(ver.Status)
+		if ver.StatusMessage != "" {
+			debug(ver.StatusMessage)
+		}
+
+		switch ver.Status {
+		case types.TemplateVersionStatusRegistrationFailed:
+			msg := ver.StatusMessage
+			if msg == "" {
+				msg = "no status message"
+			}
+			return false, fmt.Errorf("%w: %s", errRegistrationFailed, msg)
+
+		case types.TemplateVersionStatusDraft:
+			debugFmt("template version %s.%s now in %s", major, minor, ver.Status)
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+// gets the current state of a deployment. satisfied by the template kinds and servicePipeline
+type deploymentGetter interface {
+	getDeployment(ctx context.Context, c ProtonAPI, d templateDeployment) (*templateDeployment, error)
+}
+
+// waits for an environment, service instance or service pipeline deployment to succeed.
+// report is called with the deployment each time it's checked
+func waitForDeployment(ctx context.Context, protonClient ProtonAPI, kind deploymentGetter, d templateDeployment,
+	initial, max time.Duration, report func(*templateDeployment)) error {
+
+	return pollWithBackoff(ctx, initial, max, func(ctx context.Context) (bool, error) {
+		current, err := kind.getDeployment(ctx, protonClient, d)
+		if err != nil {
+			return false, err
+		}
+		report(current)
+
+		switch current.DeploymentStatus {
+		case types.DeploymentStatusSucceeded:
+			return true, nil
+		case types.DeploymentStatusFailed, types.DeploymentStatusCancelled:
+			msg := current.StatusMessage
+			if msg == "" {
+				msg = "no status message"
+			}
+			return false, fmt.Errorf("%w: %s: %s", errDeploymentFailed, current.DeploymentStatus, msg)
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// an api error with an error code, like the ones returned by the sdk
+type testAPIError struct {
+	code string
+}
+
+func (e testAPIError) Error() string     { return e.code }
+func (e testAPIError) ErrorCode() string { return e.code }
+
+func TestPollWithBackoffRetriesThrottling(t *testing.T) {
+	calls := 0
+	err := pollWithBackoff(context.Background(), time.Millisecond, 4*time.Millisecond, func(ctx context.Context) (bool, error) {
+		calls++
+		switch calls {
+		case 1:
+			return false, nil
+		case 2:
+			return false, testAPIError{"ThrottlingException"}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expecting 3 calls, got %d", calls)
+	}
+}
+
+func TestPollWithBackoffReturnsErrors(t *testing.T) {
+	expected := testAPIError{"AccessDeniedException"}
+	err := pollWithBackoff(context.Background(), time.Millisecond, time.Millisecond, func(ctx context.Context) (bool, error) {
+		return false, expected
+	})
+	if !errors.Is(err, expected) {
+		t.Error("expecting", expected, "got", err)
+	}
+}
+
+func TestPollWithBackoffTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := pollWithBackoff(ctx, time.Millisecond, 2*time.Millisecond, func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expecting deadline exceeded, got", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	d := 10 * time.Second
+	for i := 0; i < 100; i++ {
+		j := jitter(d)
+		if j < d/2 || j > d {
+			t.Fatalf("expecting jitter between %v and %v, got %v", d/2, d, j)
+		}
+	}
+}
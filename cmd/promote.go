@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagPromoteDescription string
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote <template> <major.minor>",
+	Short: "Publishes a draft template version",
+	Long: `Publishes a template version that was registered with publish --draft,
+making it available to environments and services.`,
+	Args: cobra.ExactArgs(2),
+	Run:  doPromote,
+	Example: `
+# Register a new version without publishing it
+protonizer publish --draft
+registered my_template:1.3 as DRAFT
+
+# Publish it once it has been reviewed
+protonizer promote my_template 1.3`,
+}
+
+func init() {
+	promoteCmd.Flags().StringVar(&flagPromoteDescription, "description", "",
+		"A new description for the template version")
+	rootCmd.AddCommand(promoteCmd)
+}
+
+// returned when a template version can't be promoted because it isn't a draft
+var errPromoteStatus = errors.New("only DRAFT versions can be promoted")
+
+func doPromote(cmd *cobra.Command, args []string) {
+	name := args[0]
+	major, minor, err := parseTemplateVersion(args[1])
+	if err != nil {
+		errorExit(err)
+	}
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	err = promoteTemplateVersion(ctx, newProtonClient(cfg), name, major, minor, flagPromoteDescription)
+	if errors.Is(err, errTemplateNotFound) || errors.Is(err, errTemplateVersionNotFound) || errors.Is(err, errPromoteStatus) {
+		errorExit(err)
+	}
+	handleError("publishing template version", err)
+
+	fmt.Printf("published %s:%s.%s \n", name, major, minor)
+}
+
+// publishes a draft template version, optionally updating its description
+func promoteTemplateVersion(ctx context.Context, protonClient ProtonAPI, name, major, minor, description string) error {
+	_, kind, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		return err
+	}
+
+	version, err := kind.getVersion(ctx, protonClient, name, major, minor)
+	if err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("%w: %s:%s.%s", errTemplateVersionNotFound, name, major, minor)
+		}
+		return err
+	}
+	if version.Status != types.TemplateVersionStatusDraft {
+		return fmt.Errorf("%w: template version %s:%s.%s is %s", errPromoteStatus, name, major, minor, version.Status)
+	}
+
+	return kind.updateVersion(ctx, protonClient, name, major, minor,
+		types.TemplateVersionStatusPublished, optionalString(description))
+}
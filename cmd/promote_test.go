@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func TestPromoteTemplateVersion(t *testing.T) {
+	client := newFakeProton()
+	template := client.addTemplate("environment", "vpc")
+	template.recommended = "1.0"
+	client.addVersion("environment", "vpc", "1", "0", types.TemplateVersionStatusPublished)
+	client.addVersion("environment", "vpc", "1", "1", types.TemplateVersionStatusDraft)
+
+	err := promoteTemplateVersion(context.Background(), client, "vpc", "1", "1", "reviewed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := client.getVersion("environment", "vpc", "1", "1")
+	if v.status != types.TemplateVersionStatusPublished || v.description != "reviewed" {
+		t.Errorf("expecting 1.1 to be published with its new description, got %s %q", v.status, v.description)
+	}
+	if template.recommended != "1.1" {
+		t.Errorf("expecting 1.1 to be recommended, got %s", template.recommended)
+	}
+
+	//only drafts can be promoted
+	if err := promoteTemplateVersion(context.Background(), client, "vpc", "1", "0", ""); !errors.Is(err, errPromoteStatus) {
+		t.Errorf("expecting an error promoting a published version, got %v", err)
+	}
+
+	//unknown versions and templates aren't promoted
+	client.calls = nil
+	if err := promoteTemplateVersion(context.Background(), client, "vpc", "1", "7", ""); !errors.Is(err, errTemplateVersionNotFound) {
+		t.Errorf("expecting an unknown version not to be found, got %v", err)
+	}
+	if err := promoteTemplateVersion(context.Background(), client, "nope", "1", "0", ""); !errors.Is(err, errTemplateNotFound) {
+		t.Errorf("expecting an unknown template not to be found, got %v", err)
+	}
+	if internalCalled(client, "UpdateEnvironmentTemplateVersion") {
+		t.Errorf("not expecting any version to be updated, got %v", client.calls)
+	}
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the values proton provides to a template that aren't in its spec
+type protonContextOptions struct {
+	//the environment or service name
+	name      string
+	accountID string
+
+	//the outputs of the environment that service instances are deployed to
+	environmentOutputs map[string]interface{}
+
+	//the service's source repository
+	repositoryConnectionArn string
+	repositoryID            string
+	branch                  string
+}
+
+// the values proton provides to a template when it's deployed, for example to a
+// service instance. the same structure is used for jinja and for proton-inputs.json
+type protonContext struct {
+	//what the context is for: an environment, service instance or pipeline name
+	Name   string
+	Values map[string]interface{}
+}
+
+// builds the context an environment template is deployed with, from an environment spec
+func environmentContext(schema *templateSchema, spec []byte, opts protonContextOptions) (*protonContext, error) {
+	if err := validateEnvironmentSpec(schema, spec); err != nil {
+		return nil, err
+	}
+	var s environmentSpec
+	if err := yaml.Unmarshal(spec, &s); err != nil {
+		return nil, err
+	}
+	inputType, _ := schema.inputType(schema.Schema.EnvironmentInputType)
+	return &protonContext{
+		Name: opts.name,
+		Values: map[string]interface{}{
+			"environment": map[string]interface{}{
+				"name":   opts.name,
+				"inputs": withInputDefaults(inputType, s.Spec),
+			},
+		},
+	}, nil
+}
+
+// builds the contexts the instances of a service template are deployed with, from a service spec
+func serviceInstanceContexts(schema *templateSchema, spec []byte, opts protonContextOptions) ([]*protonContext, error) {
+	s, err := readServiceSpec(schema, spec)
+	if err != nil {
+		return nil, err
+	}
+	inputType, _ := schema.inputType(schema.Schema.ServiceInputType)
+	var result []*protonContext
+	for _, instance := range s.Instances {
+		result = append(result, &protonContext{
+			Name: instance.Name,
+			Values: map[string]interface{}{
+				"environment": contextEnvironment(instance.Environment, opts),
+				"service":     contextService(opts),
+				"service_instance": map[string]interface{}{
+					"name":   instance.Name,
+					"inputs": withInputDefaults(inputType, instance.Spec),
+				},
+			},
+		})
+	}
+	return result, nil
+}
+
+// builds the context a service template's pipeline is deployed with, from a service spec.
+// returns nil if the template doesn't have a pipeline
+func pipelineContext(schema *templateSchema, spec []byte, opts protonContextOptions) (*protonContext, error) {
+	s, err := readServiceSpec(schema, spec)
+	if err != nil {
+		return nil, err
+	}
+	pipelineType, _ := schema.inputType(schema.Schema.PipelineInputType)
+	if pipelineType == nil {
+		return nil, nil
+	}
+	serviceType, _ := schema.inputType(schema.Schema.ServiceInputType)
+	instances := []interface{}{}
+	for _, instance := range s.Instances {
+		instances = append(instances, map[string]interface{}{
+			"name":        instance.Name,
+			"inputs":      withInputDefaults(serviceType, instance.Spec),
+			"environment": contextEnvironment(instance.Environment, opts),
+		})
+	}
+	return &protonContext{
+		Name: "pipeline",
+		Values: map[string]interface{}{
+			"service":           contextService(opts),
+			"service_instances": instances,
+			"pipeline": map[string]interface{}{
+				"inputs": withInputDefaults(pipelineType, s.Pipeline),
+			},
+		},
+	}, nil
+}
+
+// validates and parses a service spec
+func readServiceSpec(schema *templateSchema, spec []byte) (*serviceSpec, error) {
+	if err := validateServiceSpec(schema, spec); err != nil {
+		return nil, err
+	}
+	var s serviceSpec
+	if err := yaml.Unmarshal(spec, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// the environment a service instance is deployed to
+func contextEnvironment(name string, opts protonContextOptions) map[string]interface{} {
+	outputs := opts.environmentOutputs
+	if outputs == nil {
+		outputs = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"name":       name,
+		"account_id": opts.accountID,
+		"outputs":    outputs,
+	}
+}
+
+// the service being deployed
+func contextService(opts protonContextOptions) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                      opts.name,
+		"repository_connection_arn": opts.repositoryConnectionArn,
+		"repository_id":             opts.repositoryID,
+		"branch_name":               opts.branch,
+	}
+}
+
+// returns spec inputs with schema defaults applied to the inputs that aren't set
+func withInputDefaults(t *schemaType, inputs map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	if t != nil {
+		for k, p := range t.Properties {
+			if p != nil && p.Default != nil {
+				result[k] = p.Default
+			}
+		}
+	}
+	for k, v := range inputs {
+		result[k] = v
+	}
+	return result
+}
+
+// reads environment outputs from a json or yaml file. the file is either an object of
+// output names to values, or the output of aws proton list-environment-outputs
+func readEnvironmentOutputs(file string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var listed struct {
+		Outputs []struct {
+			Key         string `yaml:"key"`
+			ValueString string `yaml:"valueString"`
+		} `yaml:"outputs"`
+	}
+	if err := yaml.Unmarshal(b, &listed); err == nil && len(listed.Outputs) > 0 {
+		result := map[string]interface{}{}
+		for _, o := range listed.Outputs {
+			result[o.Key] = o.ValueString
+		}
+		return result, nil
+	}
+
+	var outputs map[string]interface{}
+	if err := yaml.Unmarshal(b, &outputs); err != nil {
+		return nil, fmt.Errorf("%s must be an object of output names to values: %w", file, err)
+	}
+
+	//proton outputs are strings
+	result := map[string]interface{}{}
+	for _, k := range sortedKeys(outputs) {
+		result[k] = fmt.Sprint(outputs[k])
+	}
+	return result, nil
+}
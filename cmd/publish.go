@@ -2,17 +2,21 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/proton"
 	"github.com/aws/aws-sdk-go-v2/service/proton/types"
@@ -22,7 +26,20 @@ import (
 )
 
 var (
-	flagTemplatePublishFile string
+	flagTemplatePublishFile           string
+	flagTemplatePublishScanAllowlist  string
+	flagTemplatePublishPolicy         string
+	flagTemplatePublishPolicySeverity string
+	flagTemplatePublishDryRun         bool
+	flagTemplatePublishAll            string
+	flagTemplatePublishConcurrency    int
+	flagTemplatePublishRegions        []string
+	flagTemplatePublishAccounts       []string
+	flagTemplatePublishAllowDrift     bool
+	flagTemplatePublishForce          bool
+	flagTemplatePublishOutput         string
+	flagTemplatePublishDraft          bool
+	flagTemplatePublishTimeout        time.Duration
 )
 
 type protonConfigData struct {
@@ -32,312 +49,468 @@ type protonConfigData struct {
 	Description string `yaml:"description"`
 
 	//optional
-	PublishBucket          string   `yaml:"publishBucket,omitempty"`
-	CompatibleEnvironments []string `yaml:"compatibleEnvironments,omitempty"`
+	PublishBucket          string            `yaml:"publishBucket,omitempty"`
+	CompatibleEnvironments []string          `yaml:"compatibleEnvironments,omitempty"`
+	Tags                   map[string]string `yaml:"tags,omitempty"`
+	EncryptionKey          string            `yaml:"encryptionKey,omitempty"`
+	VersionDescription     string            `yaml:"versionDescription,omitempty"`
+	MajorVersion           string            `yaml:"majorVersion,omitempty"`
+	Provisioning           string            `yaml:"provisioning,omitempty"`
+	PipelineProvisioning   string            `yaml:"pipelineProvisioning,omitempty"`
+	Targets                []publishTarget   `yaml:"targets,omitempty"`
 }
 
+const (
+	defaultVersionDescription = "published by proton cli"
+
+	//provisioning values. proton managed is represented by omitting the setting
+	provisioningCustomerManaged = string(types.ProvisioningCustomerManaged)
+	provisioningProtonManaged   = "PROTON_MANAGED"
+)
+
+// matches valid proton template names
+var protonNamePattern = regexp.MustCompile(`^[0-9A-Za-z]+[0-9A-Za-z_\-]*$`)
+
 var templatePublishCmd = &cobra.Command{
 	Use:   "publish",
 	Short: "Publishes proton templates",
 	Long:  "Publishes proton templates",
 	Run:   doTemplatePublish,
+	Example: `
+# Publish the template in the current directory
+protonizer publish
+
+# Show the S3 and Proton calls that publish would make, without making them
+protonizer publish --dry-run
+
+# Show the calls as json
+protonizer publish --dry-run --output json
+
+# Publish every template in a repository, environment templates first
+protonizer publish --all ./templates --concurrency 8
+
+# Publish to several regions and accounts
+protonizer publish --regions us-east-1,eu-west-1 \
+  --accounts arn:aws:iam::111111111111:role/proton-publisher,arn:aws:iam::222222222222:role/proton-publisher
+
+# Register a new version as a draft, then publish it after review
+protonizer publish --draft
+protonizer promote my_template 1.3`,
 }
 
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 	templatePublishCmd.Flags().StringVarP(&flagTemplatePublishFile, "file", "f", "proton.yaml", "The proton yaml file to use")
+	templatePublishCmd.Flags().StringVar(&flagTemplatePublishScanAllowlist, "scan-allowlist", "",
+		"A file listing secret scan false positives (file or file:line). Defaults to "+scanAllowlistFile+" in the template directory")
+	templatePublishCmd.Flags().StringVar(&flagTemplatePublishPolicy, "policy", "",
+		"A file of policy rules that add to, override or disable the built-in rules. Defaults to "+policyFile+" in the template directory")
+	templatePublishCmd.Flags().StringVar(&flagTemplatePublishPolicySeverity, "policy-severity", defaultPolicySeverity,
+		"The minimum policy rule severity that blocks publishing: "+strings.Join(policySeverities, ", "))
+	templatePublishCmd.Flags().BoolVar(&flagTemplatePublishDryRun, "dry-run", false,
+		"Build and validate the bundle and show what would be published, without making any changes")
+	templatePublishCmd.Flags().StringVar(&flagTemplatePublishOutput, "output", "text",
+		"The dry run output format: text or json")
+	templatePublishCmd.Flags().BoolVar(&flagTemplatePublishDraft, "draft", false,
+		"Register the new version and leave it in DRAFT. Use the promote command to publish it later")
+	templatePublishCmd.Flags().DurationVar(&flagTemplatePublishTimeout, "timeout", 10*time.Minute,
+		"How long to wait for the template version to register before giving up and deleting it")
+	templatePublishCmd.Flags().StringVar(&flagTemplatePublishAll, "all", "",
+		"Publish every template with a proton.yaml file under a directory")
+	templatePublishCmd.Flags().IntVar(&flagTemplatePublishConcurrency, "concurrency", 4,
+		"The maximum number of templates to publish at once when using --all")
+	templatePublishCmd.Flags().StringSliceVar(&flagTemplatePublishRegions, "regions", nil,
+		"Publish to each of these regions. Overrides targets in proton.yaml")
+	templatePublishCmd.Flags().StringSliceVar(&flagTemplatePublishAccounts, "accounts", nil,
+		"Publish to the account of each of these IAM role ARNs, in every region. Overrides targets in proton.yaml")
+	templatePublishCmd.Flags().BoolVar(&flagTemplatePublishAllowDrift, "allow-drift", false,
+		"Publish to multiple targets even if they would end up on different minor versions")
+	templatePublishCmd.Flags().BoolVar(&flagTemplatePublishForce, "force", false,
+		"Publish a new version even if the latest version has the same bundle")
 	rootCmd.AddCommand(templatePublishCmd)
 }
 
-func getAWSConfig() aws.Config {
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx)
-	handleError("aws config", err)
-	return cfg
-}
-
 func doTemplatePublish(cmd *cobra.Command, args []string) {
+	if !(flagTemplatePublishOutput == "text" || flagTemplatePublishOutput == "json") {
+		errorExit(fmt.Sprintf("output format: %s is invalid. only text and json are supported", flagTemplatePublishOutput))
+	}
+	if policySeverityRank(flagTemplatePublishPolicySeverity) < 0 {
+		errorExit(fmt.Sprintf("policy severity: %s is invalid. only %s are supported",
+			flagTemplatePublishPolicySeverity, strings.Join(policySeverities, ", ")))
+	}
+	if flagTemplatePublishAll != "" {
+		if flagTemplatePublishDryRun {
+			errorExit("--dry-run is not supported with --all")
+		}
+		publishAllTemplates(flagTemplatePublishAll, flagTemplatePublishConcurrency)
+		return
+	}
+	if flagTemplatePublishDryRun {
+		dryRunPublishTemplate(flagTemplatePublishFile, flagTemplatePublishOutput)
+		return
+	}
 	publishTemplate(flagTemplatePublishFile)
 }
 
 func publishTemplate(file string) {
 
-	//parse proton.yaml
-	protonConfig, err := readProtonYAMLFile(file)
-	if err != nil {
-		errorExit(fmt.Errorf("could not read proton.yaml: %w", err))
+	protonConfig, dir := readPublishConfig(file)
+	cfg := getAWSConfig()
+
+	//cancel on ctrl-c so that a partial publish can be cleaned up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	targets := resolvePublishTargets(protonConfig, flagTemplatePublishRegions, flagTemplatePublishAccounts, cfg.Region)
+	if len(targets) > 0 {
+		publishTemplateToTargets(ctx, cfg, protonConfig, dir, targets)
+		return
 	}
 
-	if protonConfig.PublishBucket == "" {
-		errorExit("The `publishBucket` key is not specified in proton.yaml. This setting is required for publishing.")
+	result, err := publishTemplateDir(ctx, newProtonClient(cfg), newS3Client(cfg),
+		protonConfig, dir, publishOptionsFromFlags(cfg.Region))
+	if err != nil {
+		exitPublishError(result, err)
+	}
+	fmt.Println("bundle sha256:", result.BundleSHA256)
+	if result.Unchanged {
+		fmt.Printf("%s:%s already has this bundle, nothing to publish \n", result.Name, result.version())
+	} else if result.Status == types.TemplateVersionStatusDraft {
+		fmt.Printf("registered %s:%s as %s \n", result.Name, result.version(), result.Status)
+		fmt.Printf("run `protonizer promote %s %s` to publish it \n", result.Name, result.version())
+	} else {
+		fmt.Printf("published %s:%s \n", result.Name, result.version())
 	}
 
-	if region := os.Getenv("AWS_REGION"); region == "" {
-		errorExit(`Please specify the AWS region by setting the "AWS_REGION" environment variable.
+	//output console url of published template
+	fmt.Printf("https://%s.console.aws.amazon.com/proton/home#/templates/%vs/detail/%s\n",
+		cfg.Region, protonConfig.Type, protonConfig.Name)
+}
 
-For example:
-export AWS_REGION=us-east-1
-OR
-AWS_REGION=us-east-1 protonizer publish`)
-	}
+// settings that apply to every template being published
+type publishOptions struct {
+	region        string
+	scanAllowlist string
+	draft         bool
+	timeout       time.Duration
 
-	//tar gz template
-	//assume template bundle is in the same directory as the proton.yaml file
-	dir := filepath.Dir(file)
-	zipFileName := "bundle.tar.gz"
-	zipPath := path.Join(dir, zipFileName)
-	m := "creating template bundle: " + zipPath
-	debug(m)
-	err = createTarGZFile(dir, zipPath)
-	handleError(m, err)
+	//policy rules file and the severity at which violations block publishing
+	policyFile     string
+	policySeverity string
 
-	cfg := getAWSConfig()
-	ctx := context.Background()
+	//publish to multiple targets even if their versions differ
+	allowDrift bool
 
-	//upload to s3
-	bucket := protonConfig.PublishBucket
-	key := zipFileName
+	//publish even if the latest version has the same bundle
+	force bool
+}
 
-	s3Client := s3.NewFromConfig(cfg)
+// returns publish options from command line flags
+func publishOptionsFromFlags(region string) publishOptions {
+	return publishOptions{
+		region:         region,
+		scanAllowlist:  flagTemplatePublishScanAllowlist,
+		draft:          flagTemplatePublishDraft,
+		timeout:        flagTemplatePublishTimeout,
+		policyFile:     flagTemplatePublishPolicy,
+		policySeverity: flagTemplatePublishPolicySeverity,
+		allowDrift:     flagTemplatePublishAllowDrift,
+		force:          flagTemplatePublishForce,
+	}
+}
 
-	m = fmt.Sprintf("uploading template bundle to s3://%s/%s", bucket, key)
-	debug(m)
-	f, err := os.Open(zipPath)
-	handleError("opening zip", err)
-	uploader := manager.NewUploader(s3Client)
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-		Body:   f,
-	})
-	handleError(m, err)
+// the outcome of publishing a template
+type publishResult struct {
+	Name         string
+	Type         string
+	MajorVersion string
+	MinorVersion string
+	Status       types.TemplateVersionStatus
+	BundleSHA256 string
+
+	//the latest version already had the bundle, so nothing was published
+	Unchanged bool
+}
 
-	//delete local zip file
-	err = os.Remove(zipPath)
-	handleError("removing zip file", err)
+// returns the published version as major.minor
+func (r *publishResult) version() string {
+	return r.MajorVersion + "." + r.MinorVersion
+}
 
-	//publish
-	var majorVersion, minorVersion string
+// scans, bundles, uploads, registers and publishes the template in a directory.
+// nothing is printed or exited on, so that templates can be published concurrently.
+// the result is returned with any error, with as much as is known about the version
+func publishTemplateDir(ctx context.Context, protonClient ProtonAPI, s3Client S3API,
+	protonConfig *protonConfigData, dir string, opts publishOptions) (*publishResult, error) {
 
-	switch protonConfig.Type {
+	result := &publishResult{Name: protonConfig.Name, Type: protonConfig.Type}
 
-	case "environment":
-		majorVersion, minorVersion = publishEnvironmentTemplate(cfg, protonConfig, key, ctx)
+	//tar gz template
+	zipPath := path.Join(dir, "bundle.tar.gz")
+	bundleHash, err := buildBundle(dir, zipPath, opts)
+	if err != nil {
+		return result, err
+	}
+	defer os.Remove(zipPath)
+	result.BundleSHA256 = bundleHash
 
-	case "service":
-		majorVersion, minorVersion = publishServiceTemplate(cfg, protonConfig, key, ctx)
+	//plan the publish
+	plan, err := planPublish(ctx, protonClient, protonConfig, bundleKey(protonConfig, bundleHash), bundleHash, opts)
+	if err != nil {
+		return result, fmt.Errorf("planning publish: %w", err)
 	}
-	fmt.Printf("published %s:%s.%s \n", protonConfig.Name, majorVersion, minorVersion)
+	result.MajorVersion = plan.MajorVersion
+	result.Status = plan.Status
+	result.Unchanged = plan.Unchanged
 
-	//output console url of published template
-	fmt.Printf("https://%s.console.aws.amazon.com/proton/home#/templates/%vs/detail/%s\n",
-		cfg.Region, protonConfig.Type, protonConfig.Name)
+	result.MinorVersion, err = publishPlanned(ctx, protonClient, s3Client, plan, zipPath, opts.timeout)
+	return result, err
 }
 
-func publishEnvironmentTemplate(cfg aws.Config, protonConfig *protonConfigData, s3Key string, ctx context.Context) (string, string) {
-
-	//publish proton template and version
-	protonClient := proton.NewFromConfig(cfg)
+// uploads a bundle and runs a publish plan, returning the new minor version
+func publishPlanned(ctx context.Context, protonClient ProtonAPI, s3Client S3API,
+	plan *publishPlan, zipPath string, timeout time.Duration) (string, error) {
 
-	reqTemplate := &proton.CreateEnvironmentTemplateInput{
-		Name:        &protonConfig.Name,
-		Description: &protonConfig.Description,
-		DisplayName: &protonConfig.DisplayName,
-		Tags: []types.Tag{
-			{
-				Key:   aws.String("creator"),
-				Value: aws.String("protonizer-cli"),
-			},
-		},
+	if plan.Unchanged {
+		debugFmt("%s:%s.%s already has bundle %s, skipping publish", plan.Name, plan.MajorVersion, plan.MinorVersion, plan.BundleSHA256)
+		return plan.MinorVersion, nil
 	}
-	m := "proton.CreateEnvironmentTemplate()"
+
+	//upload to s3
+	m := fmt.Sprintf("uploading template bundle to s3://%s/%s", plan.Bucket, plan.Key)
 	debug(m)
-	_, err := protonClient.CreateEnvironmentTemplate(ctx, reqTemplate)
-	handleError(m, err)
+	err := uploadBundle(ctx, s3Client, plan.Bucket, plan.Key, zipPath)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", m, err)
+	}
 
-	//publish version
-	majorVesion := "1"
+	//publish
+	return executePublishPlan(ctx, protonClient, plan, timeout)
+}
 
-	s3Source := types.TemplateVersionSourceInputMemberS3{
-		Value: types.S3ObjectSource{
-			Bucket: &protonConfig.PublishBucket,
-			Key:    &s3Key,
-		},
-	}
-	reqVersion := &proton.CreateEnvironmentTemplateVersionInput{
-		TemplateName: &protonConfig.Name,
-		MajorVersion: &majorVesion,
-		Source:       &s3Source,
-	}
-	m = "proton.CreateEnvironmentTemplateVersion()"
-	debug(m)
-	templateVersion, err := protonClient.CreateEnvironmentTemplateVersion(ctx, reqVersion)
-	handleError(m, err)
-
-	if templateVersion.EnvironmentTemplateVersion.MinorVersion != nil {
-		debug("minor version =", *templateVersion.EnvironmentTemplateVersion.MinorVersion)
-	}
-	debug(templateVersion.EnvironmentTemplateVersion.Status)
-	if templateVersion.EnvironmentTemplateVersion.StatusMessage != nil {
-		debug(*templateVersion.EnvironmentTemplateVersion.StatusMessage)
-	}
-
-	debug("waiting for registration to complete")
-
-	//wait for version to be available then get the minor version
-	minorVersion := ""
-	for {
-		m = "proton.GetEnvironmentTemplate()"
-		debug(m)
-		ver, err := protonClient.GetEnvironmentTemplateVersion(ctx, &proton.GetEnvironmentTemplateVersionInput{
-			TemplateName: &protonConfig.Name,
-			MajorVersion: &majorVesion,
-			MinorVersion: templateVersion.EnvironmentTemplateVersion.MinorVersion,
-		})
-		handleError(m, err)
-		debug(ver.EnvironmentTemplateVersion.Status)
-		if ver.EnvironmentTemplateVersion.StatusMessage != nil {
-			debug(*ver.EnvironmentTemplateVersion.StatusMessage)
-		}
+// builds and validates the bundle and prints the publish plan
+// without making any mutating api calls
+func dryRunPublishTemplate(file, format string) {
 
-		if ver.EnvironmentTemplateVersion.Status == types.TemplateVersionStatusRegistrationFailed {
-			errorExit(*ver.EnvironmentTemplateVersion.StatusMessage)
-		}
+	protonConfig, dir := readPublishConfig(file)
 
-		if ver.EnvironmentTemplateVersion.Status == types.TemplateVersionStatusDraft {
-			minorVersion = *ver.EnvironmentTemplateVersion.MinorVersion
-			debugFmt("template version %s.%s now in %s", majorVesion, minorVersion, ver.EnvironmentTemplateVersion.Status)
-			break
-		}
-		time.Sleep(2 * time.Second)
+	//build the bundle to validate it and compute its hash, but don't keep it
+	zipPath := path.Join(dir, "bundle.tar.gz")
+	bundleHash := bundleTemplate(dir, zipPath)
+	err := os.Remove(zipPath)
+	handleError("removing zip file", err)
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+
+	targets := resolvePublishTargets(protonConfig, flagTemplatePublishRegions, flagTemplatePublishAccounts, cfg.Region)
+	if len(targets) > 0 {
+		dryRunPublishToTargets(ctx, cfg, protonConfig, bundleHash, targets, format)
+		return
 	}
 
-	desc := "published by proton cli"
-	m = "proton.UpdateEnvironmentTemplateVersion"
-	debug(m)
-	_, err = protonClient.UpdateEnvironmentTemplateVersion(ctx, &proton.UpdateEnvironmentTemplateVersionInput{
-		TemplateName: &protonConfig.Name,
-		MajorVersion: &majorVesion,
-		MinorVersion: &minorVersion,
-		Status:       types.TemplateVersionStatusPublished,
-		Description:  &desc,
-	})
-	handleError(m, err)
+	plan, err := planPublish(ctx, newProtonClient(cfg), protonConfig, bundleKey(protonConfig, bundleHash), bundleHash, publishOptionsFromFlags(cfg.Region))
+	if errors.Is(err, errPublishConfig) {
+		errorExit(err)
+	}
+	handleError("planning publish", err)
 
-	return majorVesion, minorVersion
+	err = printPublishPlan(os.Stdout, plan, format)
+	handleError("printing publish plan", err)
 }
 
-func publishServiceTemplate(cfg aws.Config, protonConfig *protonConfigData, s3Key string, ctx context.Context) (string, string) {
+// reads and validates proton.yaml for publishing,
+// returning the config and the template directory
+func readPublishConfig(file string) (*protonConfigData, string) {
+	protonConfig, dir, err := loadPublishConfig(file)
+	if err != nil {
+		errorExit(err)
+	}
+	return protonConfig, dir
+}
 
-	//publish proton template and version
-	protonClient := proton.NewFromConfig(cfg)
+// reads and validates proton.yaml for publishing,
+// returning the config and the template directory
+func loadPublishConfig(file string) (*protonConfigData, string, error) {
 
-	reqTemplate := &proton.CreateServiceTemplateInput{
-		Name:                 &protonConfig.Name,
-		Description:          &protonConfig.Description,
-		DisplayName:          &protonConfig.DisplayName,
-		PipelineProvisioning: types.ProvisioningCustomerManaged,
-		Tags: []types.Tag{
-			{
-				Key:   aws.String("creator"),
-				Value: aws.String("protonizer-cli"),
-			},
-		},
+	//parse proton.yaml
+	protonConfig, err := readProtonYAMLFile(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read proton.yaml: %w", err)
 	}
-	m := "proton.CreateServiceTemplate()"
-	debug(m)
-	_, err := protonClient.CreateServiceTemplate(ctx, reqTemplate)
-	handleError(m, err)
 
-	//publish version
-	majorVesion := "1"
+	if protonConfig.PublishBucket == "" && !protonConfig.targetsHaveBuckets() {
+		return nil, "", errors.New("The `publishBucket` key is not specified in " + file + ". This setting is required for publishing.")
+	}
 
-	s3Source := types.TemplateVersionSourceInputMemberS3{
-		Value: types.S3ObjectSource{
-			Bucket: &protonConfig.PublishBucket,
-			Key:    &s3Key,
-		},
+	//assume template bundle is in the same directory as the proton.yaml file
+	return protonConfig, filepath.Dir(file), nil
+}
+
+// bundles a template directory using the publish flags, returning the bundle hash.
+// exits if the directory has secrets or policy violations, or can't be bundled
+func bundleTemplate(dir, zipPath string) string {
+	bundleHash, err := buildBundle(dir, zipPath, publishOptionsFromFlags(""))
+	var scanErr *scanError
+	var policyErr *policyError
+	if errors.As(err, &scanErr) || errors.As(err, &policyErr) {
+		errorExit(err)
 	}
-	reqVersion := &proton.CreateServiceTemplateVersionInput{
-		TemplateName:                   &protonConfig.Name,
-		MajorVersion:                   &majorVesion,
-		Source:                         &s3Source,
-		CompatibleEnvironmentTemplates: []types.CompatibleEnvironmentTemplateInput{},
+	handleError("bundling template", err)
+	return bundleHash
+}
+
+// scans a template directory for secrets and policy violations
+// and bundles it, returning the bundle hash
+func buildBundle(dir, zipPath string, opts publishOptions) (string, error) {
+
+	//don't publish anything that looks like a secret
+	err := scanTemplate(dir, opts.scanAllowlist, "publish")
+	if err != nil {
+		return "", err
 	}
 
-	for _, c := range protonConfig.CompatibleEnvironments {
-		parts := strings.Split(c, ":")
-		if len(parts) != 2 {
-			errorExit("compatible environments must use the format: `name:version`")
-		}
-		reqVersion.CompatibleEnvironmentTemplates = append(reqVersion.CompatibleEnvironmentTemplates, types.CompatibleEnvironmentTemplateInput{
-			TemplateName: aws.String(parts[0]),
-			MajorVersion: aws.String(parts[1]),
-		})
+	//or that breaks policy rules
+	err = checkTemplatePolicy(dir, opts.policyFile, opts.policySeverity, "publish")
+	if err != nil {
+		return "", err
 	}
 
-	m = "proton.CreateServiceTemplateVersion()"
+	m := "creating template bundle: " + zipPath
 	debug(m)
-	templateVersion, err := protonClient.CreateServiceTemplateVersion(ctx, reqVersion)
+	bundleHash, err := createTarGZFile(dir, zipPath)
 	if err != nil {
-		if strings.Contains(err.Error(), "ValidationException") {
-			errorExit(err)
-		} else {
-			handleError(m, err)
-		}
+		return "", fmt.Errorf("%s: %w", m, err)
 	}
+	return bundleHash, nil
+}
 
-	if templateVersion.ServiceTemplateVersion.MinorVersion != nil {
-		debug("minor version =", *templateVersion.ServiceTemplateVersion.MinorVersion)
+// returns the s3 key for a template's bundle. keys are addressed by the bundle's
+// content, so publishing never overwrites the bundle of an existing version and
+// identical bundles share an object
+func bundleKey(protonConfig *protonConfigData, bundleHash string) string {
+	return path.Join(protonConfig.Type, protonConfig.Name, "v"+protonConfig.majorVersion(), bundleHash+".tar.gz")
+}
+
+// runs a publish plan: creates the template if it doesn't exist, then creates a new version,
+// waits for it to register and publishes it unless it's a draft.
+// a version that doesn't finish publishing is deleted so that a failed publish
+// leaves nothing behind. returns the new minor version
+func executePublishPlan(ctx context.Context, protonClient ProtonAPI, plan *publishPlan, timeout time.Duration) (string, error) {
+	kind, err := kindFor(plan.Type)
+	if err != nil {
+		return "", err
 	}
-	debug(templateVersion.ServiceTemplateVersion.Status)
-	if templateVersion.ServiceTemplateVersion.StatusMessage != nil {
-		debug(*templateVersion.ServiceTemplateVersion.StatusMessage)
+
+	//create proton template. an existing template's display name and
+	//description are left as they are, only its tags are applied
+	if plan.TemplateExists {
+		err = tagTemplate(ctx, protonClient, plan)
+	} else {
+		err = kind.createTemplate(ctx, protonClient, plan)
+	}
+	if err != nil {
+		return "", err
 	}
 
-	debug("waiting for registration to complete")
+	//publish version
+	version, err := kind.createVersion(ctx, protonClient, plan)
+	if err != nil {
+		return "", err
+	}
+	major, minor := plan.MajorVersion, version.MinorVersion
+	debug("minor version =", minor)
+	debug(version.Status)
+
+	//wait for version to be available
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = waitForRegistration(waitCtx, protonClient, kind, plan.Name, major, minor)
+
+	//leave drafts to be promoted later
+	if err == nil && plan.Status != types.TemplateVersionStatusDraft {
+		err = kind.updateVersion(ctx, protonClient, plan.Name, major, minor, plan.Status, &plan.VersionDescription)
+	}
+	if err != nil {
+		deleteAbandonedVersion(protonClient, kind, plan.Name, major, minor)
+		return minor, err
+	}
+	return minor, nil
+}
 
-	//wait for version to be available then get the minor version
-	minorVersion := ""
-	for {
-		m = "proton.GetServiceTemplate()"
-		debug(m)
-		ver, err := protonClient.GetServiceTemplateVersion(ctx, &proton.GetServiceTemplateVersionInput{
-			TemplateName: &protonConfig.Name,
-			MajorVersion: &majorVesion,
-			MinorVersion: templateVersion.ServiceTemplateVersion.MinorVersion,
-		})
-		handleError(m, err)
-		debug(ver.ServiceTemplateVersion.Status)
-		if ver.ServiceTemplateVersion.StatusMessage != nil {
-			debug(*ver.ServiceTemplateVersion.StatusMessage)
-		}
+// deletes a template version that didn't finish publishing
+func deleteAbandonedVersion(protonClient ProtonAPI, kind templateKind, name, major, minor string) {
 
-		if ver.ServiceTemplateVersion.Status == types.TemplateVersionStatusRegistrationFailed {
-			errorExit(*ver.ServiceTemplateVersion.StatusMessage)
-		}
+	//the publish context may already be cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
 
-		if ver.ServiceTemplateVersion.Status == types.TemplateVersionStatusDraft {
-			minorVersion = *ver.ServiceTemplateVersion.MinorVersion
-			debugFmt("template version %s.%s now in %s", majorVesion, minorVersion, ver.ServiceTemplateVersion.Status)
-			break
-		}
-		time.Sleep(2 * time.Second)
+	debugFmt("deleting template version %s:%s.%s", name, major, minor)
+	err := kind.deleteVersion(ctx, protonClient, name, major, minor)
+	if err != nil {
+		fmt.Printf("WARNING: could not delete template version %s:%s.%s: %v \n", name, major, minor, err)
+	} else {
+		fmt.Printf("deleted template version %s:%s.%s \n", name, major, minor)
+	}
+}
+
+// prints a message explaining why a publish failed and exits
+func exitPublishError(result *publishResult, err error) {
+	var scanErr *scanError
+	var policyErr *policyError
+	switch {
+	case errors.Is(err, context.Canceled):
+		errorExit("publish interrupted")
+	case errors.Is(err, context.DeadlineExceeded):
+		errorExit(fmt.Sprintf("timed out after %v waiting for template version %s:%s to register",
+			flagTemplatePublishTimeout, result.Name, result.version()))
+	case errors.As(err, &scanErr),
+		errors.As(err, &policyErr),
+		errors.Is(err, errRegistrationFailed),
+		errors.Is(err, errVersionDrift),
+		errors.Is(err, errPublishConfig),
+		strings.Contains(err.Error(), "ValidationException"):
+		errorExit(err)
+	}
+	handleError("publishing template", err)
+}
+
+// uploads a template bundle to s3
+func uploadBundle(ctx context.Context, s3Client S3API, bucket, key, bundlePath string) error {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+	uploader := manager.NewUploader(s3Client)
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Body:   f,
+	})
+	return err
+}
 
-	desc := "published by proton cli"
-	m = "proton.UpdateEnvironmentTemplateVersion"
+// applies tags to an existing template, since they can't be set by an update
+func tagTemplate(ctx context.Context, protonClient ProtonAPI, plan *publishPlan) error {
+	m := "proton.TagResource()"
 	debug(m)
-	_, err = protonClient.UpdateServiceTemplateVersion(ctx, &proton.UpdateServiceTemplateVersionInput{
-		TemplateName: &protonConfig.Name,
-		MajorVersion: &majorVesion,
-		MinorVersion: &minorVersion,
-		Status:       types.TemplateVersionStatusPublished,
-		Description:  &desc,
+	_, err := protonClient.TagResource(ctx, &proton.TagResourceInput{
+		ResourceArn: &plan.TemplateArn,
+		Tags:        protonTags(plan.TemplateTags),
 	})
-	handleError(m, err)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	return nil
+}
 
-	return majorVesion, minorVersion
+// returns nil for an empty string so that optional api fields are omitted
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
 }
 
 func readProtonYAMLFile(fileName string) (*protonConfigData, error) {
@@ -356,5 +529,122 @@ func readProtonYAMLFile(fileName string) (*protonConfigData, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unmarshaling file: %s : %w", fileName, err)
 	}
+	err = result.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid file: %s : %w", fileName, err)
+	}
 	return &result, nil
 }
+
+// validates proton.yaml settings, returning all problems found
+func (c *protonConfigData) validate() error {
+	problems := []string{}
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if !protonNamePattern.MatchString(c.Name) || len(c.Name) > 100 {
+		add("name: %q is invalid. names must start with a letter or number, contain only letters, numbers, _ and -, and be at most 100 characters", c.Name)
+	}
+	if !(c.Type == "environment" || c.Type == "service") {
+		add("type: %q is invalid. only environment and service are supported", c.Type)
+	}
+	if len(c.DisplayName) > 100 {
+		add("displayName: must be at most 100 characters")
+	}
+	if len(c.Description) > 500 {
+		add("description: must be at most 500 characters")
+	}
+	if len(c.VersionDescription) > 500 {
+		add("versionDescription: must be at most 500 characters")
+	}
+	if major, err := strconv.Atoi(c.majorVersion()); err != nil || major < 1 || strconv.Itoa(major) != c.majorVersion() {
+		add("majorVersion: %q must be a whole number of at least 1", c.MajorVersion)
+	}
+	if _, err := parseCompatibleEnvironments(c.CompatibleEnvironments); err != nil {
+		add("compatibleEnvironments: %v", err)
+	}
+	if c.Type == "environment" && len(c.CompatibleEnvironments) > 0 {
+		add("compatibleEnvironments: only supported for service templates")
+	}
+
+	//tags
+	if len(c.Tags) > 50 {
+		add("tags: at most 50 tags are supported")
+	}
+	for k, v := range c.Tags {
+		if len(k) == 0 || len(k) > 128 {
+			add("tags: key %q must be between 1 and 128 characters", k)
+		}
+		if strings.HasPrefix(strings.ToLower(k), "aws:") {
+			add("tags: key %q uses the reserved aws: prefix", k)
+		}
+		if k == bundleHashTagKey || k == bundleSourceTagKey {
+			add("tags: key %q is reserved by protonizer", k)
+		}
+		if len(v) > 256 {
+			add("tags: value for key %q must be at most 256 characters", k)
+		}
+	}
+
+	//encryption key must be a kms key arn
+	if c.EncryptionKey != "" {
+		parts := strings.Split(c.EncryptionKey, ":")
+		if len(parts) < 6 || parts[0] != "arn" || parts[2] != "kms" {
+			add("encryptionKey: %q must be a KMS key ARN", c.EncryptionKey)
+		}
+	}
+
+	//provisioning
+	if c.Provisioning != "" {
+		if c.Type != "environment" {
+			add("provisioning: only supported for environment templates. use pipelineProvisioning for service templates")
+		} else if !(c.Provisioning == provisioningCustomerManaged || c.Provisioning == provisioningProtonManaged) {
+			add("provisioning: %q is invalid. only %s and %s are supported", c.Provisioning, provisioningCustomerManaged, provisioningProtonManaged)
+		}
+	}
+	if c.PipelineProvisioning != "" {
+		if c.Type != "service" {
+			add("pipelineProvisioning: only supported for service templates")
+		} else if !(c.PipelineProvisioning == provisioningCustomerManaged || c.PipelineProvisioning == provisioningProtonManaged) {
+			add("pipelineProvisioning: %q is invalid. only %s and %s are supported", c.PipelineProvisioning, provisioningCustomerManaged, provisioningProtonManaged)
+		}
+	}
+
+	//publish targets
+	for i, t := range c.Targets {
+		for _, p := range t.problems() {
+			add("targets[%d]: %s", i, p)
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// returns the major version to publish to, defaulting to 1
+func (c *protonConfigData) majorVersion() string {
+	if c.MajorVersion == "" {
+		return "1"
+	}
+	return c.MajorVersion
+}
+
+// returns the proton provisioning setting for a template,
+// defaulting service pipelines to customer managed
+func (c *protonConfigData) provisioning() types.Provisioning {
+	p := c.Provisioning
+	if c.Type == "service" {
+		p = c.PipelineProvisioning
+		if p == "" {
+			p = provisioningCustomerManaged
+		}
+	}
+	if p == provisioningProtonManaged {
+		return ""
+	}
+	return types.Provisioning(p)
+}
@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+// publish --all outcomes
+const (
+	publishStatusPublished = "PUBLISHED"
+	publishStatusDraft     = "DRAFT"
+	publishStatusUnchanged = "UNCHANGED"
+	publishStatusFailed    = "FAILED"
+	publishStatusSkipped   = "SKIPPED"
+)
+
+// a template found by publish --all, and the outcome of publishing it
+type publishJob struct {
+	file   string
+	dir    string
+	config *protonConfigData
+
+	//environment templates that must be published first
+	deps []*publishJob
+
+	result *publishResult
+	status string
+	err    error
+}
+
+// returns the job's template name, or its file if it couldn't be read
+func (j *publishJob) name() string {
+	if j.config != nil {
+		return j.config.Name
+	}
+	return j.file
+}
+
+// publishes every template under a directory, environment templates first,
+// and prints a summary. exits with an error if any template fails
+func publishAllTemplates(root string, concurrency int) {
+	if concurrency < 1 {
+		errorExit("concurrency must be at least 1")
+	}
+
+	files, err := findProtonFiles(root)
+	handleError("finding proton.yaml files", err)
+	if len(files) == 0 {
+		errorExit("no proton.yaml files found in " + root)
+	}
+	fmt.Printf("found %d templates in %s \n", len(files), root)
+
+	jobs := loadPublishJobs(files)
+	levels, err := orderPublishJobs(jobs)
+	if err != nil {
+		errorExit(err)
+	}
+
+	cfg := getAWSConfig()
+	protonClient := newProtonClient(cfg)
+	s3Client := newS3Client(cfg)
+	opts := publishOptionsFromFlags(cfg.Region)
+
+	//cancel on ctrl-c so that partial publishes can be cleaned up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runPublishJobs(ctx, levels, concurrency, func(ctx context.Context, job *publishJob) (*publishResult, error) {
+		targets := resolvePublishTargets(job.config, flagTemplatePublishRegions, flagTemplatePublishAccounts, cfg.Region)
+		if len(targets) == 0 {
+			return publishTemplateDir(ctx, protonClient, s3Client, job.config, job.dir, opts)
+		}
+		results, err := publishToTargets(ctx, awsTargetClients(cfg), job.config, job.dir, targets, opts)
+		if err != nil {
+			return &publishResult{Name: job.config.Name, Type: job.config.Type}, err
+		}
+		return combineTargetResults(results)
+	})
+
+	fmt.Println()
+	failed := printPublishSummary(os.Stdout, jobs)
+	if failed > 0 {
+		errorExit(fmt.Sprintf("\n%d of %d templates failed to publish", failed, len(jobs)))
+	}
+}
+
+// returns the proton.yaml files under a directory, skipping hidden directories
+func findProtonFiles(root string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == "proton.yaml" {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// reads each proton.yaml file. files that can't be read become failed jobs
+// so that one bad template doesn't stop the others from publishing
+func loadPublishJobs(files []string) []*publishJob {
+	jobs := []*publishJob{}
+	for _, file := range files {
+		job := &publishJob{file: file}
+		job.config, job.dir, job.err = loadPublishConfig(file)
+		if job.err != nil {
+			job.status = publishStatusFailed
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// groups jobs into levels that can be published concurrently.
+// service templates are placed after the environment templates
+// they reference in compatibleEnvironments
+func orderPublishJobs(jobs []*publishJob) ([][]*publishJob, error) {
+
+	//index templates by type, name and major version
+	byName := map[string]*publishJob{}
+	for _, job := range jobs {
+		if job.config == nil {
+			continue
+		}
+		key := job.config.Type + "/" + job.config.Name + ":" + job.config.majorVersion()
+		if other, found := byName[key]; found {
+			return nil, fmt.Errorf("%s template %s:%s is defined in both %s and %s",
+				job.config.Type, job.config.Name, job.config.majorVersion(), other.file, job.file)
+		}
+		byName[key] = job
+	}
+
+	//services depend on the environment template versions they're compatible with
+	for _, job := range jobs {
+		if job.config == nil || job.config.Type != "service" {
+			continue
+		}
+		for _, env := range job.config.CompatibleEnvironments {
+			if dep, found := byName["environment/"+env]; found {
+				job.deps = append(job.deps, dep)
+			}
+		}
+	}
+
+	//environment templates don't have dependencies, so levels are at most 2 deep
+	levels := [][]*publishJob{}
+	depth := map[*publishJob]int{}
+	for _, job := range jobs {
+		d := 0
+		for _, dep := range job.deps {
+			if depth[dep]+1 > d {
+				d = depth[dep] + 1
+			}
+		}
+		depth[job] = d
+		for len(levels) <= d {
+			levels = append(levels, []*publishJob{})
+		}
+		levels[d] = append(levels[d], job)
+	}
+	return levels, nil
+}
+
+// publishes each level of jobs in turn, running up to concurrency jobs at once.
+// jobs whose dependencies didn't publish are skipped
+func runPublishJobs(ctx context.Context, levels [][]*publishJob, concurrency int,
+	publish func(context.Context, *publishJob) (*publishResult, error)) {
+
+	for _, level := range levels {
+		queue := make(chan *publishJob)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for job := range queue {
+					runPublishJob(ctx, job, publish)
+				}
+			}()
+		}
+		for _, job := range level {
+			queue <- job
+		}
+		close(queue)
+		wg.Wait()
+	}
+}
+
+// publishes a job, recording its outcome and printing progress
+func runPublishJob(ctx context.Context, job *publishJob,
+	publish func(context.Context, *publishJob) (*publishResult, error)) {
+
+	if job.status == publishStatusFailed {
+		fmt.Printf("failed %s: %v \n", job.name(), firstLine(job.err.Error()))
+		return
+	}
+	for _, dep := range job.deps {
+		if dep.status == publishStatusFailed || dep.status == publishStatusSkipped {
+			job.status = publishStatusSkipped
+			job.err = fmt.Errorf("environment template %s did not publish", dep.name())
+			fmt.Printf("skipped %s: %v \n", job.name(), job.err)
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		job.status = publishStatusSkipped
+		job.err = fmt.Errorf("publish interrupted")
+		return
+	}
+
+	debug("publishing", job.file)
+	job.result, job.err = publish(ctx, job)
+	switch {
+	case job.err != nil:
+		job.status = publishStatusFailed
+		fmt.Printf("failed %s: %v \n", job.name(), firstLine(job.err.Error()))
+	case job.result.Unchanged:
+		job.status = publishStatusUnchanged
+		fmt.Printf("unchanged %s:%s \n", job.name(), job.result.version())
+	case job.result.Status == types.TemplateVersionStatusDraft:
+		job.status = publishStatusDraft
+		fmt.Printf("registered %s:%s as %s \n", job.name(), job.result.version(), job.status)
+	default:
+		job.status = publishStatusPublished
+		fmt.Printf("published %s:%s \n", job.name(), job.result.version())
+	}
+}
+
+// prints a table of publish outcomes followed by any errors,
+// returning the number of templates that failed or were skipped
+func printPublishSummary(w io.Writer, jobs []*publishJob) int {
+	tw := newTableWriter(w)
+	fmt.Fprintln(tw, "TEMPLATE\tTYPE\tVERSION\tSTATUS")
+	failed := []*publishJob{}
+	for _, job := range jobs {
+		templateType, version := "-", "-"
+		if job.config != nil {
+			templateType = job.config.Type
+		}
+		if job.err == nil && job.result != nil {
+			version = job.result.version()
+		} else {
+			failed = append(failed, job)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", job.name(), templateType, version, job.status)
+	}
+	tw.Flush()
+
+	for _, job := range failed {
+		fmt.Fprintf(w, "\n%s (%s):\n%v\n", job.name(), job.file, job.err)
+	}
+	return len(failed)
+}
+
+// returns the first line of a possibly multi-line message
+func firstLine(s string) string {
+	if i := strings.Index(s, "\n"); i != -1 {
+		return s[:i]
+	}
+	return s
+}
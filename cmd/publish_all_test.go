@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writes a template with a proton.yaml to a directory under root
+func internalWriteTemplate(t *testing.T, root, dir, protonYAML string) string {
+	t.Helper()
+	internalWriteFiles(t, filepath.Join(root, dir), map[string]string{
+		"proton.yaml":            protonYAML,
+		"schema/schema.yaml":     "schema:\n",
+		"infrastructure/main.tf": "",
+	})
+	return filepath.Join(root, dir, "proton.yaml")
+}
+
+func TestFindProtonFiles(t *testing.T) {
+	root := t.TempDir()
+	internalWriteFiles(t, root, map[string]string{
+		"env/proton.yaml":          "",
+		"services/svc/proton.yaml": "",
+		".git/proton.yaml":         "",
+		"README.md":                "",
+	})
+
+	files, err := findProtonFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(root, "env/proton.yaml"),
+		filepath.Join(root, "services/svc/proton.yaml"),
+	}
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Error("expecting", expected, "got", files)
+	}
+}
+
+func TestOrderPublishJobs(t *testing.T) {
+	env := &publishJob{file: "env", config: &protonConfigData{Name: "env", Type: "environment"}}
+	svc := &publishJob{file: "svc", config: &protonConfigData{Name: "svc", Type: "service", CompatibleEnvironments: []string{"env:1"}}}
+	other := &publishJob{file: "other", config: &protonConfigData{Name: "other", Type: "service", CompatibleEnvironments: []string{"external:2"}}}
+	invalid := &publishJob{file: "invalid", status: publishStatusFailed}
+
+	levels, err := orderPublishJobs([]*publishJob{svc, other, env, invalid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 2 {
+		t.Fatalf("expecting 2 levels, got %d", len(levels))
+	}
+	if len(levels[0]) != 3 || len(levels[1]) != 1 || levels[1][0] != svc {
+		t.Error("expecting service to be published after its environment template", levels)
+	}
+	if len(svc.deps) != 1 || svc.deps[0] != env {
+		t.Error("expecting service to depend on environment template", svc.deps)
+	}
+
+	//templates must be unique
+	duplicate := &publishJob{file: "env2", config: &protonConfigData{Name: "env", Type: "environment", MajorVersion: "1"}}
+	if _, err := orderPublishJobs([]*publishJob{env, duplicate}); err == nil {
+		t.Error("expecting error for duplicate templates")
+	}
+
+	//but each major version of a template is published separately
+	env.deps, svc.deps = nil, nil
+	env2 := &publishJob{file: "env/v2", config: &protonConfigData{Name: "env", Type: "environment", MajorVersion: "2"}}
+	svc2 := &publishJob{file: "svc2", config: &protonConfigData{Name: "svc2", Type: "service", CompatibleEnvironments: []string{"env:2"}}}
+	levels, err = orderPublishJobs([]*publishJob{env, env2, svc, svc2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 2 || len(levels[0]) != 2 || len(levels[1]) != 2 {
+		t.Fatal("expecting both major versions to be published before the services", levels)
+	}
+	if len(svc.deps) != 1 || svc.deps[0] != env || len(svc2.deps) != 1 || svc2.deps[0] != env2 {
+		t.Error("expecting services to depend on the major version they're compatible with", svc.deps, svc2.deps)
+	}
+}
+
+func TestPublishAllIsolatesFailures(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		internalWriteTemplate(t, root, "good_env", "name: good_env\ntype: environment\npublishBucket: bucket\n"),
+		internalWriteTemplate(t, root, "bad_env", "name: bad_env\ntype: environment\npublishBucket: bucket\n"),
+		internalWriteTemplate(t, root, "good_svc", "name: good_svc\ntype: service\npublishBucket: bucket\ncompatibleEnvironments:\n  - good_env:1\n"),
+		internalWriteTemplate(t, root, "bad_svc", "name: bad_svc\ntype: service\npublishBucket: bucket\ncompatibleEnvironments:\n  - bad_env:1\n"),
+		internalWriteTemplate(t, root, "no_bucket", "name: no_bucket\ntype: environment\n"),
+	}
+
+	protonClient := newFakeProton()
+	protonClient.registrationFailures["bad_env"] = "invalid schema"
+	s3Client := newFakeS3()
+	opts := publishOptions{region: "us-east-1", timeout: time.Minute}
+
+	jobs := loadPublishJobs(files)
+	levels, err := orderPublishJobs(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runPublishJobs(context.Background(), levels, 2, func(ctx context.Context, job *publishJob) (*publishResult, error) {
+		return publishTemplateDir(ctx, protonClient, s3Client, job.config, job.dir, opts)
+	})
+
+	expected := map[string]string{
+		"good_env": publishStatusPublished,
+		"bad_env":  publishStatusFailed,
+		"good_svc": publishStatusPublished,
+		"bad_svc":  publishStatusSkipped,
+	}
+	for _, job := range jobs {
+		if job.config == nil {
+			if job.status != publishStatusFailed {
+				t.Error("expecting invalid config to fail, got", job.status)
+			}
+			continue
+		}
+		if job.status != expected[job.name()] {
+			t.Errorf("%s: expecting %s, got %s (%v)", job.name(), expected[job.name()], job.status, job.err)
+		}
+	}
+
+	//bundles are uploaded to unique keys
+	if len(s3Client.objects) != 3 {
+		t.Error("expecting 3 bundles to be uploaded, got", len(s3Client.objects))
+	}
+
+	var out bytes.Buffer
+	if failed := printPublishSummary(&out, jobs); failed != 3 {
+		t.Error("expecting 3 failures, got", failed)
+	}
+	if !strings.Contains(out.String(), "invalid schema") || !regexp.MustCompile(`good_svc\s+service\s+1\.0\s+PUBLISHED`).MatchString(out.String()) {
+		t.Error("unexpected summary", out.String())
+	}
+}
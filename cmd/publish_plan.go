@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+// describes the proton and s3 calls that publish makes
+type publishPlan struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Region       string `json:"region"`
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	BundleSHA256 string `json:"bundleSha256"`
+
+	//template create/update
+	TemplateExists bool               `json:"templateExists"`
+	TemplateArn    string             `json:"templateArn,omitempty"`
+	DisplayName    string             `json:"displayName"`
+	Description    string             `json:"description"`
+	EncryptionKey  string             `json:"encryptionKey,omitempty"`
+	Provisioning   types.Provisioning `json:"provisioning,omitempty"`
+	TemplateTags   map[string]string  `json:"templateTags"`
+
+	//template version create/update
+	MajorVersion           string                      `json:"majorVersion"`
+	MinorVersion           string                      `json:"minorVersion"`
+	CompatibleEnvironments []string                    `json:"compatibleEnvironments,omitempty"`
+	VersionTags            map[string]string           `json:"versionTags"`
+	VersionDescription     string                      `json:"versionDescription"`
+	Status                 types.TemplateVersionStatus `json:"status"`
+
+	//the latest version already has this bundle, so nothing will be published
+	Unchanged bool `json:"unchanged"`
+}
+
+// returned when proton.yaml conflicts with what already exists in proton
+var errPublishConfig = errors.New("invalid publish configuration")
+
+// builds a publish plan using only read-only proton calls
+func planPublish(ctx context.Context, protonClient ProtonAPI,
+	protonConfig *protonConfigData, s3Key, bundleHash string, opts publishOptions) (*publishPlan, error) {
+
+	plan := &publishPlan{
+		Name:          protonConfig.Name,
+		Type:          protonConfig.Type,
+		Region:        opts.region,
+		Bucket:        protonConfig.PublishBucket,
+		Key:           s3Key,
+		BundleSHA256:  bundleHash,
+		DisplayName:   protonConfig.DisplayName,
+		Description:   protonConfig.Description,
+		EncryptionKey: protonConfig.EncryptionKey,
+		Provisioning:  protonConfig.provisioning(),
+		TemplateTags: map[string]string{
+			"creator": "protonizer-cli",
+		},
+		MajorVersion:           protonConfig.majorVersion(),
+		CompatibleEnvironments: protonConfig.CompatibleEnvironments,
+		VersionTags:            map[string]string{},
+		VersionDescription:     protonConfig.VersionDescription,
+		Status:                 types.TemplateVersionStatusPublished,
+	}
+	if plan.VersionDescription == "" {
+		plan.VersionDescription = defaultVersionDescription
+	}
+	if opts.draft {
+		plan.Status = types.TemplateVersionStatusDraft
+	}
+
+	//user tags apply to both the template and the version
+	for k, v := range protonConfig.Tags {
+		plan.TemplateTags[k] = v
+		plan.VersionTags[k] = v
+	}
+	plan.VersionTags[bundleHashTagKey] = bundleHash
+	plan.VersionTags[bundleSourceTagKey] = s3URL(plan.Bucket, plan.Key)
+
+	kind, err := kindFor(protonConfig.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	//does the template exist?
+	existing, err := kind.getTemplate(ctx, protonClient, protonConfig.Name)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	plan.TemplateExists = err == nil
+
+	if plan.TemplateExists {
+		plan.TemplateArn = existing.Arn
+
+		//the encryption key can only be set when a template is created
+		if plan.EncryptionKey != "" && plan.EncryptionKey != existing.EncryptionKey {
+			return nil, fmt.Errorf("%w: encryptionKey: template %s already exists with a different encryption key (%s). the key can only be set when a template is created",
+				errPublishConfig, plan.Name, existing.EncryptionKey)
+		}
+	}
+
+	//proton assigns the next minor version within the major version
+	plan.MinorVersion = "0"
+	if !plan.TemplateExists {
+		return plan, nil
+	}
+	versions, err := kind.listVersions(ctx, protonClient, protonConfig.Name, plan.MajorVersion)
+	if err != nil {
+		return nil, err
+	}
+	latest, latestMatching := -1, -1
+	var latestVersion *templateVersionInfo
+	for i, v := range versions {
+		minor, err := strconv.Atoi(v.MinorVersion)
+		if err != nil {
+			continue
+		}
+		if minor > latest {
+			latest = minor
+		}
+
+		//the latest published version, or draft when publishing drafts
+		if (v.Status == types.TemplateVersionStatusPublished || v.Status == plan.Status) && minor > latestMatching {
+			latestMatching = minor
+			latestVersion = &versions[i]
+		}
+	}
+	plan.MinorVersion = strconv.Itoa(latest + 1)
+
+	//skip publishing if the latest version already has this bundle
+	if latestVersion != nil && !opts.force {
+		tags, err := listTags(ctx, protonClient, latestVersion.Arn)
+		if err != nil {
+			return nil, err
+		}
+		if tags[bundleHashTagKey] == bundleHash {
+			plan.Unchanged = true
+			plan.MinorVersion = latestVersion.MinorVersion
+			plan.Status = latestVersion.Status
+		}
+	}
+
+	return plan, nil
+}
+
+// parses compatible environments in the format name:majorversion
+func parseCompatibleEnvironments(envs []string) ([]types.CompatibleEnvironmentTemplateInput, error) {
+	result := []types.CompatibleEnvironmentTemplateInput{}
+	for _, c := range envs {
+		parts := strings.Split(c, ":")
+		if len(parts) != 2 {
+			return nil, errors.New("compatible environments must use the format: `name:version`")
+		}
+		result = append(result, types.CompatibleEnvironmentTemplateInput{
+			TemplateName: aws.String(parts[0]),
+			MajorVersion: aws.String(parts[1]),
+		})
+	}
+	return result, nil
+}
+
+// returns true if an error is a proton ResourceNotFoundException
+func isNotFound(err error) bool {
+	var nf *types.ResourceNotFoundException
+	return errors.As(err, &nf)
+}
+
+// returns the tags on a proton resource
+func listTags(ctx context.Context, protonClient ProtonAPI, arn string) (map[string]string, error) {
+	m := "proton.ListTagsForResource()"
+	debug(m)
+	tags := map[string]string{}
+	p := proton.NewListTagsForResourcePaginator(protonClient, &proton.ListTagsForResourceInput{
+		ResourceArn: &arn,
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, t := range page.Tags {
+			tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		}
+	}
+	return tags, nil
+}
+
+// converts a map of tags into proton tags, sorted by key
+func protonTags(tags map[string]string) []types.Tag {
+	keys := []string{}
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := []types.Tag{}
+	for _, k := range keys {
+		result = append(result, types.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		})
+	}
+	return result
+}
+
+// formats tags as k=v pairs, sorted by key
+func formatTags(tags map[string]string) string {
+	pairs := []string{}
+	for _, t := range protonTags(tags) {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", *t.Key, *t.Value))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// writes a publish plan as human-readable text or json
+func printPublishPlan(w io.Writer, plan *publishPlan, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+
+	fmt.Fprintln(w, "dry run: no changes will be made")
+	fmt.Fprintln(w)
+
+	if plan.Unchanged {
+		fmt.Fprintf(w, "template version %s:%s.%s already has bundle sha256: %s\n", plan.Name, plan.MajorVersion, plan.MinorVersion, plan.BundleSHA256)
+		fmt.Fprintln(w, "nothing to publish. use --force to publish a new version anyway")
+		return nil
+	}
+
+	fmt.Fprintln(w, "upload template bundle")
+	fmt.Fprintf(w, "  s3://%s/%s\n", plan.Bucket, plan.Key)
+	fmt.Fprintf(w, "  sha256: %s\n", plan.BundleSHA256)
+	fmt.Fprintln(w)
+
+	if plan.TemplateExists {
+		fmt.Fprintf(w, "tag existing %s template %s (%s)\n", plan.Type, plan.Name, plan.Region)
+	} else {
+		fmt.Fprintf(w, "create %s template %s (%s)\n", plan.Type, plan.Name, plan.Region)
+		fmt.Fprintf(w, "  displayName: %s\n", plan.DisplayName)
+		fmt.Fprintf(w, "  description: %s\n", plan.Description)
+		if plan.EncryptionKey != "" {
+			fmt.Fprintf(w, "  encryptionKey: %s\n", plan.EncryptionKey)
+		}
+		if plan.Provisioning != "" {
+			fmt.Fprintf(w, "  provisioning: %s\n", plan.Provisioning)
+		}
+	}
+	fmt.Fprintf(w, "  tags: %s\n", formatTags(plan.TemplateTags))
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "create template version %s:%s.%s\n", plan.Name, plan.MajorVersion, plan.MinorVersion)
+	fmt.Fprintf(w, "  source: s3://%s/%s\n", plan.Bucket, plan.Key)
+	for _, c := range plan.CompatibleEnvironments {
+		fmt.Fprintf(w, "  compatibleEnvironment: %s\n", c)
+	}
+	fmt.Fprintf(w, "  description: %s\n", plan.VersionDescription)
+	fmt.Fprintf(w, "  tags: %s\n", formatTags(plan.VersionTags))
+	fmt.Fprintln(w)
+
+	if plan.Status == types.TemplateVersionStatusDraft {
+		fmt.Fprintf(w, "leave template version %s:%s.%s in %s\n", plan.Name, plan.MajorVersion, plan.MinorVersion, plan.Status)
+		return nil
+	}
+	fmt.Fprintf(w, "update template version %s:%s.%s\n", plan.Name, plan.MajorVersion, plan.MinorVersion)
+	fmt.Fprintf(w, "  status: %s\n", plan.Status)
+	return nil
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func internalTestPlan() *publishPlan {
+	return &publishPlan{
+		Name:                   "my_template",
+		Type:                   "service",
+		Region:                 "us-east-1",
+		Bucket:                 "my-s3-bucket",
+		Key:                    "bundle.tar.gz",
+		BundleSHA256:           "abc123",
+		TemplateExists:         true,
+		DisplayName:            "My Template",
+		Description:            "This is my template",
+		TemplateTags:           map[string]string{"creator": "protonizer-cli"},
+		MajorVersion:           "1",
+		MinorVersion:           "3",
+		CompatibleEnvironments: []string{"env1:3", "env2:4"},
+		VersionTags:            map[string]string{bundleHashTagKey: "abc123"},
+		VersionDescription:     "published by proton cli",
+		Status:                 types.TemplateVersionStatusPublished,
+	}
+}
+
+func TestPrintPublishPlanText(t *testing.T) {
+	var buf bytes.Buffer
+	err := printPublishPlan(&buf, internalTestPlan(), "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	t.Log(out)
+
+	expected := []string{
+		"s3://my-s3-bucket/bundle.tar.gz",
+		"sha256: abc123",
+		"tag existing service template my_template (us-east-1)",
+		"create template version my_template:1.3",
+		"compatibleEnvironment: env1:3",
+		"compatibleEnvironment: env2:4",
+		"tags: protonizer-bundle-sha256=abc123",
+		"status: PUBLISHED",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Error("expecting output to contain", e)
+		}
+	}
+}
+
+func TestPrintPublishPlanDraft(t *testing.T) {
+	plan := internalTestPlan()
+	plan.Status = types.TemplateVersionStatusDraft
+
+	var buf bytes.Buffer
+	err := printPublishPlan(&buf, plan, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	t.Log(out)
+
+	if !strings.Contains(out, "leave template version my_template:1.3 in DRAFT") {
+		t.Error("expecting draft versions to be left in DRAFT")
+	}
+	if strings.Contains(out, "status: PUBLISHED") {
+		t.Error("not expecting draft versions to be published")
+	}
+}
+
+func TestPrintPublishPlanJSON(t *testing.T) {
+	var buf bytes.Buffer
+	err := printPublishPlan(&buf, internalTestPlan(), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(buf.String())
+
+	var result publishPlan
+	err = json.Unmarshal(buf.Bytes(), &result)
+	if err != nil {
+		t.Fatal("invalid json", err)
+	}
+	if result.MinorVersion != "3" || len(result.CompatibleEnvironments) != 2 || result.Status != types.TemplateVersionStatusPublished {
+		t.Error("unexpected plan", result)
+	}
+}
+
+func TestParseCompatibleEnvironments(t *testing.T) {
+	result, err := parseCompatibleEnvironments([]string{"env1:3", "env2:4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || *result[1].TemplateName != "env2" || *result[1].MajorVersion != "4" {
+		t.Error("unexpected result", result)
+	}
+
+	_, err = parseCompatibleEnvironments([]string{"env1"})
+	if err == nil {
+		t.Error("expecting an error for an invalid compatible environment")
+	}
+}
+
+func TestPrintPublishPlanUnchanged(t *testing.T) {
+	plan := internalTestPlan()
+	plan.Unchanged = true
+
+	var buf bytes.Buffer
+	err := printPublishPlan(&buf, plan, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	t.Log(out)
+
+	if !strings.Contains(out, "nothing to publish") {
+		t.Error("expecting unchanged plans to publish nothing")
+	}
+	if strings.Contains(out, "upload template bundle") {
+		t.Error("not expecting unchanged plans to upload a bundle")
+	}
+}
@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// placeholders that can be used in publishBucket to name region-local buckets
+const (
+	bucketRegionPlaceholder  = "{region}"
+	bucketAccountPlaceholder = "{account}"
+)
+
+// returned when targets would end up on different template versions
+var errVersionDrift = errors.New("template versions differ between targets")
+
+// a region and account that a template is published to
+type publishTarget struct {
+	Region string `yaml:"region"`
+
+	//optional
+	RoleArn       string `yaml:"roleArn,omitempty"`
+	PublishBucket string `yaml:"publishBucket,omitempty"`
+}
+
+// returns the target as region or region/account
+func (t publishTarget) String() string {
+	if a := t.account(); a != "" {
+		return t.Region + "/" + a
+	}
+	return t.Region
+}
+
+// returns the account id from the target's role arn, if any
+func (t publishTarget) account() string {
+	parts := strings.Split(t.RoleArn, ":")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// returns the target's bucket, expanding placeholders in the default publishBucket
+func (t publishTarget) bucket(publishBucket string) string {
+	if t.PublishBucket != "" {
+		publishBucket = t.PublishBucket
+	}
+	b := strings.ReplaceAll(publishBucket, bucketRegionPlaceholder, t.Region)
+	if a := t.account(); a != "" {
+		b = strings.ReplaceAll(b, bucketAccountPlaceholder, a)
+	}
+	return b
+}
+
+// returns true if every target in proton.yaml sets its own bucket
+func (c *protonConfigData) targetsHaveBuckets() bool {
+	for _, t := range c.Targets {
+		if t.PublishBucket == "" {
+			return false
+		}
+	}
+	return len(c.Targets) > 0
+}
+
+// publishes a template to multiple targets and prints the outcome for each
+func publishTemplateToTargets(ctx context.Context, cfg aws.Config, protonConfig *protonConfigData, dir string, targets []publishTarget) {
+	fmt.Printf("publishing %s to %d targets \n", protonConfig.Name, len(targets))
+	results, err := publishToTargets(ctx, awsTargetClients(cfg), protonConfig, dir, targets, publishOptionsFromFlags(cfg.Region))
+	if err != nil {
+		exitPublishError(&publishResult{Name: protonConfig.Name}, err)
+	}
+
+	fmt.Println("bundle sha256:", results[0].result.BundleSHA256)
+	printTargetResults(os.Stdout, results)
+	if _, err := combineTargetResults(results); err != nil {
+		errorExit("\n" + err.Error())
+	}
+}
+
+// prints the publish plan for each target, followed by any version drift between them
+func dryRunPublishToTargets(ctx context.Context, cfg aws.Config, protonConfig *protonConfigData,
+	bundleHash string, targets []publishTarget, format string) {
+
+	if err := validatePublishTargets(protonConfig.PublishBucket, targets); err != nil {
+		errorExit(err)
+	}
+	clients := awsTargetClients(cfg)
+	plans := []*publishPlan{}
+	results := []*targetResult{}
+	for _, target := range targets {
+		protonClient, _ := clients(target)
+		targetConfig := *protonConfig
+		targetConfig.PublishBucket = target.bucket(protonConfig.PublishBucket)
+		plan, err := planPublish(ctx, protonClient, &targetConfig, bundleKey(protonConfig, bundleHash), bundleHash, publishOptionsFromFlags(target.Region))
+		if errors.Is(err, errPublishConfig) {
+			errorExit(fmt.Errorf("%s: %w", target, err))
+		}
+		handleError("planning publish for "+target.String(), err)
+		plans = append(plans, plan)
+		results = append(results, &targetResult{
+			target: target,
+			result: &publishResult{Name: plan.Name, MajorVersion: plan.MajorVersion, MinorVersion: plan.MinorVersion},
+		})
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		handleError("printing publish plans", enc.Encode(plans))
+		return
+	}
+	for i, plan := range plans {
+		fmt.Printf("target: %s \n\n", targets[i])
+		handleError("printing publish plan", printPublishPlan(os.Stdout, plan, format))
+		fmt.Println()
+	}
+	if drift := versionDrift(results); drift != "" {
+		fmt.Printf("WARNING: %v:\n%s\n", errVersionDrift, drift)
+	}
+}
+
+// returns the targets to publish to. --regions and --accounts take precedence over
+// targets in proton.yaml. returns nil to publish to the default region and account only
+func resolvePublishTargets(protonConfig *protonConfigData, regions, accounts []string, defaultRegion string) []publishTarget {
+	if len(regions) == 0 && len(accounts) == 0 {
+		return protonConfig.Targets
+	}
+	if len(regions) == 0 {
+		regions = []string{defaultRegion}
+	}
+	if len(accounts) == 0 {
+		accounts = []string{""}
+	}
+	targets := []publishTarget{}
+	for _, account := range accounts {
+		for _, region := range regions {
+			targets = append(targets, publishTarget{Region: region, RoleArn: account})
+		}
+	}
+	return targets
+}
+
+// validates a target's settings, returning any problems found
+func (t publishTarget) problems() []string {
+	problems := []string{}
+	if t.Region == "" {
+		problems = append(problems, "a region is required")
+	}
+	if t.RoleArn != "" {
+		parts := strings.Split(t.RoleArn, ":")
+		if len(parts) < 6 || parts[0] != "arn" || parts[2] != "iam" || !strings.HasPrefix(parts[5], "role/") {
+			problems = append(problems, fmt.Sprintf("roleArn: %q must be an IAM role ARN", t.RoleArn))
+		}
+	}
+	return problems
+}
+
+// checks that targets are unique and that each resolves to its own region-local bucket
+func validatePublishTargets(publishBucket string, targets []publishTarget) error {
+	problems := []string{}
+	seen := map[string]bool{}
+	bucketRegions := map[string]string{}
+	for _, t := range targets {
+		for _, p := range t.problems() {
+			problems = append(problems, fmt.Sprintf("target %s: %s", t, p))
+		}
+		if seen[t.String()] {
+			problems = append(problems, fmt.Sprintf("target %s: duplicate target", t))
+		}
+		seen[t.String()] = true
+
+		b := t.bucket(publishBucket)
+		switch {
+		case b == "":
+			problems = append(problems, fmt.Sprintf("target %s: no publishBucket", t))
+		case strings.Contains(b, bucketAccountPlaceholder):
+			problems = append(problems, fmt.Sprintf("target %s: %s in publishBucket requires a roleArn", t, bucketAccountPlaceholder))
+		case bucketRegions[b] != "" && bucketRegions[b] != t.Region:
+			problems = append(problems, fmt.Sprintf("target %s: bucket %s is already used in %s. buckets must be in the same region as proton, use %s in publishBucket",
+				t, b, bucketRegions[b], bucketRegionPlaceholder))
+		}
+		bucketRegions[b] = t.Region
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", errPublishConfig, strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// creates the clients used to publish to a target
+type targetClients func(target publishTarget) (ProtonAPI, S3API)
+
+// returns clients for targets using a base aws config,
+// assuming each target's role if it has one
+func awsTargetClients(base aws.Config) targetClients {
+	return func(target publishTarget) (ProtonAPI, S3API) {
+		cfg := base.Copy()
+		cfg.Region = target.Region
+		if target.RoleArn != "" {
+			cfg.Credentials = assumeRoleCredentials(cfg, target.RoleArn)
+		}
+		return newProtonClient(cfg), newS3Client(cfg)
+	}
+}
+
+// the outcome of publishing a template to one target
+type targetResult struct {
+	target publishTarget
+	bucket string
+	result *publishResult
+	err    error
+}
+
+// bundles a template once, then publishes it to every target concurrently.
+// every target is planned before anything is changed, and the publish is refused
+// if targets would end up on different versions unless drift is allowed
+func publishToTargets(ctx context.Context, clients targetClients, protonConfig *protonConfigData, dir string,
+	targets []publishTarget, opts publishOptions) ([]*targetResult, error) {
+
+	if err := validatePublishTargets(protonConfig.PublishBucket, targets); err != nil {
+		return nil, err
+	}
+
+	//tar gz template
+	zipPath := path.Join(dir, "bundle.tar.gz")
+	bundleHash, err := buildBundle(dir, zipPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(zipPath)
+
+	protonClients := make([]ProtonAPI, len(targets))
+	s3Clients := make([]S3API, len(targets))
+	for i, target := range targets {
+		protonClients[i], s3Clients[i] = clients(target)
+	}
+
+	//plan every target
+	results := make([]*targetResult, len(targets))
+	plans := make([]*publishPlan, len(targets))
+	forEachTarget(targets, func(i int, target publishTarget) {
+		targetConfig := *protonConfig
+		targetConfig.PublishBucket = target.bucket(protonConfig.PublishBucket)
+		targetOpts := opts
+		targetOpts.region = target.Region
+		plan, err := planPublish(ctx, protonClients[i], &targetConfig, bundleKey(protonConfig, bundleHash), bundleHash, targetOpts)
+		if err != nil {
+			err = fmt.Errorf("%s: planning publish: %w", target, err)
+		}
+		plans[i] = plan
+		results[i] = &targetResult{
+			target: target,
+			bucket: targetConfig.PublishBucket,
+			result: &publishResult{Name: protonConfig.Name, Type: protonConfig.Type, BundleSHA256: bundleHash},
+			err:    err,
+		}
+		if plan != nil {
+			results[i].result.MajorVersion = plan.MajorVersion
+			results[i].result.MinorVersion = plan.MinorVersion
+			results[i].result.Status = plan.Status
+			results[i].result.Unchanged = plan.Unchanged
+		}
+	})
+	for _, r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	if drift := versionDrift(results); drift != "" && !opts.allowDrift {
+		return nil, fmt.Errorf("%w before publishing, use --allow-drift to publish anyway:\n%s", errVersionDrift, drift)
+	}
+
+	//publish to every target
+	forEachTarget(targets, func(i int, target publishTarget) {
+		r := results[i]
+		r.result.MinorVersion, r.err = publishPlanned(ctx, protonClients[i], s3Clients[i], plans[i], zipPath, opts.timeout)
+		if r.err != nil {
+			r.err = fmt.Errorf("%s: %w", target, r.err)
+		}
+	})
+	return results, nil
+}
+
+// runs f for each target concurrently
+func forEachTarget(targets []publishTarget, f func(int, publishTarget)) {
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target publishTarget) {
+			defer wg.Done()
+			f(i, target)
+		}(i, target)
+	}
+	wg.Wait()
+}
+
+// describes which versions successful targets are on if they differ,
+// returning an empty string when they're aligned
+func versionDrift(results []*targetResult) string {
+	byVersion := map[string][]string{}
+	for _, r := range results {
+		if r.err == nil {
+			v := r.result.version()
+			byVersion[v] = append(byVersion[v], r.target.String())
+		}
+	}
+	if len(byVersion) < 2 {
+		return ""
+	}
+	versions := []string{}
+	for v := range byVersion {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	var sb strings.Builder
+	for _, v := range versions {
+		fmt.Fprintf(&sb, "  %s:%s: %s\n", results[0].result.Name, v, strings.Join(byVersion[v], ", "))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// combines per-target results into a single result, returning an error
+// if any target failed or targets ended up on different versions
+func combineTargetResults(results []*targetResult) (*publishResult, error) {
+	failed := []string{}
+	for _, r := range results {
+		if r.err != nil {
+			failed = append(failed, r.err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return results[0].result, fmt.Errorf("%d of %d targets failed:\n%s", len(failed), len(results), strings.Join(failed, "\n"))
+	}
+	if drift := versionDrift(results); drift != "" {
+		return results[0].result, fmt.Errorf("%w:\n%s", errVersionDrift, drift)
+	}
+	return results[0].result, nil
+}
+
+// prints a table of per-target publish outcomes
+func printTargetResults(w io.Writer, results []*targetResult) {
+	tw := newTableWriter(w)
+	fmt.Fprintln(tw, "TARGET\tBUCKET\tVERSION\tSTATUS")
+	for _, r := range results {
+		version, status := r.result.version(), string(r.result.Status)
+		if r.err != nil {
+			version, status = "-", publishStatusFailed
+		} else if r.result.Unchanged {
+			status = publishStatusUnchanged
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.target, r.bucket, version, status)
+	}
+	tw.Flush()
+}
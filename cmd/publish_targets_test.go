@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+const (
+	testRoleArn1 = "arn:aws:iam::111111111111:role/publisher"
+	testRoleArn2 = "arn:aws:iam::222222222222:role/publisher"
+)
+
+func TestResolvePublishTargets(t *testing.T) {
+	config := &protonConfigData{Targets: []publishTarget{{Region: "us-west-2"}}}
+
+	targets := resolvePublishTargets(config, []string{"us-east-1", "eu-west-1"}, []string{testRoleArn1, testRoleArn2}, "us-east-1")
+	if len(targets) != 4 {
+		t.Fatalf("expecting 4 targets, got %d", len(targets))
+	}
+	if targets[3].Region != "eu-west-1" || targets[3].RoleArn != testRoleArn2 {
+		t.Error("unexpected target", targets[3])
+	}
+
+	//accounts only publish to the default region
+	targets = resolvePublishTargets(config, nil, []string{testRoleArn1}, "us-east-1")
+	if len(targets) != 1 || targets[0].Region != "us-east-1" {
+		t.Error("expecting default region, got", targets)
+	}
+
+	//proton.yaml targets are used without flags
+	targets = resolvePublishTargets(config, nil, nil, "us-east-1")
+	if len(targets) != 1 || targets[0].Region != "us-west-2" {
+		t.Error("expecting proton.yaml targets, got", targets)
+	}
+
+	if targets := resolvePublishTargets(&protonConfigData{}, nil, nil, "us-east-1"); targets != nil {
+		t.Error("expecting no targets, got", targets)
+	}
+}
+
+func TestPublishTargetBucket(t *testing.T) {
+	target := publishTarget{Region: "eu-west-1", RoleArn: testRoleArn1}
+	if b := target.bucket("templates-{account}-{region}"); b != "templates-111111111111-eu-west-1" {
+		t.Error("unexpected bucket", b)
+	}
+	target.PublishBucket = "my-bucket"
+	if b := target.bucket("templates-{region}"); b != "my-bucket" {
+		t.Error("expecting target bucket, got", b)
+	}
+	if s := target.String(); s != "eu-west-1/111111111111" {
+		t.Error("unexpected target string", s)
+	}
+}
+
+func TestValidatePublishTargets(t *testing.T) {
+	valid := []publishTarget{
+		{Region: "us-east-1", RoleArn: testRoleArn1},
+		{Region: "eu-west-1", RoleArn: testRoleArn1},
+		{Region: "us-east-1", RoleArn: testRoleArn2},
+	}
+	if err := validatePublishTargets("templates-{account}-{region}", valid); err != nil {
+		t.Error("expecting valid targets", err)
+	}
+
+	tests := map[string][]publishTarget{
+		"shared bucket":        {{Region: "us-east-1"}, {Region: "eu-west-1"}},
+		"account without role": {{Region: "us-east-1"}},
+		"duplicate":            {{Region: "us-east-1", RoleArn: testRoleArn1}, {Region: "us-east-1", RoleArn: testRoleArn1}},
+		"invalid role":         {{Region: "us-east-1", RoleArn: "publisher"}},
+	}
+	buckets := map[string]string{"account without role": "templates-{account}", "duplicate": "templates-{region}"}
+	for name, targets := range tests {
+		bucket := buckets[name]
+		if bucket == "" {
+			bucket = "templates"
+		}
+		if err := validatePublishTargets(bucket, targets); !errors.Is(err, errPublishConfig) {
+			t.Errorf("%s: expecting config error, got %v", name, err)
+		}
+	}
+}
+
+// publishes a template to two regions, each with its own fake
+func internalPublishToTargets(t *testing.T, fakes map[string]*fakeProton, allowDrift bool) ([]*targetResult, error) {
+	t.Helper()
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{"schema/schema.yaml": "schema:\n"})
+	config := &protonConfigData{Name: "my_env", Type: "environment", PublishBucket: "templates-{region}"}
+	targets := []publishTarget{{Region: "us-east-1"}, {Region: "eu-west-1"}}
+	clients := func(target publishTarget) (ProtonAPI, S3API) {
+		return fakes[target.Region], newFakeS3()
+	}
+	opts := publishOptions{timeout: time.Minute, allowDrift: allowDrift}
+	return publishToTargets(context.Background(), clients, config, dir, targets, opts)
+}
+
+func TestPublishToTargets(t *testing.T) {
+	fakes := map[string]*fakeProton{"us-east-1": newFakeProton(), "eu-west-1": newFakeProton()}
+	results, err := internalPublishToTargets(t, fakes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := combineTargetResults(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.version() != "1.0" {
+		t.Error("expecting 1.0, got", result.version())
+	}
+	if results[1].bucket != "templates-eu-west-1" {
+		t.Error("expecting region-local bucket, got", results[1].bucket)
+	}
+	for region, fake := range fakes {
+		if v, _ := fake.getVersion("environment", "my_env", "1", "0"); v == nil || v.status != types.TemplateVersionStatusPublished {
+			t.Errorf("%s: expecting published version, got %v", region, v)
+		}
+	}
+}
+
+func TestPublishToTargetsDrift(t *testing.T) {
+	fakes := map[string]*fakeProton{"us-east-1": newFakeProton(), "eu-west-1": newFakeProton()}
+	fakes["eu-west-1"].addTemplate("environment", "my_env")
+	fakes["eu-west-1"].addVersion("environment", "my_env", "1", "0", types.TemplateVersionStatusPublished)
+
+	//refuse to publish versions that would differ
+	if _, err := internalPublishToTargets(t, fakes, false); !errors.Is(err, errVersionDrift) {
+		t.Fatal("expecting drift error, got", err)
+	}
+	if len(fakes["us-east-1"].templates) != 0 {
+		t.Error("expecting nothing to be published")
+	}
+
+	//publish anyway and report the drift
+	results, err := internalPublishToTargets(t, fakes, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := combineTargetResults(results); !errors.Is(err, errVersionDrift) {
+		t.Error("expecting drift to be reported, got", err)
+	}
+}
@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func TestReadProtonYAMLFile(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml": `name: my_template
+type: service
+displayName: My Template
+description: This is my template
+publishBucket: my-s3-bucket
+compatibleEnvironments:
+  - env1:3
+tags:
+  cost-center: "1234"
+encryptionKey: arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab
+versionDescription: adds a new input
+`,
+	})
+
+	result, err := readProtonYAMLFile(filepath.Join(dir, "proton.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tags["cost-center"] != "1234" || result.VersionDescription != "adds a new input" ||
+		!strings.HasSuffix(result.EncryptionKey, "1234567890ab") {
+		t.Error("unexpected config", result)
+	}
+
+	//service pipelines default to customer managed
+	if result.provisioning() != types.ProvisioningCustomerManaged {
+		t.Error("expecting customer managed pipeline provisioning, got", result.provisioning())
+	}
+}
+
+func TestProtonConfigValidate(t *testing.T) {
+
+	valid := protonConfigData{
+		Name: "my_template",
+		Type: "environment",
+	}
+	if err := valid.validate(); err != nil {
+		t.Error("expecting valid config", err)
+	}
+
+	invalid := protonConfigData{
+		Name:                   "my template",
+		Type:                   "pipeline",
+		CompatibleEnvironments: []string{"env1"},
+		Tags:                   map[string]string{"aws:foo": "bar", bundleHashTagKey: "x"},
+		EncryptionKey:          "alias/my-key",
+		Provisioning:           "SELF",
+		PipelineProvisioning:   "SELF",
+		MajorVersion:           "v2",
+	}
+	err := invalid.validate()
+	if err == nil {
+		t.Fatal("expecting invalid config")
+	}
+	t.Log(err)
+
+	expected := []string{
+		"name:",
+		"type:",
+		"compatibleEnvironments:",
+		"reserved aws: prefix",
+		"reserved by protonizer",
+		"encryptionKey:",
+		"provisioning: only supported for environment templates",
+		"pipelineProvisioning: only supported for service templates",
+		"majorVersion:",
+	}
+	for _, e := range expected {
+		if !strings.Contains(err.Error(), e) {
+			t.Error("expecting error to contain", e)
+		}
+	}
+}
+
+func TestProtonConfigProvisioning(t *testing.T) {
+	cases := []struct {
+		config   protonConfigData
+		expected types.Provisioning
+	}{
+		{protonConfigData{Type: "environment"}, ""},
+		{protonConfigData{Type: "environment", Provisioning: provisioningCustomerManaged}, types.ProvisioningCustomerManaged},
+		{protonConfigData{Type: "service"}, types.ProvisioningCustomerManaged},
+		{protonConfigData{Type: "service", PipelineProvisioning: provisioningProtonManaged}, ""},
+	}
+	for _, c := range cases {
+		if result := c.config.provisioning(); result != c.expected {
+			t.Errorf("expecting %q, got %q", c.expected, result)
+		}
+	}
+}
+
+// plans and executes a publish against a fake proton api
+func internalPublish(t *testing.T, protonClient *fakeProton, config *protonConfigData, bundleHash string, draft bool) (*publishPlan, string, error) {
+	t.Helper()
+	ctx := context.Background()
+	plan, err := planPublish(ctx, protonClient, config, "bundle.tar.gz", bundleHash, publishOptions{region: "us-east-1", draft: draft})
+	if err != nil {
+		t.Fatal(err)
+	}
+	minor, err := executePublishPlan(ctx, protonClient, plan, time.Minute)
+	return plan, minor, err
+}
+
+func TestExecutePublishPlanEnvironment(t *testing.T) {
+	protonClient := newFakeProton()
+	config := &protonConfigData{Name: "my_env", Type: "environment", PublishBucket: "my-bucket"}
+
+	_, minor, err := internalPublish(t, protonClient, config, "abc123", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minor != "0" {
+		t.Error("expecting minor version 0, got", minor)
+	}
+	v, _ := protonClient.getVersion("environment", "my_env", "1", "0")
+	if v == nil || v.status != types.TemplateVersionStatusPublished {
+		t.Fatal("expecting published version, got", v)
+	}
+	if tags := protonClient.tags[v.arn]; tags[bundleHashTagKey] != "abc123" {
+		t.Error("expecting bundle hash tag, got", tags)
+	}
+	if tags := protonClient.tags[v.arn]; !strings.HasPrefix(tags[bundleSourceTagKey], "s3://") {
+		t.Error("expecting bundle source tag, got", tags)
+	}
+
+	//publishing a new bundle adds the next minor version and leaves the existing template as it is
+	protonClient.templates[fakeKey("environment", "my_env")].description = "set in the console"
+	config.Description = "from proton.yaml"
+	_, minor, err = internalPublish(t, protonClient, config, "def456", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minor != "1" {
+		t.Error("expecting minor version 1, got", minor)
+	}
+	if internalCalled(protonClient, "UpdateEnvironmentTemplate") {
+		t.Error("not expecting existing template to be updated", protonClient.calls)
+	}
+	if d := protonClient.templates[fakeKey("environment", "my_env")].description; d != "set in the console" {
+		t.Error("expecting the template description to be unchanged, got", d)
+	}
+}
+
+func TestExecutePublishPlanService(t *testing.T) {
+	protonClient := newFakeProton()
+	config := &protonConfigData{
+		Name:                   "my_svc",
+		Type:                   "service",
+		PublishBucket:          "my-bucket",
+		CompatibleEnvironments: []string{"my_env:1"},
+	}
+
+	_, minor, err := internalPublish(t, protonClient, config, "abc123", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := protonClient.getVersion("service", "my_svc", "1", minor)
+	if v == nil || v.status != types.TemplateVersionStatusPublished {
+		t.Fatal("expecting published version, got", v)
+	}
+	if len(v.compatible) != 1 || *v.compatible[0].TemplateName != "my_env" {
+		t.Error("unexpected compatible environments", v.compatible)
+	}
+	for _, call := range protonClient.calls {
+		if strings.Contains(call, "Environment") {
+			t.Error("unexpected environment template call for a service template:", call)
+		}
+	}
+}
+
+func TestExecutePublishPlanMajorVersion(t *testing.T) {
+	protonClient := newFakeProton()
+	protonClient.addTemplate("environment", "my_env")
+	protonClient.addVersion("environment", "my_env", "1", "4", types.TemplateVersionStatusPublished)
+	protonClient.addVersion("environment", "my_env", "2", "0", types.TemplateVersionStatusPublished)
+	config := &protonConfigData{Name: "my_env", Type: "environment", PublishBucket: "my-bucket", MajorVersion: "2"}
+
+	plan, minor, err := internalPublish(t, protonClient, config, "abc123", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.MajorVersion != "2" || minor != "1" {
+		t.Errorf("expecting 2.1 to be published, got %s.%s", plan.MajorVersion, minor)
+	}
+	if v, _ := protonClient.getVersion("environment", "my_env", "2", "1"); v == nil {
+		t.Error("expecting version 2.1")
+	}
+	if v, _ := protonClient.getVersion("environment", "my_env", "1", "5"); v != nil {
+		t.Error("not expecting a new version of major version 1")
+	}
+
+	//bundle keys are addressed by major version and content
+	if key := bundleKey(config, "abc123"); key != "environment/my_env/v2/abc123.tar.gz" {
+		t.Error("unexpected bundle key", key)
+	}
+	config.MajorVersion = ""
+	if key := bundleKey(config, "abc123"); key != "environment/my_env/v1/abc123.tar.gz" {
+		t.Error("unexpected bundle key", key)
+	}
+}
+
+func TestExecutePublishPlanDraft(t *testing.T) {
+	protonClient := newFakeProton()
+	config := &protonConfigData{Name: "my_env", Type: "environment", PublishBucket: "my-bucket"}
+
+	_, minor, err := internalPublish(t, protonClient, config, "abc123", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := protonClient.getVersion("environment", "my_env", "1", minor)
+	if v == nil || v.status != types.TemplateVersionStatusDraft {
+		t.Error("expecting draft version, got", v)
+	}
+	if internalCalled(protonClient, "UpdateEnvironmentTemplateVersion") {
+		t.Error("draft versions should not be updated")
+	}
+}
+
+func TestExecutePublishPlanRegistrationFailed(t *testing.T) {
+	protonClient := newFakeProton()
+	protonClient.registrationFailures["my_env"] = "invalid schema"
+	config := &protonConfigData{Name: "my_env", Type: "environment", PublishBucket: "my-bucket"}
+
+	_, _, err := internalPublish(t, protonClient, config, "abc123", false)
+	if !errors.Is(err, errRegistrationFailed) {
+		t.Fatal("expecting registration failure, got", err)
+	}
+	if !strings.Contains(err.Error(), "invalid schema") {
+		t.Error("expecting status message in error, got", err)
+	}
+
+	//the failed version is cleaned up
+	if n := len(protonClient.versions[fakeKey("environment", "my_env")]); n != 0 {
+		t.Errorf("expecting failed version to be deleted, %d remaining", n)
+	}
+}
+
+func TestPublishTemplateDirUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{"schema/schema.yaml": "schema:\n"})
+	protonClient := newFakeProton()
+	s3Client := newFakeS3()
+	config := &protonConfigData{Name: "my_env", Type: "environment", PublishBucket: "my-bucket"}
+	opts := publishOptions{region: "us-east-1", timeout: time.Minute}
+
+	publish := func(opts publishOptions) *publishResult {
+		t.Helper()
+		result, err := publishTemplateDir(context.Background(), protonClient, s3Client, config, dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if result := publish(opts); result.Unchanged || result.version() != "1.0" {
+		t.Fatal("expecting 1.0 to be published, got", result)
+	}
+
+	//publishing the same bundle again does nothing
+	if result := publish(opts); !result.Unchanged || result.version() != "1.0" {
+		t.Error("expecting 1.0 to be unchanged, got", result)
+	}
+	if n := len(protonClient.versions[fakeKey("environment", "my_env")]); n != 1 {
+		t.Errorf("expecting 1 version, got %d", n)
+	}
+
+	//changing the template publishes a new version
+	internalWriteFiles(t, dir, map[string]string{"schema/schema.yaml": "schema:\n  format: {}\n"})
+	if result := publish(opts); result.Unchanged || result.version() != "1.1" {
+		t.Error("expecting 1.1 to be published, got", result)
+	}
+
+	//force publishes a new version anyway
+	opts.force = true
+	if result := publish(opts); result.Unchanged || result.version() != "1.2" {
+		t.Error("expecting 1.2 to be published, got", result)
+	}
+}
+
+func TestPublishTemplateDirKeepsBundles(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{"schema/schema.yaml": "schema:\n", "infrastructure/main.tf": "# 1.0\n"})
+	protonClient := newFakeProton()
+	s3Client := newFakeS3()
+	config := &protonConfigData{Name: "my_env", Type: "environment", PublishBucket: "my-bucket"}
+	opts := publishOptions{region: "us-east-1", timeout: time.Minute}
+	if _, err := publishTemplateDir(context.Background(), protonClient, s3Client, config, dir, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	//a later draft, such as a smoke test's, doesn't replace the published version's bundle
+	internalWriteFiles(t, dir, map[string]string{"infrastructure/main.tf": "# draft\n"})
+	opts.draft = true
+	if _, err := publishTemplateDir(context.Background(), protonClient, s3Client, config, dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s3Client.objects); n != 2 {
+		t.Errorf("expecting a bundle for each version, got %d", n)
+	}
+
+	//so 1.0's infrastructure code can still be pulled
+	out := t.TempDir()
+	result, err := pullTemplate(context.Background(), protonClient, s3Client, "my_env", "1.0", out, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.source == "" {
+		t.Fatalf("expecting 1.0's bundle to be pulled, got %+v", result)
+	}
+	b, err := os.ReadFile(filepath.Join(result.dir, "infrastructure", "main.tf"))
+	if err != nil || string(b) != "# 1.0\n" {
+		t.Errorf("expecting 1.0's main.tf, got %q %v", b, err)
+	}
+}
+
+func TestUploadBundle(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{"bundle.tar.gz": "bundle"})
+
+	s3Client := newFakeS3()
+	err := uploadBundle(context.Background(), s3Client, "my-bucket", "bundle.tar.gz", filepath.Join(dir, "bundle.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(s3Client.objects["my-bucket/bundle.tar.gz"]) != "bundle" {
+		t.Error("unexpected uploaded objects", s3Client.objects)
+	}
+
+	if err := uploadBundle(context.Background(), s3Client, "my-bucket", "x", filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expecting missing bundle error, got", err)
+	}
+}
+
+// returns true if a fake api was called
+func internalCalled(protonClient *fakeProton, call string) bool {
+	for _, c := range protonClient.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,342 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	flagPullOutDir string
+	flagPullSource string
+	flagPullForce  bool
+)
+
+var pullCmd = &cobra.Command{
+	Use:   "pull <template>[:major.minor]",
+	Short: "Pulls a template version from proton into a local template directory",
+	Long: `Pulls a template version from proton into a local <name>/v<major> template directory,
+writing its proton.yaml and schema/schema.yaml.
+
+If the version was published by protonizer and its bundle is still in S3,
+the bundle is downloaded and unpacked so that the infrastructure code is pulled too.
+Bundles are only used if they match the SHA-256 recorded when the version was published.
+Use --source to pull the infrastructure code of versions published by other tools.
+
+Defaults to the template's recommended version, or its latest version
+if no version has been published.`,
+	Args: cobra.ExactArgs(1),
+	Run:  doPull,
+	Example: `
+# Pull the recommended version of a template into ./my_template/v1
+protonizer pull my_template
+
+# Pull a specific version into another directory
+protonizer pull my_template:2.3 --out templates
+
+# Pull a version created in the console, with its source bundle
+protonizer pull my_template:1.0 --source s3://my-bucket/my_template.tar.gz`,
+}
+
+// the outcome of pulling a template version
+type pullResult struct {
+	dir          string
+	majorVersion string
+	minorVersion string
+
+	//the bundle the infrastructure code was unpacked from, if any
+	source string
+
+	//why the infrastructure code wasn't pulled
+	skipped string
+}
+
+func init() {
+	pullCmd.Flags().StringVarP(&flagPullOutDir, "out", "o", ".",
+		"The directory to create the template directory in")
+	pullCmd.Flags().StringVar(&flagPullSource, "source", "",
+		"The S3 location of the version's bundle, for example s3://bucket/key. Defaults to where protonizer published it")
+	pullCmd.Flags().BoolVar(&flagPullForce, "force", false,
+		"Overwrite an existing template directory")
+	rootCmd.AddCommand(pullCmd)
+}
+
+func doPull(cmd *cobra.Command, args []string) {
+	name, version, _ := strings.Cut(args[0], ":")
+	if flagPullSource != "" {
+		if _, _, err := parseS3URL(flagPullSource); err != nil {
+			errorExit(err)
+		}
+	}
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+
+	result, err := pullTemplate(ctx, newProtonClient(cfg), newS3Client(cfg),
+		name, version, flagPullOutDir, flagPullSource, flagPullForce)
+	if err != nil {
+		if errors.Is(err, errTemplateNotFound) || errors.Is(err, errPullConfig) {
+			errorExit(err)
+		}
+		handleError("pulling template", err)
+	}
+
+	fmt.Printf("pulled %s:%s.%s to %s \n", name, result.majorVersion, result.minorVersion, result.dir)
+	if result.source != "" {
+		fmt.Println("infrastructure code unpacked from", result.source)
+	} else {
+		fmt.Println("infrastructure code not pulled:", result.skipped)
+	}
+}
+
+// returned when a template version can't be pulled as requested
+var errPullConfig = errors.New("unable to pull template")
+
+// pulls a template version into outDir/<name>/v<major>. the version
+// defaults to the recommended version, or the latest version
+func pullTemplate(ctx context.Context, protonClient ProtonAPI, s3Client S3API,
+	name, version, outDir, source string, force bool) (*pullResult, error) {
+
+	template, kind, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var major, minor string
+	if version != "" {
+		major, minor, err = parseTemplateVersion(version)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPullConfig, err)
+		}
+	}
+	v, err := resolveTemplateVersion(ctx, protonClient, template, kind, major, minor)
+	if err != nil {
+		if errors.Is(err, errTemplateVersionNotFound) {
+			return nil, fmt.Errorf("%w: %v", errPullConfig, err)
+		}
+		return nil, err
+	}
+	major, minor = v.MajorVersion, v.MinorVersion
+	tags, err := listTags(ctx, protonClient, v.Arn)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(outDir, name, "v"+major)
+	if _, err := os.Stat(filepath.Join(dir, "proton.yaml")); err == nil && !force {
+		return nil, fmt.Errorf("%w: %s already exists. use --force to overwrite it", errPullConfig, dir)
+	}
+
+	result := &pullResult{dir: dir, majorVersion: major, minorVersion: minor}
+	if source == "" {
+		source = tags[bundleSourceTagKey]
+	}
+	var bucket string
+	if source != "" {
+		bucket, _, err = parseS3URL(source)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPullConfig, err)
+		}
+	}
+
+	//write the metadata that proton knows about
+	protonConfig := pulledProtonConfig(template, v, tags, bucket)
+	config, err := yaml.Marshal(protonConfig)
+	if err != nil {
+		return nil, err
+	}
+	contents := map[string][]byte{
+		"proton.yaml":        config,
+		"schema/schema.yaml": []byte(v.Schema),
+	}
+	for file, content := range contents {
+		p := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return nil, err
+		}
+		debug("writing", p)
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	//unpack the infrastructure code from the bundle, if it's still available
+	if source == "" {
+		result.skipped = "the version has no bundle source. use --source to specify one"
+		return result, nil
+	}
+	bundle, skipped, err := downloadBundle(ctx, s3Client, source, tags[bundleHashTagKey])
+	if err != nil {
+		return nil, err
+	}
+	if skipped != "" {
+		result.skipped = skipped
+		return result, nil
+	}
+	if err := extractBundle(bundle, dir); err != nil {
+		return nil, fmt.Errorf("unpacking %s: %w", source, err)
+	}
+	result.source = source
+	return result, nil
+}
+
+// returns proton.yaml settings for a template version
+func pulledProtonConfig(template *templateInfo, v *templateVersionInfo, tags map[string]string, bucket string) *protonConfigData {
+	c := &protonConfigData{
+		Name:                   template.Name,
+		Type:                   template.Type,
+		DisplayName:            template.DisplayName,
+		Description:            template.Description,
+		PublishBucket:          bucket,
+		CompatibleEnvironments: v.CompatibleEnvironments,
+		EncryptionKey:          template.EncryptionKey,
+	}
+	if v.MajorVersion != "1" {
+		c.MajorVersion = v.MajorVersion
+	}
+	if v.Description != defaultVersionDescription {
+		c.VersionDescription = v.Description
+	}
+
+	//reverse the defaults applied by protonConfigData.provisioning()
+	switch {
+	case template.Type == "environment" && template.Provisioning == types.ProvisioningCustomerManaged:
+		c.Provisioning = provisioningCustomerManaged
+	case template.Type == "service" && template.Provisioning == "":
+		c.PipelineProvisioning = provisioningProtonManaged
+	}
+
+	//user tags, without those managed by aws or protonizer
+	for k, val := range tags {
+		if strings.HasPrefix(strings.ToLower(k), "aws:") || strings.HasPrefix(k, "protonizer-") {
+			continue
+		}
+		if c.Tags == nil {
+			c.Tags = map[string]string{}
+		}
+		c.Tags[k] = val
+	}
+	return c
+}
+
+// downloads a bundle from s3. if the version recorded a bundle hash, bundles
+// that don't match it are skipped, as the key has since been published over.
+// returns the reason a bundle was skipped
+func downloadBundle(ctx context.Context, s3Client S3API, source, expectedHash string) ([]byte, string, error) {
+	bucket, key, err := parseS3URL(source)
+	if err != nil {
+		return nil, "", err
+	}
+	m := "s3.GetObject()"
+	debug(m, source)
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, source + " no longer exists", nil
+		}
+		return nil, "", fmt.Errorf("%s: %w", m, err)
+	}
+	defer out.Body.Close()
+	bundle, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", m, err)
+	}
+
+	hash := sha256.Sum256(bundle)
+	if expectedHash != "" && hex.EncodeToString(hash[:]) != expectedHash {
+		return nil, source + " has been replaced by a different bundle since this version was published", nil
+	}
+	return bundle, "", nil
+}
+
+// unpacks a tar.gz bundle into a directory. only files and directories
+// are unpacked, and entries can't be written outside of the directory
+func extractBundle(bundle []byte, dir string) error {
+	gr, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("bundle entry %s is outside of the template directory", header.Name)
+		}
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			mode := os.FileMode(0644)
+			if header.Mode&0111 != 0 {
+				mode = 0755
+			}
+			debug("unpacking", p)
+			f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			debug("skipping bundle entry", header.Name)
+		}
+	}
+}
+
+// returns an s3 url for a bucket and key
+func s3URL(bucket, key string) string {
+	return "s3://" + bucket + "/" + key
+}
+
+// parses an s3 url in the format s3://bucket/key
+func parseS3URL(u string) (string, string, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(u, "s3://"), "/")
+	if !strings.HasPrefix(u, "s3://") || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("source: %q must use the format: s3://bucket/key", u)
+	}
+	return bucket, key, nil
+}
+
+// returns true if an s3 object or its bucket doesn't exist
+func isS3NotFound(err error) bool {
+	var nsk *s3types.NoSuchKey
+	var nsb *s3types.NoSuchBucket
+	var nf *s3types.NotFound
+	return errors.As(err, &nsk) || errors.As(err, &nsb) || errors.As(err, &nf)
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"gopkg.in/yaml.v3"
+)
+
+// adds a published service template version whose bundle is in fake s3
+func internalAddPulledTemplate(t *testing.T, protonClient *fakeProton, s3Client *fakeS3) {
+	src := t.TempDir()
+	internalWriteFiles(t, src, map[string]string{
+		"proton.yaml":                     "name: api\ntype: service\npublishBucket: my-bucket\n",
+		"schema/schema.yaml":              "schema: {}\n",
+		"instance_infrastructure/main.tf": "resource \"null_resource\" \"x\" {}\n",
+	})
+	bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	hash, err := createTarGZFile(src, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3Client.objects["my-bucket/service/api/bundle.tar.gz"] = b
+
+	template := protonClient.addTemplate("service", "api")
+	template.displayName = "API"
+	template.recommended = "1.2"
+	v := protonClient.addVersion("service", "api", "1", "2", types.TemplateVersionStatusPublished)
+	v.schema = "schema: {}\n"
+	v.description = defaultVersionDescription
+	v.compatible = []types.CompatibleEnvironmentTemplate{
+		{TemplateName: aws.String("vpc"), MajorVersion: aws.String("1")},
+	}
+	protonClient.tag(v.arn, protonTags(map[string]string{
+		"team":             "platform",
+		bundleHashTagKey:   hash,
+		bundleSourceTagKey: "s3://my-bucket/service/api/bundle.tar.gz",
+	}))
+}
+
+func TestPullTemplate(t *testing.T) {
+	protonClient, s3Client := newFakeProton(), newFakeS3()
+	internalAddPulledTemplate(t, protonClient, s3Client)
+	out := t.TempDir()
+
+	result, err := pullTemplate(context.Background(), protonClient, s3Client, "api", "", out, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.minorVersion != "2" || result.source == "" {
+		t.Errorf("expecting 1.2 to be pulled with its bundle, got %+v", result)
+	}
+	dir := filepath.Join(out, "api", "v1")
+	for _, file := range []string{"proton.yaml", "schema/schema.yaml", "instance_infrastructure/main.tf"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("expecting %s to be pulled: %v", file, err)
+		}
+	}
+
+	//pulling again needs --force
+	_, err = pullTemplate(context.Background(), protonClient, s3Client, "api", "1.2", out, "", false)
+	if !errors.Is(err, errPullConfig) {
+		t.Errorf("expecting an error pulling over an existing template, got %v", err)
+	}
+}
+
+func TestPullTemplateMajorVersion(t *testing.T) {
+	protonClient, s3Client := newFakeProton(), newFakeS3()
+	internalAddPulledTemplate(t, protonClient, s3Client)
+	v := protonClient.addVersion("service", "api", "2", "0", types.TemplateVersionStatusPublished)
+	v.schema = "schema: {}\n"
+	out := t.TempDir()
+
+	result, err := pullTemplate(context.Background(), protonClient, s3Client, "api", "2.0", out, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.dir != filepath.Join(out, "api", "v2") {
+		t.Errorf("expecting 2.0 to be pulled to api/v2, got %s", result.dir)
+	}
+
+	//proton.yaml publishes back to the same major version
+	config, err := readProtonYAMLFile(filepath.Join(result.dir, "proton.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.majorVersion() != "2" {
+		t.Errorf("expecting major version 2, got %q", config.majorVersion())
+	}
+	plan, err := planPublish(context.Background(), protonClient, config, bundleKey(config, "abc123"), "abc123", publishOptions{region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.MajorVersion != "2" || plan.MinorVersion != "1" {
+		t.Errorf("expecting to publish 2.1, got %s.%s", plan.MajorVersion, plan.MinorVersion)
+	}
+}
+
+func TestPullTemplateReplacedBundle(t *testing.T) {
+	protonClient, s3Client := newFakeProton(), newFakeS3()
+	internalAddPulledTemplate(t, protonClient, s3Client)
+
+	//a later publish overwrote the bundle
+	s3Client.objects["my-bucket/service/api/bundle.tar.gz"] = []byte("newer bundle")
+
+	out := t.TempDir()
+	result, err := pullTemplate(context.Background(), protonClient, s3Client, "api", "1.2", out, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.source != "" || !strings.Contains(result.skipped, "replaced") {
+		t.Errorf("expecting the bundle to be skipped, got %+v", result)
+	}
+
+	//proton.yaml is rebuilt from proton's metadata
+	b, err := os.ReadFile(filepath.Join(out, "api", "v1", "proton.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config protonConfigData
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.DisplayName != "API" || config.PublishBucket != "my-bucket" || config.VersionDescription != "" {
+		t.Errorf("unexpected proton.yaml:\n%s", b)
+	}
+	if len(config.CompatibleEnvironments) != 1 || config.CompatibleEnvironments[0] != "vpc:1" {
+		t.Errorf("expecting compatible environment vpc:1, got %v", config.CompatibleEnvironments)
+	}
+	if len(config.Tags) != 1 || config.Tags["team"] != "platform" {
+		t.Errorf("expecting only user tags, got %v", config.Tags)
+	}
+	if config.PipelineProvisioning != provisioningProtonManaged {
+		t.Errorf("expecting a proton managed pipeline, got %q", config.PipelineProvisioning)
+	}
+	if err := config.validate(); err != nil {
+		t.Errorf("expecting a valid proton.yaml, got %v", err)
+	}
+}
+
+func TestExtractBundleOutsideDir(t *testing.T) {
+	var b bytes.Buffer
+	gw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gw)
+	content := "oops"
+	tw.WriteHeader(&tar.Header{Name: "../escape.txt", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(content))
+	tw.Close()
+	gw.Close()
+
+	dir := filepath.Join(t.TempDir(), "template")
+	if err := extractBundle(b.Bytes(), dir); err == nil {
+		t.Error("expecting an error for an entry outside of the template directory")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.txt")); err == nil {
+		t.Error("expecting escape.txt not to be written")
+	}
+}
+
+func TestParseS3URL(t *testing.T) {
+	bucket, key, err := parseS3URL("s3://my-bucket/service/api/bundle.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket != "my-bucket" || key != "service/api/bundle.tar.gz" {
+		t.Errorf("unexpected bucket %s and key %s", bucket, key)
+	}
+	for _, u := range []string{"my-bucket/key", "s3://my-bucket", "s3://my-bucket/", "s3:///key"} {
+		if _, _, err := parseS3URL(u); err == nil {
+			t.Error("expecting an error for", u)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nikolalohinski/gonja"
+	gonjaconfig "github.com/nikolalohinski/gonja/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	flagRenderSpec                    string
+	flagRenderName                    string
+	flagRenderOut                     string
+	flagRenderEnvOutputs              string
+	flagRenderAccountID               string
+	flagRenderRepositoryConnectionArn string
+	flagRenderRepositoryID            string
+	flagRenderBranch                  string
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render <template dir>",
+	Short: "Renders a template's jinja cloudformation locally from a spec file",
+	Long: `Renders a template's jinja cloudformation locally from a spec file, without calling AWS.
+
+The jinja is evaluated against the same environment, service, service_instance and pipeline
+values proton provides, with schema defaults applied to inputs that aren't in the spec.
+Referencing anything that isn't defined is an error.
+
+Environment templates are written to <out>/<file>. Service templates are rendered for each
+instance in the spec, to <out>/<instance>/<file>, and their pipeline to <out>/pipeline/<file>.
+
+The rendered cloudformation is then checked:
+
+- it parses as yaml and only has known top-level sections
+- every resource has a Type in the format AWS::Service::Resource, or is a Custom:: resource.
+  types from services protonizer doesn't know about are warnings, which don't fail render
+- Ref and GetAtt targets are parameters, resources or pseudo parameters
+- every output has a Value
+
+Without --env-outputs, environment.outputs references render as <environment.outputs.name>.
+Only templates with the jinja rendering engine are supported. Use run for codebuild templates.`,
+	Args: cobra.ExactArgs(1),
+	Run:  doRender,
+	Example: `
+# Render an environment template
+protonizer render my_env_template/v1 --spec env-spec.yaml --name dev
+
+# Render a service template with the outputs of the environment it's deployed to
+protonizer render my_svc_template/v1 --spec svc-spec.yaml --name api --env-outputs outputs.json`,
+}
+
+// returned when a template doesn't use the jinja rendering engine or its jinja doesn't render
+var errRenderFailed = errors.New("unable to render template")
+
+// the infrastructure templates of a manifest.yaml
+type templateManifest struct {
+	Infrastructure struct {
+		Templates []struct {
+			File             string            `yaml:"file"`
+			RenderingEngine  string            `yaml:"rendering_engine"`
+			TemplateLanguage string            `yaml:"template_language"`
+			Settings         codeBuildSettings `yaml:"settings"`
+		} `yaml:"templates"`
+	} `yaml:"infrastructure"`
+}
+
+// a rendered template file
+type renderedTemplate struct {
+	//the jinja file, relative to the template directory
+	Source string
+	//where the result is written, relative to the output directory
+	Output  string
+	Content []byte
+}
+
+// the top-level sections of a cloudformation template
+var cloudFormationSections = []string{
+	"AWSTemplateFormatVersion", "Description", "Metadata", "Parameters", "Rules",
+	"Mappings", "Conditions", "Transform", "Resources", "Outputs",
+}
+
+// matches cloudformation resource types
+var cloudFormationTypePattern = regexp.MustCompile(`^AWS::([A-Za-z0-9]+)::[A-Za-z0-9]+$`)
+
+// well known aws service namespaces of cloudformation resource types. the list isn't
+// complete, so types from other services are only warned about
+var cloudFormationServices = []string{
+	"ACMPCA", "APS", "AccessAnalyzer", "AmazonMQ", "Amplify", "ApiGateway", "ApiGatewayV2",
+	"AppConfig", "AppFlow", "AppIntegrations", "AppMesh", "AppRunner", "AppStream", "AppSync",
+	"ApplicationAutoScaling", "ApplicationInsights", "Athena", "AuditManager", "AutoScaling",
+	"Backup", "Batch", "Budgets", "CE", "CertificateManager", "Cloud9", "CloudFormation",
+	"CloudFront", "CloudTrail", "CloudWatch", "CodeArtifact", "CodeBuild", "CodeCommit",
+	"CodeDeploy", "CodeGuruProfiler", "CodePipeline", "CodeStarConnections",
+	"CodeStarNotifications", "Cognito", "Config", "Connect", "DAX", "DLM", "DMS", "DataBrew",
+	"DataSync", "Detective", "DocDB", "DynamoDB", "EC2", "ECR", "ECS", "EFS", "EKS", "EMR",
+	"EMRServerless", "ElastiCache", "ElasticBeanstalk", "ElasticLoadBalancing",
+	"ElasticLoadBalancingV2", "Elasticsearch", "EventSchemas", "Events", "FIS", "FSx",
+	"FinSpace", "Firehose", "GameLift", "GlobalAccelerator", "Glue", "GuardDuty", "IAM",
+	"IdentityStore", "ImageBuilder", "Inspector", "InspectorV2", "IoT", "KMS", "Kendra",
+	"Kinesis", "KinesisAnalytics", "KinesisAnalyticsV2", "KinesisFirehose", "LakeFormation",
+	"Lambda", "Logs", "MSK", "MWAA", "Macie", "MediaConvert", "MediaLive", "MediaPackage",
+	"MediaStore", "MemoryDB", "Neptune", "NetworkFirewall", "NetworkManager", "OpenSearchService",
+	"Organizations", "Pipes", "Proton", "QuickSight", "RAM", "RDS", "RUM", "Redshift",
+	"RedshiftServerless", "ResourceGroups", "RoboMaker", "Route53", "Route53Resolver", "S3",
+	"S3ObjectLambda", "S3Outposts", "SDB", "SES", "SNS", "SQS", "SSM", "SSMContacts",
+	"SSMIncidents", "SSO", "SageMaker", "Scheduler", "SecretsManager", "SecurityHub",
+	"ServiceCatalog", "ServiceCatalogAppRegistry", "ServiceDiscovery", "Serverless", "Shield",
+	"Signer", "StepFunctions", "Synthetics", "Timestream", "Transfer", "VpcLattice", "WAF",
+	"WAFRegional", "WAFv2", "WorkSpaces", "XRay",
+}
+
+func init() {
+	renderCmd.Flags().StringVarP(&flagRenderSpec, "spec", "s", "",
+		"The environment or service spec file to render the template with (required)")
+	renderCmd.MarkFlagRequired("spec")
+	renderCmd.Flags().StringVarP(&flagRenderName, "name", "n", "test",
+		"The name of the environment or service")
+	renderCmd.Flags().StringVarP(&flagRenderOut, "out", "o", "rendered",
+		"The directory to write the rendered templates to")
+	renderCmd.Flags().StringVar(&flagRenderEnvOutputs, "env-outputs", "",
+		"A json or yaml file of the outputs of the environment service instances are deployed to")
+	renderCmd.Flags().StringVar(&flagRenderAccountID, "account-id", "123456789012",
+		"The account id of the environment")
+	renderCmd.Flags().StringVar(&flagRenderRepositoryConnectionArn, "repository-connection-arn", "",
+		"The CodeStar connection of the service's source repository")
+	renderCmd.Flags().StringVar(&flagRenderRepositoryID, "repository-id", "",
+		"The service's source repository, in the format owner/repo")
+	renderCmd.Flags().StringVar(&flagRenderBranch, "branch", "",
+		"The branch of the service's source repository")
+	rootCmd.AddCommand(renderCmd)
+}
+
+func doRender(cmd *cobra.Command, args []string) {
+	dir := args[0]
+	config, err := readProtonYAMLFile(filepath.Join(dir, "proton.yaml"))
+	handleError("reading proton.yaml", err)
+	schema, err := readLocalSchema(dir)
+	handleError("reading schema", err)
+	spec, err := os.ReadFile(flagRenderSpec)
+	handleError("reading spec", err)
+	validate := validateServiceSpec
+	if config.Type == "environment" {
+		validate = validateEnvironmentSpec
+	}
+	if err := validate(schema, spec); err != nil {
+		errorExit(fmt.Sprintf("%s is not valid:\n%v", flagRenderSpec, err))
+	}
+
+	opts := protonContextOptions{
+		name:                    flagRenderName,
+		accountID:               flagRenderAccountID,
+		repositoryConnectionArn: flagRenderRepositoryConnectionArn,
+		repositoryID:            flagRenderRepositoryID,
+		branch:                  flagRenderBranch,
+	}
+	if flagRenderEnvOutputs != "" {
+		opts.environmentOutputs, err = readEnvironmentOutputs(flagRenderEnvOutputs)
+		handleError("reading environment outputs", err)
+	}
+
+	rendered, err := renderTemplate(dir, config.Type, schema, spec, opts)
+	if errors.Is(err, errRenderFailed) {
+		errorExit(err)
+	}
+	handleError("rendering template", err)
+
+	problems := 0
+	for _, r := range rendered {
+		out := filepath.Join(flagRenderOut, filepath.FromSlash(r.Output))
+		handleError("creating output directory", os.MkdirAll(filepath.Dir(out), 0755))
+		handleError("writing rendered template", os.WriteFile(out, r.Content, 0644))
+		fmt.Println(out)
+		for _, f := range validateCloudFormation(out, r.Content) {
+			fmt.Println(f)
+			if f.Severity == "" {
+				problems++
+			}
+		}
+	}
+	if problems > 0 {
+		errorExit(fmt.Sprintf("\n%d problem(s) found", problems))
+	}
+}
+
+// renders the jinja templates of a template directory for a spec
+func renderTemplate(dir, templateType string, schema *templateSchema, spec []byte, opts protonContextOptions) ([]renderedTemplate, error) {
+	m := "renderTemplate()"
+	debug(m)
+
+	type target struct {
+		infraDir string
+		outDir   string
+		context  *protonContext
+	}
+	var targets []target
+	switch templateType {
+	case "environment":
+		c, err := environmentContext(schema, spec, opts)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{protonInfrastructureDirEnv, "", c})
+	case "service":
+		instances, err := serviceInstanceContexts(schema, spec, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range instances {
+			targets = append(targets, target{protonInfrastructureDirSvc, c.Name, c})
+		}
+		if _, err := os.Stat(filepath.Join(dir, "pipeline_infrastructure")); err == nil {
+			c, err := pipelineContext(schema, spec, opts)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				targets = append(targets, target{"pipeline_infrastructure", "pipeline", c})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: type %q is invalid. only environment and service are supported",
+			errRenderFailed, templateType)
+	}
+
+	var result []renderedTemplate
+	for _, t := range targets {
+		files, err := jinjaTemplateFiles(filepath.Join(dir, t.infraDir))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			source := t.infraDir + "/" + file
+			debugFmt("%s: rendering %s for %s", m, source, t.context.Name)
+			b, err := os.ReadFile(filepath.Join(dir, t.infraDir, file))
+			if err != nil {
+				return nil, err
+			}
+			if opts.environmentOutputs == nil {
+				addOutputPlaceholders(t.context, jinjaReferences(source, b))
+			}
+			content, err := renderJinja(b, t.context.Values)
+			if err != nil {
+				if t.outDir != "" {
+					source = fmt.Sprintf("%s (%s)", source, t.context.Name)
+				}
+				return nil, fmt.Errorf("%w: %s: %v", errRenderFailed, source, err)
+			}
+			result = append(result, renderedTemplate{
+				Source:  source,
+				Output:  strings.TrimPrefix(t.outDir+"/"+file, "/"),
+				Content: []byte(content),
+			})
+		}
+	}
+	return result, nil
+}
+
+// returns the template files of an infrastructure directory's manifest.yaml,
+// which must all use the jinja rendering engine
+func jinjaTemplateFiles(infraDir string) ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(infraDir, "manifest.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var manifest templateManifest
+	if err := yaml.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(infraDir, "manifest.yaml"), err)
+	}
+	var result []string
+	for _, t := range manifest.Infrastructure.Templates {
+		if t.RenderingEngine != "jinja" {
+			return nil, fmt.Errorf("%w: %s uses the %q rendering engine. only jinja is supported. use run for codebuild templates",
+				errRenderFailed, filepath.Join(infraDir, "manifest.yaml"), t.RenderingEngine)
+		}
+		result = append(result, t.File)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: %s has no templates",
+			errRenderFailed, filepath.Join(infraDir, "manifest.yaml"))
+	}
+	return result, nil
+}
+
+// sets environment outputs that a template references to placeholder values,
+// for rendering without the outputs of a real environment
+func addOutputPlaceholders(c *protonContext, refs []iacReference) {
+	environment, _ := c.Values["environment"].(map[string]interface{})
+	outputs, _ := environment["outputs"].(map[string]interface{})
+	if outputs == nil {
+		return
+	}
+	for _, r := range refs {
+		if r.Namespace == "environment" && r.Kind == "outputs" {
+			if _, ok := outputs[r.Name]; !ok {
+				outputs[r.Name] = "<" + r.String() + ">"
+			}
+		}
+	}
+}
+
+// evaluates a jinja template. undefined values are an error, as they are in proton
+func renderJinja(src []byte, values map[string]interface{}) (string, error) {
+	cfg := gonjaconfig.NewConfig()
+	cfg.StrictUndefined = true
+	tpl, err := gonja.NewEnvironment(cfg, gonja.DefaultLoader).FromBytes(src)
+	if err != nil {
+		return "", err
+	}
+	return tpl.Execute(values)
+}
+
+// checks that a rendered cloudformation template is valid yaml with known resource types
+// and that its references resolve, returning every problem found. resource types from
+// unknown services are warnings, with a severity set
+func validateCloudFormation(file string, b []byte) []lintFinding {
+	var findings []lintFinding
+	add := func(line int, format string, a ...interface{}) {
+		findings = append(findings, lintFinding{File: file, Line: line, Message: fmt.Sprintf(format, a...)})
+	}
+	warn := func(line int, format string, a ...interface{}) {
+		findings = append(findings, lintFinding{File: file, Line: line, Message: "warning: " + fmt.Sprintf(format, a...), Severity: "warning"})
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		add(yamlErrorLine(err), "%v", err)
+		return findings
+	}
+	root := contentRoot(&doc)
+	if root == nil || root.Kind != yaml.MappingNode {
+		add(1, "must be a yaml mapping")
+		return findings
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if key := root.Content[i]; !SliceContains(&cloudFormationSections, key.Value, false) {
+			add(key.Line, "%s: is not a cloudformation template section", key.Value)
+		}
+	}
+
+	//names that Ref can refer to
+	targets := map[string]bool{}
+	_, parameters := yamlMappingValue(root, "Parameters")
+	for _, k := range yamlMappingKeys(parameters) {
+		targets[k.Value] = true
+	}
+
+	resourcesKey, resources := yamlMappingValue(root, "Resources")
+	switch {
+	case resources == nil:
+		add(0, "Resources: is required")
+	case resources.Kind != yaml.MappingNode || len(resources.Content) == 0:
+		add(resourcesKey.Line, "Resources: must have at least one resource")
+	}
+	for _, k := range yamlMappingKeys(resources) {
+		targets[k.Value] = true
+	}
+	for i := 0; resources != nil && i+1 < len(resources.Content); i += 2 {
+		key, resource := resources.Content[i], resources.Content[i+1]
+		typeKey, typeValue := yamlMappingValue(resource, "Type")
+		switch {
+		case typeValue == nil || typeValue.Value == "":
+			add(key.Line, "Resources.%s.Type: is required", key.Value)
+		case strings.HasPrefix(typeValue.Value, "Custom::"):
+		default:
+			//the service list isn't complete, so an unknown service is only a warning
+			match := cloudFormationTypePattern.FindStringSubmatch(typeValue.Value)
+			if match == nil {
+				add(typeKey.Line, "Resources.%s.Type: %q must use the format AWS::Service::Resource", key.Value, typeValue.Value)
+			} else if !SliceContains(&cloudFormationServices, match[1], false) {
+				warn(typeKey.Line, "Resources.%s.Type: %q is not from a known AWS service. check its spelling", key.Value, typeValue.Value)
+			}
+		}
+	}
+
+	_, outputs := yamlMappingValue(root, "Outputs")
+	for i := 0; outputs != nil && i+1 < len(outputs.Content); i += 2 {
+		key := outputs.Content[i]
+		if _, value := yamlMappingValue(outputs.Content[i+1], "Value"); value == nil {
+			add(key.Line, "Outputs.%s.Value: is required", key.Value)
+		}
+	}
+
+	for _, r := range cloudFormationReferences(root) {
+		if !targets[r.Value] && !strings.HasPrefix(r.Value, "AWS::") {
+			add(r.Line, "%s: is not a parameter or resource", r.Value)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Line < findings[j].Line })
+	return findings
+}
+
+// returns the keys of a yaml mapping
+func yamlMappingKeys(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	var result []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		result = append(result, n.Content[i])
+	}
+	return result
+}
+
+// returns the logical ids referenced by Ref and GetAtt, in short or long form.
+// each returned node has the logical id as its value
+func cloudFormationReferences(n *yaml.Node) []*yaml.Node {
+	var result []*yaml.Node
+	getAtt := func(v *yaml.Node) {
+		switch v.Kind {
+		case yaml.ScalarNode:
+			id, _, _ := strings.Cut(v.Value, ".")
+			result = append(result, &yaml.Node{Value: id, Line: v.Line})
+		case yaml.SequenceNode:
+			if len(v.Content) > 0 && v.Content[0].Kind == yaml.ScalarNode {
+				result = append(result, v.Content[0])
+			}
+		}
+	}
+	switch {
+	case n.Tag == "!Ref" && n.Kind == yaml.ScalarNode:
+		return append(result, n)
+	case n.Tag == "!GetAtt":
+		getAtt(n)
+		return result
+	case n.Kind == yaml.MappingNode && len(n.Content) == 2:
+		switch key, value := n.Content[0], n.Content[1]; key.Value {
+		case "Ref":
+			if value.Kind == yaml.ScalarNode {
+				return append(result, value)
+			}
+		case "Fn::GetAtt":
+			getAtt(value)
+			return result
+		}
+	}
+	for _, c := range n.Content {
+		result = append(result, cloudFormationReferences(c)...)
+	}
+	return result
+}
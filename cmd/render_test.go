@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"schema/schema.yaml":           testEnvironmentSchema,
+		"infrastructure/manifest.yaml": testJinjaManifest,
+		"infrastructure/cloudformation.yaml": `Resources:
+  VPC:
+    Type: AWS::EC2::VPC
+    Properties:
+      CidrBlock: {{ environment.inputs.vpc_cidr }}
+      Tags:
+        - Key: Name
+          Value: {{ environment.name }}
+{% for i in range(environment.inputs.azs) %}
+  Subnet{{ i }}:
+    Type: AWS::EC2::Subnet
+    Properties:
+      VpcId: !Ref VPC
+{% endfor %}
+`,
+	})
+	schema, err := readLocalSchema(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := "proton: EnvironmentSpec\nspec:\n  azs: 2\n"
+	rendered, err := renderTemplate(dir, "environment", schema, []byte(spec), protonContextOptions{name: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rendered) != 1 || rendered[0].Output != "cloudformation.yaml" {
+		t.Fatalf("expecting cloudformation.yaml, got %v", rendered)
+	}
+	expected := `Resources:
+  VPC:
+    Type: AWS::EC2::VPC
+    Properties:
+      CidrBlock: 10.0.0.0/16
+      Tags:
+        - Key: Name
+          Value: dev
+
+  Subnet0:
+    Type: AWS::EC2::Subnet
+    Properties:
+      VpcId: !Ref VPC
+
+  Subnet1:
+    Type: AWS::EC2::Subnet
+    Properties:
+      VpcId: !Ref VPC
+
+`
+	if actual := string(rendered[0].Content); actual != expected {
+		t.Errorf("expecting:\n%s\ngot:\n%s", expected, actual)
+	}
+	if findings := validateCloudFormation("cloudformation.yaml", rendered[0].Content); len(findings) > 0 {
+		t.Errorf("expecting valid cloudformation, got %v", findings)
+	}
+}
+
+func TestRenderService(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"schema/schema.yaml":                    testServiceSchema,
+		"instance_infrastructure/manifest.yaml": testJinjaManifest,
+		"instance_infrastructure/cloudformation.yaml": `Resources:
+  Service:
+    Type: AWS::ECS::Service
+    Properties:
+      ServiceName: {{ service.name }}-{{ service_instance.name }}
+      Cluster: {{ environment.outputs.ClusterName }}
+      DesiredCount: {{ 2 if service_instance.inputs.size == "large" else 1 }}
+      Port: {{ service_instance.inputs.port }}
+`,
+		"pipeline_infrastructure/manifest.yaml": testJinjaManifest,
+		"pipeline_infrastructure/cloudformation.yaml": `Resources:
+  Project:
+    Type: AWS::CodeBuild::Project
+    Properties:
+      Description: {{ service_instances | map(attribute="name") | join(",") }} {{ pipeline.inputs.unit_tests }}
+`,
+	})
+	schema, err := readLocalSchema(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := `proton: ServiceSpec
+instances:
+  - name: dev
+    environment: dev-env
+    spec:
+      image: nginx
+  - name: prod
+    environment: prod-env
+    spec:
+      image: nginx
+      size: large
+      port: 8080
+`
+	rendered, err := renderTemplate(dir, "service", schema, []byte(spec), protonContextOptions{name: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, r := range rendered {
+		for _, line := range strings.Split(string(r.Content), "\n") {
+			if _, value, found := strings.Cut(line, ": "); found && !strings.Contains(line, "Type:") {
+				actual = append(actual, r.Output+": "+strings.TrimSpace(line[:strings.Index(line, ":")])+"="+value)
+			}
+		}
+	}
+	expected := []string{
+		"dev/cloudformation.yaml: ServiceName=api-dev",
+		"dev/cloudformation.yaml: Cluster=<environment.outputs.ClusterName>",
+		"dev/cloudformation.yaml: DesiredCount=1",
+		"dev/cloudformation.yaml: Port=80",
+		"prod/cloudformation.yaml: ServiceName=api-prod",
+		"prod/cloudformation.yaml: Cluster=<environment.outputs.ClusterName>",
+		"prod/cloudformation.yaml: DesiredCount=2",
+		"prod/cloudformation.yaml: Port=8080",
+		"pipeline/cloudformation.yaml: Description=dev,prod True",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+
+	//real environment outputs are used when they're given
+	opts := protonContextOptions{name: "api", environmentOutputs: map[string]interface{}{"ClusterName": "main"}}
+	rendered, err = renderTemplate(dir, "service", schema, []byte(spec), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered[0].Content), "Cluster: main\n") {
+		t.Errorf("expecting the ClusterName output, got:\n%s", rendered[0].Content)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"schema/schema.yaml":           testEnvironmentSchema,
+		"infrastructure/manifest.yaml": testJinjaManifest,
+		"infrastructure/cloudformation.yaml": `Resources:
+  VPC:
+    Type: AWS::EC2::VPC
+    Properties:
+      CidrBlock: {{ environment.inputs.cidr }}
+`,
+	})
+	schema, err := readLocalSchema(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//specs are validated against the schema
+	if _, err := renderTemplate(dir, "environment", schema, []byte("proton: EnvironmentSpec\nspec: {}\n"), protonContextOptions{}); err == nil {
+		t.Error("expecting an error for an invalid spec")
+	}
+
+	//undefined values are an error, as they are in proton
+	spec := []byte("proton: EnvironmentSpec\nspec:\n  azs: 2\n")
+	_, err = renderTemplate(dir, "environment", schema, spec, protonContextOptions{})
+	if !errors.Is(err, errRenderFailed) || !strings.Contains(err.Error(), "infrastructure/cloudformation.yaml") {
+		t.Errorf("expecting an undefined input error, got %v", err)
+	}
+
+	//only jinja is supported
+	internalWriteFiles(t, dir, map[string]string{
+		"infrastructure/manifest.yaml": "infrastructure:\n  templates:\n    - rendering_engine: codebuild\n",
+	})
+	_, err = renderTemplate(dir, "environment", schema, spec, protonContextOptions{})
+	if !errors.Is(err, errRenderFailed) {
+		t.Errorf("expecting an unsupported template error, got %v", err)
+	}
+}
+
+func TestValidateCloudFormation(t *testing.T) {
+	b := []byte(`AWSTemplateFormatVersion: "2010-09-09"
+Parameters:
+  Name:
+    Type: String
+Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: !Ref Name
+  Queue:
+    Type: AWS::SQS
+    Properties:
+      QueueName: !Sub ${AWS::StackName}-queue
+      RedrivePolicy:
+        deadLetterTargetArn: !GetAtt DeadLetters.Arn
+  Custom:
+    Type: Custom::Thing
+    Properties:
+      ServiceToken: {"Fn::GetAtt": [Bucket, Arn]}
+      Region: {Ref: "AWS::Region"}
+  Topic:
+    Type: AWS::Nope::Topic
+  Untyped:
+    Properties: {}
+Outputs:
+  BucketName:
+    Value: !Ref Bucket
+  Missing:
+    Description: no value
+Extra: true
+`)
+	var actual []string
+	for _, f := range validateCloudFormation("cloudformation.yaml", b) {
+		actual = append(actual, f.String())
+	}
+	expected := []string{
+		`cloudformation.yaml:11: Resources.Queue.Type: "AWS::SQS" must use the format AWS::Service::Resource`,
+		"cloudformation.yaml:15: DeadLetters: is not a parameter or resource",
+		`cloudformation.yaml:22: warning: Resources.Topic.Type: "AWS::Nope::Topic" is not from a known AWS service. check its spelling`,
+		"cloudformation.yaml:23: Resources.Untyped.Type: is required",
+		"cloudformation.yaml:28: Outputs.Missing.Value: is required",
+		"cloudformation.yaml:30: Extra: is not a cloudformation template section",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+
+	//valid types from services that aren't in the list are only warnings
+	b = []byte(`Resources:
+  App:
+    Type: AWS::Pinpoint::App
+  Table:
+    Type: AWS::Cassandra::Table
+`)
+	for _, f := range validateCloudFormation("cloudformation.yaml", b) {
+		if f.Severity != "warning" {
+			t.Errorf("expecting a warning, got %s", f)
+		}
+	}
+
+	for _, invalid := range []string{"Resources: [", "- a\n", "Description: x\n", "Resources: {}\n"} {
+		if len(validateCloudFormation("cloudformation.yaml", []byte(invalid))) == 0 {
+			t.Errorf("expecting problems with %q", invalid)
+		}
+	}
+}
+
+const testJinjaManifest = `infrastructure:
+  templates:
+    - file: cloudformation.yaml
+      rendering_engine: jinja
+      template_language: cloudformation
+`
@@ -0,0 +1,419 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	flagRunSpec                    string
+	flagRunEnvName                 string
+	flagRunName                    string
+	flagRunInstance                string
+	flagRunEnvOutputs              string
+	flagRunAccountID               string
+	flagRunRepositoryConnectionArn string
+	flagRunRepositoryID            string
+	flagRunBranch                  string
+	flagRunShell                   string
+	flagRunOutputs                 string
+	flagRunKeep                    bool
+)
+
+var runCmd = &cobra.Command{
+	Use:       "run provision|deprovision <template dir>",
+	Short:     "Runs a codebuild template's provision or deprovision steps locally",
+	ValidArgs: []string{"provision", "deprovision"},
+	Long: `Runs a codebuild template's provision or deprovision steps locally, the way CodeBuild runs them for proton.
+
+1. the template's infrastructure directory is copied to a temporary workspace
+2. ` + protonInputsFile + ` is generated from the spec, as it is by the inputs command
+3. the manifest's env variables are exported, along with RESOURCE_ARN
+4. each step runs in order in the same shell, stopping at the first one that fails
+
+aws proton notify-resource-deployment-status-change is stubbed, and the outputs it's called
+with are shown when the steps finish. Other aws commands use the aws cli, if it's installed,
+with your usual credentials. The workspace is deleted afterwards unless --keep is set.
+
+For service templates, the steps run for the instance in the spec that's deployed to --env-name.`,
+	Args: cobra.ExactArgs(2),
+	Run:  doRun,
+	Example: `
+# Provision an environment template locally
+protonizer run provision my_env_template/v1 --spec spec.yaml --env-name dev --outputs dev-outputs.json
+
+# Provision a service instance with the environment's outputs, then deprovision it
+protonizer run provision my_svc_template/v1 --spec spec.yaml --env-name dev --name api --env-outputs dev-outputs.json
+protonizer run deprovision my_svc_template/v1 --spec spec.yaml --env-name dev --name api --env-outputs dev-outputs.json`,
+}
+
+// returned when a template can't be run locally
+var errRunUnsupported = errors.New("unable to run template")
+
+// returned when a provision or deprovision step fails
+var errRunFailed = errors.New("step failed")
+
+// the settings of a codebuild manifest template
+type codeBuildSettings struct {
+	Env struct {
+		Variables map[string]string `yaml:"variables"`
+	} `yaml:"env"`
+	Provision   []string `yaml:"provision"`
+	Deprovision []string `yaml:"deprovision"`
+}
+
+// options for running a codebuild manifest locally
+type runOptions struct {
+	action    string
+	shell     string
+	region    string
+	accountID string
+	keep      bool
+	stdout    io.Writer
+	stderr    io.Writer
+}
+
+// the result of running a codebuild manifest locally
+type runResult struct {
+	//the temporary directory holding the workspace. removed unless keep is set
+	dir string
+	//the statuses of the captured notify-resource-deployment-status-change calls
+	statuses []string
+	//the outputs of the captured calls, with later calls overriding earlier ones
+	outputs []types.Output
+}
+
+func init() {
+	runCmd.Flags().StringVarP(&flagRunSpec, "spec", "s", "",
+		"The environment or service spec file (required)")
+	runCmd.MarkFlagRequired("spec")
+	runCmd.Flags().StringVarP(&flagRunEnvName, "env-name", "e", "",
+		"The name of the environment, or for service templates the environment the instance is deployed to (required)")
+	runCmd.MarkFlagRequired("env-name")
+	runCmd.Flags().StringVarP(&flagRunName, "name", "n", "test",
+		"The name of the service")
+	runCmd.Flags().StringVar(&flagRunInstance, "instance", "",
+		"The service instance, if more than one in the spec is deployed to --env-name")
+	runCmd.Flags().StringVar(&flagRunEnvOutputs, "env-outputs", "",
+		"A json or yaml file of the outputs of the environment the service instance is deployed to")
+	runCmd.Flags().StringVar(&flagRunAccountID, "account-id", "123456789012",
+		"The account id of the environment, used in "+protonInputsFile+" and RESOURCE_ARN")
+	runCmd.Flags().StringVar(&flagRunRepositoryConnectionArn, "repository-connection-arn", "",
+		"The CodeStar connection of the service's source repository")
+	runCmd.Flags().StringVar(&flagRunRepositoryID, "repository-id", "",
+		"The service's source repository, in the format owner/repo")
+	runCmd.Flags().StringVar(&flagRunBranch, "branch", "",
+		"The branch of the service's source repository")
+	runCmd.Flags().StringVar(&flagRunShell, "shell", "sh",
+		"The shell the steps run in")
+	runCmd.Flags().StringVarP(&flagRunOutputs, "outputs", "o", "",
+		"A json file to write the captured outputs to, which can be passed to --env-outputs")
+	runCmd.Flags().BoolVar(&flagRunKeep, "keep", false,
+		"Keep the workspace after the steps run")
+	rootCmd.AddCommand(runCmd)
+}
+
+func doRun(cmd *cobra.Command, args []string) {
+	action, dir := args[0], args[1]
+	if action != "provision" && action != "deprovision" {
+		errorExit(fmt.Sprintf("%q is invalid. only provision and deprovision are supported", action))
+	}
+	config, err := readProtonYAMLFile(filepath.Join(dir, "proton.yaml"))
+	handleError("reading proton.yaml", err)
+	schema, err := readLocalSchema(dir)
+	handleError("reading schema", err)
+	spec, err := os.ReadFile(flagRunSpec)
+	handleError("reading spec", err)
+	validate := validateServiceSpec
+	if config.Type == "environment" {
+		validate = validateEnvironmentSpec
+	}
+	if err := validate(schema, spec); err != nil {
+		errorExit(fmt.Sprintf("%s is not valid:\n%v", flagRunSpec, err))
+	}
+
+	contextOpts := protonContextOptions{
+		name:                    flagRunName,
+		accountID:               flagRunAccountID,
+		repositoryConnectionArn: flagRunRepositoryConnectionArn,
+		repositoryID:            flagRunRepositoryID,
+		branch:                  flagRunBranch,
+	}
+	if flagRunEnvOutputs != "" {
+		contextOpts.environmentOutputs, err = readEnvironmentOutputs(flagRunEnvOutputs)
+		handleError("reading environment outputs", err)
+	}
+	c, err := protonInputsContext(config.Type, schema, spec, flagRunEnvName, flagRunInstance, contextOpts)
+	if errors.Is(err, errInputsInstance) {
+		errorExit(err)
+	}
+	handleError("generating inputs", err)
+
+	//ctrl-c stops the running step
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := runOptions{
+		action:    action,
+		shell:     flagRunShell,
+		region:    flagAWSRegion,
+		accountID: flagRunAccountID,
+		keep:      flagRunKeep,
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+	}
+	result, err := runManifest(ctx, dir, config.Type, c, opts)
+	if result != nil && flagRunKeep {
+		fmt.Println("\nworkspace:", filepath.Join(result.dir, "workspace"))
+	}
+	if errors.Is(err, errRunUnsupported) || errors.Is(err, errRunFailed) {
+		errorExit(err)
+	}
+	handleError("running "+action, err)
+
+	printDeploymentOutputs(os.Stdout, []deploymentOutputs{{Name: c.Name, Outputs: result.outputs}})
+	if flagRunOutputs != "" {
+		outputs := map[string]string{}
+		for _, o := range result.outputs {
+			outputs[aws.ToString(o.Key)] = aws.ToString(o.ValueString)
+		}
+		b, err := json.MarshalIndent(outputs, "", "  ")
+		handleError("writing outputs", err)
+		handleError("writing outputs", os.WriteFile(flagRunOutputs, append(b, '\n'), 0644))
+		fmt.Println("\nwrote", flagRunOutputs)
+	}
+}
+
+// runs the provision or deprovision steps of a template's codebuild manifest in a temporary
+// workspace, with the proton-inputs.json of a context and a stubbed aws cli
+func runManifest(ctx context.Context, dir, templateType string, c *protonContext, opts runOptions) (*runResult, error) {
+	m := "runManifest()"
+	debug(m)
+
+	infraDir := protonInfrastructureDirEnv
+	if templateType == "service" {
+		infraDir = protonInfrastructureDirSvc
+	}
+	settings, err := readCodeBuildSettings(filepath.Join(dir, infraDir))
+	if err != nil {
+		return nil, err
+	}
+	steps := settings.Provision
+	if opts.action == "deprovision" {
+		steps = settings.Deprovision
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%w: %s/manifest.yaml has no %s steps", errRunUnsupported, infraDir, opts.action)
+	}
+	inputs, err := protonInputs(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "protonizer-run-")
+	if err != nil {
+		return nil, err
+	}
+	result := &runResult{dir: tmp}
+	if !opts.keep {
+		defer os.RemoveAll(tmp)
+	}
+	workspace := filepath.Join(tmp, "workspace")
+	bin := filepath.Join(tmp, "bin")
+	notifications := filepath.Join(tmp, "notifications")
+	debugFmt("%s: staging %s in %s", m, infraDir, workspace)
+	if err := copyDir(filepath.Join(dir, infraDir), workspace); err != nil {
+		return result, fmt.Errorf("%s: staging workspace: %w", m, err)
+	}
+	if err := os.WriteFile(filepath.Join(workspace, protonInputsFile), inputs, 0644); err != nil {
+		return result, err
+	}
+	for _, d := range []string{bin, notifications} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			return result, err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(bin, "aws"), readTemplateFS("run/aws.sh"), 0755); err != nil {
+		return result, err
+	}
+
+	//the real aws cli, for the commands that aren't stubbed
+	realAWS, _ := exec.LookPath("aws")
+
+	region := opts.region
+	if region == "" {
+		region = settings.Env.Variables["AWS_REGION"]
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	env := os.Environ()
+	for k, v := range settings.Env.Variables {
+		env = append(env, k+"="+v)
+	}
+	env = append(env,
+		"RESOURCE_ARN="+resourceArn(region, opts.accountID, templateType, c),
+		"CODEBUILD_SRC_DIR="+workspace,
+		"PROTONIZER_NOTIFY_DIR="+notifications,
+		"PROTONIZER_AWS="+realAWS,
+		"PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"),
+	)
+
+	script := filepath.Join(tmp, opts.action+".sh")
+	if err := os.WriteFile(script, []byte(codeBuildScript(steps)), 0644); err != nil {
+		return result, err
+	}
+	cmd := exec.CommandContext(ctx, opts.shell, script)
+	cmd.Dir = workspace
+	cmd.Env = env
+	cmd.Stdout = opts.stdout
+	cmd.Stderr = opts.stderr
+	runErr := cmd.Run()
+
+	result.statuses, result.outputs, err = capturedNotifications(notifications)
+	if err != nil {
+		return result, err
+	}
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) {
+		return result, fmt.Errorf("%w: %s exited with status %d", errRunFailed, opts.action, exitErr.ExitCode())
+	}
+	return result, runErr
+}
+
+// reads the codebuild settings of an infrastructure directory's manifest.yaml
+func readCodeBuildSettings(infraDir string) (*codeBuildSettings, error) {
+	file := filepath.Join(infraDir, "manifest.yaml")
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var manifest templateManifest
+	if err := yaml.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	templates := manifest.Infrastructure.Templates
+	if len(templates) != 1 || templates[0].RenderingEngine != "codebuild" {
+		return nil, fmt.Errorf("%w: %s must have one template with the codebuild rendering engine. use render for jinja templates",
+			errRunUnsupported, file)
+	}
+	return &templates[0].Settings, nil
+}
+
+// returns a shell script that runs steps in order, like a codebuild phase. every step runs
+// in the same shell, so exported variables carry over, and the first failing step stops the script
+func codeBuildScript(steps []string) string {
+	var b strings.Builder
+	for i, step := range steps {
+		fmt.Fprintf(&b, "printf '\\n%%s\\n' %s\n", shellQuote(fmt.Sprintf("[%d/%d] %s", i+1, len(steps), step)))
+		fmt.Fprintf(&b, "%s\n", step)
+		fmt.Fprintf(&b, "protonizer_status=$?\n")
+		fmt.Fprintf(&b, "if [ $protonizer_status -ne 0 ]; then echo %s $protonizer_status >&2; exit $protonizer_status; fi\n",
+			shellQuote(fmt.Sprintf("step %d failed with exit status", i+1)))
+	}
+	return b.String()
+}
+
+// quotes a string for a posix shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// returns the arn proton sets RESOURCE_ARN to, for an environment or service instance
+func resourceArn(region, accountID, templateType string, c *protonContext) string {
+	if templateType == "environment" {
+		return fmt.Sprintf("arn:aws:proton:%s:%s:environment/%s", region, accountID, c.Name)
+	}
+	service, _ := c.Values["service"].(map[string]interface{})
+	return fmt.Sprintf("arn:aws:proton:%s:%s:service/%s/service-instance/%s", region, accountID, service["name"], c.Name)
+}
+
+// reads the notify-resource-deployment-status-change calls captured by the aws cli stub,
+// returning their statuses and outputs
+func capturedNotifications(dir string) ([]string, []types.Output, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.status"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(files)
+	var statuses []string
+	var result []types.Output
+	index := map[string]int{}
+	for _, f := range files {
+		status, err := os.ReadFile(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		statuses = append(statuses, string(status))
+
+		b, err := os.ReadFile(strings.TrimSuffix(f, ".status") + ".outputs")
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var captured []types.Output
+		if err := json.Unmarshal(b, &captured); err != nil {
+			return nil, nil, fmt.Errorf("notify-resource-deployment-status-change --outputs must be a json list of key and valueString: %w", err)
+		}
+		for _, o := range captured {
+			if o.Key == nil {
+				continue
+			}
+			if i, found := index[*o.Key]; found {
+				result[i] = o
+				continue
+			}
+			index[*o.Key] = len(result)
+			result = append(result, o)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return *result[i].Key < *result[j].Key })
+	return statuses, result, nil
+}
+
+// copies a directory tree, keeping file modes
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, info.Mode().Perm())
+	})
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const testCodeBuildManifest = `infrastructure:
+  templates:
+    - rendering_engine: codebuild
+      settings:
+        image: aws/codebuild/standard:6.0
+        env:
+          variables:
+            TF_VERSION: 1.4.5
+            RETRIES: 3
+        provision:
+          - export GREETING="hello $TF_VERSION $RETRIES"
+          - echo "$GREETING $RESOURCE_ARN"
+          - grep -q '"vpc_cidr"' proton-inputs.json && ./notify.sh
+          - >-
+            aws proton notify-resource-deployment-status-change --resource-arn $RESOURCE_ARN
+            --status SUCCEEDED --outputs '[{"key":"Greeting","valueString":"bye"},{"key":"Count","valueString":"1"}]'
+        deprovision:
+          - echo one
+          - "false"
+          - echo never
+`
+
+func TestRunManifest(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"schema/schema.yaml":           testEnvironmentSchema,
+		"infrastructure/manifest.yaml": testCodeBuildManifest,
+		"infrastructure/notify.sh": `echo '[{"key":"Greeting","valueString":"hi"}]' > output.json
+aws proton notify-resource-deployment-status-change --resource-arn ${RESOURCE_ARN} --status IN_PROGRESS --outputs file://./output.json
+`,
+	})
+	if err := os.Chmod(filepath.Join(dir, "infrastructure", "notify.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := readLocalSchema(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := protonInputsContext("environment", schema, []byte("proton: EnvironmentSpec\nspec:\n  azs: 2\n"), "dev", "", protonContextOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	opts := runOptions{action: "provision", shell: "sh", region: "us-west-2", accountID: "111111111111", stdout: &stdout, stderr: io.Discard}
+	result, err := runManifest(context.Background(), dir, "environment", c, opts)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "hello 1.4.5 3 arn:aws:proton:us-west-2:111111111111:environment/dev\n") {
+		t.Errorf("expecting exported variables and RESOURCE_ARN in:\n%s", stdout.String())
+	}
+	if strings.Join(result.statuses, ",") != "IN_PROGRESS,SUCCEEDED" {
+		t.Errorf("expecting two captured notifications, got %v", result.statuses)
+	}
+	var actual []string
+	for _, o := range result.outputs {
+		actual = append(actual, aws.ToString(o.Key)+"="+aws.ToString(o.ValueString))
+	}
+	if strings.Join(actual, ",") != "Count=1,Greeting=bye" {
+		t.Errorf("expecting the latest outputs, got %v", actual)
+	}
+	if _, err := os.Stat(result.dir); !os.IsNotExist(err) {
+		t.Error("expecting the workspace to be removed")
+	}
+
+	//the first failing step stops the run
+	stdout.Reset()
+	opts.action = "deprovision"
+	_, err = runManifest(context.Background(), dir, "environment", c, opts)
+	if !errors.Is(err, errRunFailed) {
+		t.Errorf("expecting a step to fail, got %v", err)
+	}
+	if !strings.Contains(stdout.String(), "one\n") || strings.Contains(stdout.String(), "never") {
+		t.Errorf("expecting the steps to stop at false, got:\n%s", stdout.String())
+	}
+}
+
+func TestRunManifestUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"instance_infrastructure/manifest.yaml": testJinjaManifest,
+	})
+	c := &protonContext{Name: "dev", Values: map[string]interface{}{}}
+	_, err := runManifest(context.Background(), dir, "service", c, runOptions{action: "provision"})
+	if !errors.Is(err, errRunUnsupported) {
+		t.Errorf("expecting an unsupported template error, got %v", err)
+	}
+}
+
+func TestCodeBuildScript(t *testing.T) {
+	actual := codeBuildScript([]string{"echo 'hi'"})
+	expected := `printf '\n%s\n' '[1/1] echo '\''hi'\'''
+echo 'hi'
+protonizer_status=$?
+if [ $protonizer_status -ne 0 ]; then echo 'step 1 failed with exit status' $protonizer_status >&2; exit $protonizer_status; fi
+`
+	if actual != expected {
+		t.Errorf("expecting:\n%s\ngot:\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// default name of the file used to allow secret scan false positives
+const scanAllowlistFile = ".protonizer-allowlist"
+
+// minimum length and shannon entropy (bits per char) of a default value to be reported
+const (
+	scanEntropyMinLength = 20
+	scanEntropyThreshold = 4.0
+)
+
+// a potential secret found in a template
+type scanFinding struct {
+	File string
+	Line int
+	Rule string
+}
+
+func (f scanFinding) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("%s: %s", f.File, f.Rule)
+	}
+	return fmt.Sprintf("%s:%d: %s", f.File, f.Line, f.Rule)
+}
+
+// a rule that matches secrets in a single line
+type scanLineRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var scanLineRules = []scanLineRule{
+	{
+		name:    "AWS access key ID",
+		pattern: regexp.MustCompile(`\b(AKIA|ASIA|ABIA|ACCA|A3T[A-Z0-9])[A-Z0-9]{16}\b`),
+	},
+	{
+		name:    "AWS secret access key",
+		pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}\b`),
+	},
+	{
+		name:    "private key",
+		pattern: regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY( BLOCK)?-----`),
+	},
+}
+
+// matches default values in HCL (default = "x") and YAML (default: x)
+var scanDefaultValue = regexp.MustCompile(`(?i)\bdefault\b["']?\s*[=:]\s*["']?([^"'\s,}]+)`)
+
+// file name patterns that should never be published
+var scanFileRules = map[string]string{
+	"*.tfstate":        "terraform state file",
+	"*.tfstate.backup": "terraform state file",
+	"*.tfvars":         "terraform variables file",
+	"*.tfvars.json":    "terraform variables file",
+}
+
+// scans a template directory for potential secrets,
+// returning findings that are not allowed by the allowlist
+func scanTemplateDir(dir string, allowlist []string) ([]scanFinding, error) {
+	findings := []scanFinding{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		//ignore .git inside the template, but not .github, .gitignore or
+		//directories above the template whose names contain .git
+		if p != dir && d.Name() == ".git" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		//ignore a stale local bundle
+		if rel == "bundle.tar.gz" {
+			return nil
+		}
+
+		for pattern, rule := range scanFileRules {
+			if ok, _ := path.Match(pattern, d.Name()); ok {
+				findings = append(findings, scanFinding{File: rel, Rule: rule})
+			}
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		findings = append(findings, scanContents(rel, b)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []scanFinding{}
+	for _, f := range findings {
+		if !scanAllowed(allowlist, f) {
+			result = append(result, f)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].File != result[j].File {
+			return result[i].File < result[j].File
+		}
+		return result[i].Line < result[j].Line
+	})
+	return result, nil
+}
+
+// scans file contents line by line
+func scanContents(file string, b []byte) []scanFinding {
+
+	//skip binary files
+	if bytes.IndexByte(b, 0) != -1 {
+		return nil
+	}
+
+	findings := []scanFinding{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		for _, r := range scanLineRules {
+			if r.pattern.MatchString(text) {
+				findings = append(findings, scanFinding{File: file, Line: line, Rule: r.name})
+			}
+		}
+		for _, m := range scanDefaultValue.FindAllStringSubmatch(text, -1) {
+			if len(m[1]) >= scanEntropyMinLength && shannonEntropy(m[1]) >= scanEntropyThreshold {
+				findings = append(findings, scanFinding{File: file, Line: line, Rule: "high-entropy default value"})
+			}
+		}
+	}
+	return findings
+}
+
+// returns the shannon entropy of a string in bits per character
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	result := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(total)
+		result -= p * math.Log2(p)
+	}
+	return result
+}
+
+// returns true if a finding is allowed by an allowlist entry.
+// entries are file globs (relative to the template directory)
+// optionally followed by :line
+func scanAllowed(allowlist []string, f scanFinding) bool {
+	for _, entry := range allowlist {
+		pattern, line := entry, 0
+		if i := strings.LastIndex(entry, ":"); i != -1 {
+			if n, err := strconv.Atoi(entry[i+1:]); err == nil {
+				pattern, line = entry[:i], n
+			}
+		}
+		if line != 0 && line != f.Line {
+			continue
+		}
+		if ok, _ := path.Match(pattern, f.File); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reads an allowlist file, ignoring blank lines and # comments.
+// a missing file results in an empty allowlist
+func readScanAllowlist(file string) ([]string, error) {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result, nil
+}
+
+// returned when a template contains potential secrets
+type scanError struct {
+	findings      []scanFinding
+	allowlistFile string
+	operation     string
+}
+
+func (e *scanError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "secret scan found %d potential secret(s), refusing to %s:\n", len(e.findings), e.operation)
+	for _, f := range e.findings {
+		fmt.Fprintln(&sb, "  "+f.String())
+	}
+	fmt.Fprintf(&sb, "\nto allow a false positive, add its file or file:line to %s", e.allowlistFile)
+	return sb.String()
+}
+
+// scans a template directory for secrets before an operation,
+// returning a *scanError listing any findings
+func scanTemplate(dir, allowlistFile, operation string) error {
+	if allowlistFile == "" {
+		allowlistFile = path.Join(dir, scanAllowlistFile)
+	}
+	m := "reading secret scan allowlist: " + allowlistFile
+	debug(m)
+	allowlist, err := readScanAllowlist(allowlistFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+
+	m = "scanning template for secrets: " + dir
+	debug(m)
+	findings, err := scanTemplateDir(dir, allowlist)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	if len(findings) > 0 {
+		return &scanError{findings: findings, allowlistFile: allowlistFile, operation: operation}
+	}
+	return nil
+}
+
+// scans a template directory for secrets, printing a report and exiting if any are found
+func scanTemplateOrExit(dir, allowlistFile, operation string) {
+	err := scanTemplate(dir, allowlistFile, operation)
+	var scanErr *scanError
+	if errors.As(err, &scanErr) {
+		errorExit(err)
+	}
+	handleError("scanning template", err)
+}
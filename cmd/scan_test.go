@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func internalWriteFiles(t *testing.T, dir string, files map[string]string) {
+	for f, contents := range files {
+		p := filepath.Join(dir, f)
+		err := os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(p, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScanTemplateDir(t *testing.T) {
+
+	//build fake secrets at runtime so that this file doesn't trip scanners
+	accessKey := "AKIA" + "ABCDEFGHIJKLMNOP"
+	privateKey := "-----BEGIN RSA " + "PRIVATE KEY-----"
+
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		"proton.yaml":                             "name: my_template\n",
+		"infrastructure/src/main.tf":              "locals {\n  key = \"" + accessKey + "\"\n}\n",
+		"infrastructure/src/key.pem":              privateKey + "\n",
+		"infrastructure/src/terraform.tfvars":     "region = \"us-east-1\"\n",
+		"infrastructure/src/terraform.tfstate":    "{}",
+		"infrastructure/src/variables.tf":         "variable \"token\" {\n  default = \"q8Zr1Xf0pLk3Vb7Nw2Ys9Tj4Hm6Gc5Ea\"\n}\n",
+		"infrastructure/src/low_entropy.tf":       "variable \"name\" {\n  default = \"aaaaaaaaaaaaaaaaaaaaaaaaaaaa\"\n}\n",
+		"schema/schema.yaml":                      "default: 10.0.0.0/16\n",
+		"infrastructure/src/.git/config":          accessKey,
+		"infrastructure/src/allowed/terraform.tf": accessKey,
+	})
+
+	findings, err := scanTemplateDir(dir, []string{"infrastructure/src/allowed/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		t.Log(f)
+	}
+
+	expected := []string{
+		"infrastructure/src/key.pem:1: private key",
+		"infrastructure/src/main.tf:2: AWS access key ID",
+		"infrastructure/src/terraform.tfstate: terraform state file",
+		"infrastructure/src/terraform.tfvars: terraform variables file",
+		"infrastructure/src/variables.tf:2: high-entropy default value",
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("expecting %d findings, got %d", len(expected), len(findings))
+	}
+	for i, f := range findings {
+		if f.String() != expected[i] {
+			t.Errorf("expecting %s, got %s", expected[i], f)
+		}
+	}
+}
+
+func TestScanAllowed(t *testing.T) {
+	f := scanFinding{File: "infrastructure/main.tf", Line: 12, Rule: "AWS access key ID"}
+
+	cases := []struct {
+		allowlist []string
+		allowed   bool
+	}{
+		{[]string{}, false},
+		{[]string{"infrastructure/main.tf"}, true},
+		{[]string{"infrastructure/*.tf"}, true},
+		{[]string{"infrastructure/main.tf:12"}, true},
+		{[]string{"infrastructure/main.tf:13"}, false},
+		{[]string{"main.tf"}, false},
+	}
+	for _, c := range cases {
+		if scanAllowed(c.allowlist, f) != c.allowed {
+			t.Errorf("expecting allowed=%v for %v", c.allowed, c.allowlist)
+		}
+	}
+}
+
+func TestReadScanAllowlist(t *testing.T) {
+	dir := t.TempDir()
+
+	result, err := readScanAllowlist(filepath.Join(dir, "missing"))
+	if err != nil || len(result) != 0 {
+		t.Error("expecting an empty allowlist for a missing file")
+	}
+
+	file := filepath.Join(dir, scanAllowlistFile)
+	internalWriteFiles(t, dir, map[string]string{
+		scanAllowlistFile: "# test fixtures\n\ninfrastructure/test/*.pem\n  schema/schema.yaml:4  \n",
+	})
+	result, err = readScanAllowlist(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result[0] != "infrastructure/test/*.pem" || result[1] != "schema/schema.yaml:4" {
+		t.Error("unexpected allowlist", result)
+	}
+}
+
+func TestScanTemplateDirGitNames(t *testing.T) {
+	accessKey := "AKIA" + "ABCDEFGHIJKLMNOP"
+
+	//a template under a directory whose name contains .git is still scanned
+	dir := filepath.Join(t.TempDir(), "my.github.io", "vpc", "v1")
+	internalWriteFiles(t, dir, map[string]string{
+		".github/workflows/publish.yaml": "key: " + accessKey + "\n",
+		".gitignore":                     accessKey + "\n",
+		".git/config":                    accessKey + "\n",
+	})
+
+	findings, err := scanTemplateDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, f := range findings {
+		actual = append(actual, f.String())
+	}
+	expected := []string{
+		".github/workflows/publish.yaml:1: AWS access key ID",
+		".gitignore:1: AWS access key ID",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
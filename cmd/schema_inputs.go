@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// a proton template schema, as written in schema/schema.yaml
+type templateSchema struct {
+	Schema struct {
+		EnvironmentInputType string                 `yaml:"environment_input_type"`
+		ServiceInputType     string                 `yaml:"service_input_type"`
+		PipelineInputType    string                 `yaml:"pipeline_input_type"`
+		Types                map[string]*schemaType `yaml:"types"`
+	} `yaml:"schema"`
+}
+
+// an openapi type or property in a template schema
+type schemaType struct {
+	Type        string                 `yaml:"type"`
+	Title       string                 `yaml:"title"`
+	Description string                 `yaml:"description"`
+	Default     interface{}            `yaml:"default"`
+	Enum        []interface{}          `yaml:"enum"`
+	Items       *schemaType            `yaml:"items"`
+	Properties  map[string]*schemaType `yaml:"properties"`
+	Required    []string               `yaml:"required"`
+}
+
+// parses a template schema
+func parseTemplateSchema(b []byte) (*templateSchema, error) {
+	var s templateSchema
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	return &s, nil
+}
+
+// returns a schema input type, or nil if name is empty
+func (s *templateSchema) inputType(name string) (*schemaType, error) {
+	if name == "" {
+		return nil, nil
+	}
+	t, found := s.Schema.Types[name]
+	if !found || t == nil {
+		return nil, fmt.Errorf("schema type %s is not defined in schema.types", name)
+	}
+	return t, nil
+}
+
+// returns inputs set to their defaults, or to an empty value if they don't have one.
+// also returns the required inputs without a default, which need a value
+func (t *schemaType) defaultInputs() (map[string]interface{}, []string) {
+	inputs := map[string]interface{}{}
+	missing := []string{}
+	for name, p := range t.Properties {
+		if p == nil {
+			continue
+		}
+		if p.Default != nil {
+			inputs[name] = p.Default
+			continue
+		}
+		if t.isRequired(name) {
+			inputs[name] = p.emptyValue()
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return inputs, missing
+}
+
+// returns true if a property is required
+func (t *schemaType) isRequired(name string) bool {
+	for _, r := range t.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// returns a placeholder value for a property's type
+func (t *schemaType) emptyValue() interface{} {
+	switch t.Type {
+	case "number", "integer":
+		return 0
+	case "boolean":
+		return false
+	case "array":
+		return []interface{}{}
+	case "object":
+		return map[string]interface{}{}
+	}
+	return ""
+}
+
+// validates inputs against a schema object type, returning all problems found.
+// path prefixes the names of inputs in problems
+func (t *schemaType) validateInputs(inputs map[string]interface{}, path string) []string {
+	problems := []string{}
+	for _, name := range t.Required {
+		p := t.Properties[name]
+		if _, set := inputs[name]; !set && (p == nil || p.Default == nil) {
+			problems = append(problems, fmt.Sprintf("%s%s: is required", path, name))
+		}
+	}
+
+	names := []string{}
+	for name := range inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, found := t.Properties[name]
+		if !found || p == nil {
+			problems = append(problems, fmt.Sprintf("%s%s: is not defined in the schema", path, name))
+			continue
+		}
+		problems = append(problems, p.validateValue(inputs[name], path+name)...)
+	}
+	return problems
+}
+
+// validates a value against a property's type and enum
+func (t *schemaType) validateValue(v interface{}, path string) []string {
+	invalid := func() []string {
+		return []string{fmt.Sprintf("%s: %v is not a valid %s", path, v, t.Type)}
+	}
+
+	switch t.Type {
+	case "string":
+		if _, ok := v.(string); !ok {
+			return invalid()
+		}
+	case "number":
+		if _, ok := number(v); !ok {
+			return invalid()
+		}
+	case "integer":
+		if n, ok := number(v); !ok || n != math.Trunc(n) {
+			return invalid()
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return invalid()
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return invalid()
+		}
+		if t.Items == nil {
+			return nil
+		}
+		problems := []string{}
+		for i, item := range items {
+			problems = append(problems, t.Items.validateValue(item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	case "object":
+		inputs, ok := v.(map[string]interface{})
+		if !ok {
+			return invalid()
+		}
+		if len(t.Properties) == 0 {
+			return nil
+		}
+		return t.validateInputs(inputs, path+".")
+	}
+
+	if len(t.Enum) > 0 {
+		for _, e := range t.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s: %v must be one of %v", path, v, t.Enum)}
+	}
+	return nil
+}
+
+// returns a yaml or json number as a float
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
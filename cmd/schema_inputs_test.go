@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+const testServiceSchema = `schema:
+  format:
+    openapi: "3.0.0"
+  service_input_type: ServiceInput
+  pipeline_input_type: PipelineInput
+  types:
+    ServiceInput:
+      type: object
+      properties:
+        image:
+          type: string
+        port:
+          type: integer
+          default: 80
+        size:
+          type: string
+          enum: [small, large]
+          default: small
+        subnets:
+          type: array
+          items:
+            type: string
+      required:
+        - image
+        - port
+    PipelineInput:
+      type: object
+      properties:
+        unit_tests:
+          type: boolean
+          default: true
+`
+
+func TestSchemaDefaultInputs(t *testing.T) {
+	schema, err := parseTemplateSchema([]byte(testServiceSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceType, err := schema.inputType(schema.Schema.ServiceInputType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs, missing := serviceType.defaultInputs()
+	if len(missing) != 1 || missing[0] != "image" {
+		t.Errorf("expecting image to be missing, got %v", missing)
+	}
+	if inputs["image"] != "" || inputs["port"] != 80 || inputs["size"] != "small" {
+		t.Errorf("unexpected default inputs %v", inputs)
+	}
+	if _, set := inputs["subnets"]; set {
+		t.Error("expecting optional inputs without a default to be left out")
+	}
+
+	if _, err := schema.inputType("Missing"); err == nil {
+		t.Error("expecting an error for an undefined type")
+	}
+}
+
+func TestSchemaValidateInputs(t *testing.T) {
+	schema, err := parseTemplateSchema([]byte(testServiceSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceType, _ := schema.inputType(schema.Schema.ServiceInputType)
+
+	valid := map[string]interface{}{"image": "nginx", "port": 8080, "subnets": []interface{}{"a", "b"}}
+	if problems := serviceType.validateInputs(valid, ""); len(problems) > 0 {
+		t.Errorf("expecting valid inputs, got %v", problems)
+	}
+
+	invalid := map[string]interface{}{
+		"port":    8.5,
+		"size":    "medium",
+		"subnets": []interface{}{"a", 1},
+		"extra":   true,
+	}
+	problems := strings.Join(serviceType.validateInputs(invalid, "spec."), "\n")
+	for _, expected := range []string{
+		"spec.image: is required",
+		"spec.port: 8.5 is not a valid integer",
+		"spec.size: medium must be one of [small large]",
+		"spec.subnets[1]: 1 is not a valid string",
+		"spec.extra: is not defined in the schema",
+	} {
+		if !strings.Contains(problems, expected) {
+			t.Errorf("expecting %q in:\n%s", expected, problems)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// where service sync reads proton ops from, relative to the repository root
+const protonOpsFile = ".aws/proton/proton-ops.yaml"
+
+var (
+	flagServiceSyncTemplate      string
+	flagServiceSyncInstances     []string
+	flagServiceSyncBranch        string
+	flagServiceSyncRepo          string
+	flagServiceSyncProvider      string
+	flagServiceSyncConnectionArn string
+	flagServiceSyncDir           string
+	flagServiceSyncForce         bool
+)
+
+var serviceSyncCmd = &cobra.Command{
+	Use:   "service-sync",
+	Short: "Manages proton service sync files",
+	Long: `Manages the ` + protonOpsFile + ` and spec files that proton service sync
+uses to deploy a service's instances and pipeline from a git repository.`,
+}
+
+var serviceSyncInitCmd = &cobra.Command{
+	Use:   "init <service>",
+	Short: "Generates proton-ops.yaml and spec files and configures service sync",
+	Long: `Generates ` + protonOpsFile + ` and a spec file for each service instance,
+and for the pipeline if the template has one.
+
+Inputs are set to their schema defaults. Required inputs without a default are
+set to an empty value and listed so that they can be filled in.
+
+Existing spec files are kept unless --force is used.
+
+The service's sync config is then created, or updated if it already exists, to
+sync from the repository and branch. The repository is linked to proton first
+if --connection-arn is used.`,
+	Args: cobra.ExactArgs(1),
+	Run:  doServiceSyncInit,
+	Example: `
+# Generate files for dev and prod instances using the recommended template version
+protonizer service-sync init my-service --template my_template --repo my-org/my-service \
+  --instance dev=dev-env --instance prod=prod-env
+
+# Use a specific template version, syncing from the release branch of a repository that isn't linked yet
+protonizer service-sync init my-service --template my_template:2.1 \
+  --instance dev=dev-env --branch release --repo my-org/my-service \
+  --connection-arn arn:aws:codestar-connections:us-east-1:123456789012:connection/abc`,
+}
+
+var serviceSyncValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates proton-ops.yaml and its spec files against a service template's schema",
+	Args:  cobra.NoArgs,
+	Run:   doServiceSyncValidate,
+	Example: `
+# Validate against the template's recommended version
+protonizer service-sync validate --template my_template
+
+# Validate a repository in another directory against version 2.1
+protonizer service-sync validate --template my_template:2.1 --dir ../my-service`,
+}
+
+// proton-ops.yaml
+type protonOps struct {
+	Pipeline         *protonOpsPipeline  `yaml:"pipeline,omitempty"`
+	ServiceInstances []protonOpsInstance `yaml:"service_instances"`
+}
+
+type protonOpsPipeline struct {
+	Branch string `yaml:"branch"`
+	Spec   string `yaml:"spec"`
+}
+
+type protonOpsInstance struct {
+	Name   string `yaml:"name"`
+	Branch string `yaml:"branch"`
+	Spec   string `yaml:"spec"`
+}
+
+// a proton service spec
+type serviceSpec struct {
+	Proton    string                 `yaml:"proton"`
+	Pipeline  map[string]interface{} `yaml:"pipeline,omitempty"`
+	Instances []serviceSpecInstance  `yaml:"instances,omitempty"`
+}
+
+type serviceSpecInstance struct {
+	Name        string                 `yaml:"name"`
+	Environment string                 `yaml:"environment"`
+	Spec        map[string]interface{} `yaml:"spec"`
+}
+
+// the proton value of service spec files
+const serviceSpecKind = "ServiceSpec"
+
+// the outcome of generating service sync files
+type serviceSyncResult struct {
+	written []string
+	kept    []string
+
+	//required inputs that need a value, by spec file
+	missing map[string][]string
+}
+
+func init() {
+	for _, c := range []*cobra.Command{serviceSyncInitCmd, serviceSyncValidateCmd} {
+		c.Flags().StringVarP(&flagServiceSyncTemplate, "template", "t", "",
+			"The service template and optional version, in the format name[:major.minor] (required)")
+		c.Flags().StringVar(&flagServiceSyncDir, "dir", ".",
+			"The root of the service's repository")
+		c.MarkFlagRequired("template")
+	}
+	serviceSyncInitCmd.Flags().StringArrayVarP(&flagServiceSyncInstances, "instance", "i", []string{},
+		"A service instance and its environment, in the format name=environment. Can be repeated (required)")
+	serviceSyncInitCmd.Flags().StringVar(&flagServiceSyncBranch, "branch", "main",
+		"The branch that instances and the pipeline sync from")
+	serviceSyncInitCmd.Flags().StringVar(&flagServiceSyncRepo, "repo", "",
+		"The repository to sync from, in the format owner/repo (required)")
+	serviceSyncInitCmd.Flags().StringVar(&flagServiceSyncProvider, "provider", "GITHUB",
+		"The repository provider: GITHUB, GITHUB_ENTERPRISE or BITBUCKET")
+	serviceSyncInitCmd.Flags().StringVar(&flagServiceSyncConnectionArn, "connection-arn", "",
+		"The CodeStar connection ARN used to link the repository to proton, if it isn't linked yet")
+	serviceSyncInitCmd.Flags().BoolVar(&flagServiceSyncForce, "force", false,
+		"Overwrite existing proton-ops.yaml and spec files")
+	serviceSyncInitCmd.MarkFlagRequired("instance")
+	serviceSyncInitCmd.MarkFlagRequired("repo")
+
+	serviceSyncCmd.AddCommand(serviceSyncInitCmd)
+	serviceSyncCmd.AddCommand(serviceSyncValidateCmd)
+	rootCmd.AddCommand(serviceSyncCmd)
+}
+
+func doServiceSyncInit(cmd *cobra.Command, args []string) {
+	service := args[0]
+	provider, err := parseRepositoryProvider(flagServiceSyncProvider)
+	if err != nil {
+		errorExit(err)
+	}
+	instances, err := parseServiceInstances(flagServiceSyncInstances)
+	if err != nil {
+		errorExit(err)
+	}
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	protonClient := newProtonClient(cfg)
+
+	schema, version := getServiceSyncSchema(ctx, protonClient, flagServiceSyncTemplate)
+	result, err := generateServiceSync(flagServiceSyncDir, schema, instances, flagServiceSyncBranch, flagServiceSyncForce)
+	if err != nil {
+		if errors.Is(err, errServiceSyncConfig) {
+			errorExit(err)
+		}
+		handleError("generating service sync files", err)
+	}
+
+	for _, f := range result.written {
+		fmt.Println("wrote", f)
+	}
+	for _, f := range result.kept {
+		fmt.Println("kept existing", f)
+	}
+	files := []string{}
+	for f := range result.missing {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		fmt.Printf("%s needs values for: %s \n", f, strings.Join(result.missing[f], ", "))
+	}
+
+	err = ensureRepository(ctx, protonClient, provider, flagServiceSyncRepo, flagServiceSyncConnectionArn)
+	if err != nil {
+		if errors.Is(err, errRepositoryNotLinked) {
+			errorExit(err)
+		}
+		handleError("linking repository", err)
+	}
+
+	created, err := putServiceSyncConfig(ctx, protonClient, &serviceSyncConfig{
+		ServiceName:        aws.String(service),
+		RepositoryProvider: provider,
+		RepositoryName:     aws.String(flagServiceSyncRepo),
+		Branch:             aws.String(flagServiceSyncBranch),
+		FilePath:           aws.String(protonOpsFile),
+	})
+	handleError("configuring service sync", err)
+
+	action := "updated"
+	if created {
+		action = "created"
+	}
+	fmt.Printf("generated for %s. %s service sync config for %s from %s %s (%s) \n",
+		version, action, service, provider, flagServiceSyncRepo, flagServiceSyncBranch)
+}
+
+func doServiceSyncValidate(cmd *cobra.Command, args []string) {
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	protonClient := newProtonClient(cfg)
+
+	schema, version := getServiceSyncSchema(ctx, protonClient, flagServiceSyncTemplate)
+	err := validateServiceSync(flagServiceSyncDir, schema)
+	if err != nil {
+		errorExit(fmt.Sprintf("%s is not valid for %s:\n%v", protonOpsFile, version, err))
+	}
+	fmt.Printf("%s is valid for %s \n", protonOpsFile, version)
+}
+
+// returned when service sync files can't be generated as requested
+var errServiceSyncConfig = errors.New("unable to generate service sync files")
+
+// gets the schema of a published service template version, exiting on errors.
+// returns the schema and the template version in the format name:major.minor
+func getServiceSyncSchema(ctx context.Context, protonClient ProtonAPI, templateVersion string) (*templateSchema, string) {
+	name, version, _ := strings.Cut(templateVersion, ":")
+	var major, minor string
+	var err error
+	if version != "" {
+		major, minor, err = parseTemplateVersion(version)
+		if err != nil {
+			errorExit(err)
+		}
+	}
+
+	kind := serviceKind{}
+	template, err := kind.getTemplate(ctx, protonClient, name)
+	if err != nil {
+		if isNotFound(err) {
+			errorExit(fmt.Sprintf("service template %s not found", name))
+		}
+		handleError("getting template", err)
+	}
+	v, err := resolveTemplateVersion(ctx, protonClient, template, kind, major, minor)
+	if err != nil {
+		if errors.Is(err, errTemplateVersionNotFound) {
+			errorExit(err)
+		}
+		handleError("getting template version", err)
+	}
+	schema, err := parseTemplateSchema([]byte(v.Schema))
+	handleError("reading template schema", err)
+	return schema, fmt.Sprintf("%s:%s.%s", name, v.MajorVersion, v.MinorVersion)
+}
+
+// creates or updates a service sync config, returning true if it was created
+func putServiceSyncConfig(ctx context.Context, protonClient ProtonAPI, config *serviceSyncConfig) (bool, error) {
+	m := "proton.GetServiceSyncConfig()"
+	debug(m)
+	out, err := protonClient.GetServiceSyncConfig(ctx, &getServiceSyncConfigInput{
+		ServiceName: config.ServiceName,
+	})
+	if err != nil && !isNotFound(err) {
+		return false, fmt.Errorf("%s: %w", m, err)
+	}
+
+	if err == nil && out.ServiceSyncConfig != nil {
+		m = "proton.UpdateServiceSyncConfig()"
+		debug(m)
+		_, err = protonClient.UpdateServiceSyncConfig(ctx, &updateServiceSyncConfigInput{
+			ServiceName:        config.ServiceName,
+			RepositoryProvider: config.RepositoryProvider,
+			RepositoryName:     config.RepositoryName,
+			Branch:             config.Branch,
+			FilePath:           config.FilePath,
+		})
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", m, err)
+		}
+		return false, nil
+	}
+
+	m = "proton.CreateServiceSyncConfig()"
+	debug(m)
+	_, err = protonClient.CreateServiceSyncConfig(ctx, &createServiceSyncConfigInput{
+		ServiceName:        config.ServiceName,
+		RepositoryProvider: config.RepositoryProvider,
+		RepositoryName:     config.RepositoryName,
+		Branch:             config.Branch,
+		FilePath:           config.FilePath,
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", m, err)
+	}
+	return true, nil
+}
+
+// parses service instances in the format name=environment, keeping their order
+func parseServiceInstances(values []string) ([]serviceSpecInstance, error) {
+	result := []serviceSpecInstance{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		name, env, _ := strings.Cut(v, "=")
+		if name == "" || env == "" {
+			return nil, fmt.Errorf("instance: %q must use the format: name=environment", v)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("instance: %s is specified more than once", name)
+		}
+		seen[name] = true
+		result = append(result, serviceSpecInstance{Name: name, Environment: env})
+	}
+	return result, nil
+}
+
+// returns the spec file path for a service instance, or the pipeline if name is empty
+func serviceSyncSpecPath(name string) string {
+	if name == "" {
+		name = "pipeline"
+	}
+	return path.Join(path.Dir(protonOpsFile), name+".yaml")
+}
+
+// generates proton-ops.yaml and spec files under dir
+func generateServiceSync(dir string, schema *templateSchema, instances []serviceSpecInstance,
+	branch string, force bool) (*serviceSyncResult, error) {
+
+	opsPath := filepath.Join(dir, filepath.FromSlash(protonOpsFile))
+	if _, err := os.Stat(opsPath); err == nil && !force {
+		return nil, fmt.Errorf("%w: %s already exists. use --force to overwrite it or validate to check it",
+			errServiceSyncConfig, opsPath)
+	}
+
+	serviceType, err := schema.inputType(schema.Schema.ServiceInputType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errServiceSyncConfig, err)
+	}
+	if serviceType == nil {
+		return nil, fmt.Errorf("%w: the template schema has no service_input_type", errServiceSyncConfig)
+	}
+	pipelineType, err := schema.inputType(schema.Schema.PipelineInputType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errServiceSyncConfig, err)
+	}
+
+	result := &serviceSyncResult{missing: map[string][]string{}}
+	ops := protonOps{}
+	specs := map[string]serviceSpec{}
+	for _, instance := range instances {
+		inputs, missing := serviceType.defaultInputs()
+		spec := serviceSyncSpecPath(instance.Name)
+		if len(missing) > 0 {
+			result.missing[spec] = missing
+		}
+		instance.Spec = inputs
+		specs[spec] = serviceSpec{Proton: serviceSpecKind, Instances: []serviceSpecInstance{instance}}
+		ops.ServiceInstances = append(ops.ServiceInstances, protonOpsInstance{Name: instance.Name, Branch: branch, Spec: spec})
+	}
+	if pipelineType != nil {
+		inputs, missing := pipelineType.defaultInputs()
+		spec := serviceSyncSpecPath("")
+		if len(missing) > 0 {
+			result.missing[spec] = missing
+		}
+		specs[spec] = serviceSpec{Proton: serviceSpecKind, Pipeline: inputs}
+		ops.Pipeline = &protonOpsPipeline{Branch: branch, Spec: spec}
+	}
+
+	write := func(file string, v interface{}) error {
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		p := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		debug("writing", p)
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			return err
+		}
+		result.written = append(result.written, file)
+		return nil
+	}
+
+	if err := write(protonOpsFile, ops); err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for f := range specs {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f))); err == nil && !force {
+			result.kept = append(result.kept, f)
+			delete(result.missing, f)
+			continue
+		}
+		if err := write(f, specs[f]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// validates proton-ops.yaml under dir and the spec files it references
+// against a service template's schema, returning all problems found
+func validateServiceSync(dir string, schema *templateSchema) error {
+	ops := protonOps{}
+	if err := readYAMLFile(filepath.Join(dir, filepath.FromSlash(protonOpsFile)), &ops); err != nil {
+		return err
+	}
+
+	problems := []string{}
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	serviceType, err := schema.inputType(schema.Schema.ServiceInputType)
+	if err != nil {
+		return err
+	}
+	pipelineType, err := schema.inputType(schema.Schema.PipelineInputType)
+	if err != nil {
+		return err
+	}
+
+	//reads a spec file, recording problems if it can't be read
+	readSpec := func(file string) *serviceSpec {
+		var spec serviceSpec
+		if err := readYAMLFile(filepath.Join(dir, filepath.FromSlash(file)), &spec); err != nil {
+			add("%v", err)
+			return nil
+		}
+		if spec.Proton != serviceSpecKind {
+			add("%s: proton must be %s", file, serviceSpecKind)
+		}
+		return &spec
+	}
+
+	if len(ops.ServiceInstances) == 0 {
+		add("service_instances: at least one service instance is required")
+	}
+	seen := map[string]bool{}
+	for i, instance := range ops.ServiceInstances {
+		if instance.Name == "" || instance.Branch == "" || instance.Spec == "" {
+			add("service_instances[%d]: name, branch and spec are required", i)
+			continue
+		}
+		if seen[instance.Name] {
+			add("service_instances[%d]: %s is specified more than once", i, instance.Name)
+		}
+		seen[instance.Name] = true
+
+		spec := readSpec(instance.Spec)
+		if spec == nil {
+			continue
+		}
+		var found *serviceSpecInstance
+		for j := range spec.Instances {
+			if spec.Instances[j].Name == instance.Name {
+				found = &spec.Instances[j]
+			}
+		}
+		if found == nil {
+			add("%s: instance %s is not defined", instance.Spec, instance.Name)
+			continue
+		}
+		if found.Environment == "" {
+			add("%s: instance %s has no environment", instance.Spec, instance.Name)
+		}
+		if serviceType != nil {
+			problems = append(problems, serviceType.validateInputs(found.Spec, instance.Spec+": "+instance.Name+".spec.")...)
+		}
+	}
+
+	if ops.Pipeline != nil {
+		switch {
+		case pipelineType == nil:
+			add("pipeline: the template version has no pipeline")
+		case ops.Pipeline.Branch == "" || ops.Pipeline.Spec == "":
+			add("pipeline: branch and spec are required")
+		default:
+			if spec := readSpec(ops.Pipeline.Spec); spec != nil {
+				problems = append(problems, pipelineType.validateInputs(spec.Pipeline, ops.Pipeline.Spec+": pipeline.")...)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// reads a yaml file into v
+func readYAMLFile(p string, v interface{}) error {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", p, err)
+	}
+	return nil
+}
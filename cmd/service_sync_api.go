@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+//the pinned proton sdk predates the service sync config apis, so they're called
+//directly using proton's json protocol. the types mirror the sdk's so that they
+//can be swapped for proton.GetServiceSyncConfig() etc. once service/proton is bumped
+
+// the proton api's json protocol target prefix
+const protonTargetPrefix = "AwsProton20200720."
+
+// a proton service sync config
+type serviceSyncConfig struct {
+	ServiceName        *string                  `json:"serviceName,omitempty"`
+	RepositoryProvider types.RepositoryProvider `json:"repositoryProvider,omitempty"`
+	RepositoryName     *string                  `json:"repositoryName,omitempty"`
+	Branch             *string                  `json:"branch,omitempty"`
+	FilePath           *string                  `json:"filePath,omitempty"`
+}
+
+type getServiceSyncConfigInput struct {
+	ServiceName *string `json:"serviceName,omitempty"`
+}
+
+type getServiceSyncConfigOutput struct {
+	ServiceSyncConfig *serviceSyncConfig `json:"serviceSyncConfig,omitempty"`
+}
+
+type createServiceSyncConfigInput struct {
+	ServiceName        *string                  `json:"serviceName,omitempty"`
+	RepositoryProvider types.RepositoryProvider `json:"repositoryProvider,omitempty"`
+	RepositoryName     *string                  `json:"repositoryName,omitempty"`
+	Branch             *string                  `json:"branch,omitempty"`
+	FilePath           *string                  `json:"filePath,omitempty"`
+}
+
+type createServiceSyncConfigOutput struct {
+	ServiceSyncConfig *serviceSyncConfig `json:"serviceSyncConfig,omitempty"`
+}
+
+type updateServiceSyncConfigInput struct {
+	ServiceName        *string                  `json:"serviceName,omitempty"`
+	RepositoryProvider types.RepositoryProvider `json:"repositoryProvider,omitempty"`
+	RepositoryName     *string                  `json:"repositoryName,omitempty"`
+	Branch             *string                  `json:"branch,omitempty"`
+	FilePath           *string                  `json:"filePath,omitempty"`
+}
+
+type updateServiceSyncConfigOutput struct {
+	ServiceSyncConfig *serviceSyncConfig `json:"serviceSyncConfig,omitempty"`
+}
+
+// a proton client with the service sync config apis
+type serviceSyncProtonClient struct {
+	*proton.Client
+	cfg      aws.Config
+	endpoint string
+}
+
+func (c *serviceSyncProtonClient) GetServiceSyncConfig(ctx context.Context, in *getServiceSyncConfigInput, optFns ...func(*proton.Options)) (*getServiceSyncConfigOutput, error) {
+	out := &getServiceSyncConfigOutput{}
+	if err := c.invoke(ctx, "GetServiceSyncConfig", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceSyncProtonClient) CreateServiceSyncConfig(ctx context.Context, in *createServiceSyncConfigInput, optFns ...func(*proton.Options)) (*createServiceSyncConfigOutput, error) {
+	out := &createServiceSyncConfigOutput{}
+	if err := c.invoke(ctx, "CreateServiceSyncConfig", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceSyncProtonClient) UpdateServiceSyncConfig(ctx context.Context, in *updateServiceSyncConfigInput, optFns ...func(*proton.Options)) (*updateServiceSyncConfigOutput, error) {
+	out := &updateServiceSyncConfigOutput{}
+	if err := c.invoke(ctx, "UpdateServiceSyncConfig", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sends a signed json protocol request for a proton operation, decoding the response into out
+func (c *serviceSyncProtonClient) invoke(ctx context.Context, operation string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := c.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://proton.%s.amazonaws.com", c.cfg.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", protonTargetPrefix+operation)
+
+	if c.cfg.Credentials != nil {
+		creds, err := c.cfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			return fmt.Errorf("retrieving credentials: %w", err)
+		}
+		hash := sha256.Sum256(body)
+		err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "proton", c.cfg.Region, time.Now())
+		if err != nil {
+			return fmt.Errorf("signing request: %w", err)
+		}
+	}
+
+	var httpClient aws.HTTPClient = http.DefaultClient
+	if c.cfg.HTTPClient != nil {
+		httpClient = c.cfg.HTTPClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return protonJSONError(res, b)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// returns the error for a failed json protocol response, using
+// the sdk's error types so that callers can check them the same way
+func protonJSONError(res *http.Response, body []byte) error {
+	var e struct {
+		Type         string `json:"__type"`
+		Code         string `json:"code"`
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	json.Unmarshal(body, &e)
+
+	code := res.Header.Get("X-Amzn-Errortype")
+	if code == "" {
+		code = e.Type
+	}
+	if code == "" {
+		code = e.Code
+	}
+	//codes can be namespaced and suffixed, for example: aws.proton#ConflictException:http://...
+	if i := strings.LastIndex(code, "#"); i >= 0 {
+		code = code[i+1:]
+	}
+	code, _, _ = strings.Cut(code, ":")
+
+	msg := e.Message
+	if msg == "" {
+		msg = e.MessageUpper
+	}
+	message := aws.String(msg)
+
+	switch code {
+	case "ResourceNotFoundException":
+		return &types.ResourceNotFoundException{Message: message}
+	case "ConflictException":
+		return &types.ConflictException{Message: message}
+	case "ValidationException":
+		return &types.ValidationException{Message: message}
+	case "AccessDeniedException":
+		return &types.AccessDeniedException{Message: message}
+	case "ThrottlingException":
+		return &types.ThrottlingException{Message: message}
+	case "InternalServerException":
+		return &types.InternalServerException{Message: message}
+	case "":
+		return fmt.Errorf("http %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return fmt.Errorf("%s: %s", code, msg)
+}
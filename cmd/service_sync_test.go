@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func TestGenerateServiceSync(t *testing.T) {
+	schema, err := parseTemplateSchema([]byte(testServiceSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, err := parseServiceInstances([]string{"dev=dev-env", "prod=prod-env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	result, err := generateServiceSync(dir, schema, instances, "main", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{protonOpsFile, ".aws/proton/dev.yaml", ".aws/proton/pipeline.yaml", ".aws/proton/prod.yaml"}
+	if strings.Join(result.written, ",") != strings.Join(expected, ",") {
+		t.Errorf("expecting %v to be written, got %v", expected, result.written)
+	}
+	if missing := result.missing[".aws/proton/dev.yaml"]; len(missing) != 1 || missing[0] != "image" {
+		t.Errorf("expecting dev to need an image, got %v", result.missing)
+	}
+
+	//placeholders are valid so the generated files can be pushed as is
+	if err := validateServiceSync(dir, schema); err != nil {
+		t.Errorf("expecting the generated files to be valid, got %v", err)
+	}
+
+	//generating again needs --force
+	if _, err := generateServiceSync(dir, schema, instances, "main", false); !errors.Is(err, errServiceSyncConfig) {
+		t.Errorf("expecting an error generating over proton-ops.yaml, got %v", err)
+	}
+
+	//existing spec files are kept
+	os.Remove(filepath.Join(dir, filepath.FromSlash(protonOpsFile)))
+	os.Remove(filepath.Join(dir, ".aws", "proton", "prod.yaml"))
+	result, err = generateServiceSync(dir, schema, instances, "main", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{".aws/proton/dev.yaml", ".aws/proton/pipeline.yaml"}
+	if strings.Join(result.kept, ",") != strings.Join(expected, ",") {
+		t.Errorf("expecting %v to be kept, got %v", expected, result.kept)
+	}
+	if _, found := result.missing[".aws/proton/dev.yaml"]; found {
+		t.Error("expecting kept spec files not to be reported as missing values")
+	}
+}
+
+func TestValidateServiceSync(t *testing.T) {
+	schema, err := parseTemplateSchema([]byte(testServiceSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{
+		protonOpsFile: `pipeline:
+  branch: main
+  spec: .aws/proton/pipeline.yaml
+service_instances:
+  - name: dev
+    branch: main
+    spec: .aws/proton/dev.yaml
+`,
+		".aws/proton/dev.yaml": `proton: ServiceSpec
+instances:
+  - name: dev
+    environment: dev-env
+    spec:
+      image: nginx
+`,
+		".aws/proton/pipeline.yaml": "proton: ServiceSpec\npipeline:\n  unit_tests: false\n",
+	})
+	if err := validateServiceSync(dir, schema); err != nil {
+		t.Errorf("expecting valid service sync files, got %v", err)
+	}
+
+	internalWriteFiles(t, dir, map[string]string{
+		protonOpsFile: `service_instances:
+  - name: dev
+    branch: main
+    spec: .aws/proton/dev.yaml
+  - name: prod
+    branch: main
+    spec: .aws/proton/prod.yaml
+  - name: test
+    branch: main
+    spec: .aws/proton/dev.yaml
+`,
+		".aws/proton/dev.yaml": `proton: ServiceSpec
+instances:
+  - name: dev
+    spec:
+      image: nginx
+      port: eighty
+`,
+	})
+	err = validateServiceSync(dir, schema)
+	if err == nil {
+		t.Fatal("expecting problems")
+	}
+	for _, expected := range []string{
+		".aws/proton/dev.yaml: instance dev has no environment",
+		".aws/proton/dev.yaml: dev.spec.port: eighty is not a valid integer",
+		"prod.yaml: no such file or directory",
+		".aws/proton/dev.yaml: instance test is not defined",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting %q in:\n%v", expected, err)
+		}
+	}
+}
+
+func TestParseServiceInstances(t *testing.T) {
+	for _, values := range [][]string{{"dev"}, {"=dev-env"}, {"dev=a", "dev=b"}} {
+		if _, err := parseServiceInstances(values); err == nil {
+			t.Error("expecting an error for", values)
+		}
+	}
+}
+
+func TestPutServiceSyncConfig(t *testing.T) {
+	client := newFakeProton()
+	config := &serviceSyncConfig{
+		ServiceName:        aws.String("my-service"),
+		RepositoryProvider: types.RepositoryProviderGithub,
+		RepositoryName:     aws.String("my-org/my-service"),
+		Branch:             aws.String("main"),
+		FilePath:           aws.String(protonOpsFile),
+	}
+
+	//not found creates
+	created, err := putServiceSyncConfig(context.Background(), client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || !internalCalled(client, "CreateServiceSyncConfig") {
+		t.Error("expecting the service sync config to be created")
+	}
+	if c := client.serviceSyncConfigs["my-service"]; c == nil || aws.ToString(c.FilePath) != protonOpsFile {
+		t.Error("unexpected service sync config", c)
+	}
+
+	//found updates
+	config.Branch = aws.String("release")
+	created, err = putServiceSyncConfig(context.Background(), client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || !internalCalled(client, "UpdateServiceSyncConfig") {
+		t.Error("expecting the service sync config to be updated")
+	}
+	if aws.ToString(client.serviceSyncConfigs["my-service"].Branch) != "release" {
+		t.Error("expecting the updated branch, got", client.serviceSyncConfigs["my-service"])
+	}
+}
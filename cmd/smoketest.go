@@ -0,0 +1,468 @@
+package cmd
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// the spec key holding smoke test assertions, removed before the spec is deployed
+const smokeTestSpecKey = "smoke_test"
+
+var (
+	flagSmokeTestSpec                    string
+	flagSmokeTestName                    string
+	flagSmokeTestJUnit                   string
+	flagSmokeTestTimeout                 time.Duration
+	flagSmokeTestProtonRoleArn           string
+	flagSmokeTestCodebuildRoleArn        string
+	flagSmokeTestRepositoryConnectionArn string
+	flagSmokeTestRepositoryID            string
+	flagSmokeTestBranch                  string
+)
+
+var smokeTestCmd = &cobra.Command{
+	Use:   "smoke-test <template dir>",
+	Short: "Publishes a draft template version, deploys it and checks its outputs",
+	Long: `Runs an end-to-end smoke test of a template before it's released:
+
+1. validates the spec against the template's schema/schema.yaml
+2. publishes the template as a new DRAFT minor version
+3. deploys a throwaway environment, or a service with the spec's instances, from the draft
+4. checks the outputs declared under ` + smokeTestSpecKey + `.outputs in the spec
+5. destroys what was deployed and deletes the draft
+
+Teardown always runs, even if a step fails or the smoke test is interrupted.
+Results are written as a JUnit report.
+
+Expected outputs are regular expressions that must match the whole output value.
+For service templates they're checked on each service instance.
+
+  proton: EnvironmentSpec
+  spec:
+    vpc_cidr: 10.0.0.0/16
+  ` + smokeTestSpecKey + `:
+    outputs:
+      VpcId: vpc-.+`,
+	Args: cobra.ExactArgs(1),
+	Run:  doSmokeTest,
+	Example: `
+# Smoke test an environment template
+protonizer smoke-test my_env_template/v1 --spec example-spec.yaml \
+  --proton-role-arn arn:aws:iam::123456789012:role/ProtonServiceRole
+
+# Smoke test a service template, writing the report for ci
+protonizer smoke-test my_svc_template/v1 --spec example-spec.yaml --junit reports/smoke-test.xml`,
+}
+
+// a smoke test step and its outcome
+type smokeTestStep struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+	Skipped  bool
+}
+
+// the smoke test outcome of a step
+func (s *smokeTestStep) status() string {
+	switch {
+	case s.Skipped:
+		return "SKIPPED"
+	case s.Err != nil:
+		return "FAILED"
+	}
+	return "PASSED"
+}
+
+// settings for a smoke test
+type smokeTestOptions struct {
+	//the name of the throwaway environment or service
+	name string
+
+	protonRoleArn           string
+	codebuildRoleArn        string
+	repositoryConnectionArn string
+	repositoryID            string
+	branch                  string
+
+	publish publishOptions
+
+	//how long teardown can take once the smoke test is done or interrupted
+	teardownTimeout time.Duration
+
+	pollInitial time.Duration
+	pollMax     time.Duration
+}
+
+func init() {
+	smokeTestCmd.Flags().StringVarP(&flagSmokeTestSpec, "spec", "s", "",
+		"The spec file to deploy (required)")
+	smokeTestCmd.Flags().StringVarP(&flagSmokeTestName, "name", "n", "",
+		"The name of the throwaway environment or service. Defaults to smoke-<template>-<timestamp>")
+	smokeTestCmd.Flags().StringVar(&flagSmokeTestJUnit, "junit", "smoke-test.xml",
+		"The file to write the JUnit report to")
+	smokeTestCmd.Flags().DurationVar(&flagSmokeTestTimeout, "timeout", 60*time.Minute,
+		"How long to wait for each of publishing, deploying and tearing down")
+	smokeTestCmd.Flags().StringVar(&flagSmokeTestProtonRoleArn, "proton-role-arn", "",
+		"The IAM role proton uses to provision environments")
+	smokeTestCmd.Flags().StringVar(&flagSmokeTestCodebuildRoleArn, "codebuild-role-arn", "",
+		"The IAM role used for CodeBuild provisioning of environments")
+	smokeTestCmd.Flags().StringVar(&flagSmokeTestRepositoryConnectionArn, "repository-connection-arn", "",
+		"The CodeStar connection for the service's source repository. Required for templates with a pipeline")
+	smokeTestCmd.Flags().StringVar(&flagSmokeTestRepositoryID, "repository-id", "",
+		"The service's source repository, in the format owner/repo. Required for templates with a pipeline")
+	smokeTestCmd.Flags().StringVar(&flagSmokeTestBranch, "branch", "",
+		"The branch of the service's source repository. Required for templates with a pipeline")
+	smokeTestCmd.MarkFlagRequired("spec")
+	rootCmd.AddCommand(smokeTestCmd)
+}
+
+func doSmokeTest(cmd *cobra.Command, args []string) {
+	dir := args[0]
+	protonConfig, _ := readPublishConfig(filepath.Join(dir, "proton.yaml"))
+	spec, err := os.ReadFile(flagSmokeTestSpec)
+	handleError("reading spec", err)
+
+	cfg := getAWSConfig()
+	opts := smokeTestOptions{
+		name:                    flagSmokeTestName,
+		protonRoleArn:           flagSmokeTestProtonRoleArn,
+		codebuildRoleArn:        flagSmokeTestCodebuildRoleArn,
+		repositoryConnectionArn: flagSmokeTestRepositoryConnectionArn,
+		repositoryID:            flagSmokeTestRepositoryID,
+		branch:                  flagSmokeTestBranch,
+		publish: publishOptions{
+			region:  cfg.Region,
+			draft:   true,
+			force:   true,
+			timeout: flagSmokeTestTimeout,
+		},
+		teardownTimeout: flagSmokeTestTimeout,
+		pollInitial:     pollInitialDelay,
+		pollMax:         pollMaxDelay,
+	}
+	if opts.name == "" {
+		opts.name = fmt.Sprintf("smoke-%s-%d", protonConfig.Name, time.Now().Unix())
+	}
+
+	//ctrl-c stops the smoke test but not its teardown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, flagSmokeTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	steps := runSmokeTest(ctx, newProtonClient(cfg), newS3Client(cfg), protonConfig, dir, spec, opts, os.Stdout)
+
+	fmt.Println()
+	failed := printSmokeTestSummary(os.Stdout, steps)
+	err = writeJUnitReport(flagSmokeTestJUnit, protonConfig.Name, steps, time.Since(start))
+	handleError("writing junit report", err)
+	fmt.Println("wrote", flagSmokeTestJUnit)
+	if failed > 0 {
+		errorExit(fmt.Sprintf("smoke test failed: %d of %d steps didn't pass", failed, len(steps)))
+	}
+}
+
+// runs the smoke test steps in order. once a step fails the remaining steps are skipped,
+// except for teardown, which runs with its own context so that it isn't cut short
+// when ctx is cancelled
+func runSmokeTest(ctx context.Context, protonClient ProtonAPI, s3Client S3API, protonConfig *protonConfigData,
+	dir string, specFile []byte, opts smokeTestOptions, w io.Writer) []*smokeTestStep {
+
+	steps := []*smokeTestStep{}
+	failed := false
+	run := func(name string, skip bool, f func() error) *smokeTestStep {
+		step := &smokeTestStep{Name: name, Skipped: skip}
+		steps = append(steps, step)
+		if skip {
+			fmt.Fprintln(w, "skipping", name)
+			return step
+		}
+		fmt.Fprintln(w, name)
+		start := time.Now()
+		step.Err = f()
+		step.Duration = time.Since(start)
+		if step.Err != nil {
+			fmt.Fprintf(w, "%s failed: %v\n", name, step.Err)
+		}
+		return step
+	}
+
+	kind, err := kindFor(protonConfig.Type)
+	if err != nil {
+		return []*smokeTestStep{{Name: "validate spec", Err: err}}
+	}
+
+	var spec []byte
+	var expected map[string]string
+	hasPipeline := false
+	step := run("validate spec", false, func() error {
+		var err error
+		spec, expected, err = parseSmokeTestSpec(specFile)
+		if err != nil {
+			return err
+		}
+		schema, err := readLocalSchema(dir)
+		if err != nil {
+			return err
+		}
+		if protonConfig.Type == "environment" {
+			return validateEnvironmentSpec(schema, spec)
+		}
+		hasPipeline = schema.Schema.PipelineInputType != ""
+		if hasPipeline && (opts.repositoryConnectionArn == "" || opts.repositoryID == "" || opts.branch == "") {
+			return errors.New("the template has a pipeline. --repository-connection-arn, --repository-id and --branch are required")
+		}
+		return validateServiceSpec(schema, spec)
+	})
+	failed = step.Err != nil
+
+	var result *publishResult
+	step = run("publish draft", failed, func() error {
+		var err error
+		result, err = publishTemplateDir(ctx, protonClient, s3Client, protonConfig, dir, opts.publish)
+		if err == nil {
+			fmt.Fprintf(w, "published draft %s:%s \n", protonConfig.Name, result.version())
+		}
+		return err
+	})
+	published := step.Err == nil && !step.Skipped
+	failed = failed || step.Err != nil
+
+	//only tear down what the smoke test created, never an existing deployment with the same name
+	var outputs []deploymentOutputs
+	deployed := false
+	step = run("deploy", failed, func() error {
+		var err error
+		outputs, deployed, err = deploySmokeTest(ctx, protonClient, protonConfig, result, spec, hasPipeline, opts, w)
+		return err
+	})
+	failed = failed || step.Err != nil
+
+	run("check outputs", failed, func() error {
+		return checkSmokeTestOutputs(outputs, expected)
+	})
+
+	//teardown
+	teardownCtx, cancel := context.WithTimeout(context.Background(), opts.teardownTimeout)
+	defer cancel()
+	run("destroy", !deployed, func() error {
+		var err error
+		if protonConfig.Type == "environment" {
+			err = destroyEnvironment(teardownCtx, protonClient, opts.name, opts.pollInitial, opts.pollMax, w)
+		} else {
+			err = destroyService(teardownCtx, protonClient, opts.name, opts.pollInitial, opts.pollMax, w)
+		}
+
+		//nothing to destroy if the deployment wasn't created
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	run("delete draft", !published, func() error {
+		return kind.deleteVersion(teardownCtx, protonClient, protonConfig.Name, result.MajorVersion, result.MinorVersion)
+	})
+
+	return steps
+}
+
+// deploys the throwaway environment or service from the published draft,
+// returning its outputs and whether it was created
+func deploySmokeTest(ctx context.Context, protonClient ProtonAPI, protonConfig *protonConfigData,
+	result *publishResult, spec []byte, hasPipeline bool, opts smokeTestOptions, w io.Writer) ([]deploymentOutputs, bool, error) {
+
+	if protonConfig.Type == "environment" {
+		return deployEnvironment(ctx, protonClient, &proton.CreateEnvironmentInput{
+			Name:                 aws.String(opts.name),
+			TemplateName:         aws.String(protonConfig.Name),
+			TemplateMajorVersion: aws.String(result.MajorVersion),
+			TemplateMinorVersion: aws.String(result.MinorVersion),
+			Spec:                 aws.String(string(spec)),
+			ProtonServiceRoleArn: optionalString(opts.protonRoleArn),
+			CodebuildRoleArn:     optionalString(opts.codebuildRoleArn),
+		}, opts.pollInitial, opts.pollMax, w)
+	}
+	in := &proton.CreateServiceInput{
+		Name:                 aws.String(opts.name),
+		TemplateName:         aws.String(protonConfig.Name),
+		TemplateMajorVersion: aws.String(result.MajorVersion),
+		TemplateMinorVersion: aws.String(result.MinorVersion),
+		Spec:                 aws.String(string(spec)),
+	}
+
+	//the source repository is only used by the pipeline
+	if hasPipeline {
+		in.RepositoryConnectionArn = aws.String(opts.repositoryConnectionArn)
+		in.RepositoryId = aws.String(opts.repositoryID)
+		in.BranchName = aws.String(opts.branch)
+	}
+	return deployService(ctx, protonClient, in, hasPipeline, opts.pollInitial, opts.pollMax, w)
+}
+
+// removes the smoke test assertions from a spec file, returning
+// the spec to deploy and the expected outputs
+func parseSmokeTestSpec(b []byte) ([]byte, map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing spec: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, errors.New("spec must be a yaml mapping")
+	}
+
+	root := doc.Content[0]
+	var assertions struct {
+		Outputs map[string]string `yaml:"outputs"`
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != smokeTestSpecKey {
+			continue
+		}
+		if err := root.Content[i+1].Decode(&assertions); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", smokeTestSpecKey, err)
+		}
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		break
+	}
+	for name, pattern := range assertions.Outputs {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, nil, fmt.Errorf("%s.outputs.%s: %w", smokeTestSpecKey, name, err)
+		}
+	}
+
+	spec, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return spec, assertions.Outputs, nil
+}
+
+// checks that each environment or service instance has the expected outputs,
+// returning all mismatches
+func checkSmokeTestOutputs(deployments []deploymentOutputs, expected map[string]string) error {
+	names := []string{}
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := []string{}
+	for _, d := range deployments {
+		if strings.HasSuffix(d.Name, " pipeline") {
+			continue
+		}
+		actual := map[string]string{}
+		for _, o := range d.Outputs {
+			actual[aws.ToString(o.Key)] = aws.ToString(o.ValueString)
+		}
+		for _, name := range names {
+			value, found := actual[name]
+			if !found {
+				problems = append(problems, fmt.Sprintf("%s: output %s is missing", d.Name, name))
+				continue
+			}
+			if !regexp.MustCompile("^(?:" + expected[name] + ")$").MatchString(value) {
+				problems = append(problems, fmt.Sprintf("%s: output %s is %q, expecting it to match %q", d.Name, name, value, expected[name]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// prints a table of step outcomes, returning the number of steps that didn't pass
+func printSmokeTestSummary(w io.Writer, steps []*smokeTestStep) int {
+	tw := newTableWriter(w)
+	fmt.Fprintln(tw, "STEP\tSTATUS\tDURATION")
+	failed := 0
+	for _, s := range steps {
+		if s.status() != "PASSED" {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.status(), s.Duration.Round(time.Second))
+	}
+	tw.Flush()
+	return failed
+}
+
+// a junit xml report with one test case per step
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// writes smoke test steps as a junit xml report
+func writeJUnitReport(file, template string, steps []*smokeTestStep, duration time.Duration) error {
+	seconds := func(d time.Duration) string {
+		return fmt.Sprintf("%.3f", d.Seconds())
+	}
+	suite := junitTestSuite{
+		Name:  "smoke-test " + template,
+		Tests: len(steps),
+		Time:  seconds(duration),
+	}
+	for _, s := range steps {
+		tc := junitTestCase{Name: s.Name, ClassName: template, Time: seconds(s.Duration)}
+		switch {
+		case s.Skipped:
+			suite.Skipped++
+			tc.Skipped = &junitSkipped{Message: "a previous step failed"}
+		case s.Err != nil:
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: firstLine(s.Err.Error()), Text: s.Err.Error()}
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	b, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(file); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(file, append([]byte(xml.Header), append(b, '\n')...), 0644)
+}
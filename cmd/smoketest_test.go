@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+const testSmokeTestSpec = `proton: EnvironmentSpec
+spec:
+  azs: 2
+smoke_test:
+  outputs:
+    VpcId: vpc-[0-9a-f]+
+`
+
+// runs a smoke test of an environment template against fakes
+func internalRunSmokeTest(t *testing.T, protonClient *fakeProton, spec string) []*smokeTestStep {
+	t.Helper()
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{"schema/schema.yaml": testEnvironmentSchema})
+	config := &protonConfigData{Name: "vpc", Type: "environment", PublishBucket: "my-bucket"}
+	opts := smokeTestOptions{
+		name:            "smoke-vpc",
+		publish:         publishOptions{region: "us-east-1", draft: true, force: true, timeout: time.Minute},
+		teardownTimeout: time.Minute,
+		pollInitial:     time.Millisecond,
+		pollMax:         time.Millisecond,
+	}
+	var out bytes.Buffer
+	return runSmokeTest(context.Background(), protonClient, newFakeS3(), config, dir, []byte(spec), opts, &out)
+}
+
+// returns the status of each step as name=status
+func internalSmokeTestStatuses(steps []*smokeTestStep) string {
+	statuses := []string{}
+	for _, s := range steps {
+		statuses = append(statuses, s.Name+"="+s.status())
+	}
+	return strings.Join(statuses, ",")
+}
+
+func TestRunSmokeTest(t *testing.T) {
+	protonClient := newFakeProton()
+	protonClient.outputs["smoke-vpc"] = []types.Output{{Key: aws.String("VpcId"), ValueString: aws.String("vpc-0abc")}}
+
+	steps := internalRunSmokeTest(t, protonClient, testSmokeTestSpec)
+	expected := "validate spec=PASSED,publish draft=PASSED,deploy=PASSED,check outputs=PASSED,destroy=PASSED,delete draft=PASSED"
+	if actual := internalSmokeTestStatuses(steps); actual != expected {
+		t.Errorf("expecting %s, got %s", expected, actual)
+	}
+	if n := len(protonClient.versions[fakeKey("environment", "vpc")]); n != 0 {
+		t.Errorf("expecting the draft to be deleted, %d versions remaining", n)
+	}
+	if _, err := protonClient.getDeployment(protonClient.environments, "", "smoke-vpc"); !isNotFound(err) {
+		t.Errorf("expecting the environment to be destroyed, got %v", err)
+	}
+}
+
+func TestRunSmokeTestFailures(t *testing.T) {
+	//outputs that don't match still tear down
+	protonClient := newFakeProton()
+	protonClient.outputs["smoke-vpc"] = []types.Output{{Key: aws.String("VpcId"), ValueString: aws.String("none")}}
+	steps := internalRunSmokeTest(t, protonClient, testSmokeTestSpec)
+	expected := "validate spec=PASSED,publish draft=PASSED,deploy=PASSED,check outputs=FAILED,destroy=PASSED,delete draft=PASSED"
+	if actual := internalSmokeTestStatuses(steps); actual != expected {
+		t.Errorf("expecting %s, got %s", expected, actual)
+	}
+
+	//a failed deployment skips the output checks but still tears down
+	protonClient = newFakeProton()
+	protonClient.deploymentFailures["smoke-vpc"] = "stack rollback"
+	steps = internalRunSmokeTest(t, protonClient, testSmokeTestSpec)
+	expected = "validate spec=PASSED,publish draft=PASSED,deploy=FAILED,check outputs=SKIPPED,destroy=PASSED,delete draft=PASSED"
+	if actual := internalSmokeTestStatuses(steps); actual != expected {
+		t.Errorf("expecting %s, got %s", expected, actual)
+	}
+	if !errors.Is(steps[2].Err, errDeploymentFailed) {
+		t.Errorf("expecting a failed deployment, got %v", steps[2].Err)
+	}
+
+	//an environment that already exists with the smoke test's name is left alone
+	protonClient = newFakeProton()
+	protonClient.addEnvironment("smoke-vpc", "other", "1", "0")
+	steps = internalRunSmokeTest(t, protonClient, testSmokeTestSpec)
+	expected = "validate spec=PASSED,publish draft=PASSED,deploy=FAILED,check outputs=SKIPPED,destroy=SKIPPED,delete draft=PASSED"
+	if actual := internalSmokeTestStatuses(steps); actual != expected {
+		t.Errorf("expecting %s, got %s", expected, actual)
+	}
+	var conflict *types.ConflictException
+	if !errors.As(steps[2].Err, &conflict) {
+		t.Errorf("expecting a conflict, got %v", steps[2].Err)
+	}
+	if internalCalled(protonClient, "DeleteEnvironment") {
+		t.Errorf("not expecting the existing environment to be deleted, got %v", protonClient.calls)
+	}
+	if _, err := protonClient.getDeployment(protonClient.environments, "", "smoke-vpc"); err != nil {
+		t.Errorf("expecting the existing environment to remain, got %v", err)
+	}
+
+	//an invalid spec publishes and deploys nothing
+	protonClient = newFakeProton()
+	steps = internalRunSmokeTest(t, protonClient, "proton: EnvironmentSpec\nspec: {}\n")
+	expected = "validate spec=FAILED,publish draft=SKIPPED,deploy=SKIPPED,check outputs=SKIPPED,destroy=SKIPPED,delete draft=SKIPPED"
+	if actual := internalSmokeTestStatuses(steps); actual != expected {
+		t.Errorf("expecting %s, got %s", expected, actual)
+	}
+	if len(protonClient.calls) != 0 {
+		t.Errorf("expecting no api calls, got %v", protonClient.calls)
+	}
+}
+
+func TestRunSmokeTestServicePipeline(t *testing.T) {
+	dir := t.TempDir()
+	internalWriteFiles(t, dir, map[string]string{"schema/schema.yaml": testServiceSchema})
+	config := &protonConfigData{Name: "api", Type: "service", PublishBucket: "my-bucket", CompatibleEnvironments: []string{"vpc:1"}}
+	spec := "proton: ServiceSpec\ninstances:\n  - name: dev\n    environment: dev-env\n    spec:\n      image: nginx\n"
+	opts := smokeTestOptions{
+		name:            "smoke-api",
+		publish:         publishOptions{region: "us-east-1", draft: true, force: true, timeout: time.Minute},
+		teardownTimeout: time.Minute,
+		pollInitial:     time.Millisecond,
+		pollMax:         time.Millisecond,
+	}
+	var out bytes.Buffer
+
+	//templates with a pipeline need its source repository
+	protonClient := newFakeProton()
+	steps := runSmokeTest(context.Background(), protonClient, newFakeS3(), config, dir, []byte(spec), opts, &out)
+	if !strings.HasPrefix(internalSmokeTestStatuses(steps), "validate spec=FAILED") {
+		t.Errorf("expecting the missing repository to fail validation, got %s", internalSmokeTestStatuses(steps))
+	}
+
+	//the pipeline's deployment is waited for
+	opts.repositoryConnectionArn = "arn:aws:codestar-connections:us-east-1:123456789012:connection/abc"
+	opts.repositoryID = "my-org/api"
+	opts.branch = "main"
+	protonClient = newFakeProton()
+	protonClient.deploymentFailures["smoke-api/pipeline"] = "build failed"
+	steps = runSmokeTest(context.Background(), protonClient, newFakeS3(), config, dir, []byte(spec), opts, &out)
+	expected := "validate spec=PASSED,publish draft=PASSED,deploy=FAILED,check outputs=SKIPPED,destroy=PASSED,delete draft=PASSED"
+	if actual := internalSmokeTestStatuses(steps); actual != expected {
+		t.Errorf("expecting %s, got %s", expected, actual)
+	}
+	if !errors.Is(steps[2].Err, errDeploymentFailed) || !strings.Contains(steps[2].Err.Error(), "build failed") {
+		t.Errorf("expecting the pipeline deployment to fail, got %v", steps[2].Err)
+	}
+
+	//templates without a pipeline aren't given the repository
+	protonClient = newFakeProton()
+	result := &publishResult{MajorVersion: "1", MinorVersion: "0"}
+	outputs, _, err := deploySmokeTest(context.Background(), protonClient, config, result, []byte(spec), false, opts, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := protonClient.services["smoke-api"]; s == nil || s.Pipeline != nil {
+		t.Errorf("expecting a service without a pipeline, got %+v", s)
+	}
+	if len(outputs) != 1 || outputs[0].Name != "smoke-api/dev" {
+		t.Errorf("expecting only the instance's outputs, got %v", outputs)
+	}
+}
+
+func TestParseSmokeTestSpec(t *testing.T) {
+	spec, expected, err := parseSmokeTestSpec([]byte(testSmokeTestSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(spec), smokeTestSpecKey) || !strings.Contains(string(spec), "azs: 2") {
+		t.Errorf("expecting the assertions to be removed from:\n%s", spec)
+	}
+	if expected["VpcId"] != "vpc-[0-9a-f]+" {
+		t.Errorf("unexpected expected outputs %v", expected)
+	}
+
+	if _, _, err := parseSmokeTestSpec([]byte("smoke_test:\n  outputs:\n    VpcId: \"[\"\n")); err == nil {
+		t.Error("expecting an error for an invalid pattern")
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "reports", "smoke-test.xml")
+	steps := []*smokeTestStep{
+		{Name: "deploy", Duration: 1500 * time.Millisecond, Err: errors.New("deployment failed\nmore detail")},
+		{Name: "check outputs", Skipped: true},
+		{Name: "destroy", Duration: time.Second},
+	}
+	if err := writeJUnitReport(file, "vpc", steps, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(b, &suite); err != nil {
+		t.Fatal(err)
+	}
+	if suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 || suite.Time != "3.000" {
+		t.Errorf("unexpected suite %+v", suite)
+	}
+	failure := suite.TestCases[0].Failure
+	if failure == nil || failure.Message != "deployment failed" || suite.TestCases[0].Time != "1.500" {
+		t.Errorf("unexpected failed test case %+v", suite.TestCases[0])
+	}
+}
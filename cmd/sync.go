@@ -0,0 +1,519 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// file template sync reads service template compatible environments from
+const templateRegistrationFile = ".template-registration.yaml"
+
+// matches the major version directories that template sync picks up
+var syncVersionDirPattern = regexp.MustCompile(`^v[0-9]+$`)
+
+var (
+	flagSyncRepo          string
+	flagSyncProvider      string
+	flagSyncBranch        string
+	flagSyncSubdirectory  string
+	flagSyncConnectionArn string
+	flagSyncDir           string
+	flagSyncMajorVersion  string
+	flagSyncOutput        string
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Manages proton template sync",
+	Long: `Manages proton template sync, which publishes template versions
+when changes are pushed to a git repository.`,
+}
+
+var syncEnableCmd = &cobra.Command{
+	Use:   "enable <template>",
+	Short: "Enables template sync for a template",
+	Long: `Enables template sync for a template, or updates its sync settings.
+
+The local repository layout is validated first to make sure template sync will pick up
+the template: <subdirectory>/<template>/v<major>/ directories must contain schema/schema.yaml
+and an infrastructure manifest, and service templates need a ` + templateRegistrationFile + `
+listing their compatible environments.
+
+The repository must be linked to proton. Use --connection-arn to link it
+using a CodeStar connection if it isn't.`,
+	Args: cobra.ExactArgs(1),
+	Run:  doSyncEnable,
+	Example: `
+# Sync a template from the main branch of a GitHub repository
+protonizer sync enable my_template --repo my-org/proton-templates --branch main
+
+# Sync from a subdirectory, linking the repository first
+protonizer sync enable my_template --repo my-org/platform --subdirectory templates \
+  --connection-arn arn:aws:codestar-connections:us-east-1:123456789012:connection/abc`,
+}
+
+var syncStatusCmd = &cobra.Command{
+	Use:   "status <template>",
+	Short: "Shows a template's sync settings and most recent sync attempts",
+	Args:  cobra.ExactArgs(1),
+	Run:   doSyncStatus,
+	Example: `
+# Show the sync status of a template's recommended major version
+protonizer sync status my_template
+
+# Show the sync status of major version 2 as json
+protonizer sync status my_template --major-version 2 -o json`,
+}
+
+// a template's sync settings and most recent sync attempts
+type templateSyncStatus struct {
+	Template     string `json:"template" yaml:"template"`
+	Type         string `json:"type" yaml:"type"`
+	MajorVersion string `json:"majorVersion" yaml:"majorVersion"`
+	Provider     string `json:"provider" yaml:"provider"`
+	Repository   string `json:"repository" yaml:"repository"`
+	Branch       string `json:"branch" yaml:"branch"`
+	Subdirectory string `json:"subdirectory,omitempty" yaml:"subdirectory,omitempty"`
+
+	DesiredCommit        string       `json:"desiredCommit,omitempty" yaml:"desiredCommit,omitempty"`
+	LatestSync           *syncAttempt `json:"latestSync,omitempty" yaml:"latestSync,omitempty"`
+	LatestSuccessfulSync *syncAttempt `json:"latestSuccessfulSync,omitempty" yaml:"latestSuccessfulSync,omitempty"`
+}
+
+type syncAttempt struct {
+	Status    types.ResourceSyncStatus `json:"status" yaml:"status"`
+	StartedAt time.Time                `json:"startedAt" yaml:"startedAt"`
+	Target    string                   `json:"target,omitempty" yaml:"target,omitempty"`
+	Commit    string                   `json:"commit,omitempty" yaml:"commit,omitempty"`
+	Events    []syncEvent              `json:"events,omitempty" yaml:"events,omitempty"`
+}
+
+type syncEvent struct {
+	Time  time.Time `json:"time" yaml:"time"`
+	Type  string    `json:"type" yaml:"type"`
+	Event string    `json:"event" yaml:"event"`
+}
+
+func init() {
+	syncEnableCmd.Flags().StringVar(&flagSyncRepo, "repo", "",
+		"The repository to sync from, in the format owner/repo (required)")
+	syncEnableCmd.Flags().StringVar(&flagSyncProvider, "provider", string(types.RepositoryProviderGithub),
+		"The repository provider: GITHUB, GITHUB_ENTERPRISE or BITBUCKET")
+	syncEnableCmd.Flags().StringVar(&flagSyncBranch, "branch", "main",
+		"The branch to sync from")
+	syncEnableCmd.Flags().StringVar(&flagSyncSubdirectory, "subdirectory", "",
+		"The repository directory containing the template directory. Defaults to the repository root")
+	syncEnableCmd.Flags().StringVar(&flagSyncConnectionArn, "connection-arn", "",
+		"A CodeStar connection ARN used to link the repository to proton if it isn't linked")
+	syncEnableCmd.Flags().StringVar(&flagSyncDir, "dir", ".",
+		"The local clone of the repository, used to validate the template layout")
+	syncEnableCmd.MarkFlagRequired("repo")
+
+	syncStatusCmd.Flags().StringVar(&flagSyncMajorVersion, "major-version", "",
+		"The major version to show sync attempts for. Defaults to the recommended major version")
+	syncStatusCmd.Flags().StringVarP(&flagSyncOutput, "output", "o", outputTable,
+		"The output format: table, json or yaml")
+
+	syncCmd.AddCommand(syncEnableCmd)
+	syncCmd.AddCommand(syncStatusCmd)
+	rootCmd.AddCommand(syncCmd)
+}
+
+func doSyncEnable(cmd *cobra.Command, args []string) {
+	name := args[0]
+	provider, err := parseRepositoryProvider(flagSyncProvider)
+	if err != nil {
+		errorExit(err)
+	}
+	if !strings.Contains(flagSyncRepo, "/") {
+		errorExit(fmt.Sprintf("repo: %q must use the format: owner/repo", flagSyncRepo))
+	}
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	protonClient := newProtonClient(cfg)
+
+	//template sync publishes versions of an existing template
+	template, _, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		if errors.Is(err, errTemplateNotFound) {
+			errorExit(fmt.Sprintf("%v. template sync requires the template to exist. create it with publish first", err))
+		}
+		handleError("getting template", err)
+	}
+
+	root := filepath.Join(flagSyncDir, filepath.FromSlash(flagSyncSubdirectory))
+	if err := validateSyncLayout(root, name, template.Type); err != nil {
+		errorExit(fmt.Sprintf("template sync won't pick up %s from %s:\n%v", name, root, err))
+	}
+
+	err = ensureRepository(ctx, protonClient, provider, flagSyncRepo, flagSyncConnectionArn)
+	if err != nil {
+		if errors.Is(err, errRepositoryNotLinked) {
+			errorExit(err)
+		}
+		handleError("linking repository", err)
+	}
+
+	created, err := putTemplateSyncConfig(ctx, protonClient, &types.TemplateSyncConfig{
+		TemplateName:       aws.String(name),
+		TemplateType:       syncTemplateType(template.Type),
+		RepositoryProvider: provider,
+		RepositoryName:     aws.String(flagSyncRepo),
+		Branch:             aws.String(flagSyncBranch),
+		Subdirectory:       optionalString(flagSyncSubdirectory),
+	})
+	handleError("configuring template sync", err)
+
+	action := "updated"
+	if created {
+		action = "enabled"
+	}
+	fmt.Printf("%s template sync for %s from %s %s (%s) \n", action, name, provider, flagSyncRepo, flagSyncBranch)
+}
+
+func doSyncStatus(cmd *cobra.Command, args []string) {
+	if err := validateOutputFormat(flagSyncOutput); err != nil {
+		errorExit(err)
+	}
+	name := args[0]
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	protonClient := newProtonClient(cfg)
+
+	template, _, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		if errors.Is(err, errTemplateNotFound) {
+			errorExit(err)
+		}
+		handleError("getting template", err)
+	}
+
+	major := flagSyncMajorVersion
+	if major == "" {
+		major = "1"
+		if template.RecommendedVersion != "" {
+			major, _, _ = strings.Cut(template.RecommendedVersion, ".")
+		}
+	}
+
+	status, err := getTemplateSyncStatus(ctx, protonClient, template, major)
+	if err != nil {
+		if isNotFound(err) {
+			errorExit(fmt.Sprintf("template sync is not enabled for %s. use sync enable to enable it", name))
+		}
+		handleError("getting template sync status", err)
+	}
+	err = printTemplateSyncStatus(os.Stdout, status, flagSyncOutput)
+	handleError("printing template sync status", err)
+}
+
+// returns the proton template type for a protonizer template type
+func syncTemplateType(templateType string) types.TemplateType {
+	return types.TemplateType(strings.ToUpper(templateType))
+}
+
+// parses a repository provider, ignoring case
+func parseRepositoryProvider(s string) (types.RepositoryProvider, error) {
+	for _, p := range types.RepositoryProviderGithub.Values() {
+		if strings.EqualFold(s, string(p)) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("provider: %q is invalid. only GITHUB, GITHUB_ENTERPRISE and BITBUCKET are supported", s)
+}
+
+// checks that template sync will find the template's major version directories
+// under root, returning all problems found
+func validateSyncLayout(root, name, templateType string) error {
+	dir := filepath.Join(root, name)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("template directory %s not found. template sync expects <subdirectory>/%s/v<major>/", dir, name)
+	}
+
+	problems := []string{}
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	versions := 0
+	for _, e := range entries {
+		if !e.IsDir() || !syncVersionDirPattern.MatchString(e.Name()) {
+			continue
+		}
+		versions++
+		versionDir := filepath.Join(dir, e.Name())
+		rel := name + "/" + e.Name()
+
+		required := []string{
+			"schema/schema.yaml",
+			getInfrastructureDirectory(templateType) + "/manifest.yaml",
+		}
+		for _, file := range required {
+			if _, err := os.Stat(filepath.Join(versionDir, filepath.FromSlash(file))); err != nil {
+				add("%s: %s is missing", rel, file)
+			}
+		}
+
+		//proton.yaml is ignored by template sync, but shouldn't disagree with it
+		config, err := readProtonConfigFile(filepath.Join(versionDir, "proton.yaml"))
+		if err == nil && (config.Name != name || config.Type != templateType) {
+			add("%s: proton.yaml is for %s template %s, not %s template %s", rel, config.Type, config.Name, templateType, name)
+		}
+
+		if templateType == "service" {
+			compatible, err := readTemplateRegistration(filepath.Join(versionDir, templateRegistrationFile))
+			switch {
+			case err != nil && os.IsNotExist(err):
+				hint := "compatible_environments:\n    - <environment template>:<major version>"
+				if config != nil && len(config.CompatibleEnvironments) > 0 {
+					hint = "compatible_environments:\n    - " + strings.Join(config.CompatibleEnvironments, "\n    - ")
+				}
+				add("%s: %s is required for service templates, for example:\n  %s", rel, templateRegistrationFile, hint)
+			case err != nil:
+				add("%s: %s: %v", rel, templateRegistrationFile, err)
+			case len(compatible) == 0:
+				add("%s: %s: compatible_environments is empty", rel, templateRegistrationFile)
+			default:
+				if _, err := parseCompatibleEnvironments(compatible); err != nil {
+					add("%s: %s: %v", rel, templateRegistrationFile, err)
+				}
+			}
+		}
+	}
+	if versions == 0 {
+		add("%s: no v<major> directories found", name)
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// reads proton.yaml without validating it
+func readProtonConfigFile(file string) (*protonConfigData, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c protonConfigData
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// reads the compatible environments from a template registration file
+func readTemplateRegistration(file string) ([]string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var registration struct {
+		CompatibleEnvironments []string `yaml:"compatible_environments"`
+	}
+	if err := yaml.Unmarshal(b, &registration); err != nil {
+		return nil, err
+	}
+	return registration.CompatibleEnvironments, nil
+}
+
+// returned when a repository isn't linked to proton and can't be linked
+var errRepositoryNotLinked = errors.New("repository is not linked to proton")
+
+// makes sure a repository is linked to proton, linking it
+// with a connection if one is provided
+func ensureRepository(ctx context.Context, protonClient ProtonAPI,
+	provider types.RepositoryProvider, name, connectionArn string) error {
+
+	m := "proton.GetRepository()"
+	debug(m)
+	_, err := protonClient.GetRepository(ctx, &proton.GetRepositoryInput{
+		Name:     &name,
+		Provider: provider,
+	})
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	if connectionArn == "" {
+		return fmt.Errorf("%w: %s %s. use --connection-arn to link it", errRepositoryNotLinked, provider, name)
+	}
+
+	m = "proton.CreateRepository()"
+	debug(m)
+	_, err = protonClient.CreateRepository(ctx, &proton.CreateRepositoryInput{
+		Name:          &name,
+		Provider:      provider,
+		ConnectionArn: &connectionArn,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", m, err)
+	}
+	fmt.Printf("linked repository %s %s \n", provider, name)
+	return nil
+}
+
+// creates or updates a template sync config, returning true if it was created
+func putTemplateSyncConfig(ctx context.Context, protonClient ProtonAPI, config *types.TemplateSyncConfig) (bool, error) {
+	m := "proton.GetTemplateSyncConfig()"
+	debug(m)
+	_, err := protonClient.GetTemplateSyncConfig(ctx, &proton.GetTemplateSyncConfigInput{
+		TemplateName: config.TemplateName,
+		TemplateType: config.TemplateType,
+	})
+	if err != nil && !isNotFound(err) {
+		return false, fmt.Errorf("%s: %w", m, err)
+	}
+
+	if err == nil {
+		m = "proton.UpdateTemplateSyncConfig()"
+		debug(m)
+		_, err = protonClient.UpdateTemplateSyncConfig(ctx, &proton.UpdateTemplateSyncConfigInput{
+			TemplateName:       config.TemplateName,
+			TemplateType:       config.TemplateType,
+			RepositoryProvider: config.RepositoryProvider,
+			RepositoryName:     config.RepositoryName,
+			Branch:             config.Branch,
+			Subdirectory:       config.Subdirectory,
+		})
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", m, err)
+		}
+		return false, nil
+	}
+
+	m = "proton.CreateTemplateSyncConfig()"
+	debug(m)
+	_, err = protonClient.CreateTemplateSyncConfig(ctx, &proton.CreateTemplateSyncConfigInput{
+		TemplateName:       config.TemplateName,
+		TemplateType:       config.TemplateType,
+		RepositoryProvider: config.RepositoryProvider,
+		RepositoryName:     config.RepositoryName,
+		Branch:             config.Branch,
+		Subdirectory:       config.Subdirectory,
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", m, err)
+	}
+	return true, nil
+}
+
+// returns a template's sync config and the most recent sync attempts for a major version.
+// returns a not found error if template sync isn't enabled
+func getTemplateSyncStatus(ctx context.Context, protonClient ProtonAPI, template *templateInfo, major string) (*templateSyncStatus, error) {
+	templateType := syncTemplateType(template.Type)
+
+	m := "proton.GetTemplateSyncConfig()"
+	debug(m)
+	out, err := protonClient.GetTemplateSyncConfig(ctx, &proton.GetTemplateSyncConfigInput{
+		TemplateName: &template.Name,
+		TemplateType: templateType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	config := out.TemplateSyncConfig
+	status := &templateSyncStatus{
+		Template:     template.Name,
+		Type:         template.Type,
+		MajorVersion: major,
+		Provider:     string(config.RepositoryProvider),
+		Repository:   aws.ToString(config.RepositoryName),
+		Branch:       aws.ToString(config.Branch),
+		Subdirectory: aws.ToString(config.Subdirectory),
+	}
+
+	m = "proton.GetTemplateSyncStatus()"
+	debug(m)
+	syncStatus, err := protonClient.GetTemplateSyncStatus(ctx, &proton.GetTemplateSyncStatusInput{
+		TemplateName:    &template.Name,
+		TemplateType:    templateType,
+		TemplateVersion: &major,
+	})
+	if err != nil {
+		//nothing has synced yet
+		if isNotFound(err) {
+			return status, nil
+		}
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	if syncStatus.DesiredState != nil {
+		status.DesiredCommit = aws.ToString(syncStatus.DesiredState.Sha)
+	}
+	status.LatestSync = newSyncAttempt(syncStatus.LatestSync)
+	status.LatestSuccessfulSync = newSyncAttempt(syncStatus.LatestSuccessfulSync)
+	return status, nil
+}
+
+func newSyncAttempt(a *types.ResourceSyncAttempt) *syncAttempt {
+	if a == nil {
+		return nil
+	}
+	result := &syncAttempt{
+		Status:    a.Status,
+		StartedAt: aws.ToTime(a.StartedAt),
+		Target:    aws.ToString(a.Target),
+	}
+	if a.TargetRevision != nil {
+		result.Commit = aws.ToString(a.TargetRevision.Sha)
+	}
+	for _, e := range a.Events {
+		result.Events = append(result.Events, syncEvent{
+			Time:  aws.ToTime(e.Time),
+			Type:  aws.ToString(e.Type),
+			Event: aws.ToString(e.Event),
+		})
+	}
+	return result
+}
+
+func printTemplateSyncStatus(w io.Writer, status *templateSyncStatus, format string) error {
+	return printOutput(w, format, status, func(w io.Writer) {
+		fmt.Fprintf(w, "Template:\t%s (%s)\n", status.Template, status.Type)
+		fmt.Fprintf(w, "Repository:\t%s %s\n", status.Provider, status.Repository)
+		fmt.Fprintf(w, "Branch:\t%s\n", status.Branch)
+		fmt.Fprintf(w, "Subdirectory:\t%s\n", cell(status.Subdirectory))
+		fmt.Fprintf(w, "Major version:\t%s\n", status.MajorVersion)
+		fmt.Fprintf(w, "Desired commit:\t%s\n", cell(status.DesiredCommit))
+		printSyncAttempt(w, "Latest sync", status.LatestSync)
+		printSyncAttempt(w, "Latest successful sync", status.LatestSuccessfulSync)
+	})
+}
+
+func printSyncAttempt(w io.Writer, label string, a *syncAttempt) {
+	if a == nil {
+		fmt.Fprintf(w, "%s:\t-\n", label)
+		return
+	}
+	fmt.Fprintf(w, "%s:\t%s at %s (commit %s)\n", label, a.Status,
+		a.StartedAt.Format(time.RFC3339), cell(a.Commit))
+	if a.Status != types.ResourceSyncStatusFailed {
+		return
+	}
+
+	//failed attempts show what went wrong
+	for _, e := range a.Events {
+		fmt.Fprintf(w, "\t%s %s: %s\n", e.Time.Format(time.RFC3339), e.Type, e.Event)
+	}
+}
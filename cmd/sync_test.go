@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/proton"
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func TestValidateSyncLayout(t *testing.T) {
+	root := t.TempDir()
+	internalWriteFiles(t, root, map[string]string{
+		"api/v1/proton.yaml":                           "name: api\ntype: service\ncompatibleEnvironments:\n  - vpc:1\n",
+		"api/v1/schema/schema.yaml":                    "schema: {}\n",
+		"api/v1/instance_infrastructure/manifest.yaml": "infrastructure: {}\n",
+		"api/v1/" + templateRegistrationFile:           "compatible_environments:\n  - vpc:1\n",
+		"api/README.md":                                "ignored\n",
+	})
+	if err := validateSyncLayout(root, "api", "service"); err != nil {
+		t.Errorf("expecting a valid layout, got %v", err)
+	}
+
+	//a second major version missing its manifest and registration
+	internalWriteFiles(t, root, map[string]string{
+		"api/v2/proton.yaml":        "name: api\ntype: service\ncompatibleEnvironments:\n  - vpc:2\n",
+		"api/v2/schema/schema.yaml": "schema: {}\n",
+	})
+	err := validateSyncLayout(root, "api", "service")
+	if err == nil {
+		t.Fatal("expecting layout problems")
+	}
+	for _, expected := range []string{
+		"api/v2: instance_infrastructure/manifest.yaml is missing",
+		"api/v2: " + templateRegistrationFile + " is required",
+		"- vpc:2",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting %q in:\n%v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "api/v1") {
+		t.Errorf("expecting v1 to be valid, got:\n%v", err)
+	}
+
+	if err := validateSyncLayout(root, "vpc", "environment"); err == nil {
+		t.Error("expecting an error for a missing template directory")
+	}
+}
+
+func TestEnsureRepository(t *testing.T) {
+	client := newFakeProton()
+	err := ensureRepository(context.Background(), client, types.RepositoryProviderGithub, "my-org/templates", "")
+	if !errors.Is(err, errRepositoryNotLinked) {
+		t.Errorf("expecting an unlinked repository error, got %v", err)
+	}
+
+	connection := "arn:aws:codestar-connections:us-east-1:123456789012:connection/abc"
+	err = ensureRepository(context.Background(), client, types.RepositoryProviderGithub, "my-org/templates", connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, linked := client.repositories["GITHUB/my-org/templates"]; !linked {
+		t.Error("expecting the repository to be linked")
+	}
+}
+
+func TestPutTemplateSyncConfig(t *testing.T) {
+	client := newFakeProton()
+	config := &types.TemplateSyncConfig{
+		TemplateName:       aws.String("api"),
+		TemplateType:       syncTemplateType("service"),
+		RepositoryProvider: types.RepositoryProviderGithub,
+		RepositoryName:     aws.String("my-org/templates"),
+		Branch:             aws.String("main"),
+	}
+	created, err := putTemplateSyncConfig(context.Background(), client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("expecting the sync config to be created")
+	}
+
+	config.Branch = aws.String("release")
+	created, err = putTemplateSyncConfig(context.Background(), client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || aws.ToString(client.syncConfigs["SERVICE/api"].Branch) != "release" {
+		t.Error("expecting the sync config to be updated")
+	}
+}
+
+func TestTemplateSyncStatus(t *testing.T) {
+	client := newFakeProton()
+	template := &templateInfo{Name: "api", Type: "service"}
+
+	_, err := getTemplateSyncStatus(context.Background(), client, template, "1")
+	if !isNotFound(err) {
+		t.Errorf("expecting not found when sync isn't enabled, got %v", err)
+	}
+
+	client.syncConfigs["SERVICE/api"] = &types.TemplateSyncConfig{
+		TemplateName:       aws.String("api"),
+		TemplateType:       types.TemplateTypeService,
+		RepositoryProvider: types.RepositoryProviderGithub,
+		RepositoryName:     aws.String("my-org/templates"),
+		Branch:             aws.String("main"),
+	}
+	status, err := getTemplateSyncStatus(context.Background(), client, template, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LatestSync != nil {
+		t.Error("expecting no sync attempts yet")
+	}
+
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	client.syncStatus["SERVICE/api:1"] = &proton.GetTemplateSyncStatusOutput{
+		DesiredState: &types.Revision{Sha: aws.String("def456")},
+		LatestSync: &types.ResourceSyncAttempt{
+			Status:         types.ResourceSyncStatusFailed,
+			StartedAt:      &started,
+			TargetRevision: &types.Revision{Sha: aws.String("def456")},
+			Events: []types.ResourceSyncEvent{
+				{Time: &started, Type: aws.String("FAILED"), Event: aws.String("schema.yaml is invalid")},
+			},
+		},
+		LatestSuccessfulSync: &types.ResourceSyncAttempt{
+			Status:         types.ResourceSyncStatusSucceeded,
+			StartedAt:      &started,
+			TargetRevision: &types.Revision{Sha: aws.String("abc123")},
+		},
+	}
+	status, err = getTemplateSyncStatus(context.Background(), client, template, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := printTemplateSyncStatus(&out, status, outputTable); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"GITHUB my-org/templates", "FAILED at 2024-01-02T03:04:05Z (commit def456)", "schema.yaml is invalid", "SUCCEEDED"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expecting %q in:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestParseRepositoryProvider(t *testing.T) {
+	p, err := parseRepositoryProvider("github_enterprise")
+	if err != nil || p != types.RepositoryProviderGithubEnterprise {
+		t.Errorf("expecting GITHUB_ENTERPRISE, got %s %v", p, err)
+	}
+	if _, err := parseRepositoryProvider("gitlab"); err == nil {
+		t.Error("expecting an error for gitlab")
+	}
+}
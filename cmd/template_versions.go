@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+// returned when a template doesn't exist as any kind of template
+var errTemplateNotFound = errors.New("template not found")
+
+// the parts of a proton template that commands need regardless of its kind
+type templateInfo struct {
+	Name               string             `json:"name" yaml:"name"`
+	Type               string             `json:"type" yaml:"type"`
+	Arn                string             `json:"arn" yaml:"arn"`
+	DisplayName        string             `json:"displayName,omitempty" yaml:"displayName,omitempty"`
+	Description        string             `json:"description,omitempty" yaml:"description,omitempty"`
+	EncryptionKey      string             `json:"encryptionKey,omitempty" yaml:"encryptionKey,omitempty"`
+	Provisioning       types.Provisioning `json:"provisioning,omitempty" yaml:"provisioning,omitempty"`
+	RecommendedVersion string             `json:"recommendedVersion,omitempty" yaml:"recommendedVersion,omitempty"`
+}
+
+// the parts of a proton template version that commands need regardless of its kind
+type templateVersionInfo struct {
+	Arn                    string                      `json:"arn" yaml:"arn"`
+	MajorVersion           string                      `json:"majorVersion" yaml:"majorVersion"`
+	MinorVersion           string                      `json:"minorVersion" yaml:"minorVersion"`
+	Status                 types.TemplateVersionStatus `json:"status" yaml:"status"`
+	StatusMessage          string                      `json:"statusMessage,omitempty" yaml:"statusMessage,omitempty"`
+	Description            string                      `json:"description,omitempty" yaml:"description,omitempty"`
+	Schema                 string                      `json:"schema,omitempty" yaml:"schema,omitempty"`
+	CompatibleEnvironments []string                    `json:"compatibleEnvironments,omitempty" yaml:"compatibleEnvironments,omitempty"`
+}
+
+// parses a template version in the format major.minor
+func parseTemplateVersion(v string) (string, string, error) {
+	parts := strings.Split(v, ".")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("version: %q must use the format: `major.minor`", v)
+	}
+	for _, p := range parts {
+		if _, err := strconv.Atoi(p); err != nil {
+			return "", "", fmt.Errorf("version: %q must use the format: `major.minor`", v)
+		}
+	}
+	return parts[0], parts[1], nil
+}
+
+// compares two major.minor versions numerically, returning -1, 0 or 1
+func compareTemplateVersions(aMajor, aMinor, bMajor, bMinor string) int {
+	number := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	for _, pair := range [][2]string{{aMajor, bMajor}, {aMinor, bMinor}} {
+		a, b := number(pair[0]), number(pair[1])
+		if a < b {
+			return -1
+		}
+		if a > b {
+			return 1
+		}
+	}
+	return 0
+}
+
+// looks up a template by name, trying each kind of template in turn
+func getTemplate(ctx context.Context, protonClient ProtonAPI, name string) (*templateInfo, templateKind, error) {
+	for _, kind := range templateKinds {
+		t, err := kind.getTemplate(ctx, protonClient, name)
+		if err == nil {
+			return t, kind, nil
+		}
+		if !isNotFound(err) {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: %s", errTemplateNotFound, name)
+}
+
+// lists every version of a template. without a major version proton only lists
+// the latest minor version of each major version, so each major version is listed in turn
+func listAllTemplateVersions(ctx context.Context, protonClient ProtonAPI, kind templateKind, name string) ([]templateVersionInfo, error) {
+	majors, err := kind.listVersions(ctx, protonClient, name, "")
+	if err != nil {
+		return nil, err
+	}
+	result := []templateVersionInfo{}
+	for _, major := range majors {
+		versions, err := kind.listVersions(ctx, protonClient, name, major.MajorVersion)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, versions...)
+	}
+	return result, nil
+}
+
+// returned when a template has no version matching the one requested
+var errTemplateVersionNotFound = errors.New("template version not found")
+
+// gets a template version. if major is empty, defaults to the template's
+// recommended version, or its latest version if none has been published
+func resolveTemplateVersion(ctx context.Context, protonClient ProtonAPI, template *templateInfo, kind templateKind,
+	major, minor string) (*templateVersionInfo, error) {
+
+	if major == "" && template.RecommendedVersion != "" {
+		major, minor, _ = strings.Cut(template.RecommendedVersion, ".")
+	}
+	if major == "" {
+		versions, err := kind.listVersions(ctx, protonClient, template.Name, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("%w: template %s has no versions", errTemplateVersionNotFound, template.Name)
+		}
+		sortTemplateVersions(versions)
+		latest := versions[len(versions)-1]
+		major, minor = latest.MajorVersion, latest.MinorVersion
+	}
+
+	v, err := kind.getVersion(ctx, protonClient, template.Name, major, minor)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: %s:%s.%s", errTemplateVersionNotFound, template.Name, major, minor)
+		}
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import "testing"
+
+func TestParseTemplateVersion(t *testing.T) {
+	major, minor, err := parseTemplateVersion("1.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if major != "1" || minor != "3" {
+		t.Errorf("expecting 1 and 3, got %s and %s", major, minor)
+	}
+
+	for _, v := range []string{"1", "1.3.1", "a.b", ""} {
+		if _, _, err := parseTemplateVersion(v); err == nil {
+			t.Error("expecting an error for", v)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+#!/bin/sh
+# stands in for the aws cli when protonizer runs a codebuild manifest locally.
+# proton notify-resource-deployment-status-change is captured in $PROTONIZER_NOTIFY_DIR
+# and everything else is passed to the real aws cli, if there is one
+if [ "$1" = "proton" ] && [ "$2" = "notify-resource-deployment-status-change" ]; then
+  shift 2
+  status=""
+  outputs=""
+  while [ $# -gt 0 ]; do
+    case "$1" in
+      --status) status="$2"; shift ;;
+      --status=*) status="${1#*=}" ;;
+      --outputs) outputs="$2"; shift ;;
+      --outputs=*) outputs="${1#*=}" ;;
+    esac
+    shift
+  done
+  n=$(ls "$PROTONIZER_NOTIFY_DIR" | grep -c '\.status$')
+  f="$PROTONIZER_NOTIFY_DIR/$(printf '%04d' "$n")"
+  case "$outputs" in
+    file://*) cp "${outputs#file://}" "$f.outputs" || exit 1 ;;
+    "") ;;
+    *) printf '%s' "$outputs" > "$f.outputs" ;;
+  esac
+  printf '%s' "$status" > "$f.status"
+  echo "captured notify-resource-deployment-status-change ${status}"
+  exit 0
+fi
+if [ -z "$PROTONIZER_AWS" ]; then
+  echo "aws: command not found. only proton notify-resource-deployment-status-change is stubbed" >&2
+  exit 127
+fi
+exec "$PROTONIZER_AWS" "$@"
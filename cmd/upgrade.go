@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/spf13/cobra"
+)
+
+const (
+	//deployment polling backoff
+	deployPollInitialDelay = 5 * time.Second
+	deployPollMaxDelay     = 30 * time.Second
+)
+
+// upgrade outcomes
+const (
+	upgradeStatusUpgraded = "UPGRADED"
+	upgradeStatusFailed   = "FAILED"
+	upgradeStatusSkipped  = "SKIPPED"
+)
+
+var (
+	flagUpgradeTo          string
+	flagUpgradeSelector    string
+	flagUpgradeCanary      string
+	flagUpgradeConcurrency int
+	flagUpgradeTimeout     time.Duration
+	flagUpgradeDryRun      bool
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade <template> --to <major.minor>",
+	Short: "Upgrades environments or service instances to a template version",
+	Long: `Upgrades the environments (for environment templates) or service instances
+(for service templates) using a template to a new template version.
+
+Deployments run concurrently and are polled until they finish.
+Once a deployment fails no new deployments are started.
+Deployments matching --canary are upgraded first, one at a time,
+and the rest are only upgraded if they all succeed.
+
+Selectors are comma separated key=pattern pairs, where pattern can use * wildcards.
+Supported keys are env (the environment name), service (the service name)
+and name (the environment or service instance name).`,
+	Args: cobra.ExactArgs(1),
+	Run:  doUpgrade,
+	Example: `
+# Upgrade every environment using a template to 1.4
+protonizer upgrade my_env_template --to 1.4
+
+# Upgrade service instances in production environments, prod-canary first
+protonizer upgrade my_template --to 2.0 --selector env=prod*,service=orders --canary env=prod-canary
+
+# Show what would be upgraded
+protonizer upgrade my_template --to 1.4 --dry-run`,
+}
+
+// deployment name patterns, keyed by env, service or name
+type deploymentSelector map[string]string
+
+// a deployment to upgrade and its outcome
+type upgradeJob struct {
+	deployment templateDeployment
+	status     string
+	err        error
+}
+
+func init() {
+	upgradeCmd.Flags().StringVar(&flagUpgradeTo, "to", "",
+		"The template version to upgrade to, in the format major.minor (required)")
+	upgradeCmd.Flags().StringVar(&flagUpgradeSelector, "selector", "",
+		"Only upgrade deployments matching this selector, for example env=prod*")
+	upgradeCmd.Flags().StringVar(&flagUpgradeCanary, "canary", "",
+		"Upgrade deployments matching this selector first, one at a time")
+	upgradeCmd.Flags().IntVar(&flagUpgradeConcurrency, "concurrency", 4,
+		"The maximum number of deployments to run at once")
+	upgradeCmd.Flags().DurationVar(&flagUpgradeTimeout, "timeout", 60*time.Minute,
+		"How long to wait for each deployment to finish")
+	upgradeCmd.Flags().BoolVar(&flagUpgradeDryRun, "dry-run", false,
+		"Show the deployments that would be upgraded without upgrading them")
+	upgradeCmd.MarkFlagRequired("to")
+	rootCmd.AddCommand(upgradeCmd)
+}
+
+func doUpgrade(cmd *cobra.Command, args []string) {
+	name := args[0]
+	major, minor, err := parseTemplateVersion(flagUpgradeTo)
+	if err != nil {
+		errorExit(err)
+	}
+	selector, err := parseDeploymentSelector(flagUpgradeSelector)
+	if err != nil {
+		errorExit(err)
+	}
+	canary, err := parseDeploymentSelector(flagUpgradeCanary)
+	if err != nil {
+		errorExit(err)
+	}
+	if flagUpgradeConcurrency < 1 {
+		errorExit("concurrency must be at least 1")
+	}
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	protonClient := newProtonClient(cfg)
+
+	_, kind, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		if errors.Is(err, errTemplateNotFound) {
+			errorExit(err)
+		}
+		handleError("getting template", err)
+	}
+
+	//only published versions can be deployed
+	version, err := kind.getVersion(ctx, protonClient, name, major, minor)
+	if err != nil {
+		if isNotFound(err) {
+			errorExit(fmt.Sprintf("template version %s:%s not found", name, flagUpgradeTo))
+		}
+		handleError("getting template version", err)
+	}
+	if version.Status != types.TemplateVersionStatusPublished {
+		errorExit(fmt.Sprintf("template version %s:%s is %s. only published versions can be deployed",
+			name, flagUpgradeTo, version.Status))
+	}
+
+	deployments, err := kind.listDeployments(ctx, protonClient, name)
+	handleError("listing template deployments", err)
+	stages := planUpgrade(deployments, major, minor, selector, canary)
+	jobs := []*upgradeJob{}
+	for _, stage := range stages {
+		jobs = append(jobs, stage...)
+	}
+	if len(jobs) == 0 {
+		fmt.Printf("no deployments need upgrading to %s:%s \n", name, flagUpgradeTo)
+		return
+	}
+
+	if flagUpgradeDryRun {
+		fmt.Printf("would upgrade %d deployments to %s:%s \n", len(jobs), name, flagUpgradeTo)
+		for i, stage := range stages {
+			for _, job := range stage {
+				prefix := ""
+				if i == 0 && len(stages) > 1 {
+					prefix = "(canary) "
+				}
+				fmt.Printf("  %s%s %s -> %s \n", prefix, job.deployment, job.deployment.version(), flagUpgradeTo)
+			}
+		}
+		return
+	}
+
+	//stop starting deployments on ctrl-c
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("upgrading %d deployments to %s:%s \n", len(jobs), name, flagUpgradeTo)
+	runUpgrades(ctx, stages, flagUpgradeConcurrency, func(ctx context.Context, d templateDeployment) error {
+		ctx, cancel := context.WithTimeout(ctx, flagUpgradeTimeout)
+		defer cancel()
+		return upgradeDeployment(ctx, protonClient, kind, d, major, minor,
+			deployPollInitialDelay, deployPollMaxDelay)
+	})
+
+	fmt.Println()
+	failed := printUpgradeSummary(os.Stdout, jobs)
+	if failed > 0 {
+		errorExit(fmt.Sprintf("\n%d of %d deployments were not upgraded", failed, len(jobs)))
+	}
+}
+
+// parses a selector in the format key=pattern[,key=pattern]
+func parseDeploymentSelector(s string) (deploymentSelector, error) {
+	selector := deploymentSelector{}
+	if s == "" {
+		return selector, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		key, pattern, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found || pattern == "" {
+			return nil, fmt.Errorf("selector: %q must use the format: key=pattern", part)
+		}
+		switch key {
+		case "env", "service", "name":
+		default:
+			return nil, fmt.Errorf("selector: %q is invalid. only env, service and name are supported", key)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("selector: %q is not a valid pattern", pattern)
+		}
+		selector[key] = pattern
+	}
+	return selector, nil
+}
+
+// returns true if a deployment matches every pattern in the selector
+func (s deploymentSelector) matches(d templateDeployment) bool {
+	values := map[string]string{
+		"env":     d.Environment,
+		"service": d.Service,
+		"name":    d.Name,
+	}
+	for key, pattern := range s {
+		if ok, _ := path.Match(pattern, values[key]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the deployments to upgrade in stages. deployments already at
+// or past the target version, or not matching the selector, are left out.
+// when a canary selector is set, the first stage is the canary deployments
+func planUpgrade(deployments []templateDeployment, major, minor string,
+	selector, canary deploymentSelector) [][]*upgradeJob {
+
+	canaries, rest := []*upgradeJob{}, []*upgradeJob{}
+	for _, d := range deployments {
+		if compareTemplateVersions(d.MajorVersion, d.MinorVersion, major, minor) >= 0 {
+			continue
+		}
+		if !selector.matches(d) {
+			continue
+		}
+		job := &upgradeJob{deployment: d}
+		if len(canary) > 0 && canary.matches(d) {
+			canaries = append(canaries, job)
+		} else {
+			rest = append(rest, job)
+		}
+	}
+
+	stages := [][]*upgradeJob{}
+	for _, stage := range [][]*upgradeJob{canaries, rest} {
+		if len(stage) > 0 {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// runs each stage of upgrades in turn. the canary stage, if there is more
+// than one stage, runs one deployment at a time. once a deployment fails
+// or the context ends, the remaining deployments are skipped
+func runUpgrades(ctx context.Context, stages [][]*upgradeJob, concurrency int,
+	upgrade func(context.Context, templateDeployment) error) {
+
+	var mu sync.Mutex
+	failed := false
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed || ctx.Err() != nil
+	}
+
+	for i, stage := range stages {
+		workers := concurrency
+		if i == 0 && len(stages) > 1 {
+			workers = 1
+		}
+		queue := make(chan *upgradeJob)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for job := range queue {
+					if stopped() {
+						job.status = upgradeStatusSkipped
+						continue
+					}
+					debug("upgrading", job.deployment)
+					job.err = upgrade(ctx, job.deployment)
+					if job.err != nil {
+						job.status = upgradeStatusFailed
+						mu.Lock()
+						failed = true
+						mu.Unlock()
+						fmt.Printf("failed %s: %v \n", job.deployment, firstLine(job.err.Error()))
+						continue
+					}
+					job.status = upgradeStatusUpgraded
+					fmt.Printf("upgraded %s \n", job.deployment)
+				}
+			}()
+		}
+		for _, job := range stage {
+			queue <- job
+		}
+		close(queue)
+		wg.Wait()
+	}
+}
+
+// starts a deployment to a template version and waits for it to finish
+func upgradeDeployment(ctx context.Context, protonClient ProtonAPI, kind templateKind,
+	d templateDeployment, major, minor string, initial, max time.Duration) error {
+
+	deploymentType := types.DeploymentUpdateTypeMinorVersion
+	if d.MajorVersion != major {
+		deploymentType = types.DeploymentUpdateTypeMajorVersion
+	}
+	err := kind.updateDeployment(ctx, protonClient, d, major, minor, deploymentType)
+	if err != nil {
+		return err
+	}
+
+	return waitForDeployment(ctx, protonClient, kind, d, initial, max, func(current *templateDeployment) {
+		debug(d, current.DeploymentStatus)
+	})
+}
+
+// prints a table of upgrade outcomes followed by any errors,
+// returning the number of deployments that failed or were skipped
+func printUpgradeSummary(w io.Writer, jobs []*upgradeJob) int {
+	tw := newTableWriter(w)
+	fmt.Fprintln(tw, "DEPLOYMENT\tENVIRONMENT\tFROM\tSTATUS")
+	failed := []*upgradeJob{}
+	for _, job := range jobs {
+		if job.status != upgradeStatusUpgraded {
+			failed = append(failed, job)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", job.deployment, job.deployment.Environment,
+			job.deployment.version(), cell(job.status))
+	}
+	tw.Flush()
+
+	for _, job := range failed {
+		if job.err != nil {
+			fmt.Fprintf(w, "\n%s:\n%v\n", job.deployment, job.err)
+		}
+	}
+	return len(failed)
+}
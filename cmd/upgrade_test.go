@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+)
+
+func TestParseDeploymentSelector(t *testing.T) {
+	selector, err := parseDeploymentSelector("env=prod*, service=orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod := templateDeployment{Name: "orders-prod", Service: "orders", Environment: "prod-us"}
+	dev := templateDeployment{Name: "orders-dev", Service: "orders", Environment: "dev"}
+	if !selector.matches(prod) || selector.matches(dev) {
+		t.Errorf("expecting only prod to match %v", selector)
+	}
+	if !(deploymentSelector{}).matches(dev) {
+		t.Error("expecting an empty selector to match everything")
+	}
+
+	for _, s := range []string{"prod", "env=", "region=us-*", "name=[a"} {
+		if _, err := parseDeploymentSelector(s); err == nil {
+			t.Error("expecting an error for", s)
+		}
+	}
+}
+
+func TestPlanUpgrade(t *testing.T) {
+	deployments := []templateDeployment{
+		{Name: "dev", Environment: "dev", MajorVersion: "1", MinorVersion: "3"},
+		{Name: "prod-eu", Environment: "prod-eu", MajorVersion: "1", MinorVersion: "2"},
+		{Name: "prod-us", Environment: "prod-us", MajorVersion: "1", MinorVersion: "3"},
+		{Name: "prod-new", Environment: "prod-new", MajorVersion: "1", MinorVersion: "4"},
+		{Name: "prod-next", Environment: "prod-next", MajorVersion: "2", MinorVersion: "0"},
+	}
+	selector, _ := parseDeploymentSelector("env=prod*")
+	canary, _ := parseDeploymentSelector("name=prod-eu")
+
+	stages := planUpgrade(deployments, "1", "4", selector, canary)
+	if len(stages) != 2 || len(stages[0]) != 1 || len(stages[1]) != 1 {
+		t.Fatalf("expecting a canary stage and one other, got %v", stages)
+	}
+	if stages[0][0].deployment.Name != "prod-eu" || stages[1][0].deployment.Name != "prod-us" {
+		t.Errorf("unexpected stages: %v, %v", stages[0][0].deployment, stages[1][0].deployment)
+	}
+
+	stages = planUpgrade(deployments, "1", "4", deploymentSelector{}, deploymentSelector{})
+	if len(stages) != 1 || len(stages[0]) != 3 {
+		t.Errorf("expecting one stage of 3 deployments, got %v", stages)
+	}
+}
+
+func TestUpgradeDeployment(t *testing.T) {
+	client := newFakeProton()
+	client.addServiceInstance("orders", "orders-prod", "prod", "api", "1", "3")
+	d := templateDeployment{Name: "orders-prod", Service: "orders", MajorVersion: "1", MinorVersion: "3"}
+
+	err := upgradeDeployment(context.Background(), client, serviceKind{}, d, "2", "0", time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := client.instances[0]
+	if i.major != "2" || i.minor != "0" || i.status != types.DeploymentStatusSucceeded {
+		t.Errorf("expecting a successful deployment of 2.0, got %s.%s %s", i.major, i.minor, i.status)
+	}
+
+	client.addEnvironment("dev", "vpc", "1", "0")
+	client.deploymentFailures["dev"] = "stack rollback"
+	d = templateDeployment{Name: "dev", Environment: "dev", MajorVersion: "1", MinorVersion: "0"}
+	err = upgradeDeployment(context.Background(), client, environmentKind{}, d, "1", "1", time.Millisecond, time.Millisecond)
+	if !errors.Is(err, errDeploymentFailed) {
+		t.Errorf("expecting a deployment failure, got %v", err)
+	}
+}
+
+func TestRunUpgradesStopsOnFailure(t *testing.T) {
+	client := newFakeProton()
+	for _, name := range []string{"canary", "dev", "prod-1", "prod-2"} {
+		client.addEnvironment(name, "vpc", "1", "0")
+	}
+	client.deploymentFailures["dev"] = "stack rollback"
+
+	deployments, err := environmentKind{}.listDeployments(context.Background(), client, "vpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	canary, _ := parseDeploymentSelector("name=canary")
+	stages := planUpgrade(deployments, "1", "1", deploymentSelector{}, canary)
+	jobs := append(stages[0], stages[1]...)
+
+	//upgrade one deployment at a time so that the failure stops the rest
+	runUpgrades(context.Background(), stages, 1, func(ctx context.Context, d templateDeployment) error {
+		return upgradeDeployment(ctx, client, environmentKind{}, d, "1", "1", time.Millisecond, time.Millisecond)
+	})
+
+	expected := map[string]string{
+		"canary": upgradeStatusUpgraded,
+		"dev":    upgradeStatusFailed,
+		"prod-1": upgradeStatusSkipped,
+		"prod-2": upgradeStatusSkipped,
+	}
+	for _, job := range jobs {
+		if job.status != expected[job.deployment.Name] {
+			t.Errorf("expecting %s to be %s, got %s", job.deployment.Name, expected[job.deployment.Name], job.status)
+		}
+	}
+
+	var out bytes.Buffer
+	if failed := printUpgradeSummary(&out, jobs); failed != 3 {
+		t.Errorf("expecting 3 deployments not upgraded, got %d", failed)
+	}
+	if !regexp.MustCompile(`dev\s+dev\s+1\.0\s+FAILED`).MatchString(out.String()) {
+		t.Errorf("expecting dev to have failed, got:\n%s", out.String())
+	}
+}
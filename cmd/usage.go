@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/proton/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagUsageOutput string
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage <template>",
+	Short: "Shows which environments or service instances use a template",
+	Long: `Shows which environments or service instances use each version of a template,
+flagging any that are behind the template's recommended version.`,
+	Args: cobra.ExactArgs(1),
+	Run:  doUsage,
+	Example: `
+# Show the environments using an environment template
+protonizer usage my_env_template
+
+# Show the service instances using a service template, as json
+protonizer usage my_template -o json`,
+}
+
+// an environment or service instance deployed from a template
+type templateDeployment struct {
+	Name             string                 `json:"name" yaml:"name"`
+	Service          string                 `json:"service,omitempty" yaml:"service,omitempty"`
+	Environment      string                 `json:"environment" yaml:"environment"`
+	MajorVersion     string                 `json:"majorVersion" yaml:"majorVersion"`
+	MinorVersion     string                 `json:"minorVersion" yaml:"minorVersion"`
+	DeploymentStatus types.DeploymentStatus `json:"deploymentStatus" yaml:"deploymentStatus"`
+	StatusMessage    string                 `json:"statusMessage,omitempty" yaml:"statusMessage,omitempty"`
+	Behind           bool                   `json:"behind" yaml:"behind"`
+}
+
+func (d templateDeployment) version() string {
+	return d.MajorVersion + "." + d.MinorVersion
+}
+
+// returns the deployment's name, qualified by its service for service instances
+func (d templateDeployment) String() string {
+	if d.Service != "" {
+		return d.Service + "/" + d.Name
+	}
+	return d.Name
+}
+
+// the deployments of a template, grouped by version
+type templateUsage struct {
+	Template           string         `json:"template" yaml:"template"`
+	Type               string         `json:"type" yaml:"type"`
+	RecommendedVersion string         `json:"recommendedVersion,omitempty" yaml:"recommendedVersion,omitempty"`
+	Total              int            `json:"total" yaml:"total"`
+	Behind             int            `json:"behind" yaml:"behind"`
+	Versions           []versionUsage `json:"versions" yaml:"versions"`
+}
+
+type versionUsage struct {
+	Version     string               `json:"version" yaml:"version"`
+	Deployments []templateDeployment `json:"deployments" yaml:"deployments"`
+}
+
+func init() {
+	usageCmd.Flags().StringVarP(&flagUsageOutput, "output", "o", outputTable,
+		"The output format: table, json or yaml")
+	rootCmd.AddCommand(usageCmd)
+}
+
+func doUsage(cmd *cobra.Command, args []string) {
+	if err := validateOutputFormat(flagUsageOutput); err != nil {
+		errorExit(err)
+	}
+	name := args[0]
+
+	cfg := getAWSConfig()
+	ctx := context.Background()
+	protonClient := newProtonClient(cfg)
+
+	template, kind, err := getTemplate(ctx, protonClient, name)
+	if err != nil {
+		if errors.Is(err, errTemplateNotFound) {
+			errorExit(err)
+		}
+		handleError("getting template", err)
+	}
+	deployments, err := kind.listDeployments(ctx, protonClient, name)
+	handleError("listing template deployments", err)
+
+	err = printTemplateUsage(os.Stdout, newTemplateUsage(template, deployments), flagUsageOutput)
+	handleError("printing template usage", err)
+}
+
+// groups deployments by version, oldest first, flagging
+// those behind the template's recommended version
+func newTemplateUsage(template *templateInfo, deployments []templateDeployment) *templateUsage {
+	usage := &templateUsage{
+		Template:           template.Name,
+		Type:               template.Type,
+		RecommendedVersion: template.RecommendedVersion,
+		Total:              len(deployments),
+		Versions:           []versionUsage{},
+	}
+
+	recommendedMajor, recommendedMinor, _ := strings.Cut(template.RecommendedVersion, ".")
+	sort.SliceStable(deployments, func(i, j int) bool {
+		a, b := deployments[i], deployments[j]
+		if c := compareTemplateVersions(a.MajorVersion, a.MinorVersion, b.MajorVersion, b.MinorVersion); c != 0 {
+			return c < 0
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Name < b.Name
+	})
+
+	for _, d := range deployments {
+		if template.RecommendedVersion != "" &&
+			compareTemplateVersions(d.MajorVersion, d.MinorVersion, recommendedMajor, recommendedMinor) < 0 {
+			d.Behind = true
+			usage.Behind++
+		}
+		last := len(usage.Versions) - 1
+		if last < 0 || usage.Versions[last].Version != d.version() {
+			usage.Versions = append(usage.Versions, versionUsage{Version: d.version()})
+			last++
+		}
+		usage.Versions[last].Deployments = append(usage.Versions[last].Deployments, d)
+	}
+	return usage
+}
+
+func printTemplateUsage(w io.Writer, usage *templateUsage, format string) error {
+	return printOutput(w, format, usage, func(w io.Writer) {
+		isService := usage.Type == "service"
+		if isService {
+			fmt.Fprintln(w, "VERSION\tSERVICE\tINSTANCE\tENVIRONMENT\tSTATUS\tBEHIND")
+		} else {
+			fmt.Fprintln(w, "VERSION\tENVIRONMENT\tSTATUS\tBEHIND")
+		}
+		for _, v := range usage.Versions {
+			for _, d := range v.Deployments {
+				behind := ""
+				if d.Behind {
+					behind = "yes"
+				}
+				if isService {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Version, d.Service, d.Name, d.Environment, d.DeploymentStatus, cell(behind))
+				} else {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Version, d.Name, d.DeploymentStatus, cell(behind))
+				}
+			}
+		}
+		fmt.Fprintln(w)
+		what := "environments"
+		if isService {
+			what = "service instances"
+		}
+		if usage.RecommendedVersion == "" {
+			fmt.Fprintf(w, "%d %s use %s, which has no recommended version\n", usage.Total, what, usage.Template)
+			return
+		}
+		fmt.Fprintf(w, "%d of %d %s are behind the recommended version %s\n",
+			usage.Behind, usage.Total, what, usage.RecommendedVersion)
+	})
+}
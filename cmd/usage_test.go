@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func TestEnvironmentUsage(t *testing.T) {
+	client := newFakeProton()
+	client.addTemplate("environment", "vpc").recommended = "1.10"
+	client.addEnvironment("prod", "vpc", "1", "10")
+	client.addEnvironment("dev", "vpc", "1", "9")
+	client.addEnvironment("staging", "vpc", "1", "9")
+	client.addEnvironment("other", "cluster", "1", "0")
+
+	template, _, err := getTemplate(context.Background(), client, "vpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployments, err := environmentKind{}.listDeployments(context.Background(), client, "vpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := newTemplateUsage(template, deployments)
+
+	if usage.Total != 3 || usage.Behind != 2 {
+		t.Errorf("expecting 2 of 3 behind, got %d of %d", usage.Behind, usage.Total)
+	}
+	if len(usage.Versions) != 2 || usage.Versions[0].Version != "1.9" || usage.Versions[1].Version != "1.10" {
+		t.Fatalf("expecting versions 1.9 and 1.10, got %v", usage.Versions)
+	}
+	if usage.Versions[0].Deployments[0].Name != "dev" || !usage.Versions[0].Deployments[0].Behind {
+		t.Errorf("expecting dev to be behind, got %v", usage.Versions[0].Deployments[0])
+	}
+	if usage.Versions[1].Deployments[0].Behind {
+		t.Error("expecting prod not to be behind")
+	}
+
+	var out bytes.Buffer
+	if err := printTemplateUsage(&out, usage, outputTable); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`1\.9\s+staging\s+SUCCEEDED\s+yes`).MatchString(out.String()) {
+		t.Errorf("expecting staging to be flagged, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "2 of 3 environments are behind the recommended version 1.10") {
+		t.Errorf("expecting a summary, got:\n%s", out.String())
+	}
+}
+
+func TestServiceUsage(t *testing.T) {
+	client := newFakeProton()
+	client.addTemplate("service", "api").recommended = "2.0"
+	client.addServiceInstance("orders", "orders-prod", "prod", "api", "2", "0")
+	client.addServiceInstance("orders", "orders-dev", "dev", "api", "1", "4")
+	client.addServiceInstance("orders", "orders-test", "dev", "api", "1", "4")
+	client.addServiceInstance("payments", "payments-prod", "prod", "api", "2", "0")
+	client.addServiceInstance("web", "web-prod", "prod", "frontend", "1", "0")
+
+	template, _, err := getTemplate(context.Background(), client, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployments, err := serviceKind{}.listDeployments(context.Background(), client, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 4 {
+		t.Fatalf("expecting 4 service instances across pages, got %d", len(deployments))
+	}
+	usage := newTemplateUsage(template, deployments)
+
+	var out bytes.Buffer
+	if err := printTemplateUsage(&out, usage, outputJSON); err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON templateUsage
+	if err := json.Unmarshal(out.Bytes(), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if fromJSON.Behind != 2 || len(fromJSON.Versions) != 2 {
+		t.Fatalf("expecting 2 behind in 2 versions, got:\n%s", out.String())
+	}
+	latest := fromJSON.Versions[1]
+	if latest.Version != "2.0" || len(latest.Deployments) != 2 || latest.Deployments[1].Service != "payments" {
+		t.Errorf("unexpected 2.0 deployments: %v", latest.Deployments)
+	}
+}
+
+func TestEnvironmentListDeploymentsSDKValidation(t *testing.T) {
+	//runs the sdk's client side validation, which fakeProton doesn't
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Write([]byte(`{"environments":[
+			{"name":"dev","templateName":"vpc","templateMajorVersion":"1","templateMinorVersion":"2"},
+			{"name":"other","templateName":"cluster","templateMajorVersion":"1","templateMinorVersion":"0"}]}`))
+	}))
+	defer server.Close()
+
+	flagAWSProtonEndpointURL = server.URL
+	defer func() { flagAWSProtonEndpointURL = "" }()
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}
+
+	deployments, err := environmentKind{}.listDeployments(context.Background(), newProtonClient(cfg), "vpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 1 || deployments[0].Name != "dev" {
+		t.Errorf("expecting only dev, got %v", deployments)
+	}
+	if _, found := body["environmentTemplates"]; found {
+		t.Errorf("expecting no template filter, got %v", body)
+	}
+}